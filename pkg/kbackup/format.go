@@ -0,0 +1,340 @@
+package kbackup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// The backup file format is a magic header followed by frames. Every frame is
+// a one byte type, a uvarint length, the frame payload, and a big endian
+// crc32c of the type and payload. The first frame is always the manifest, and
+// all following frames are records.
+//
+// Integers within frames are Go varints (zigzag encoded). Byte slices are a
+// varint length followed by the bytes, with a length of -1 signifying nil.
+// Records reference their topic by the topic's index in the manifest, with
+// topics in the manifest sorted by name.
+const magic = "KBAK\x01"
+
+const (
+	frameManifest byte = 1
+	frameRecord   byte = 2
+)
+
+// maxFrameSize is the largest frame payload we write or read: the largest
+// record batch Kafka allows by default is 1MiB, and this leaves plenty of room
+// for brokers configured with larger batches as well as for large manifests.
+const maxFrameSize = 256 << 20
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// ErrCorrupt is returned when reading a backup that has an invalid frame,
+// which may occur if a backup was interrupted mid write.
+var ErrCorrupt = errors.New("kbackup: corrupt backup frame")
+
+// Range is a range of offsets in a partition, from Start inclusive to End
+// exclusive.
+type Range struct {
+	Start int64
+	End   int64
+}
+
+// Manifest describes what a backup contains: the offset ranges of every
+// partition of every backed up topic, captured when the backup began.
+type Manifest struct {
+	// Created is when the backup began.
+	Created time.Time
+
+	// Partitions maps topics to partitions to the range of offsets
+	// being backed up. Every partition of every topic is included, even if
+	// its range is empty.
+	Partitions map[string]map[int32]Range
+}
+
+func (m *Manifest) sortedTopics() []string {
+	topics := make([]string, 0, len(m.Partitions))
+	for t := range m.Partitions {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Writer writes records to a backup.
+type Writer struct {
+	w      *bufio.Writer
+	topics map[string]uint64
+	buf    []byte
+}
+
+// NewWriter writes the backup header and the manifest to w, returning a writer
+// that can be used to write records that are in the manifest.
+func NewWriter(w io.Writer, m Manifest) (*Writer, error) {
+	bw := newAppendWriter(w, &m)
+	if _, err := bw.w.WriteString(magic); err != nil {
+		return nil, err
+	}
+
+	b := appendVarint(nil, m.Created.UnixNano()/1e6)
+	b = appendVarint(b, int64(len(m.Partitions)))
+	for _, t := range m.sortedTopics() {
+		ps := m.Partitions[t]
+		b = appendBytes(b, []byte(t))
+		b = appendVarint(b, int64(len(ps)))
+		for p, r := range ps {
+			b = appendVarint(b, int64(p))
+			b = appendVarint(b, r.Start)
+			b = appendVarint(b, r.End)
+		}
+	}
+	if err := bw.writeFrame(frameManifest, b); err != nil {
+		return nil, err
+	}
+	return bw, nil
+}
+
+// newAppendWriter returns a writer that appends records to an existing backup
+// that has the given manifest.
+func newAppendWriter(w io.Writer, m *Manifest) *Writer {
+	topics := make(map[string]uint64, len(m.Partitions))
+	for i, t := range m.sortedTopics() {
+		topics[t] = uint64(i)
+	}
+	return &Writer{w: bufio.NewWriter(w), topics: topics}
+}
+
+// Write writes a record to the backup. The record's topic must be in the
+// backup's manifest.
+func (w *Writer) Write(r *kgo.Record) error {
+	idx, ok := w.topics[r.Topic]
+	if !ok {
+		return fmt.Errorf("kbackup: topic %q is not in the backup manifest", r.Topic)
+	}
+	b := appendUvarint(w.buf[:0], idx)
+	b = appendVarint(b, int64(r.Partition))
+	b = appendVarint(b, r.Offset)
+	b = appendVarint(b, r.Timestamp.UnixNano()/1e6)
+	b = appendBytes(b, r.Key)
+	b = appendBytes(b, r.Value)
+	b = appendVarint(b, int64(len(r.Headers)))
+	for _, h := range r.Headers {
+		b = appendBytes(b, []byte(h.Key))
+		b = appendBytes(b, h.Value)
+	}
+	w.buf = b
+	return w.writeFrame(frameRecord, b)
+}
+
+// Flush flushes any buffered data to the underlying writer.
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+func (w *Writer) writeFrame(typ byte, payload []byte) error {
+	if len(payload) > maxFrameSize {
+		return fmt.Errorf("kbackup: frame of %d bytes is larger than the max of %d", len(payload), maxFrameSize)
+	}
+	var hdr [1 + binary.MaxVarintLen64]byte
+	hdr[0] = typ
+	n := 1 + binary.PutUvarint(hdr[1:], uint64(len(payload)))
+
+	crc := crc32.Update(crc32.Checksum(hdr[:1], crc32c), crc32c, payload)
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc)
+
+	w.w.Write(hdr[:n])
+	w.w.Write(payload)
+	_, err := w.w.Write(tail[:])
+	return err
+}
+
+// Reader reads records from a backup.
+type Reader struct {
+	r      *bufio.Reader
+	m      Manifest
+	topics []string
+
+	// read is how many bytes of complete frames have been read, which is
+	// where a resumed backup must truncate to and continue writing.
+	read int64
+	buf  []byte
+}
+
+// NewReader reads the backup header and manifest from r, returning a reader
+// that can be used to read the backup's records.
+func NewReader(r io.Reader) (*Reader, error) {
+	br := &Reader{r: bufio.NewReader(r)}
+	hdr := make([]byte, len(magic))
+	if _, err := io.ReadFull(br.r, hdr); err != nil {
+		return nil, fmt.Errorf("kbackup: unable to read header: %w", err)
+	}
+	if string(hdr) != magic {
+		return nil, errors.New("kbackup: invalid backup header")
+	}
+	br.read = int64(len(magic))
+
+	typ, b, err := br.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if typ != frameManifest {
+		return nil, ErrCorrupt
+	}
+	d := decoder{b: b}
+	br.m.Created = time.Unix(0, d.varint()*1e6)
+	br.m.Partitions = make(map[string]map[int32]Range)
+	for nt := d.varint(); nt > 0 && d.err == nil; nt-- {
+		t := string(d.bytes())
+		ps := make(map[int32]Range)
+		for np := d.varint(); np > 0 && d.err == nil; np-- {
+			p := int32(d.varint())
+			ps[p] = Range{Start: d.varint(), End: d.varint()}
+		}
+		br.m.Partitions[t] = ps
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	br.topics = br.m.sortedTopics()
+	return br, nil
+}
+
+// Manifest returns the backup's manifest.
+func (r *Reader) Manifest() Manifest {
+	return r.m
+}
+
+// Next returns the next record in the backup, or io.EOF if there are no more
+// records. If the backup ends with a partial or invalid frame, this returns
+// ErrCorrupt.
+func (r *Reader) Next() (*kgo.Record, error) {
+	typ, b, err := r.readFrame()
+	if err != nil {
+		return nil, err
+	}
+	if typ != frameRecord {
+		return nil, ErrCorrupt
+	}
+	d := decoder{b: b}
+	idx, n := binary.Uvarint(d.b)
+	if n <= 0 || idx >= uint64(len(r.topics)) {
+		return nil, ErrCorrupt
+	}
+	d.b = d.b[n:]
+	rec := &kgo.Record{
+		Topic:     r.topics[idx],
+		Partition: int32(d.varint()),
+		Offset:    d.varint(),
+		Timestamp: time.Unix(0, d.varint()*1e6),
+		Key:       d.bytes(),
+		Value:     d.bytes(),
+	}
+	for nh := d.varint(); nh > 0 && d.err == nil; nh-- {
+		k := string(d.bytes())
+		rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: k, Value: d.bytes()})
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return rec, nil
+}
+
+func (r *Reader) readFrame() (byte, []byte, error) {
+	typ, err := r.r.ReadByte()
+	if err != nil {
+		return 0, nil, err
+	}
+	cr := countingByteReader{r: r.r}
+	size, err := binary.ReadUvarint(&cr)
+	if err != nil || size > maxFrameSize {
+		return 0, nil, ErrCorrupt
+	}
+	// We read the frame incrementally rather than allocating its size up
+	// front, so that a corrupt size cannot allocate more than the input
+	// actually has.
+	buf := bytes.NewBuffer(r.buf[:0])
+	if _, err := io.CopyN(buf, r.r, int64(size)+4); err != nil {
+		return 0, nil, ErrCorrupt
+	}
+	b := buf.Bytes()
+	r.buf = b
+	payload, tail := b[:size], b[size:]
+	crc := crc32.Update(crc32.Checksum([]byte{typ}, crc32c), crc32c, payload)
+	if crc != binary.BigEndian.Uint32(tail) {
+		return 0, nil, ErrCorrupt
+	}
+	r.read += 1 + int64(cr.n) + int64(len(b))
+	return typ, payload, nil
+}
+
+type countingByteReader struct {
+	r *bufio.Reader
+	n int
+}
+
+func (c *countingByteReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+type decoder struct {
+	b   []byte
+	err error
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		d.err = ErrCorrupt
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *decoder) bytes() []byte {
+	l := d.varint()
+	if d.err != nil || l < 0 {
+		return nil
+	}
+	if int64(len(d.b)) < l {
+		d.err = ErrCorrupt
+		return nil
+	}
+	b := make([]byte, l)
+	copy(b, d.b)
+	d.b = d.b[l:]
+	return b
+}
+
+func appendVarint(b []byte, v int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], v)]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
+
+func appendBytes(b, v []byte) []byte {
+	if v == nil {
+		return appendVarint(b, -1)
+	}
+	return append(appendVarint(b, int64(len(v))), v...)
+}
@@ -0,0 +1,147 @@
+package kbackup
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+func TestFormatRoundTrip(t *testing.T) {
+	m := Manifest{
+		Created: time.Unix(1650000000, 0),
+		Partitions: map[string]map[int32]Range{
+			"foo": {0: {0, 2}, 1: {5, 6}},
+			"bar": {0: {0, 0}},
+		},
+	}
+	recs := []*kgo.Record{
+		{Topic: "foo", Partition: 0, Offset: 0, Timestamp: time.Unix(1, 0), Key: []byte("k"), Value: []byte("v")},
+		{Topic: "foo", Partition: 1, Offset: 5, Timestamp: time.Unix(2, 0), Key: []byte{}, Headers: []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}, {Key: "nil"}}},
+		{Topic: "foo", Partition: 0, Offset: 1, Timestamp: time.Unix(3, 0), Value: []byte("v2")},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, r := range recs {
+		if err := w.Write(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Write(&kgo.Record{Topic: "unknown"}); err == nil {
+		t.Error("expected error writing a topic not in the manifest")
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	full := buf.Bytes()
+
+	r, err := NewReader(bytes.NewReader(full))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := r.Manifest(); !got.Created.Equal(m.Created) || !reflect.DeepEqual(got.Partitions, m.Partitions) {
+		t.Errorf("got manifest %v != exp %v", got, m)
+	}
+	for i, exp := range recs {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("%d: unable to read: %v", i, err)
+		}
+		if !got.Timestamp.Equal(exp.Timestamp) {
+			t.Errorf("%d: got timestamp %v != exp %v", i, got.Timestamp, exp.Timestamp)
+		}
+		got.Timestamp = exp.Timestamp
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%d: got %#v != exp %#v", i, got, exp)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("got err %v != io.EOF", err)
+	}
+	if r.read != int64(len(full)) {
+		t.Errorf("got read %d != exp %d", r.read, len(full))
+	}
+
+	// Truncating mid frame and corrupting a frame are both detected.
+	for _, bad := range [][]byte{
+		full[:len(full)-3],
+		append(append([]byte(nil), full[:len(full)-1]...), full[len(full)-1]^0xff),
+	} {
+		r, err := NewReader(bytes.NewReader(bad))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := r.Next(); err != nil {
+				t.Fatalf("%d: unable to read: %v", i, err)
+			}
+		}
+		complete := r.read
+		if _, err := r.Next(); err != ErrCorrupt {
+			t.Errorf("got err %v != ErrCorrupt", err)
+		}
+		if r.read != complete {
+			t.Errorf("got read %d != exp %d after corruption", r.read, complete)
+		}
+	}
+
+	if _, err := NewReader(bytes.NewReader([]byte("not a backup"))); err == nil {
+		t.Error("expected error reading an invalid header")
+	}
+
+	// Frame sizes past the input or past our max are corrupt.
+	for _, size := range []uint64{maxFrameSize, maxFrameSize + 1, 1 << 62} {
+		bad := appendUvarint(append([]byte(magic), frameManifest), size)
+		bad = append(bad, full[len(magic)+2:]...)
+		if _, err := NewReader(bytes.NewReader(bad)); err != ErrCorrupt {
+			t.Errorf("size %d: got err %v != ErrCorrupt", size, err)
+		}
+	}
+}
+
+func TestResumeTruncates(t *testing.T) {
+	m := Manifest{
+		Created:    time.Unix(1650000000, 0),
+		Partitions: map[string]map[int32]Range{"foo": {0: {0, 2}}},
+	}
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf, m)
+	w.Write(&kgo.Record{Topic: "foo", Offset: 0, Value: []byte("a")})
+	w.Write(&kgo.Record{Topic: "foo", Offset: 1, Value: []byte("b")})
+	w.Flush()
+	complete := buf.Len()
+	buf.Write([]byte{frameRecord, 10, 1, 2}) // an interrupted write
+
+	f, err := ioutil.TempFile("", "kbackup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Every partition is complete, so resuming only truncates the
+	// interrupted write and does not need to consume.
+	if _, err := Resume(context.Background(), f); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Size() != int64(complete) {
+		t.Errorf("got size %d != exp %d after resume", fi.Size(), complete)
+	}
+}
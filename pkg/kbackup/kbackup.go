@@ -0,0 +1,370 @@
+// Package kbackup backs up Kafka topics to files and restores them.
+//
+// A backup is a snapshot: when a backup begins, the start and end offsets of
+// every partition in the requested topics are captured into a manifest, and
+// each partition is consumed from its start offset up to its captured end
+// offset. Records produced after the backup begins are not included. Records
+// are consumed with the read committed isolation level, meaning aborted
+// transactional records are not backed up, and the captured end offsets are
+// last stable offsets.
+//
+// Backups are written in a compact binary format (see Writer and Reader) that
+// keeps each record's partition, offset, timestamp, key, value, and headers.
+// Every frame in the file is checksummed, so a backup that was interrupted can
+// be continued with Resume, which drops any partially written trailing frame
+// and continues each partition after the last offset that was written.
+//
+// Restore produces a backup's records back to their original partitions, in
+// order, optionally into renamed topics, no matter the producer's partitioner.
+package kbackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// Backup backs up the given topics to w.
+//
+// The client options are used to create the clients that list offsets and
+// consume; they should contain connection options (seed brokers, TLS, SASL,
+// etc.), but not consumer options. This function configures consuming itself.
+// The returned manifest describes what was backed up.
+//
+// If the context is canceled, this flushes what was consumed so far and returns
+// the context error; the backup can be continued with Resume.
+func Backup(ctx context.Context, w io.Writer, topics []string, clientOpts ...kgo.Opt) (Manifest, error) {
+	if len(topics) == 0 {
+		return Manifest{}, errors.New("kbackup: no topics to back up")
+	}
+	m, err := listManifest(ctx, topics, clientOpts)
+	if err != nil {
+		return m, err
+	}
+	bw, err := NewWriter(w, m)
+	if err != nil {
+		return m, err
+	}
+	from := make(map[string]map[int32]int64, len(m.Partitions))
+	for t, ps := range m.Partitions {
+		from[t] = make(map[int32]int64, len(ps))
+		for p, r := range ps {
+			from[t][p] = r.Start
+		}
+	}
+	return m, consume(ctx, bw, m, from, clientOpts)
+}
+
+// Resume continues an interrupted backup in f, which must be opened for
+// reading and writing. Any partially written trailing data is truncated, and
+// each partition continues from after the last offset in the backup up to the
+// end offset in the original manifest.
+//
+// The client options are used the same as in Backup.
+func Resume(ctx context.Context, f *os.File, clientOpts ...kgo.Opt) (Manifest, error) {
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return Manifest{}, err
+	}
+	br, err := NewReader(f)
+	if err != nil {
+		return Manifest{}, err
+	}
+	m := br.Manifest()
+	from := make(map[string]map[int32]int64, len(m.Partitions))
+	for t, ps := range m.Partitions {
+		from[t] = make(map[int32]int64, len(ps))
+		for p, r := range ps {
+			from[t][p] = r.Start
+		}
+	}
+	for {
+		r, err := br.Next()
+		if err == io.EOF || err == ErrCorrupt {
+			break
+		} else if err != nil {
+			return m, err
+		}
+		if ps, ok := from[r.Topic]; ok && r.Offset+1 > ps[r.Partition] {
+			ps[r.Partition] = r.Offset + 1
+		}
+	}
+
+	if err := f.Truncate(br.read); err != nil {
+		return m, err
+	}
+	if _, err := f.Seek(br.read, io.SeekStart); err != nil {
+		return m, err
+	}
+	return m, consume(ctx, newAppendWriter(f, &m), m, from, clientOpts)
+}
+
+// listManifest lists the partitions and offset ranges for all topics.
+func listManifest(ctx context.Context, topics []string, clientOpts []kgo.Opt) (Manifest, error) {
+	m := Manifest{
+		Created:    time.Now(),
+		Partitions: make(map[string]map[int32]Range, len(topics)),
+	}
+
+	cl, err := kgo.NewClient(clientOpts...)
+	if err != nil {
+		return m, err
+	}
+	defer cl.Close()
+
+	metaReq := kmsg.NewPtrMetadataRequest()
+	for _, t := range topics {
+		rt := kmsg.NewMetadataRequestTopic()
+		rt.Topic = kmsg.StringPtr(t)
+		metaReq.Topics = append(metaReq.Topics, rt)
+	}
+	metaResp, err := metaReq.RequestWith(ctx, cl)
+	if err != nil {
+		return m, fmt.Errorf("kbackup: unable to request metadata: %w", err)
+	}
+	for _, t := range metaResp.Topics {
+		if err := kerr.ErrorForCode(t.ErrorCode); err != nil {
+			return m, fmt.Errorf("kbackup: unable to load metadata for topic %q: %w", *t.Topic, err)
+		}
+		ps := make(map[int32]Range, len(t.Partitions))
+		for _, p := range t.Partitions {
+			ps[p.Partition] = Range{}
+		}
+		m.Partitions[*t.Topic] = ps
+	}
+
+	for _, list := range []struct {
+		isolation int8
+		timestamp int64
+		set       func(*Range, int64)
+	}{
+		{0, -2, func(r *Range, o int64) { r.Start = o }},
+		{1, -1, func(r *Range, o int64) { r.End = o }},
+	} {
+		req := kmsg.NewPtrListOffsetsRequest()
+		req.IsolationLevel = list.isolation
+		for t, ps := range m.Partitions {
+			rt := kmsg.NewListOffsetsRequestTopic()
+			rt.Topic = t
+			for p := range ps {
+				rp := kmsg.NewListOffsetsRequestTopicPartition()
+				rp.Partition = p
+				rp.Timestamp = list.timestamp
+				rt.Partitions = append(rt.Partitions, rp)
+			}
+			req.Topics = append(req.Topics, rt)
+		}
+		resp, err := req.RequestWith(ctx, cl)
+		if err != nil {
+			return m, fmt.Errorf("kbackup: unable to list offsets: %w", err)
+		}
+		for _, t := range resp.Topics {
+			for _, p := range t.Partitions {
+				if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+					return m, fmt.Errorf("kbackup: unable to list offsets for %s[%d]: %w", t.Topic, p.Partition, err)
+				}
+				r := m.Partitions[t.Topic][p.Partition]
+				list.set(&r, p.Offset)
+				m.Partitions[t.Topic][p.Partition] = r
+			}
+		}
+	}
+	return m, nil
+}
+
+// consume consumes every partition from its from offset up to its manifest end
+// offset, writing records to w.
+func consume(ctx context.Context, w *Writer, m Manifest, from map[string]map[int32]int64, clientOpts []kgo.Opt) error {
+	offsets := make(map[string]map[int32]kgo.Offset)
+	for t, ps := range from {
+		for p, at := range ps {
+			if at >= m.Partitions[t][p].End {
+				continue
+			}
+			if offsets[t] == nil {
+				offsets[t] = make(map[int32]kgo.Offset)
+			}
+			offsets[t][p] = kgo.NewOffset().At(at)
+		}
+	}
+	if len(offsets) == 0 {
+		return w.Flush()
+	}
+
+	// We consume until the end that each partition has when we begin
+	// fetching it, which is at or after the manifest end. The client tracks
+	// its fetch position, so partitions finish even if the records just
+	// before the end were compacted away, were aborted, or are transaction
+	// markers. Records at or after the manifest end are dropped.
+	cl, err := kgo.NewClient(append(clientOpts[:len(clientOpts):len(clientOpts)],
+		kgo.ConsumePartitions(offsets),
+		kgo.FetchIsolationLevel(kgo.ReadCommitted()),
+		kgo.ConsumeUntilEnd(),
+	)...)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+
+	for {
+		fs := cl.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			if ferr := w.Flush(); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+		if fs.IsConsumedToEnd() {
+			return w.Flush()
+		}
+		var err error
+		fs.EachError(func(t string, p int32, ferr error) {
+			if err == nil {
+				err = fmt.Errorf("kbackup: unable to consume %s[%d]: %w", t, p, ferr)
+			}
+		})
+		if err != nil {
+			if ferr := w.Flush(); ferr != nil {
+				return ferr
+			}
+			return err
+		}
+
+		fs.EachRecord(func(r *kgo.Record) {
+			if err == nil && r.Offset < m.Partitions[r.Topic][r.Partition].End {
+				err = w.Write(r)
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// RestoreOpt is an option to configure Restore.
+type RestoreOpt interface {
+	apply(*restoreCfg)
+}
+
+type restoreOpt struct{ fn func(*restoreCfg) }
+
+func (o restoreOpt) apply(cfg *restoreCfg) { o.fn(cfg) }
+
+type restoreCfg struct {
+	rename map[string]string
+}
+
+// RenameTopics restores records from topics in the backup into differently
+// named topics. Topics not in the map are restored with their original name.
+func RenameTopics(from2to map[string]string) RestoreOpt {
+	return restoreOpt{func(cfg *restoreCfg) { cfg.rename = from2to }}
+}
+
+// restoreBatchBytes bounds the key, value, and header bytes of the records
+// that Restore produces as one batch. This is well below the default maximum
+// batch size of producers and brokers (1MB), leaving room for record overhead.
+const restoreBatchBytes = 256 << 10
+
+// Restore produces every record in the backup read from r with cl, returning
+// the number of records produced.
+//
+// Records are restored to their original partitions no matter the client's
+// partitioner: consecutive records for the same partition are produced
+// together with ProduceBatch, so the client must not be configured with
+// ProducerSpillDir. Every restored topic must have at least as many partitions
+// as the backed up topic. Records are produced in order per partition with
+// their original keys, values, headers, and timestamps; offsets are assigned
+// by the destination.
+func Restore(ctx context.Context, cl *kgo.Client, r io.Reader, opts ...RestoreOpt) (int64, error) {
+	var cfg restoreCfg
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+
+	br, err := NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+
+	var (
+		mu       sync.Mutex
+		firstErr error
+		produced int64
+	)
+	promise := func(batch kgo.PartitionRecords, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if err != nil && firstErr == nil {
+			firstErr = err
+		} else if err == nil {
+			produced += int64(len(batch.Records))
+		}
+	}
+	failed := func() error {
+		mu.Lock()
+		defer mu.Unlock()
+		return firstErr
+	}
+
+	var (
+		batch kgo.PartitionRecords
+		size  int
+	)
+	flush := func() {
+		if len(batch.Records) > 0 {
+			cl.ProduceBatch(ctx, batch, promise)
+		}
+		batch, size = kgo.PartitionRecords{}, 0
+	}
+
+	var readErr error
+	for {
+		rec, err := br.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			readErr = err
+			break
+		}
+		if err := failed(); err != nil {
+			break
+		}
+		if to, ok := cfg.rename[rec.Topic]; ok {
+			rec.Topic = to
+		}
+
+		recSize := len(rec.Key) + len(rec.Value)
+		for _, h := range rec.Headers {
+			recSize += len(h.Key) + len(h.Value)
+		}
+		if rec.Topic != batch.Topic || rec.Partition != batch.Partition || size+recSize > restoreBatchBytes {
+			flush()
+			batch.Topic, batch.Partition = rec.Topic, rec.Partition
+		}
+		batch.Records = append(batch.Records, rec)
+		size += recSize
+	}
+	if failed() == nil {
+		flush()
+	}
+
+	// We must wait for every promise before reading what was produced.
+	flushErr := cl.Flush(ctx)
+
+	mu.Lock()
+	defer mu.Unlock()
+	switch {
+	case readErr != nil:
+		return produced, readErr
+	case flushErr != nil:
+		return produced, flushErr
+	}
+	return produced, firstErr
+}
@@ -77,6 +77,13 @@ type Client struct {
 	compressor   *compressor
 	decompressor *decompressor
 
+	// topicCfgs and topicCompressors are the producer configurations and
+	// compressors for topics in ProducerTopicOverrides.
+	topicCfgs        map[string]*cfg
+	topicCompressors map[string]*compressor
+
+	spill *spill // non-nil if using ProducerSpillDir
+
 	coordinatorsMu sync.Mutex
 	coordinators   map[coordinatorKey]*coordinatorLoad
 
@@ -92,6 +99,35 @@ type Client struct {
 
 func (cl *Client) idempotent() bool { return !cl.cfg.disableIdempotency }
 
+// initTopicCfgs initializes the producer configurations and compressors for
+// topics in ProducerTopicOverrides.
+func (cl *Client) initTopicCfgs() error {
+	if len(cl.cfg.topicOverrides) == 0 {
+		return nil
+	}
+	cl.topicCfgs = make(map[string]*cfg, len(cl.cfg.topicOverrides))
+	cl.topicCompressors = make(map[string]*compressor, len(cl.cfg.topicOverrides))
+	for topic := range cl.cfg.topicOverrides {
+		tcfg, _ := cl.cfg.topicCfg(topic)
+		compressor, err := newCompressor(tcfg.compression...)
+		if err != nil {
+			return fmt.Errorf("invalid producer overrides for topic %q: %w", topic, err)
+		}
+		cl.topicCfgs[topic] = tcfg
+		cl.topicCompressors[topic] = compressor
+	}
+	return nil
+}
+
+// producerCfg returns the producer configuration for a topic, which is the
+// client configuration unless the topic is in ProducerTopicOverrides.
+func (cl *Client) producerCfg(topic string) *cfg {
+	if tcfg, ok := cl.topicCfgs[topic]; ok {
+		return tcfg
+	}
+	return &cl.cfg
+}
+
 type sinkAndSource struct {
 	sink   *sink
 	source *source
@@ -174,8 +210,6 @@ func NewClient(opts ...Opt) (*Client, error) {
 		bufPool: newBufPool(),
 		prsPool: newPrsPool(),
 
-		decompressor: newDecompressor(),
-
 		coordinators: make(map[coordinatorKey]*coordinatorLoad),
 
 		updateMetadataCh:     make(chan string, 1),
@@ -190,6 +224,24 @@ func NewClient(opts ...Opt) (*Client, error) {
 	}
 	cl.compressor = compressor
 
+	decompressor, err := newDecompressor(cl.cfg.decompressors, cl.cfg.zstdDicts)
+	if err != nil {
+		return nil, err
+	}
+	cl.decompressor = decompressor
+
+	if err := cl.initTopicCfgs(); err != nil {
+		return nil, err
+	}
+
+	if cfg.spillDir != "" {
+		spill, err := newSpill(cl)
+		if err != nil {
+			return nil, err
+		}
+		cl.spill = spill
+	}
+
 	// Before we start any goroutines below, we must notify any interested
 	// hooks of our existence.
 	cl.cfg.hooks.each(func(h Hook) {
@@ -213,6 +265,9 @@ func NewClient(opts ...Opt) (*Client, error) {
 	sort.Slice(cl.seeds, func(i, j int) bool { return cl.seeds[i].meta.NodeID < cl.seeds[j].meta.NodeID })
 	go cl.updateMetadataLoop()
 	go cl.reapConnectionsLoop()
+	if cl.spill != nil {
+		go cl.spill.loop()
+	}
 
 	return cl, nil
 }
@@ -616,6 +671,9 @@ func (cl *Client) Close() {
 	}
 
 	cl.failBufferedRecords(ErrClientClosed)
+	if cl.spill != nil {
+		cl.spill.close()
+	}
 
 	// We need one final poll: if any sources buffered a fetch, then the
 	// manageFetchConcurrency loop only exits when all fetches have been
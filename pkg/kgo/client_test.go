@@ -1,7 +1,9 @@
 package kgo
 
 import (
+	"context"
 	"testing"
+	"time"
 )
 
 func TestParseBrokerAddr(t *testing.T) {
@@ -93,3 +95,76 @@ func TestParseBrokerAddrErrors(t *testing.T) {
 		})
 	}
 }
+
+func TestProducerTopicOverrides(t *testing.T) {
+	cl, err := NewClient(
+		ProducerLinger(0),
+		ProducerTopicOverrides(map[string][]ProducerOpt{
+			"blobs": {
+				ProducerLinger(time.Second),
+				ProducerBatchMaxBytes(16 << 20),
+				ProducerBatchCompression(ZstdCompression()),
+			},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	if tcfg := cl.producerCfg("blobs"); tcfg.linger != time.Second || tcfg.maxRecordBatchBytes != 16<<20 {
+		t.Errorf("got blobs linger %v max bytes %d, exp 1s and %d", tcfg.linger, tcfg.maxRecordBatchBytes, 16<<20)
+	}
+	if tcfg := cl.producerCfg("events"); tcfg != &cl.cfg {
+		t.Error("expected topic without overrides to use the client cfg")
+	}
+	if c := cl.topicCompressors["blobs"]; c == nil || len(c.options) != 1 || c.options[0] != 4 {
+		t.Errorf("got blobs compressor %v, exp zstd", c)
+	}
+
+	// Options that cannot be overridden have no effect.
+	cl2, err := NewClient(
+		MaxBufferedRecords(100),
+		ProducerTopicOverrides(map[string][]ProducerOpt{
+			"foo": {MaxBufferedRecords(1), ProducerLinger(time.Second)},
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl2.Close()
+	if tcfg := cl2.producerCfg("foo"); tcfg.maxBufferedRecords != 100 || tcfg.linger != time.Second {
+		t.Errorf("got foo max buffered %d linger %v, exp 100 and 1s", tcfg.maxBufferedRecords, tcfg.linger)
+	}
+
+	for _, opts := range [][]ProducerOpt{
+		{RequiredAcks(LeaderAck())},
+		{ProducerLinger(time.Hour)},
+	} {
+		if _, err := NewClient(ProducerTopicOverrides(map[string][]ProducerOpt{"foo": opts})); err == nil {
+			t.Error("expected error for invalid producer topic override")
+		}
+	}
+}
+
+func TestAddGroupOffsetsToTransaction(t *testing.T) {
+	offsets := map[string]map[int32]EpochOffset{"foo": {0: {-1, 1}}}
+
+	cl, err := NewClient(SeedBrokers("127.0.0.1:1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+	if err := cl.AddGroupOffsetsToTransaction(context.Background(), "g", offsets, nil); err != errNotTransactional {
+		t.Errorf("got err %v != errNotTransactional", err)
+	}
+
+	txn, err := NewClient(SeedBrokers("127.0.0.1:1"), TransactionalID("txn"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer txn.Close()
+	if err := txn.AddGroupOffsetsToTransaction(context.Background(), "g", offsets, nil); err != errNotInTransaction {
+		t.Errorf("got err %v != errNotInTransaction", err)
+	}
+}
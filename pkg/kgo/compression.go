@@ -5,6 +5,7 @@ import (
 	"compress/gzip"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"runtime"
 	"sync"
@@ -32,23 +33,56 @@ var sliceWriters = sync.Pool{New: func() interface{} { r := make([]byte, 8<<10);
 type CompressionCodec struct {
 	codec int8 // 1: gzip, 2: snappy, 3: lz4, 4: zstd
 	level int8
+
+	// opts is nil unless options beyond the level are used. The options
+	// are never modified once created, and keeping them behind a pointer
+	// keeps CompressionCodec comparable.
+	opts *codecOpts
+}
+
+type codecOpts struct {
+	compressor   Compressor // if non-nil, used rather than our implementation
+	lz4BlockSize int        // if non-zero, the lz4 block size
+	zstdWindow   int        // if non-zero, the zstd window size
+	zstdDict     []byte     // if non-nil, the zstd dictionary to compress with
+}
+
+// withOpts returns the codec with a copy of its options modified by fn.
+func (c CompressionCodec) withOpts(fn func(*codecOpts)) CompressionCodec {
+	opts := new(codecOpts)
+	if c.opts != nil {
+		*opts = *c.opts
+	}
+	fn(opts)
+	c.opts = opts
+	return c
+}
+
+// options returns the codec's options, which are empty if there are none.
+func (c CompressionCodec) options() codecOpts {
+	if c.opts == nil {
+		return codecOpts{}
+	}
+	return *c.opts
 }
 
 // NoCompression is a compression option that avoids compression. This can
 // always be used as a fallback compression.
-func NoCompression() CompressionCodec { return CompressionCodec{0, 0} }
+func NoCompression() CompressionCodec { return CompressionCodec{codec: 0} }
 
 // GzipCompression enables gzip compression with the default compression level.
-func GzipCompression() CompressionCodec { return CompressionCodec{1, gzip.DefaultCompression} }
+func GzipCompression() CompressionCodec {
+	return CompressionCodec{codec: 1, level: gzip.DefaultCompression}
+}
 
 // SnappyCompression enables snappy compression.
-func SnappyCompression() CompressionCodec { return CompressionCodec{2, 0} }
+func SnappyCompression() CompressionCodec { return CompressionCodec{codec: 2} }
 
 // Lz4Compression enables lz4 compression with the fastest compression level.
-func Lz4Compression() CompressionCodec { return CompressionCodec{3, 0} }
+func Lz4Compression() CompressionCodec { return CompressionCodec{codec: 3} }
 
 // ZstdCompression enables zstd compression with the default compression level.
-func ZstdCompression() CompressionCodec { return CompressionCodec{4, 0} }
+func ZstdCompression() CompressionCodec { return CompressionCodec{codec: 4} }
 
 // WithLevel changes the compression codec's "level", effectively allowing for
 // higher or lower compression ratios at the expense of CPU speed.
@@ -65,8 +99,61 @@ func (c CompressionCodec) WithLevel(level int) CompressionCodec {
 	return c
 }
 
+// Compressor is an alternative implementation of a compression codec, such as
+// a cgo zstd implementation or a faster snappy implementation. Compressors must
+// be safe for concurrent use.
+type Compressor interface {
+	// Compress appends the compressed form of src to dst and returns the
+	// updated dst. The output must be readable by any implementation of
+	// the codec, since it is decompressed by any consumer.
+	Compress(dst, src []byte) ([]byte, error)
+}
+
+// Decompressor is an alternative implementation of a decompression codec.
+// Decompressors must be safe for concurrent use. See ConsumeDecompressor.
+type Decompressor interface {
+	// Decompress returns the decompressed form of src.
+	Decompress(src []byte) ([]byte, error)
+}
+
+// WithCompressor returns the codec using compressor rather than this package's
+// implementation of the codec. The codec's level and any other codec options
+// are ignored; compressor is responsible for its own options.
+//
+// If compressing fails, records are produced uncompressed.
+func (c CompressionCodec) WithCompressor(compressor Compressor) CompressionCodec {
+	return c.withOpts(func(opts *codecOpts) { opts.compressor = compressor })
+}
+
+// WithLz4BlockSize returns the codec using the given lz4 block size, which
+// must be 64KiB, 256KiB, 1MiB, or 4MiB (the default). This option is ignored
+// for codecs other than lz4, and invalid sizes cause client creation to fail.
+func (c CompressionCodec) WithLz4BlockSize(size int) CompressionCodec {
+	return c.withOpts(func(opts *codecOpts) { opts.lz4BlockSize = size })
+}
+
+// WithZstdWindowSize returns the codec using the given zstd window size, which
+// must be a power of two between 1KiB and 512MiB. The default is 64KiB. This
+// option is ignored for codecs other than zstd, and invalid sizes cause client
+// creation to fail.
+func (c CompressionCodec) WithZstdWindowSize(size int) CompressionCodec {
+	return c.withOpts(func(opts *codecOpts) { opts.zstdWindow = size })
+}
+
+// WithZstdDictionary returns the codec compressing with the given zstd
+// dictionary, which must be in the zstd dictionary format, such as those
+// created by "zstd --train". Consumers must load the dictionary with
+// ConsumeZstdDictionaries to decompress batches compressed with it. This
+// option is ignored for codecs other than zstd, and invalid dictionaries cause
+// client creation to fail.
+func (c CompressionCodec) WithZstdDictionary(dict []byte) CompressionCodec {
+	dict = append([]byte(nil), dict...)
+	return c.withOpts(func(opts *codecOpts) { opts.zstdDict = dict })
+}
+
 type compressor struct {
 	options  []int8
+	custom   [5]Compressor // per codec, see WithCompressor
 	gzPool   sync.Pool
 	lz4Pool  sync.Pool
 	zstdPool sync.Pool
@@ -100,13 +187,18 @@ func newCompressor(codecs ...CompressionCodec) (*compressor, error) {
 out:
 	for _, codec := range codecs {
 		c.options = append(c.options, codec.codec)
+		extra := codec.options()
+		if extra.compressor != nil && codec.codec != 0 {
+			c.custom[codec.codec] = extra.compressor
+			continue
+		}
 		switch codec.codec {
 		case 0:
 			break out
 		case 1:
 			level := gzip.DefaultCompression
 			if codec.level != 0 {
-				if _, err := gzip.NewWriterLevel(nil, int(codec.level)); err != nil {
+				if _, err := gzip.NewWriterLevel(nil, int(codec.level)); err == nil {
 					level = int(codec.level)
 				}
 			}
@@ -116,17 +208,29 @@ out:
 			if level < 0 {
 				level = 0 // 0 == lz4.Fast
 			}
-			fn := func() interface{} { return lz4.NewWriter(new(bytes.Buffer)) }
+			var opts []lz4.Option
 			w := lz4.NewWriter(new(bytes.Buffer))
 			if err := w.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(level))); err == nil {
-				fn = func() interface{} {
-					w := lz4.NewWriter(new(bytes.Buffer))
-					w.Apply(lz4.CompressionLevelOption(lz4.CompressionLevel(level)))
-					return w
-				}
+				opts = append(opts, lz4.CompressionLevelOption(lz4.CompressionLevel(level)))
 			}
 			w.Close()
-			c.lz4Pool = sync.Pool{New: fn}
+			if extra.lz4BlockSize != 0 {
+				// A writer that fails applying options stays
+				// failed, so we check user options separately.
+				blockSize := lz4.BlockSizeOption(lz4.BlockSize(extra.lz4BlockSize))
+				w := lz4.NewWriter(new(bytes.Buffer))
+				err := w.Apply(append(opts, blockSize)...)
+				w.Close()
+				if err != nil {
+					return nil, fmt.Errorf("invalid lz4 block size %d: %w", extra.lz4BlockSize, err)
+				}
+				opts = append(opts, blockSize)
+			}
+			c.lz4Pool = sync.Pool{New: func() interface{} {
+				w := lz4.NewWriter(new(bytes.Buffer))
+				w.Apply(opts...)
+				return w
+			}}
 		case 4:
 			opts := []zstd.EOption{
 				zstd.WithWindowSize(64 << 10),
@@ -144,6 +248,21 @@ out:
 				zstdEnc.Close()
 				opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevel(codec.level)))
 			}
+			var zstdExtra []zstd.EOption
+			if extra.zstdWindow != 0 {
+				zstdExtra = append(zstdExtra, zstd.WithWindowSize(extra.zstdWindow))
+			}
+			if extra.zstdDict != nil {
+				zstdExtra = append(zstdExtra, zstd.WithEncoderDict(extra.zstdDict))
+			}
+			if len(zstdExtra) > 0 {
+				zstdEnc, err := zstd.NewWriter(nil, append(opts, zstdExtra...)...)
+				if err != nil {
+					return nil, fmt.Errorf("invalid zstd options: %w", err)
+				}
+				zstdEnc.Close()
+				opts = append(opts, zstdExtra...)
+			}
 			c.zstdPool = sync.Pool{New: fn}
 		}
 	}
@@ -176,6 +295,15 @@ func (c *compressor) compress(dst *sliceWriter, src []byte, produceRequestVersio
 		break
 	}
 
+	if custom := c.custom[use]; custom != nil {
+		compressed, err := custom.Compress(dst.inner, src)
+		if err != nil {
+			return nil, -1
+		}
+		dst.inner = compressed
+		return dst.inner, use
+	}
+
 	switch use {
 	case 0:
 		return src, 0
@@ -213,12 +341,26 @@ func (c *compressor) compress(dst *sliceWriter, src []byte, produceRequestVersio
 }
 
 type decompressor struct {
+	custom     [5]Decompressor // per codec, see ConsumeDecompressor
 	ungzPool   sync.Pool
 	unlz4Pool  sync.Pool
 	unzstdPool sync.Pool
 }
 
-func newDecompressor() *decompressor {
+func newDecompressor(custom map[int8]Decompressor, zstdDicts [][]byte) (*decompressor, error) {
+	zstdOpts := []zstd.DOption{
+		zstd.WithDecoderLowmem(true),
+		zstd.WithDecoderConcurrency(1),
+	}
+	if len(zstdDicts) > 0 {
+		zstdOpts = append(zstdOpts, zstd.WithDecoderDicts(zstdDicts...))
+		zstdDec, err := zstd.NewReader(nil, zstdOpts...)
+		if err != nil {
+			return nil, fmt.Errorf("invalid zstd dictionaries: %w", err)
+		}
+		zstdDec.Close()
+	}
+
 	d := &decompressor{
 		ungzPool: sync.Pool{
 			New: func() interface{} { return new(gzip.Reader) },
@@ -228,10 +370,7 @@ func newDecompressor() *decompressor {
 		},
 		unzstdPool: sync.Pool{
 			New: func() interface{} {
-				zstdDec, _ := zstd.NewReader(nil,
-					zstd.WithDecoderLowmem(true),
-					zstd.WithDecoderConcurrency(1),
-				)
+				zstdDec, _ := zstd.NewReader(nil, zstdOpts...)
 				r := &zstdDecoder{zstdDec}
 				runtime.SetFinalizer(r, func(r *zstdDecoder) {
 					r.inner.Close()
@@ -240,7 +379,13 @@ func newDecompressor() *decompressor {
 			},
 		},
 	}
-	return d
+	for codec, decompressor := range custom {
+		if codec <= 0 || int(codec) >= len(d.custom) {
+			return nil, errors.New("unknown compression codec")
+		}
+		d.custom[codec] = decompressor
+	}
+	return d, nil
 }
 
 type zstdDecoder struct {
@@ -248,6 +393,14 @@ type zstdDecoder struct {
 }
 
 func (d *decompressor) decompress(src []byte, codec byte) ([]byte, error) {
+	if int(codec) < len(d.custom) && d.custom[codec] != nil {
+		custom := d.custom[codec]
+		if codec == 2 && len(src) > 16 && bytes.HasPrefix(src, xerialPfx) {
+			return xerialDecode(src, custom)
+		}
+		return custom.Decompress(src)
+	}
+
 	switch codec {
 	case 0:
 		return src, nil
@@ -260,7 +413,7 @@ func (d *decompressor) decompress(src []byte, codec byte) ([]byte, error) {
 		return ioutil.ReadAll(ungz)
 	case 2:
 		if len(src) > 16 && bytes.HasPrefix(src, xerialPfx) {
-			return xerialDecode(src)
+			return xerialDecode(src, nil)
 		}
 		return s2.Decode(nil, src)
 	case 3:
@@ -281,7 +434,9 @@ var xerialPfx = []byte{130, 83, 78, 65, 80, 80, 89, 0}
 
 var errMalformedXerial = errors.New("malformed xerial framing")
 
-func xerialDecode(src []byte) ([]byte, error) {
+// xerialDecode decodes xerial framed snappy chunks with custom, or with s2 if
+// custom is nil.
+func xerialDecode(src []byte, custom Decompressor) ([]byte, error) {
 	// bytes 0-8: xerial header
 	// bytes 8-16: xerial version
 	// everything after: uint32 chunk size, snappy chunk
@@ -298,7 +453,12 @@ func xerialDecode(src []byte) ([]byte, error) {
 		if size < 0 || len(src) < int(size) {
 			return nil, errMalformedXerial
 		}
-		if chunk, err = s2.Decode(chunk[:cap(chunk)], src[:size]); err != nil {
+		if custom != nil {
+			chunk, err = custom.Decompress(src[:size])
+		} else {
+			chunk, err = s2.Decode(chunk[:cap(chunk)], src[:size])
+		}
+		if err != nil {
 			return nil, err
 		}
 		src = src[size:]
@@ -2,11 +2,15 @@ package kgo
 
 import (
 	"bytes"
+	"compress/gzip"
 	"encoding/base64"
 	"fmt"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"testing"
+
+	"github.com/klauspost/compress/s2"
 )
 
 func TestNewCompressor(t *testing.T) {
@@ -45,9 +49,35 @@ func TestNewCompressor(t *testing.T) {
 	}
 }
 
+func TestGzipLevel(t *testing.T) {
+	t.Parallel()
+	in := bytes.Repeat([]byte("abcdefghijklmno pqrs tuvwxy   z"), 100)
+	for _, level := range []int{gzip.BestSpeed, gzip.BestCompression, 127} {
+		c, err := newCompressor(GzipCompression().WithLevel(level))
+		if err != nil {
+			t.Fatalf("level %d: unexpected err: %v", level, err)
+		}
+		w := sliceWriters.Get().(*sliceWriter)
+		got, _ := c.compress(w, in, 99)
+
+		expLevel := level
+		if level == 127 {
+			expLevel = gzip.DefaultCompression // invalid levels use the default
+		}
+		var exp bytes.Buffer
+		gz, _ := gzip.NewWriterLevel(&exp, expLevel)
+		gz.Write(in)
+		gz.Close()
+		if !bytes.Equal(got, exp.Bytes()) {
+			t.Errorf("level %d: compressed output does not match gzip at level %d", level, expLevel)
+		}
+		sliceWriters.Put(w)
+	}
+}
+
 func TestCompressDecompress(t *testing.T) {
 	t.Parallel()
-	d := newDecompressor()
+	d, _ := newDecompressor(nil, nil)
 	in := []byte("foo")
 	var wg sync.WaitGroup
 	for _, produceVersion := range []int16{
@@ -151,7 +181,7 @@ func Test_xerialDecode(t *testing.T) {
 				t.Errorf("base64 decode error = %v", err)
 				return
 			}
-			got, err := xerialDecode(data)
+			got, err := xerialDecode(data, nil)
 			if (err != nil) != test.wantErr {
 				t.Errorf("xerialDecode() error = %v, wantErr %v", err, test.wantErr)
 				return
@@ -162,3 +192,148 @@ func Test_xerialDecode(t *testing.T) {
 		})
 	}
 }
+
+type countingSnappy struct{ compressed, decompressed int64 }
+
+func (c *countingSnappy) Compress(dst, src []byte) ([]byte, error) {
+	atomic.AddInt64(&c.compressed, 1)
+	return append(dst, s2.EncodeSnappy(nil, src)...), nil
+}
+
+func (c *countingSnappy) Decompress(src []byte) ([]byte, error) {
+	atomic.AddInt64(&c.decompressed, 1)
+	return s2.Decode(nil, src)
+}
+
+func TestCustomCompression(t *testing.T) {
+	t.Parallel()
+	custom := new(countingSnappy)
+	c, err := newCompressor(SnappyCompression().WithCompressor(custom))
+	if err != nil {
+		t.Fatalf("unexpected compressor err: %v", err)
+	}
+	d, err := newDecompressor(map[int8]Decompressor{2: custom}, nil)
+	if err != nil {
+		t.Fatalf("unexpected decompressor err: %v", err)
+	}
+
+	in := []byte("foo bar baz foo bar baz")
+	w := sliceWriters.Get().(*sliceWriter)
+	defer sliceWriters.Put(w)
+	compressed, used := c.compress(w, in, 99)
+	if used != 2 {
+		t.Fatalf("got codec %d != exp 2", used)
+	}
+	got, err := d.decompress(compressed, 2)
+	if err != nil || !bytes.Equal(got, in) {
+		t.Errorf("got decompressed %q (err %v) != exp %q", got, err, in)
+	}
+
+	// Xerial framing is removed before calling custom decompressors.
+	xerial, _ := base64.StdEncoding.DecodeString("glNOQVBQWQAAAAABAAAAAQAAAA8NMEhlbGxvLCBXb3JsZCE=")
+	if got, err := d.decompress(xerial, 2); err != nil || string(got) != "Hello, World!" {
+		t.Errorf("got xerial decompressed %q (err %v) != exp %q", got, err, "Hello, World!")
+	}
+	if c, d := atomic.LoadInt64(&custom.compressed), atomic.LoadInt64(&custom.decompressed); c != 1 || d != 2 {
+		t.Errorf("got %d compresses and %d decompresses != exp 1 and 2", c, d)
+	}
+
+	if _, err := newDecompressor(map[int8]Decompressor{0: custom}, nil); err == nil {
+		t.Error("unexpected success overriding no compression")
+	}
+	if _, err := newDecompressor(map[int8]Decompressor{5: custom}, nil); err == nil {
+		t.Error("unexpected success overriding unknown codec")
+	}
+}
+
+func TestCompressionOptions(t *testing.T) {
+	t.Parallel()
+	in := bytes.Repeat([]byte("abcdefghijklmno pqrs tuvwxy   z"), 100)
+	d, _ := newDecompressor(nil, nil)
+	for _, codec := range []CompressionCodec{
+		GzipCompression().WithLevel(9),
+		Lz4Compression().WithLz4BlockSize(64 << 10),
+		ZstdCompression().WithZstdWindowSize(1 << 20),
+	} {
+		c, err := newCompressor(codec)
+		if err != nil {
+			t.Errorf("codec %d: unexpected err: %v", codec.codec, err)
+			continue
+		}
+		w := sliceWriters.Get().(*sliceWriter)
+		compressed, used := c.compress(w, in, 99)
+		got, err := d.decompress(compressed, byte(used))
+		if used != codec.codec || err != nil || !bytes.Equal(got, in) {
+			t.Errorf("codec %d: got used %d, err %v, equal %v", codec.codec, used, err, bytes.Equal(got, in))
+		}
+		sliceWriters.Put(w)
+	}
+
+	for _, codec := range []CompressionCodec{
+		Lz4Compression().WithLz4BlockSize(123),
+		ZstdCompression().WithZstdWindowSize(3),
+		ZstdCompression().WithZstdDictionary([]byte("not a dictionary")),
+	} {
+		if _, err := newCompressor(codec); err == nil {
+			t.Errorf("codec %d: unexpected success with invalid options", codec.codec)
+		}
+	}
+
+	// Codecs stay comparable, and options do not leak into the codecs
+	// they were derived from.
+	base := Lz4Compression()
+	custom := base.WithCompressor(new(countingSnappy))
+	opts := custom.WithLz4BlockSize(64 << 10)
+	if base != Lz4Compression() || base == custom || custom == opts {
+		t.Error("unexpected codec equality")
+	}
+	if custom.options().lz4BlockSize != 0 || opts.options().compressor == nil {
+		t.Errorf("got options %+v and %+v, expected an lz4 block size only on the latter", custom.options(), opts.options())
+	}
+}
+
+func TestZstdDictionaries(t *testing.T) {
+	t.Parallel()
+
+	// A 1KiB dictionary with ID 42 trained with "zstd --train" on small
+	// JSON events, and an event compressed with the zstd CLI using it.
+	dict, _ := base64.StdEncoding.DecodeString("N6Qw7CoAAAAVEOgK0wEAAAARAfAopZRJJpnAcEJI4wMAAAAAAGHkhwEABAAAAAAAAACAHhAHFQBpBBU5LqEDAAAQCAAIxwAAAC4hANqNAAAAAIQWIDBEKGIAAAAAAAAAAAAAAAABAAAABAAAAAgAAAByX2lkIjo2NTM0OSwic3RhdHVzIjoiZmFpbGVkIiwicmVnaW9uIjoiZXUtd2VzdC0xIiwiYW1vdW50Ijo1OTR9eyJldmVudCI6Im9yZGVyX3NoaXBwZWQiLCJ1c2VyX2lkIjo1MzQ4OTYsInN0YXR1cyI6Im9rIiwicmVnaW9uIjoiYXAtc291dGgtMSIsImFtb3VudCI6NDQzfXsiZXZlbnQiOiJvcmRlcl9wYWlkIiwidXNlcl9pZCI6MjE0NzcyLCJzdGF0dXMiOiJwZW5kaW5nIiwicmVnaW9uIjoidXMtZWFzdC0xIiwiYW1vdW50Ijo5MjZ9eyJldmVudCI6Im9yZGVyX3NoaXBwZWQiLCJ1c2VyX2lkIjozNDc5OSwic3RhdHVzIjoicGVuZGluZyIsInJlZ2lvbiI6ImFwLXNvdXRoLTEiLCJhbW91bnQiOjEzMn17ImV2ZW50Ijoib3JkZXJfY3JlYXRlZCIsInVzZXJfaWQiOjI0MDM2NCwic3RhdHVzIjoiZmFpbGVkIiwicmVnaW9uIjoidXMtZWFzdC0xIiwiYW1vdW50IjoxMDZ9eyJldmVudCI6Im9yZGVyX3BhaWQiLCJ1c2VyX2lkIjo0MzA0Nywic3RhdHVzIjoicGVuZGluZyIsInJlZ2lvbiI6InVzLWVhc3QtMSIsImFtb3VudCI6MzI1fXsiZXZlbnQiOiJvcmRlcl9zaGlwcGVkIiwidXNlcl9pZCI6NjkwNzg3LCJzdGF0dXMiOiJwZW5kaW5nIiwicmVnaW9uIjoiZXUtd2VzdC0xIiwiYW1vdW50Ijo1MjR9eyJldmVudCI6Im9yZGVyX3NoaXBwZWQiLCJ1c2VyX2lkIjo3MjM2MzEsInN0YXR1cyI6Im9rIiwicmVnaW9uIjoiYXAtc291dGgtMSIsImFtb3VudCI6NzgwfXsiZXZlbnQiOiJvcmRlcl9jcmVhdGVkIiwidXNlcl9pZCI6Mjg1ODcsInN0YXR1cyI6Im9rIiwicmVnaW9uIjoiZXUtd2VzdC0xIiwiYW1vdW50Ijo3NDR9eyJldmVudCI6Im9yZGVyX2NyZWF0ZWQiLCJ1c2VyX2lkIjo4NjYyNTAsInN0YXR1cyI6Im9rIiwicmVnaW9uIjoidXMtZWFzdC0xIiwiYW1vdW50IjoyNDZ9eyJldmVudCI6Im9yZGVyX3BhaQ==")
+	cliCompressed, _ := base64.StdEncoding.DecodeString("KLUv/SUqWp0AAJMAAafQRucE/KyjVLBrPFyPGA31bG7t")
+	cliExp := `{"event":"order_created","user_id":596854,"status":"ok","region":"eu-west-1","amount":121}`
+
+	plain, _ := newDecompressor(nil, nil)
+	withDict, err := newDecompressor(nil, [][]byte{dict})
+	if err != nil {
+		t.Fatalf("unexpected dictionary err: %v", err)
+	}
+
+	if got, err := withDict.decompress(cliCompressed, 4); err != nil || string(got) != cliExp {
+		t.Errorf("got cli decompressed %q (err %v) != exp %q", got, err, cliExp)
+	}
+	if _, err := plain.decompress(cliCompressed, 4); err == nil {
+		t.Error("unexpected success decompressing dictionary frame without the dictionary")
+	}
+
+	c, err := newCompressor(ZstdCompression().WithZstdDictionary(dict))
+	if err != nil {
+		t.Fatalf("unexpected compressor err: %v", err)
+	}
+	in := []byte(`{"event":"order_paid","user_id":12345,"status":"pending","region":"us-east-1","amount":50}`)
+	w := sliceWriters.Get().(*sliceWriter)
+	defer sliceWriters.Put(w)
+	compressed, _ := c.compress(w, in, 99)
+	if got, err := withDict.decompress(compressed, 4); err != nil || !bytes.Equal(got, in) {
+		t.Errorf("got decompressed %q (err %v) != exp %q", got, err, in)
+	}
+
+	// Frames without a dictionary still decompress.
+	c, _ = newCompressor(ZstdCompression())
+	compressed, _ = c.compress(w, in, 99)
+	if got, err := withDict.decompress(compressed, 4); err != nil || !bytes.Equal(got, in) {
+		t.Errorf("got dictless decompressed %q (err %v) != exp %q", got, err, in)
+	}
+
+	if _, err := newDecompressor(nil, [][]byte{[]byte("not a dictionary")}); err == nil {
+		t.Error("unexpected success with an invalid dictionary")
+	}
+}
@@ -111,6 +111,7 @@ type cfg struct {
 	defaultProduceTopic string
 	maxRecordBatchBytes int32
 	maxBufferedRecords  int64
+	maxBufferedBytes    int64
 	produceTimeout      time.Duration
 	recordRetries       int64
 	maxUnknownFailures  int64
@@ -121,6 +122,11 @@ type cfg struct {
 
 	partitioner Partitioner
 
+	topicOverrides map[string][]ProducerOpt
+
+	spillDir      string
+	spillRestored func(*Record, error)
+
 	stopOnDataLoss bool
 	onDataLoss     func(string, int32)
 
@@ -136,10 +142,18 @@ type cfg struct {
 	isolationLevel int8
 	keepControl    bool
 	rack           string
+	untilEnd       bool
+	untilTime      bool
+	untilMilli     int64
+
+	onConsumedToEnd func(context.Context, *Client, map[string][]int32)
 
 	maxConcurrentFetches int
 	disableFetchSessions bool
 
+	decompressors map[int8]Decompressor
+	zstdDicts     [][]byte
+
 	topics     map[string]*regexp.Regexp   // topics to consume; if regex is true, values are compiled regular expressions
 	partitions map[string]map[int32]Offset // partitions to directly consume from
 	regex      bool
@@ -297,6 +311,7 @@ func (cfg *cfg) validate() error {
 
 		// Some random producer settings.
 		{name: "max buffered records", v: cfg.maxBufferedRecords, allowed: 1, badcmp: i64lt},
+		{name: "max buffered bytes", v: cfg.maxBufferedBytes, allowed: 0, badcmp: i64lt},
 		{name: "linger", v: int64(cfg.linger), allowed: int64(time.Minute), badcmp: i64gt, durs: true},
 		{name: "produce timeout", v: int64(cfg.produceTimeout), allowed: int64(100 * time.Millisecond), badcmp: i64lt, durs: true},
 		{name: "record timeout", v: int64(cfg.recordTimeout), allowed: int64(time.Second), badcmp: func(l, r int64) (bool, string) {
@@ -358,6 +373,16 @@ func (cfg *cfg) validate() error {
 		}
 	}
 
+	if cfg.untilEnd && (len(cfg.group) > 0 || cfg.regex) {
+		return errors.New("ConsumeUntilEnd and ConsumeTimeRange are only supported when directly consuming non-regex topics or partitions")
+	}
+	if cfg.untilTime && cfg.resetOffset.afterMilli && cfg.untilMilli <= cfg.resetOffset.at {
+		return errors.New("ConsumeTimeRange end must be after its start")
+	}
+	if cfg.onConsumedToEnd != nil && !cfg.untilEnd {
+		return errors.New("OnPartitionsConsumedToEnd requires ConsumeUntilEnd or ConsumeTimeRange")
+	}
+
 	if cfg.topics != nil && cfg.partitions != nil {
 		for topic := range cfg.partitions {
 			if _, exists := cfg.topics[topic]; exists {
@@ -382,6 +407,25 @@ func (cfg *cfg) validate() error {
 		return errors.New("invalid group partition assigned/revoked/lost functions set when a group was not specified")
 	}
 
+	if cfg.spillDir != "" {
+		if cfg.txnID != nil {
+			return errors.New("ProducerSpillDir cannot be used with transactions")
+		}
+		if cfg.manualFlushing {
+			return errors.New("ProducerSpillDir cannot be used with ManualFlushing")
+		}
+	}
+
+	for topic := range cfg.topicOverrides {
+		tcfg, applied := cfg.topicCfg(topic)
+		if applied.acks != cfg.acks {
+			return fmt.Errorf("invalid producer overrides for topic %q: RequiredAcks cannot be overridden per topic", topic)
+		}
+		if err := tcfg.validate(); err != nil {
+			return fmt.Errorf("invalid producer overrides for topic %q: %w", topic, err)
+		}
+	}
+
 	return nil
 }
 
@@ -915,6 +959,18 @@ func MaxBufferedRecords(n int) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.maxBufferedRecords = int64(n) }}
 }
 
+// MaxBufferedBytes sets the max amount of bytes the client will buffer,
+// blocking produces until records are finished if this limit is reached. A
+// record's bytes are the length of its key, value, and header keys and values.
+// This limit is enforced alongside MaxBufferedRecords, and by default there is
+// no byte limit.
+//
+// A record that is larger than this limit on its own is buffered only once
+// nothing else is buffered, rather than blocking forever.
+func MaxBufferedBytes(n int) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.maxBufferedBytes = int64(n) }}
+}
+
 // RecordPartitioner uses the given partitioner to partition records, overriding
 // the default UniformBytesPartitioner(64KiB, true, true, nil).
 func RecordPartitioner(partitioner Partitioner) ProducerOpt {
@@ -1011,11 +1067,107 @@ func ProducerLinger(linger time.Duration) ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.linger = linger }}
 }
 
+// ProducerTopicOverrides overrides producer options for specific topics, which
+// allows one client to produce to topics with different needs. For example,
+// latency sensitive events can be produced without lingering while large
+// blobs are produced to a different topic with lingering, compression, and
+// large batches.
+//
+// The following options can be overridden per topic:
+//
+//     ProducerBatchCompression
+//     ProducerBatchMaxBytes
+//     ProducerLinger
+//     RecordPartitioner
+//     RecordRetries
+//     RecordDeliveryTimeout
+//
+// Each topic's options are applied on top of the client's options. Other
+// options apply to the client as a whole or to entire produce requests, and
+// have no effect if used as an override, with the exception of RequiredAcks:
+// acks apply to entire produce requests, which can contain many topics, so
+// overriding acks is an error.
+func ProducerTopicOverrides(overrides map[string][]ProducerOpt) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.topicOverrides = overrides }}
+}
+
+// topicCfg returns the configuration for a topic in ProducerTopicOverrides,
+// which is this configuration with the topic's overrides applied, and the
+// configuration the overrides were applied to in full. Only the fields of
+// options that can be overridden are copied into the topic's configuration.
+func (cfg *cfg) topicCfg(topic string) (tcfg, applied *cfg) {
+	a := *cfg
+	a.topicOverrides = nil
+	for _, opt := range cfg.topicOverrides[topic] {
+		opt.apply(&a)
+	}
+
+	t := *cfg
+	t.topicOverrides = nil
+	t.compression = a.compression
+	t.maxRecordBatchBytes = a.maxRecordBatchBytes
+	t.linger = a.linger
+	t.partitioner = a.partitioner
+	t.recordRetries = a.recordRetries
+	t.recordTimeout = a.recordTimeout
+	return &t, &a
+}
+
+// ProducerSpillDir enables spilling records to disk in the given directory
+// when the client cannot buffer more records in memory, rather than blocking
+// in Produce or failing in TryProduce. This allows producing to continue
+// through broker outages without losing records.
+//
+// Once MaxBufferedRecords or MaxBufferedBytes is reached, produced records are
+// appended to a log in the directory. While any records are in the log, all
+// newly produced records are also appended to the log, and the log is
+// replayed into the client in order as space frees up. Replayed records go
+// through the client's normal producing path, meaning partitioning, ordering,
+// and idempotent sequence numbers work as they do for records that are never
+// spilled. Flush waits for all spilled records to be replayed and finished.
+//
+// While a record is spilled, the client keeps only its promise in memory: the
+// record's Key, Value, and Headers are cleared once they are written to the
+// log, and are read back into the same *Record when it is replayed or when its
+// promise is called. Promises and hooks therefore receive the same *Record
+// that was produced, but the record's data must not be read until its promise
+// is called. The log is split into segment files, and each segment is deleted
+// once every record in it is finished.
+//
+// Records in the log that are not yet finished survive a process restart:
+// when a client is created with the same directory, unfinished records are
+// replayed before any newly produced record. The promises for records from a
+// prior process are lost; these records use the promise set with
+// ProducerSpillRestoredPromise. If a spilled record is not finished when the
+// client is closed, its promise is called with ErrClientClosed and the record
+// is replayed by the next client using the directory. As records are written
+// without syncing, records survive a process crash but may not survive an
+// operating system crash. Records can be replayed more than once if the
+// process crashes after a record is produced but before it is recorded as
+// finished.
+//
+// Records in the log are always replayed, so to avoid losing records during
+// long outages, RecordDeliveryTimeout and RecordRetries should be left
+// unbounded (the default); a record that fails while replaying is failed as
+// usual and is not spilled again.
+//
+// Only one client at a time may use a directory. This option cannot be used
+// with transactions nor with ManualFlushing.
+func ProducerSpillDir(dir string) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.spillDir = dir }}
+}
+
+// ProducerSpillRestoredPromise sets the promise to use for spilled records
+// that were restored from a prior process when using ProducerSpillDir.
+func ProducerSpillRestoredPromise(promise func(*Record, error)) ProducerOpt {
+	return producerOpt{func(cfg *cfg) { cfg.spillRestored = promise }}
+}
+
 // ManualFlushing disables auto-flushing when producing. While you can still
 // set lingering, it would be useless to do so.
 //
-// With manual flushing, producing while MaxBufferedRecords have already been
-// produced and not flushed will return ErrMaxBuffered.
+// With manual flushing, producing while MaxBufferedRecords or MaxBufferedBytes
+// have already been produced and not flushed will return ErrMaxBuffered.
 func ManualFlushing() ProducerOpt {
 	return producerOpt{func(cfg *cfg) { cfg.manualFlushing = true }}
 }
@@ -1119,6 +1271,38 @@ func FetchMaxBytes(b int32) ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.maxBytes = b }}
 }
 
+// ConsumeDecompressor sets an alternative implementation to use when
+// decompressing batches compressed with the given codec, for example a cgo
+// zstd implementation or a faster snappy implementation. Only the type of the
+// codec is used; the codec's level and options are ignored. This option can be
+// used once per codec; NoCompression cannot be overridden.
+//
+// For snappy, batches produced with the Java client's xerial framing are
+// unframed before being passed to decompressor, with one call per chunk.
+func ConsumeDecompressor(codec CompressionCodec, decompressor Decompressor) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) {
+		if cfg.decompressors == nil {
+			cfg.decompressors = make(map[int8]Decompressor)
+		}
+		cfg.decompressors[codec.codec] = decompressor
+	}}
+}
+
+// ConsumeZstdDictionaries sets zstd dictionaries to use when decompressing zstd
+// batches, which is necessary to consume batches produced by producers that
+// compress with dictionaries (see CompressionCodec.WithZstdDictionary).
+// Dictionaries are loaded once when the client is
+// created, and the dictionary to decompress with is chosen by the dictionary
+// ID in each zstd frame. Batches compressed without a dictionary are still
+// decompressed normally.
+//
+// Dictionaries must be in the zstd dictionary format, such as those created by
+// "zstd --train"; invalid dictionaries cause client creation to fail. This
+// option is ignored if zstd has a decompressor set with ConsumeDecompressor.
+func ConsumeZstdDictionaries(dicts ...[]byte) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.zstdDicts = append(cfg.zstdDicts, dicts...) }}
+}
+
 // FetchMinBytes sets the minimum amount of bytes a broker will try to send
 // during a fetch, overriding the default 1 byte.
 //
@@ -1236,6 +1420,65 @@ func KeepControlRecords() ConsumerOpt {
 	return consumerOpt{func(cfg *cfg) { cfg.keepControl = true }}
 }
 
+// ConsumeUntilEnd sets the client to consume each partition only up to the end
+// offset it had when the partition was assigned, which allows for bounded
+// batch consuming.
+//
+// A partition's end offset is captured from the first fetch response for the
+// partition after it is assigned: the high watermark, or the last stable
+// offset if using the ReadCommitted isolation level. Records at or past the
+// end are not returned, and a partition stops being fetched once it is
+// consumed to its end. If a partition is reassigned (i.e., with SetOffsets),
+// its end offset is captured again.
+//
+// Once every partition has been consumed to its end and every record has been
+// polled, polling immediately returns a fake fetch with ErrConsumedToEnd,
+// which can be checked with Fetches.IsConsumedToEnd.
+//
+// This option is only supported for direct, non-regex consuming.
+func ConsumeUntilEnd() ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.untilEnd = true }}
+}
+
+// ConsumeTimeRange sets the client to consume only records in the time window
+// [start, end), which allows for replaying an exact window of time.
+//
+// This option sets the ConsumeResetOffset to NewOffset().AfterMilli(start), so
+// partitions begin at the first offset whose timestamp is at or after start.
+// When a partition is assigned, its end offset is listed by the end timestamp:
+// the first offset whose timestamp is at or after end. If no such offset
+// exists yet, the end is captured from the first fetch response as with
+// ConsumeUntilEnd. As well, each partition stops at the first (non-control)
+// record whose timestamp is at or after end, even if that record is before
+// the listed end offset.
+//
+// Offsets given to ConsumePartitions are used as is: exact offsets do not
+// have their end listed, but still stop at the end timestamp.
+//
+// This option implies ConsumeUntilEnd: once every partition has been consumed
+// to its end, polling returns a fake fetch with ErrConsumedToEnd. To know when
+// individual partitions are complete, use OnPartitionsConsumedToEnd.
+func ConsumeTimeRange(start, end time.Time) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) {
+		cfg.resetOffset = NewOffset().AfterMilli(start.UnixNano() / 1e6)
+		cfg.untilEnd = true
+		cfg.untilTime = true
+		cfg.untilMilli = end.UnixNano() / 1e6
+	}}
+}
+
+// OnPartitionsConsumedToEnd sets the function to be called when partitions are
+// consumed to their end with ConsumeUntilEnd or ConsumeTimeRange.
+//
+// This function is called in the polling goroutine at the start of a poll,
+// after the final records of the partitions have been returned from a prior
+// poll, or just before a poll returns ErrConsumedToEnd. The context is the
+// context passed to the poll, or the client's context if the poll was given a
+// nil context. Each partition is passed only once per assignment.
+func OnPartitionsConsumedToEnd(onConsumedToEnd func(context.Context, *Client, map[string][]int32)) ConsumerOpt {
+	return consumerOpt{func(cfg *cfg) { cfg.onConsumedToEnd = onConsumedToEnd }}
+}
+
 // ConsumeTopics adds topics to use for consuming.
 //
 // By default, consuming will start at the beginning of partitions. To change
@@ -195,6 +195,10 @@ type consumer struct {
 	sourcesReadyCond        *sync.Cond
 	sourcesReadyForDraining []*source
 	fakeReadyForDraining    []Fetch
+	consumedToEndWake       bool // set when a cursor is drained with ConsumeUntilEnd
+
+	consumedToEndMu sync.Mutex
+	consumedToEnd   map[string][]int32 // partitions drained since the last OnPartitionsConsumedToEnd call
 
 	pollWaitMu    sync.Mutex
 	pollWaitC     *sync.Cond
@@ -332,6 +336,49 @@ func (c *consumer) addFakeReadyForDraining(topic string, partition int32, err er
 	c.sourcesReadyCond.Broadcast()
 }
 
+// wakeConsumedToEnd wakes any blocked poll to check whether every partition
+// has been consumed to its end with ConsumeUntilEnd.
+func (c *consumer) wakeConsumedToEnd() {
+	c.sourcesReadyMu.Lock()
+	c.consumedToEndWake = true
+	c.sourcesReadyMu.Unlock()
+	c.sourcesReadyCond.Broadcast()
+}
+
+// addConsumedToEnd tracks a partition that was consumed to its end, to be
+// passed to OnPartitionsConsumedToEnd.
+func (c *consumer) addConsumedToEnd(topic string, partition int32) {
+	if c.cl.cfg.onConsumedToEnd == nil {
+		return
+	}
+	c.consumedToEndMu.Lock()
+	defer c.consumedToEndMu.Unlock()
+	if c.consumedToEnd == nil {
+		c.consumedToEnd = make(map[string][]int32)
+	}
+	c.consumedToEnd[topic] = append(c.consumedToEnd[topic], partition)
+}
+
+// notifyConsumedToEnd calls OnPartitionsConsumedToEnd with any partitions that
+// were consumed to their end since the last call.
+func (c *consumer) notifyConsumedToEnd(ctx context.Context) {
+	if c.cl.cfg.onConsumedToEnd == nil {
+		return
+	}
+	c.consumedToEndMu.Lock()
+	consumedToEnd := c.consumedToEnd
+	c.consumedToEnd = nil
+	c.consumedToEndMu.Unlock()
+
+	if len(consumedToEnd) == 0 {
+		return
+	}
+	if ctx == nil {
+		ctx = c.cl.ctx
+	}
+	c.cl.cfg.onConsumedToEnd(ctx, c.cl, consumedToEnd)
+}
+
 func errFetch(err error) Fetches {
 	return []Fetch{{
 		Topics: []FetchTopic{{
@@ -405,6 +452,10 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 		}
 	}
 
+	// Any partitions that were consumed to their end had their final
+	// records returned in a prior poll.
+	c.notifyConsumedToEnd(ctx)
+
 	var fetches Fetches
 	fill := func() {
 		if c.cl.cfg.blockRebalanceOnPoll {
@@ -454,6 +505,11 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 		fetches = append(fetches, c.fakeReadyForDraining...)
 		c.fakeReadyForDraining = nil
 
+		c.consumedToEndWake = false
+		if len(fetches) == 0 && c.d != nil && c.cl.cfg.untilEnd && c.d.consumedToEnd() {
+			fetches = errFetch(ErrConsumedToEnd)
+		}
+
 		c.sourcesReadyMu.Unlock()
 
 		if len(realFetches) == 0 {
@@ -477,41 +533,54 @@ func (cl *Client) PollRecords(ctx context.Context, maxPollRecords int) Fetches {
 	// We try filling fetches once before waiting. If we have no context,
 	// we guarantee that we just drain anything available and return.
 	fill()
+	if fetches.IsConsumedToEnd() {
+		c.notifyConsumedToEnd(ctx)
+	}
 	if len(fetches) > 0 || ctx == nil {
 		return fetches
 	}
 
-	done := make(chan struct{})
-	quit := false
-	go func() {
-		c.sourcesReadyMu.Lock()
-		defer c.sourcesReadyMu.Unlock()
-		defer close(done)
+	// If we are woken because a partition was consumed to its end, but
+	// not all partitions are done, we have nothing to return and wait
+	// again.
+	for {
+		done := make(chan struct{})
+		quit := false
+		go func() {
+			c.sourcesReadyMu.Lock()
+			defer c.sourcesReadyMu.Unlock()
+			defer close(done)
 
-		for !quit && len(c.sourcesReadyForDraining) == 0 {
-			c.sourcesReadyCond.Wait()
+			for !quit && len(c.sourcesReadyForDraining) == 0 && !c.consumedToEndWake {
+				c.sourcesReadyCond.Wait()
+			}
+		}()
+
+		exit := func() {
+			c.sourcesReadyMu.Lock()
+			quit = true
+			c.sourcesReadyMu.Unlock()
+			c.sourcesReadyCond.Broadcast()
 		}
-	}()
 
-	exit := func() {
-		c.sourcesReadyMu.Lock()
-		quit = true
-		c.sourcesReadyMu.Unlock()
-		c.sourcesReadyCond.Broadcast()
-	}
+		select {
+		case <-cl.ctx.Done():
+			exit()
+			return errFetch(ErrClientClosed)
+		case <-ctx.Done():
+			exit()
+			return errFetch(ctx.Err())
+		case <-done:
+		}
 
-	select {
-	case <-cl.ctx.Done():
-		exit()
-		return errFetch(ErrClientClosed)
-	case <-ctx.Done():
-		exit()
-		return errFetch(ctx.Err())
-	case <-done:
+		fill()
+		if fetches.IsConsumedToEnd() {
+			c.notifyConsumedToEnd(ctx)
+		}
+		if len(fetches) > 0 || !cl.cfg.untilEnd {
+			return fetches
+		}
 	}
-
-	fill()
-	return fetches
 }
 
 // AllowRebalance allows a consumer group to rebalance if it was blocked by you
@@ -1615,10 +1684,19 @@ func (s *consumerSession) handleListOrEpochResults(loaded loadedOffsets) (reload
 				tusing[load.partition] = offsetEpoch{load.offset, load.leaderEpoch}
 			}
 
-			load.cursor.setOffset(cursorOffset{
-				offset:            load.offset,
-				lastConsumedEpoch: load.leaderEpoch,
-			})
+			// If this is a reload, we keep any end offset that
+			// was captured for ConsumeUntilEnd.
+			loaded := load.cursor.cursorOffset
+			loaded.offset = load.offset
+			loaded.lastConsumedEpoch = load.leaderEpoch
+			if load.endKnown && !loaded.endKnown {
+				loaded.end = load.end
+				loaded.endKnown = true
+				if loaded.offset > loaded.end {
+					loaded.offset = loaded.end
+				}
+			}
+			load.cursor.setOffset(loaded)
 			load.cursor.allowUsable()
 			s.c.usingCursors.use(load.cursor)
 		}
@@ -1715,6 +1793,11 @@ type loadedOffset struct {
 	offset      int64
 	leaderEpoch int32
 
+	// With ConsumeTimeRange, end is the offset listed for the end
+	// timestamp, if one was found.
+	end      int64
+	endKnown bool
+
 	// Any error encountered for loading this partition, or for epoch
 	// loading, potentially ErrDataLoss. If this error is not retriable, we
 	// avoid reloading the offset and instead inject a fake partition for
@@ -1746,6 +1829,8 @@ func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker,
 		wg     sync.WaitGroup
 		kresp2 kmsg.Response
 		err2   error
+		kresp3 kmsg.Response
+		err3   error
 	)
 	if req2 != nil {
 		wg.Add(1)
@@ -1754,9 +1839,26 @@ func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker,
 			kresp2, err2 = broker.waitResp(ctx, req2)
 		}()
 	}
+	// With ConsumeTimeRange, we also list the end timestamp to know
+	// where each partition that is not loading an exact offset stops.
+	if cl.cfg.untilTime {
+		if req3 := load.buildUntilListReq(req1, cl.cfg.untilMilli); req3 != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				kresp3, err3 = broker.waitResp(ctx, req3)
+			}()
+		}
+	}
 	kresp, err := broker.waitResp(ctx, req1)
 	wg.Wait()
-	if err != nil || err2 != nil {
+	if err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = err3
+	}
+	if err != nil {
 		results <- loaded.addAll(load.errToLoaded(err))
 		return
 	}
@@ -1859,6 +1961,26 @@ func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker,
 		return offset
 	}
 
+	// The end timestamp response is only used to bound consuming, so
+	// rather than matching its shape, we only keep offsets for partitions
+	// that have no error. A partition whose timestamp is past its end
+	// has offset -1, in which case the end is captured when fetching.
+	ends := make(map[string]map[int32]int64)
+	if kresp3 != nil {
+		for _, t := range kresp3.(*kmsg.ListOffsetsResponse).Topics {
+			for i := range t.Partitions {
+				p := &t.Partitions[i]
+				if p.ErrorCode != 0 || len(p.OldStyleOffsets) > 0 || p.Offset < 0 {
+					continue
+				}
+				if ends[t.Topic] == nil {
+					ends[t.Topic] = make(map[int32]int64)
+				}
+				ends[t.Topic][p.Partition] = p.Offset
+			}
+		}
+	}
+
 	for i, rTopic := range resp.Topics {
 		topic := rTopic.Topic
 		loadParts, ok := load[topic]
@@ -1940,12 +2062,15 @@ func (cl *Client) listOffsetsForBrokerLoad(ctx context.Context, broker *broker,
 				offset = 0 // sanity
 			}
 
+			listedEnd, endKnown := ends[topic][partition]
 			loaded.add(loadedOffset{
 				topic:       topic,
 				partition:   partition,
 				cursor:      topicPartition.cursor,
 				offset:      offset,
 				leaderEpoch: rPartition.LeaderEpoch,
+				end:         listedEnd,
+				endKnown:    endKnown,
 				request:     loadPart,
 			})
 		}
@@ -2076,23 +2201,56 @@ func (o offsetLoadMap) buildListReq(isolationLevel int8) (r1, r2 *kmsg.ListOffse
 	}
 
 	if createEnd {
-		r2 = kmsg.NewPtrListOffsetsRequest()
-		*r2 = *r1
-		r2.Topics = append([]kmsg.ListOffsetsRequestTopic(nil), r1.Topics...)
-		for i := range r1.Topics {
-			l := &r2.Topics[i]
-			r := &r1.Topics[i]
-			*l = *r
-			l.Partitions = append([]kmsg.ListOffsetsRequestTopicPartition(nil), r.Partitions...)
-			for i := range l.Partitions {
-				l.Partitions[i].Timestamp = -1
-			}
-		}
+		r2 = listReqAt(r1, -1)
 	}
 
 	return r1, r2
 }
 
+// buildUntilListReq returns a copy of the list request that lists the given
+// end timestamp for every partition that is not loading an exact offset, or
+// nil if every partition is loading an exact offset. See ConsumeTimeRange.
+func (o offsetLoadMap) buildUntilListReq(r1 *kmsg.ListOffsetsRequest, timestamp int64) *kmsg.ListOffsetsRequest {
+	r3 := listReqAt(r1, timestamp)
+	keept := r3.Topics[:0]
+	for _, t := range r3.Topics {
+		keepp := t.Partitions[:0]
+		for _, p := range t.Partitions {
+			if offset := o[t.Topic][p.Partition]; offset.at >= 0 && !offset.afterMilli {
+				continue // exact offset
+			}
+			keepp = append(keepp, p)
+		}
+		if len(keepp) > 0 {
+			t.Partitions = keepp
+			keept = append(keept, t)
+		}
+	}
+	if len(keept) == 0 {
+		return nil
+	}
+	r3.Topics = keept
+	return r3
+}
+
+// listReqAt returns a copy of the list request with every partition listing
+// the given timestamp.
+func listReqAt(r1 *kmsg.ListOffsetsRequest, timestamp int64) *kmsg.ListOffsetsRequest {
+	r2 := kmsg.NewPtrListOffsetsRequest()
+	*r2 = *r1
+	r2.Topics = append([]kmsg.ListOffsetsRequestTopic(nil), r1.Topics...)
+	for i := range r1.Topics {
+		l := &r2.Topics[i]
+		r := &r1.Topics[i]
+		*l = *r
+		l.Partitions = append([]kmsg.ListOffsetsRequestTopicPartition(nil), r.Partitions...)
+		for i := range l.Partitions {
+			l.Partitions[i].Timestamp = timestamp
+		}
+	}
+	return r2
+}
+
 func (o offsetLoadMap) buildEpochReq() *kmsg.OffsetForLeaderEpochRequest {
 	req := kmsg.NewPtrOffsetForLeaderEpochRequest()
 	req.ReplicaID = -1
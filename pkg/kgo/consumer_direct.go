@@ -143,3 +143,35 @@ func (d *directConsumer) findNewAssignments() map[string]map[int32]Offset {
 
 	return toUse
 }
+
+// consumedToEnd, called under the consumer mu with ConsumeUntilEnd, returns
+// whether every partition we want to consume has been assigned and consumed to
+// its end.
+func (d *directConsumer) consumedToEnd() bool {
+	for topic := range d.cfg.topics {
+		if _, ok := d.using[topic]; !ok {
+			return false
+		}
+	}
+	for topic, partitions := range d.cfg.partitions {
+		for partition := range partitions {
+			if _, ok := d.using[topic][partition]; !ok {
+				return false
+			}
+		}
+	}
+
+	topics := d.tps.load()
+	for topic, partitions := range d.using {
+		t := topics.loadTopic(topic)
+		if t == nil {
+			return false
+		}
+		for partition := range partitions {
+			if partition < 0 || int(partition) >= len(t.partitions) || !t.partitions[partition].cursor.isDrained() {
+				return false
+			}
+		}
+	}
+	return true
+}
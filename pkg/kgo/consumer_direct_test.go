@@ -0,0 +1,150 @@
+package kgo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestConsumeUntilEndValidate(t *testing.T) {
+	for _, opts := range [][]Opt{
+		{ConsumeUntilEnd(), ConsumeTopics("foo"), ConsumerGroup("g")},
+		{ConsumeUntilEnd(), ConsumeTopics("foo.*"), ConsumeRegex()},
+		{ConsumeTimeRange(time.Unix(2, 0), time.Unix(1, 0)), ConsumeTopics("foo")},
+		{OnPartitionsConsumedToEnd(func(context.Context, *Client, map[string][]int32) {}), ConsumeTopics("foo")},
+	} {
+		if _, err := NewClient(opts...); err == nil {
+			t.Error("expected error for invalid ConsumeUntilEnd or ConsumeTimeRange options")
+		}
+	}
+}
+
+func TestBuildUntilListReq(t *testing.T) {
+	load := offsetLoadMap{
+		"foo": {
+			0: {-1, NewOffset().AtStart()},
+			1: {-1, NewOffset().At(10)},
+			2: {-1, NewOffset().AfterMilli(1)},
+			3: {-1, NewOffset().AtEnd().Relative(-1)},
+		},
+		"bar": {0: {-1, NewOffset().At(3)}},
+	}
+	req1, _ := load.buildListReq(0)
+	req := load.buildUntilListReq(req1, 100)
+
+	listed := make(map[string][]int32)
+	for _, rt := range req.Topics {
+		ps := listed[rt.Topic]
+		for _, p := range rt.Partitions {
+			if p.Timestamp != 100 {
+				t.Errorf("%s[%d]: got timestamp %d != exp 100", rt.Topic, p.Partition, p.Timestamp)
+			}
+			ps = append(ps, p.Partition)
+		}
+		sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+		listed[rt.Topic] = ps
+	}
+	if exp := map[string][]int32{"foo": {0, 2, 3}}; !reflect.DeepEqual(listed, exp) {
+		t.Errorf("got listed %v != exp %v", listed, exp)
+	}
+
+	if req := (offsetLoadMap{"bar": {0: {-1, NewOffset().At(3)}}}).buildUntilListReq(req1, 100); req != nil {
+		t.Errorf("got unexpected request for only exact offsets: %v", req.Topics)
+	}
+}
+
+func TestTrimToEnd(t *testing.T) {
+	recs := func(offsets ...int64) []*Record {
+		var rs []*Record
+		for _, o := range offsets {
+			rs = append(rs, &Record{Offset: o, Timestamp: time.Unix(o, 0)})
+		}
+		return rs
+	}
+
+	for _, test := range []struct {
+		name  string
+		start cursorOffset
+		fp    FetchPartition
+		cfg   cfg
+
+		expEnd    int64
+		expOffset int64
+		expRecs   int
+		expAtEnd  bool
+	}{
+		{
+			name:      "captures high watermark and trims",
+			start:     cursorOffset{offset: 5},
+			fp:        FetchPartition{HighWatermark: 7, LastStableOffset: 6, Records: recs(5, 6, 7, 8)},
+			expEnd:    7,
+			expOffset: 7,
+			expRecs:   2,
+			expAtEnd:  true,
+		},
+		{
+			name:      "captures last stable offset when reading committed",
+			start:     cursorOffset{offset: 5},
+			fp:        FetchPartition{HighWatermark: 7, LastStableOffset: 6, Records: recs(5, 6)},
+			cfg:       cfg{isolationLevel: 1},
+			expEnd:    6,
+			expOffset: 6,
+			expRecs:   1,
+			expAtEnd:  true,
+		},
+		{
+			name:      "keeps known end",
+			start:     cursorOffset{offset: 5, end: 10, endKnown: true},
+			fp:        FetchPartition{HighWatermark: 20, Records: recs(5, 6)},
+			expEnd:    10,
+			expOffset: 7,
+			expRecs:   2,
+		},
+		{
+			name:      "stops at end timestamp",
+			start:     cursorOffset{offset: 5},
+			fp:        FetchPartition{HighWatermark: 20, Records: recs(5, 6, 7, 8)},
+			cfg:       cfg{untilTime: true, untilMilli: 7000},
+			expEnd:    7,
+			expOffset: 7,
+			expRecs:   2,
+			expAtEnd:  true,
+		},
+		{
+			name:      "listed end before end timestamp",
+			start:     cursorOffset{offset: 5, end: 6, endKnown: true},
+			fp:        FetchPartition{HighWatermark: 20, Records: recs(5, 6, 7)},
+			cfg:       cfg{untilTime: true, untilMilli: 7000},
+			expEnd:    6,
+			expOffset: 6,
+			expRecs:   1,
+			expAtEnd:  true,
+		},
+		{
+			name:      "empty partition is immediately at its end",
+			start:     cursorOffset{offset: 3},
+			fp:        FetchPartition{HighWatermark: 3},
+			expEnd:    3,
+			expOffset: 3,
+			expAtEnd:  true,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			o := &cursorOffsetNext{cursorOffset: test.start}
+			// processRespPartition advances the offset past
+			// processed records before trimming.
+			if n := len(test.fp.Records); n > 0 {
+				o.offset = test.fp.Records[n-1].Offset + 1
+			}
+			fp := test.fp
+			o.trimToEnd(&fp, &test.cfg)
+			if o.end != test.expEnd || o.offset != test.expOffset || len(fp.Records) != test.expRecs || o.atEnd() != test.expAtEnd {
+				t.Errorf("got end %d offset %d recs %d at end %v != exp end %d offset %d recs %d at end %v",
+					o.end, o.offset, len(fp.Records), o.atEnd(),
+					test.expEnd, test.expOffset, test.expRecs, test.expAtEnd)
+			}
+		})
+	}
+}
@@ -0,0 +1,185 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Headers that a DeadLetterQueue adds to the records it produces. If a record
+// that is sent to a DeadLetterQueue already has these headers (that is, the
+// record was consumed from a retry topic), the original topic, partition, and
+// offset headers are kept and the attempt header is incremented.
+const (
+	// DeadLetterHeaderTopic is the topic the record was originally
+	// consumed from.
+	DeadLetterHeaderTopic = "kgo-dlq-topic"
+	// DeadLetterHeaderPartition is the partition the record was originally
+	// consumed from, as a base 10 string.
+	DeadLetterHeaderPartition = "kgo-dlq-partition"
+	// DeadLetterHeaderOffset is the offset the record was originally
+	// consumed from, as a base 10 string.
+	DeadLetterHeaderOffset = "kgo-dlq-offset"
+	// DeadLetterHeaderError is the text of the most recent error that the
+	// record was sent with.
+	DeadLetterHeaderError = "kgo-dlq-error"
+	// DeadLetterHeaderAttempt is the number of times the record has been
+	// sent to a DeadLetterQueue, as a base 10 string, starting at 1.
+	DeadLetterHeaderAttempt = "kgo-dlq-attempt"
+	// DeadLetterHeaderRetryAt is the time in unix milliseconds, as a base
+	// 10 string, at which a record produced to a retry topic should be
+	// retried. This header is only set on records produced to retry
+	// topics.
+	DeadLetterHeaderRetryAt = "kgo-dlq-retry-at"
+)
+
+var errNoDeadLetterTopic = errors.New("dead letter queue topic must not be empty")
+
+// RetryTopic is a topic that failed records are produced to before they are
+// given up on and produced to a dead letter topic, and how long records
+// should wait before being retried.
+type RetryTopic struct {
+	// Topic is the topic to produce failed records to.
+	Topic string
+	// Delay is how long after producing a record it should be retried.
+	// The time to retry at is set in the DeadLetterHeaderRetryAt header;
+	// consumers of the retry topic are responsible for waiting until then.
+	Delay time.Duration
+}
+
+// DeadLetterOpt is an option to configure a DeadLetterQueue.
+type DeadLetterOpt interface {
+	apply(*DeadLetterQueue)
+}
+
+type deadLetterOpt struct{ fn func(*DeadLetterQueue) }
+
+func (opt deadLetterOpt) apply(q *DeadLetterQueue) { opt.fn(q) }
+
+// DeadLetterRetryTopics sets topics to produce failed records to before
+// producing them to the dead letter topic. The first time a record is sent,
+// it is produced to the first retry topic, the second time to the second, and
+// so on. Once a record has been sent more times than there are retry topics,
+// it is produced to the dead letter topic.
+//
+// By default, there are no retry topics and records are produced directly to
+// the dead letter topic.
+func DeadLetterRetryTopics(topics ...RetryTopic) DeadLetterOpt {
+	return deadLetterOpt{func(q *DeadLetterQueue) { q.retries = append([]RetryTopic(nil), topics...) }}
+}
+
+// DeadLetterQueue routes records that could not be processed to a dead letter
+// topic, optionally by way of retry topics, and marks the original records
+// for committing once they are safely produced.
+type DeadLetterQueue struct {
+	cl      *Client
+	topic   string
+	retries []RetryTopic
+}
+
+// NewDeadLetterQueue returns a DeadLetterQueue that uses the given client to
+// produce failed records to topic and to mark the original records committed.
+//
+// The client is expected to be consuming in a group with the AutoCommitMarks
+// option: records are marked with MarkCommitRecords, which does nothing for
+// clients that do not use AutoCommitMarks. If you commit manually, commit
+// records after Send returns successfully.
+func NewDeadLetterQueue(cl *Client, topic string, opts ...DeadLetterOpt) (*DeadLetterQueue, error) {
+	if topic == "" {
+		return nil, errNoDeadLetterTopic
+	}
+	q := &DeadLetterQueue{
+		cl:    cl,
+		topic: topic,
+	}
+	for _, opt := range opts {
+		opt.apply(q)
+	}
+	for _, retry := range q.retries {
+		if retry.Topic == "" {
+			return nil, errNoDeadLetterTopic
+		}
+	}
+	return q, nil
+}
+
+// Send produces a copy of r, which failed processing with err, to the next
+// retry topic or to the dead letter topic, and waits for the produce to
+// finish. The copy has r's key, value, and headers, plus headers describing
+// where r was originally consumed from, err, and how many times the record
+// has been sent (see the DeadLetterHeader constants).
+//
+// Only once the produce succeeds is r marked to be committed with
+// MarkCommitRecords. If the produce fails, r is not marked and the produce
+// error is returned, in which case you can retry Send or stop processing the
+// partition so that r is consumed again.
+func (q *DeadLetterQueue) Send(ctx context.Context, r *Record, err error) error {
+	dlr := q.deadLetterRecord(r, err, time.Now())
+	if perr := q.cl.ProduceSync(ctx, dlr).FirstErr(); perr != nil {
+		return perr
+	}
+	q.cl.MarkCommitRecords(r)
+	return nil
+}
+
+// deadLetterRecord returns the record to produce for r failing with err.
+func (q *DeadLetterQueue) deadLetterRecord(r *Record, err error, now time.Time) *Record {
+	var (
+		attempt int
+		origin  = []RecordHeader{
+			{Key: DeadLetterHeaderTopic, Value: []byte(r.Topic)},
+			{Key: DeadLetterHeaderPartition, Value: []byte(strconv.FormatInt(int64(r.Partition), 10))},
+			{Key: DeadLetterHeaderOffset, Value: []byte(strconv.FormatInt(r.Offset, 10))},
+		}
+		headers = make([]RecordHeader, 0, len(r.Headers)+6)
+	)
+
+	// If the record came from a retry topic, we keep where it originally
+	// came from and continue its attempt count.
+	for _, h := range r.Headers {
+		switch h.Key {
+		case DeadLetterHeaderTopic:
+			origin[0].Value = h.Value
+		case DeadLetterHeaderPartition:
+			origin[1].Value = h.Value
+		case DeadLetterHeaderOffset:
+			origin[2].Value = h.Value
+		case DeadLetterHeaderAttempt:
+			if n, perr := strconv.Atoi(string(h.Value)); perr == nil {
+				attempt = n
+			}
+		case DeadLetterHeaderError, DeadLetterHeaderRetryAt:
+		default:
+			headers = append(headers, h)
+		}
+	}
+	attempt++
+
+	var errText string
+	if err != nil {
+		errText = err.Error()
+	}
+	headers = append(headers, origin...)
+	headers = append(headers,
+		RecordHeader{Key: DeadLetterHeaderError, Value: []byte(errText)},
+		RecordHeader{Key: DeadLetterHeaderAttempt, Value: []byte(strconv.Itoa(attempt))},
+	)
+
+	dlr := &Record{
+		Key:     r.Key,
+		Value:   r.Value,
+		Topic:   q.topic,
+		Headers: headers,
+	}
+	if attempt <= len(q.retries) {
+		retry := q.retries[attempt-1]
+		dlr.Topic = retry.Topic
+		retryAt := now.Add(retry.Delay).UnixNano() / 1e6
+		dlr.Headers = append(dlr.Headers, RecordHeader{
+			Key:   DeadLetterHeaderRetryAt,
+			Value: []byte(strconv.FormatInt(retryAt, 10)),
+		})
+	}
+	return dlr
+}
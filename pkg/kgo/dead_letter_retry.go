@@ -0,0 +1,287 @@
+package kgo
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RetryPipeline consumes the retry topics of a DeadLetterQueue, delivering
+// each record only once its DeadLetterHeaderRetryAt time has passed.
+//
+// Rather than sleeping, which would block every partition the client
+// consumes, a retry topic partition whose next record is not yet due is paused
+// with PauseFetchPartitions and its records are held until they are due. Once
+// all held records for a partition are delivered, the partition is resumed.
+// Records in a partition are delivered in order.
+//
+// Held records have been polled from the client but not returned from Poll.
+// If group consuming, the client must use AutoCommitMarks, and records must be
+// marked only once processed: plain autocommitting commits everything the
+// client has polled, including held records, which would then be lost if the
+// client stops before they are due.
+//
+// Records from retry topics that fail again should be sent back to the
+// DeadLetterQueue, which produces them to the next retry topic or, once
+// retries are exhausted, to the dead letter topic.
+type RetryPipeline struct {
+	q      *DeadLetterQueue
+	topics map[string]struct{}
+
+	mu   sync.Mutex
+	held map[string]map[int32][]*Record
+}
+
+// NewRetryPipeline returns a RetryPipeline for the retry topics of q and adds
+// the retry topics to be consumed by q's client with AddConsumeTopics. If the
+// client consumes via regex, the regex must match the retry topics.
+//
+// You must use the pipeline's Poll rather than the client's poll functions so
+// that records are held until they are due. If group consuming, the client
+// must use AutoCommitMarks (see RetryPipeline), and you must call
+// DropPartitions from your OnPartitionsRevoked and OnPartitionsLost
+// callbacks so that held records for partitions you no longer own are not
+// delivered.
+func NewRetryPipeline(q *DeadLetterQueue) *RetryPipeline {
+	p := &RetryPipeline{
+		q:      q,
+		topics: make(map[string]struct{}, len(q.retries)),
+		held:   make(map[string]map[int32][]*Record),
+	}
+	for _, retry := range q.retries {
+		p.topics[retry.Topic] = struct{}{}
+	}
+	q.cl.AddConsumeTopics(p.Topics()...)
+	return p
+}
+
+// Topics returns the retry topics this pipeline consumes.
+func (p *RetryPipeline) Topics() []string {
+	topics := make([]string, 0, len(p.q.retries))
+	for _, retry := range p.q.retries {
+		topics = append(topics, retry.Topic)
+	}
+	return topics
+}
+
+// Send is a shortcut for Send on the pipeline's DeadLetterQueue.
+func (p *RetryPipeline) Send(ctx context.Context, r *Record, err error) error {
+	return p.q.Send(ctx, r, err)
+}
+
+// Poll is PollFetches for a client that consumes retry topics. Records from
+// topics that are not retry topics are returned as is. Records from retry
+// topics are returned only once they are due; Poll returns when any records
+// are fetched or become due, or when the fetches contain errors.
+//
+// The returned fetches contain held records that became due before any newly
+// fetched records, and contain errors as they are returned from PollFetches.
+func (p *RetryPipeline) Poll(ctx context.Context) Fetches {
+	cl := p.q.cl
+	if ctx == nil {
+		due, _ := p.release(time.Now())
+		return append(due, p.hold(cl.PollFetches(nil), time.Now())...)
+	}
+
+	for {
+		due, next := p.release(time.Now())
+
+		// If something is due, we only take what is already buffered.
+		// Otherwise, we poll until the next held record is due, if any.
+		var (
+			pollCtx context.Context
+			cancel  = func() {}
+		)
+		switch {
+		case len(due) > 0:
+		case !next.IsZero():
+			pollCtx, cancel = context.WithDeadline(ctx, next)
+		default:
+			pollCtx = ctx
+		}
+		fetched := cl.PollFetches(pollCtx)
+		if pollCtx != nil && pollCtx != ctx && pollCtx.Err() != nil && ctx.Err() == nil {
+			fetched = dropErrFetch(fetched, pollCtx.Err())
+		}
+		cancel()
+
+		// If every fetched record was held and nothing was due, we
+		// keep waiting.
+		if fetches := append(due, p.hold(fetched, time.Now())...); len(fetches) > 0 {
+			return fetches
+		}
+	}
+}
+
+// DropPartitions drops any held records for the given partitions and resumes
+// fetching the partitions if they were paused by this pipeline. This should be
+// called from OnPartitionsRevoked and OnPartitionsLost when group consuming.
+func (p *RetryPipeline) DropPartitions(topicPartitions map[string][]int32) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	resume := make(map[string][]int32)
+	for topic, partitions := range topicPartitions {
+		held := p.held[topic]
+		for _, partition := range partitions {
+			if _, exists := held[partition]; exists {
+				delete(held, partition)
+				resume[topic] = append(resume[topic], partition)
+			}
+		}
+		if len(held) == 0 {
+			delete(p.held, topic)
+		}
+	}
+	if len(resume) > 0 {
+		p.q.cl.ResumeFetchPartitions(resume)
+	}
+}
+
+// release returns fetches containing all held records that are due as of now,
+// resuming partitions that have no more held records, and returns when the
+// next held record is due.
+func (p *RetryPipeline) release(now time.Time) (Fetches, time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		fetch  Fetch
+		next   time.Time
+		resume = make(map[string][]int32)
+	)
+	for topic, held := range p.held {
+		ft := FetchTopic{Topic: topic}
+		for partition, rs := range held {
+			i := 0
+			for ; i < len(rs); i++ {
+				if due := retryDue(rs[i]); due.After(now) {
+					if next.IsZero() || due.Before(next) {
+						next = due
+					}
+					break
+				}
+			}
+			if i > 0 {
+				ft.Partitions = append(ft.Partitions, FetchPartition{
+					Partition: partition,
+					Records:   rs[:i:i],
+				})
+			}
+			if i == len(rs) {
+				delete(held, partition)
+				resume[topic] = append(resume[topic], partition)
+			} else {
+				held[partition] = rs[i:]
+			}
+		}
+		if len(held) == 0 {
+			delete(p.held, topic)
+		}
+		if len(ft.Partitions) > 0 {
+			fetch.Topics = append(fetch.Topics, ft)
+		}
+	}
+	if len(resume) > 0 {
+		p.q.cl.ResumeFetchPartitions(resume)
+	}
+	if len(fetch.Topics) == 0 {
+		return nil, next
+	}
+	return Fetches{fetch}, next
+}
+
+// hold removes records from retry topics that are not yet due as of now from
+// fetched, holding them and pausing their partitions. Once a partition has a
+// held record, all later records for that partition are held as well so that
+// records are delivered in order. Fetches that end up with no records and no
+// errors are dropped.
+func (p *RetryPipeline) hold(fetched Fetches, now time.Time) Fetches {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var (
+		fetches Fetches
+		pause   = make(map[string][]int32)
+	)
+	for _, fetch := range fetched {
+		var keep Fetch
+		for _, ft := range fetch.Topics {
+			if _, isRetry := p.topics[ft.Topic]; !isRetry {
+				keep.Topics = append(keep.Topics, ft)
+				continue
+			}
+
+			keepTopic := FetchTopic{Topic: ft.Topic}
+			for _, fp := range ft.Partitions {
+				held := p.held[ft.Topic]
+				rs := held[fp.Partition]
+
+				i := 0
+				if len(rs) == 0 {
+					for ; i < len(fp.Records); i++ {
+						if retryDue(fp.Records[i]).After(now) {
+							break
+						}
+					}
+				}
+				if i < len(fp.Records) {
+					if held == nil {
+						held = make(map[int32][]*Record)
+						p.held[ft.Topic] = held
+					}
+					if len(rs) == 0 {
+						pause[ft.Topic] = append(pause[ft.Topic], fp.Partition)
+					}
+					held[fp.Partition] = append(rs, fp.Records[i:]...)
+					fp.Records = fp.Records[:i:i]
+				}
+				if len(fp.Records) > 0 || fp.Err != nil {
+					keepTopic.Partitions = append(keepTopic.Partitions, fp)
+				}
+			}
+			if len(keepTopic.Partitions) > 0 {
+				keep.Topics = append(keep.Topics, keepTopic)
+			}
+		}
+		if len(keep.Topics) > 0 {
+			fetches = append(fetches, keep)
+		}
+	}
+	if len(pause) > 0 {
+		p.q.cl.PauseFetchPartitions(pause)
+	}
+	return fetches
+}
+
+// retryDue returns when a record from a retry topic is due, which is the zero
+// time if the record has no valid DeadLetterHeaderRetryAt header.
+func retryDue(r *Record) time.Time {
+	for _, h := range r.Headers {
+		if h.Key == DeadLetterHeaderRetryAt {
+			millis, err := strconv.ParseInt(string(h.Value), 10, 64)
+			if err != nil {
+				return time.Time{}
+			}
+			return time.Unix(0, millis*1e6)
+		}
+	}
+	return time.Time{}
+}
+
+// dropErrFetch removes the fake fetch that polling injects when the poll
+// context is done with err.
+func dropErrFetch(fetches Fetches, err error) Fetches {
+	kept := fetches[:0]
+	for _, fetch := range fetches {
+		if len(fetch.Topics) == 1 &&
+			fetch.Topics[0].Topic == "" &&
+			len(fetch.Topics[0].Partitions) == 1 &&
+			fetch.Topics[0].Partitions[0].Err == err {
+			continue
+		}
+		kept = append(kept, fetch)
+	}
+	return kept
+}
@@ -0,0 +1,263 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeadLetterRecord(t *testing.T) {
+	now := time.Unix(100, 0)
+	cl, _ := NewClient(SeedBrokers("127.0.0.1:1"))
+	defer cl.Close()
+
+	for _, test := range []struct {
+		name    string
+		retries []RetryTopic
+		in      *Record
+		err     error
+
+		expTopic   string
+		expHeaders []RecordHeader
+	}{
+		{
+			name: "no retries",
+			in: &Record{
+				Topic:     "foo",
+				Partition: 2,
+				Offset:    30,
+				Headers:   []RecordHeader{{"h", []byte("v")}},
+			},
+			err:      errors.New("bad"),
+			expTopic: "dlq",
+			expHeaders: []RecordHeader{
+				{"h", []byte("v")},
+				{DeadLetterHeaderTopic, []byte("foo")},
+				{DeadLetterHeaderPartition, []byte("2")},
+				{DeadLetterHeaderOffset, []byte("30")},
+				{DeadLetterHeaderError, []byte("bad")},
+				{DeadLetterHeaderAttempt, []byte("1")},
+			},
+		},
+
+		{
+			name:    "first retry",
+			retries: []RetryTopic{{"retry-1", time.Second}, {"retry-2", time.Minute}},
+			in: &Record{
+				Topic:     "foo",
+				Partition: 0,
+				Offset:    7,
+			},
+			err:      errors.New("bad"),
+			expTopic: "retry-1",
+			expHeaders: []RecordHeader{
+				{DeadLetterHeaderTopic, []byte("foo")},
+				{DeadLetterHeaderPartition, []byte("0")},
+				{DeadLetterHeaderOffset, []byte("7")},
+				{DeadLetterHeaderError, []byte("bad")},
+				{DeadLetterHeaderAttempt, []byte("1")},
+				{DeadLetterHeaderRetryAt, []byte("101000")},
+			},
+		},
+
+		{
+			name:    "second retry keeps origin",
+			retries: []RetryTopic{{"retry-1", time.Second}, {"retry-2", time.Minute}},
+			in: &Record{
+				Topic:     "retry-1",
+				Partition: 3,
+				Offset:    1,
+				Headers: []RecordHeader{
+					{DeadLetterHeaderTopic, []byte("foo")},
+					{DeadLetterHeaderPartition, []byte("0")},
+					{DeadLetterHeaderOffset, []byte("7")},
+					{DeadLetterHeaderError, []byte("bad")},
+					{DeadLetterHeaderAttempt, []byte("1")},
+					{DeadLetterHeaderRetryAt, []byte("101000")},
+				},
+			},
+			err:      errors.New("worse"),
+			expTopic: "retry-2",
+			expHeaders: []RecordHeader{
+				{DeadLetterHeaderTopic, []byte("foo")},
+				{DeadLetterHeaderPartition, []byte("0")},
+				{DeadLetterHeaderOffset, []byte("7")},
+				{DeadLetterHeaderError, []byte("worse")},
+				{DeadLetterHeaderAttempt, []byte("2")},
+				{DeadLetterHeaderRetryAt, []byte("160000")},
+			},
+		},
+
+		{
+			name:    "retries exhausted",
+			retries: []RetryTopic{{"retry-1", time.Second}},
+			in: &Record{
+				Topic: "retry-1",
+				Headers: []RecordHeader{
+					{DeadLetterHeaderTopic, []byte("foo")},
+					{DeadLetterHeaderPartition, []byte("0")},
+					{DeadLetterHeaderOffset, []byte("7")},
+					{DeadLetterHeaderAttempt, []byte("1")},
+				},
+			},
+			expTopic: "dlq",
+			expHeaders: []RecordHeader{
+				{DeadLetterHeaderTopic, []byte("foo")},
+				{DeadLetterHeaderPartition, []byte("0")},
+				{DeadLetterHeaderOffset, []byte("7")},
+				{DeadLetterHeaderError, []byte("")},
+				{DeadLetterHeaderAttempt, []byte("2")},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			q, err := NewDeadLetterQueue(cl, "dlq", DeadLetterRetryTopics(test.retries...))
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			got := q.deadLetterRecord(test.in, test.err, now)
+			if got.Topic != test.expTopic {
+				t.Errorf("got topic %q != exp %q", got.Topic, test.expTopic)
+			}
+			if !reflect.DeepEqual(got.Headers, test.expHeaders) {
+				t.Errorf("got headers %v != exp %v", got.Headers, test.expHeaders)
+			}
+		})
+	}
+
+	if _, err := NewDeadLetterQueue(cl, ""); err != errNoDeadLetterTopic {
+		t.Errorf("got err %v != exp %v", err, errNoDeadLetterTopic)
+	}
+	if _, err := NewDeadLetterQueue(cl, "dlq", DeadLetterRetryTopics(RetryTopic{})); err != errNoDeadLetterTopic {
+		t.Errorf("got err %v != exp %v", err, errNoDeadLetterTopic)
+	}
+}
+
+func TestRetryPipelineHoldRelease(t *testing.T) {
+	cl, _ := NewClient(SeedBrokers("127.0.0.1:1"))
+	defer cl.Close()
+
+	q, _ := NewDeadLetterQueue(cl, "dlq", DeadLetterRetryTopics(RetryTopic{"retry", time.Minute}))
+	p := NewRetryPipeline(q)
+
+	at := func(offset int64, millis int64) *Record {
+		return &Record{
+			Topic:   "retry",
+			Offset:  offset,
+			Headers: []RecordHeader{{DeadLetterHeaderRetryAt, []byte(strconv.FormatInt(millis, 10))}},
+		}
+	}
+	offsets := func(fs Fetches) []int64 {
+		var os []int64
+		fs.EachRecord(func(r *Record) { os = append(os, r.Offset) })
+		return os
+	}
+	paused := func() map[string][]int32 {
+		paused := cl.PauseFetchPartitions(nil)
+		for _, ps := range paused {
+			sort.Slice(ps, func(i, j int) bool { return ps[i] < ps[j] })
+		}
+		return paused
+	}
+
+	fetched := Fetches{{Topics: []FetchTopic{
+		{Topic: "foo", Partitions: []FetchPartition{{Records: []*Record{{Topic: "foo", Offset: 9}}}}},
+		{Topic: "retry", Partitions: []FetchPartition{
+			{Partition: 0, Records: []*Record{at(0, 1000), at(1, 2000), at(2, 1000)}},
+			{Partition: 1, Records: []*Record{at(0, 3000)}},
+		}},
+	}}}
+
+	got := p.hold(fetched, time.Unix(1, 0))
+	if exp := []int64{9, 0}; !reflect.DeepEqual(offsets(got), exp) {
+		t.Errorf("got held offsets %v != exp %v", offsets(got), exp)
+	}
+	if paused, exp := paused(), map[string][]int32{"retry": {0, 1}}; !reflect.DeepEqual(paused, exp) {
+		t.Errorf("got paused %v != exp %v", paused, exp)
+	}
+
+	// Partition 0 is already holding, so newly fetched records are
+	// held behind it even if they are due.
+	p.hold(Fetches{{Topics: []FetchTopic{{Topic: "retry", Partitions: []FetchPartition{
+		{Partition: 0, Records: []*Record{at(3, 0)}},
+	}}}}}, time.Unix(1, 0))
+
+	due, next := p.release(time.Unix(2, 0))
+	if exp := []int64{1, 2, 3}; !reflect.DeepEqual(offsets(due), exp) {
+		t.Errorf("got released offsets %v != exp %v", offsets(due), exp)
+	}
+	if exp := time.Unix(3, 0); !next.Equal(exp) {
+		t.Errorf("got next due %v != exp %v", next, exp)
+	}
+	if paused, exp := paused(), map[string][]int32{"retry": {1}}; !reflect.DeepEqual(paused, exp) {
+		t.Errorf("got paused %v != exp %v", paused, exp)
+	}
+
+	p.DropPartitions(map[string][]int32{"retry": {1}})
+	if due, next := p.release(time.Unix(5, 0)); len(due) != 0 || !next.IsZero() {
+		t.Errorf("got unexpected release of dropped partition: %v, %v", offsets(due), next)
+	}
+	if paused := paused(); len(paused) != 0 {
+		t.Errorf("got unexpected paused %v", paused)
+	}
+}
+
+func TestRetryPipelinePoll(t *testing.T) {
+	cl, _ := NewClient(SeedBrokers("127.0.0.1:1"))
+	defer cl.Close()
+
+	q, _ := NewDeadLetterQueue(cl, "dlq", DeadLetterRetryTopics(RetryTopic{"retry", time.Minute}))
+	p := NewRetryPipeline(q)
+
+	held := func(due time.Time) {
+		p.hold(Fetches{{Topics: []FetchTopic{{Topic: "retry", Partitions: []FetchPartition{{Records: []*Record{{
+			Topic:   "retry",
+			Offset:  1,
+			Headers: []RecordHeader{{DeadLetterHeaderRetryAt, []byte(strconv.FormatInt(due.UnixNano()/1e6, 10))}},
+		}}}}}}}}, time.Now())
+	}
+
+	// The client cannot fetch anything, so Poll waits until the held
+	// record is due. The error from our own deadline is dropped.
+	held(time.Now().Add(50 * time.Millisecond))
+	fs := p.Poll(context.Background())
+	if err := fs.Err(); err != nil {
+		t.Errorf("got unexpected err %v", err)
+	}
+	if rs := fs.Records(); len(rs) != 1 || rs[0].Offset != 1 {
+		t.Errorf("got records %v, expected the held record", rs)
+	}
+
+	// If the caller's context is done before anything is due, its error
+	// is returned and the record stays held.
+	held(time.Now().Add(time.Hour))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	fs = p.Poll(ctx)
+	if err := fs.Err(); err != context.DeadlineExceeded {
+		t.Errorf("got err %v != exp %v", err, context.DeadlineExceeded)
+	}
+	if rs := fs.Records(); len(rs) != 0 {
+		t.Errorf("got unexpected records %v", rs)
+	}
+	if _, next := p.release(time.Now()); next.IsZero() {
+		t.Error("record is unexpectedly no longer held")
+	}
+}
+
+func TestDropErrFetch(t *testing.T) {
+	other := errors.New("other")
+	fs := Fetches{
+		errFetch(context.DeadlineExceeded)[0],
+		{Topics: []FetchTopic{{Topic: "foo", Partitions: []FetchPartition{{Err: context.DeadlineExceeded}}}}},
+		errFetch(other)[0],
+	}
+	got := dropErrFetch(fs, context.DeadlineExceeded)
+	if len(got) != 2 || got[0].Topics[0].Topic != "foo" || got[1].Topics[0].Partitions[0].Err != other {
+		t.Errorf("got unexpected fetches after dropping: %+v", got)
+	}
+}
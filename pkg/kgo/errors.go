@@ -166,9 +166,9 @@ var (
 	// unable to be produced after RecordRetries attempts.
 	ErrRecordRetries = errors.New("record failed after being retried too many times")
 
-	// ErrMaxBuffered is returned when the maximum amount of records are
-	// buffered and either manual flushing is enabled or you are using
-	// TryProduce.
+	// ErrMaxBuffered is returned when the maximum amount of records or
+	// bytes are buffered and either manual flushing is enabled or you are
+	// using TryProduce.
 	ErrMaxBuffered = errors.New("the maximum amount of records are buffered, cannot buffer more")
 
 	// ErrAborting is returned for all buffered records while
@@ -185,6 +185,11 @@ var (
 	//
 	// For any request, the request is failed with this error.
 	ErrClientClosed = errors.New("client closed")
+
+	// ErrConsumedToEnd is injected into a poll response as a fake
+	// partition error when using ConsumeUntilEnd and every partition has
+	// been consumed to its end offset and all records have been polled.
+	ErrConsumedToEnd = errors.New("all partitions have been consumed to their end offsets")
 )
 
 // ErrDataLoss is returned for Kafka >=2.1.0 when data loss is detected and the
@@ -449,7 +449,8 @@ func (cl *Client) fetchTopicMetadata(all bool, reqTopics []string) (map[string]*
 				},
 
 				records: &recBuf{
-					cl: cl,
+					cl:  cl,
+					cfg: cl.producerCfg(topic),
 
 					topic:     topic,
 					partition: partMeta.Partition,
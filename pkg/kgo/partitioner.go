@@ -1,6 +1,7 @@
 package kgo
 
 import (
+	"hash/crc32"
 	"math"
 	"math/rand"
 	"sync/atomic"
@@ -73,6 +74,57 @@ type TopicBackupIter interface {
 	Rem() int
 }
 
+// TopicFeedbackPartitioner is an optional extension interface to
+// TopicPartitioner that receives feedback from produce requests and
+// partitions with knowledge of which partition each index corresponds to.
+//
+// If a partitioner implements this interface, the Partition and
+// PartitionByBackup functions will never be called.
+type TopicFeedbackPartitioner interface {
+	TopicPartitioner
+
+	// OnPartitionFeedback is called after every produce request that
+	// contained a batch for a partition of this topic finishes, whether
+	// the request succeeded or failed. This is called while no record is
+	// being partitioned for the topic, meaning partitioners do not need
+	// locks.
+	OnPartitionFeedback(PartitionFeedback)
+
+	// PartitionByFeedback is similar to PartitionByBackup, but the
+	// iterator also returns the partition number for each partition
+	// index, so that the partitioner can match indices to feedback. The
+	// iterator's Next function can only be called up to n times, calling
+	// it any more will panic.
+	PartitionByFeedback(r *Record, n int, feedbackIter TopicFeedbackIter) int
+}
+
+// PartitionFeedback is the result of a produce request for a single
+// partition.
+type PartitionFeedback struct {
+	// Partition is the partition the produce request was for.
+	Partition int32
+	// Latency is how long the produce request took, from when the request
+	// was issued until its response was received. All partitions in a
+	// request (i.e., all partitions led by the same broker) have the same
+	// latency.
+	Latency time.Duration
+	// Err is the error from the request, if any. This is either an error
+	// for the whole request, such as a connection failure, or an error
+	// specific to this partition in the produce response. Retriable
+	// errors are included even though the batch will be retried.
+	Err error
+}
+
+// TopicFeedbackIter iterates through partition indices.
+type TopicFeedbackIter interface {
+	// Next returns the next partition index, the partition number, and
+	// the total buffered records for the partition. If Rem returns 0,
+	// calling this function again will panic.
+	Next() (int, int32, int64)
+	// Rem returns the number of elements left to iterate through.
+	Rem() int
+}
+
 ////////////
 // SIMPLE // - BasicConsistent, Manual, RoundRobin
 ////////////
@@ -210,6 +262,20 @@ func (i *leastBackupInput) Rem() int {
 	return len(i.mapping)
 }
 
+type feedbackInput struct{ mapping []*topicPartition }
+
+func (i *feedbackInput) Next() (int, int32, int64) {
+	last := len(i.mapping) - 1
+	records := i.mapping[last].records
+	buffered := atomic.LoadInt64(&records.buffered)
+	i.mapping = i.mapping[:last]
+	return last, records.partition, buffered
+}
+
+func (i *feedbackInput) Rem() int {
+	return len(i.mapping)
+}
+
 func (*leastBackupPartitioner) ForTopic(string) TopicPartitioner {
 	return &leastBackupTopicPartitioner{
 		onPart: -1,
@@ -321,22 +387,7 @@ func (p *uniformBytesTopicPartitioner) PartitionByBackup(r *Record, n int, backu
 		return p.u.hasher(r.Key, n)
 	}
 
-	l := 1 + // attributes, int8 unused
-		1 + // ts delta, 1 minimum (likely 2 or 3)
-		1 + // offset delta, likely 1
-		kbin.VarintLen(int32(len(r.Key))) +
-		len(r.Key) +
-		kbin.VarintLen(int32(len(r.Value))) +
-		len(r.Value) +
-		kbin.VarintLen(int32(len(r.Headers))) // varint array len headers
-
-	for _, h := range r.Headers {
-		l += kbin.VarintLen(int32(len(h.Key))) +
-			len(h.Key) +
-			kbin.VarintLen(int32(len(h.Value))) +
-			len(h.Value)
-	}
-
+	l := partitionerRecordLength(r)
 	p.bytes += l
 	if p.bytes >= p.u.bytes {
 		p.bytes = l
@@ -393,6 +444,194 @@ func (p *uniformBytesTopicPartitioner) PartitionByBackup(r *Record, n int, backu
 	return p.onPart
 }
 
+// partitionerRecordLength returns the approximate length of r in a batch, for
+// partitioners that switch partitions after a number of bytes.
+func partitionerRecordLength(r *Record) int {
+	l := 1 + // attributes, int8 unused
+		1 + // ts delta, 1 minimum (likely 2 or 3)
+		1 + // offset delta, likely 1
+		kbin.VarintLen(int32(len(r.Key))) +
+		len(r.Key) +
+		kbin.VarintLen(int32(len(r.Value))) +
+		len(r.Value) +
+		kbin.VarintLen(int32(len(r.Headers))) // varint array len headers
+
+	for _, h := range r.Headers {
+		l += kbin.VarintLen(int32(len(h.Key))) +
+			len(h.Key) +
+			kbin.VarintLen(int32(len(h.Value))) +
+			len(h.Value)
+	}
+	return l
+}
+
+//////////////////
+// AVAILABILITY //
+//////////////////
+
+// AvailabilityPartitioner is a sticky partitioner that steers records away
+// from partitions that are erroring or slow to produce to, using feedback from
+// produce requests (see TopicFeedbackPartitioner). This is similar to the
+// partition availability tracking of KIP-794, but rather than only tracking
+// how long a partition's queue has not drained, this tracks produce latency
+// and errors directly.
+//
+// Like the UniformBytesPartitioner, this returns the same partition until
+// 'bytes' is hit, at which point a new partition is chosen. A new partition is
+// also chosen as soon as producing to the current partition fails. When
+// choosing, partitions that had a produce error within the last 'avoidFor'
+// are skipped (unless every partition had an error), and the remaining
+// partitions are chosen randomly, weighted by the inverse of their records
+// buffered multiplied by their average produce latency. A partition that
+// successfully produces after an error is no longer avoided.
+//
+// If keys is true, this uses standard hashing based on record key for records
+// with non-nil keys. Keyed records are never steered, because they must be
+// partitioned consistently. hasher is optional; if nil, the default hasher
+// murmur2 (Kafka's default).
+func AvailabilityPartitioner(bytes int, avoidFor time.Duration, keys bool, hasher PartitionerHasher) Partitioner {
+	if hasher == nil {
+		hasher = KafkaHasher(murmur2)
+	}
+	return &availabilityPartitioner{
+		bytes,
+		avoidFor,
+		keys,
+		hasher,
+	}
+}
+
+type (
+	availabilityPartitioner struct {
+		bytes    int
+		avoidFor time.Duration
+		keys     bool
+		hasher   PartitionerHasher
+	}
+
+	availabilityTopicPartitioner struct {
+		a      availabilityPartitioner
+		bytes  int
+		onPart int
+		onID   int32
+		rng    *rand.Rand
+		stats  map[int32]*partitionAvailability
+
+		calc []availabilityCalc
+	}
+
+	availabilityCalc struct {
+		f     float64
+		n     int
+		id    int32
+		avoid bool
+	}
+
+	partitionAvailability struct {
+		latency float64 // moving average, in milliseconds
+		lastErr time.Time
+	}
+)
+
+func (a *availabilityPartitioner) ForTopic(string) TopicPartitioner {
+	return &availabilityTopicPartitioner{
+		a:      *a,
+		onPart: -1,
+		rng:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		stats:  make(map[int32]*partitionAvailability),
+	}
+}
+
+func (p *availabilityTopicPartitioner) RequiresConsistency(r *Record) bool {
+	return p.a.keys && r.Key != nil
+}
+func (*availabilityTopicPartitioner) Partition(*Record, int) int { panic("unreachable") }
+
+func (p *availabilityTopicPartitioner) OnPartitionFeedback(fb PartitionFeedback) {
+	// We weigh the latest latency at 20%, which smooths out spikes while
+	// still reacting within a handful of requests.
+	const alpha = 0.2
+
+	s := p.stats[fb.Partition]
+	latency := float64(fb.Latency) / float64(time.Millisecond)
+	if s == nil {
+		s = &partitionAvailability{latency: latency}
+		p.stats[fb.Partition] = s
+	} else {
+		s.latency = alpha*latency + (1-alpha)*s.latency
+	}
+
+	if fb.Err == nil {
+		s.lastErr = time.Time{}
+		return
+	}
+	s.lastErr = time.Now()
+	if p.onPart >= 0 && fb.Partition == p.onID {
+		p.onPart = -1
+	}
+}
+
+func (p *availabilityTopicPartitioner) PartitionByFeedback(r *Record, n int, feedback TopicFeedbackIter) int {
+	if p.a.keys && r.Key != nil {
+		return p.a.hasher(r.Key, n)
+	}
+
+	l := partitionerRecordLength(r)
+	p.bytes += l
+	if p.bytes >= p.a.bytes {
+		p.bytes = l
+		p.onPart = -1
+	}
+	if p.onPart >= 0 && p.onPart < n {
+		return p.onPart
+	}
+
+	// We weigh each partition by the inverse of its backup times its
+	// latency, skipping partitions that recently errored. If every
+	// partition recently errored, we consider them all.
+	now := time.Now()
+	p.calc = p.calc[:0]
+	var total, avoidedTotal float64
+	for ; n > 0; n-- {
+		idx, id, backup := feedback.Next()
+		c := availabilityCalc{n: idx, id: id}
+		latency := 1.0
+		if s := p.stats[id]; s != nil {
+			if s.latency > 1 {
+				latency = s.latency
+			}
+			c.avoid = !s.lastErr.IsZero() && now.Sub(s.lastErr) < p.a.avoidFor
+		}
+		c.f = 1 / (float64(backup+1) * latency)
+		if c.avoid {
+			avoidedTotal += c.f
+		} else {
+			total += c.f
+		}
+		p.calc = append(p.calc, c)
+	}
+
+	useAvoided := total == 0
+	if useAvoided {
+		total = avoidedTotal
+	}
+
+	// Similar to the UniformBytesPartitioner, we choose the first
+	// partition that takes our pick negative; if floating point rounding
+	// leaves us with nothing, we use the last usable partition.
+	pick := p.rng.Float64() * total
+	for _, c := range p.calc {
+		if c.avoid && !useAvoided {
+			continue
+		}
+		p.onPart, p.onID = c.n, c.id
+		if pick -= c.f; pick <= 0 {
+			break
+		}
+	}
+	return p.onPart
+}
+
 /////////////////////
 // STICKY & COMPAT // - Sticky, Kafka (custom hash), Sarama (custom hash)
 /////////////////////
@@ -459,10 +698,40 @@ func (p *stickyTopicPartitioner) Partition(_ *Record, n int) int {
 // hash keys, will mask out the 32nd bit, and then will mod by the number of
 // potential partitions.
 func StickyKeyPartitioner(hasher PartitionerHasher) Partitioner {
+	return KeyExtractorPartitioner(nil, hasher)
+}
+
+// KeyExtractorPartitioner is a StickyKeyPartitioner that hashes the bytes
+// returned from extract rather than the record key. This can be used to
+// partition by something other than the key, such as a field in the value. If
+// extract returns nil, the record is partitioned as a record with no key.
+//
+// If extract is nil, this partitions by the record key exactly like the
+// StickyKeyPartitioner. hasher is optional; if nil, this uses the same hasher
+// that the StickyKeyPartitioner uses by default.
+func KeyExtractorPartitioner(extract func(*Record) []byte, hasher PartitionerHasher) Partitioner {
+	if extract == nil {
+		extract = func(r *Record) []byte { return r.Key }
+	}
 	if hasher == nil {
 		hasher = KafkaHasher(murmur2)
 	}
-	return &keyPartitioner{hasher}
+	return &keyPartitioner{extract, hasher}
+}
+
+// HeaderKeyPartitioner is a KeyExtractorPartitioner that hashes the value of
+// the first record header with the given key. If a record does not have the
+// header, or if the header's value is nil, the record is partitioned as a
+// record with no key.
+func HeaderKeyPartitioner(header string, hasher PartitionerHasher) Partitioner {
+	return KeyExtractorPartitioner(func(r *Record) []byte {
+		for _, h := range r.Headers {
+			if h.Key == header {
+				return h.Value
+			}
+		}
+		return nil
+	}, hasher)
 }
 
 // PartitionerHasher returns a partition to use given the input data and number
@@ -515,25 +784,96 @@ func SaramaHasher(hashFn func([]byte) uint32) PartitionerHasher {
 	}
 }
 
+// LibrdkafkaHasher returns a PartitionerHasher using hashFn that mirrors how
+// librdkafka partitions after hashing data. Unlike Kafka, librdkafka's crc32
+// partitioners do not mask the high bit of the hash, and instead mod the
+// unsigned hash by the number of partitions. librdkafka's fnv1a partitioners
+// mod the absolute value of the hash as a signed integer, and its murmur2
+// partitioners mask the high bit exactly like Kafka.
+//
+// librdkafka's default partitioner, consistent_random, hashes keys with
+// crc32. The LibrdkafkaCRC32Hasher, LibrdkafkaMurmur2Hasher, and
+// LibrdkafkaFNV1aHasher functions return hashers for each of librdkafka's
+// hashing partitioners, including the partitioners of clients built on
+// librdkafka, such as confluent-kafka-go and node-rdkafka. KafkaJS partitions
+// the same as the Java client by default, which KafkaHasher matches.
+//
+// librdkafka's "_random" partitioners (consistent_random, murmur2_random, and
+// fnv1a_random) choose a random partition for records with no key, which
+// the StickyKeyPartitioner approximates with sticky partitioning:
+//
+//     kgo.StickyKeyPartitioner(kgo.LibrdkafkaMurmur2Hasher())
+//
+// Unlike the other "_random" partitioners, consistent_random also chooses a
+// random partition for records with an empty key, whereas the
+// StickyKeyPartitioner hashes empty keys. To match consistent_random, treat
+// empty keys as no key with the KeyExtractorPartitioner:
+//
+//     kgo.KeyExtractorPartitioner(func(r *kgo.Record) []byte {
+//             if len(r.Key) == 0 {
+//                     return nil
+//             }
+//             return r.Key
+//     }, kgo.LibrdkafkaCRC32Hasher())
+//
+// The non-random partitioners hash records with no key as if the key were
+// empty, which can be matched with the KeyExtractorPartitioner:
+//
+//     kgo.KeyExtractorPartitioner(func(r *kgo.Record) []byte {
+//             if r.Key == nil {
+//                     return []byte{}
+//             }
+//             return r.Key
+//     }, kgo.LibrdkafkaCRC32Hasher())
+//
+func LibrdkafkaHasher(hashFn func([]byte) uint32) PartitionerHasher {
+	return func(key []byte, n int) int {
+		return int(hashFn(key) % uint32(n))
+	}
+}
+
+// LibrdkafkaCRC32Hasher returns a PartitionerHasher that matches librdkafka's
+// consistent and consistent_random partitioners, which is the default
+// partitioning in librdkafka.
+func LibrdkafkaCRC32Hasher() PartitionerHasher {
+	return LibrdkafkaHasher(crc32.ChecksumIEEE)
+}
+
+// LibrdkafkaMurmur2Hasher returns a PartitionerHasher that matches
+// librdkafka's murmur2 and murmur2_random partitioners, which is the same as
+// the Java client's hashing.
+func LibrdkafkaMurmur2Hasher() PartitionerHasher {
+	return KafkaHasher(murmur2)
+}
+
+// LibrdkafkaFNV1aHasher returns a PartitionerHasher that matches librdkafka's
+// fnv1a and fnv1a_random partitioners, which partition the same as Sarama's
+// default hash partitioner.
+func LibrdkafkaFNV1aHasher() PartitionerHasher {
+	return LibrdkafkaHasher(librdkafkaFNV1a)
+}
+
 type (
 	keyPartitioner struct {
-		hasher PartitionerHasher
+		extract func(*Record) []byte
+		hasher  PartitionerHasher
 	}
 
 	stickyKeyTopicPartitioner struct {
-		hasher PartitionerHasher
+		extract func(*Record) []byte
+		hasher  PartitionerHasher
 		stickyTopicPartitioner
 	}
 )
 
 func (k *keyPartitioner) ForTopic(string) TopicPartitioner {
-	return &stickyKeyTopicPartitioner{k.hasher, newStickyTopicPartitioner()}
+	return &stickyKeyTopicPartitioner{k.extract, k.hasher, newStickyTopicPartitioner()}
 }
 
-func (*stickyKeyTopicPartitioner) RequiresConsistency(r *Record) bool { return r.Key != nil }
+func (p *stickyKeyTopicPartitioner) RequiresConsistency(r *Record) bool { return p.extract(r) != nil }
 func (p *stickyKeyTopicPartitioner) Partition(r *Record, n int) int {
-	if r.Key != nil {
-		return p.hasher(r.Key, n)
+	if key := p.extract(r); key != nil {
+		return p.hasher(key, n)
 	}
 	return p.stickyTopicPartitioner.Partition(r, n)
 }
@@ -581,3 +921,32 @@ func murmur2(b []byte) uint32 {
 	h ^= h >> 15
 	return h
 }
+
+///////////
+// FNV1A //
+///////////
+
+// The 32 bit FNV-1a hash, which is what librdkafka implements. This is the
+// same as hash/fnv's New32a, without allocating.
+func fnv1a(b []byte) uint32 {
+	const (
+		offset uint32 = 0x811c9dc5
+		prime  uint32 = 0x01000193
+	)
+	h := offset
+	for _, c := range b {
+		h ^= uint32(c)
+		h *= prime
+	}
+	return h
+}
+
+// librdkafkaFNV1a is librdkafka's rd_fnv1a, which returns the absolute value
+// of the hash as a signed integer to match Sarama.
+func librdkafkaFNV1a(b []byte) uint32 {
+	h := int32(fnv1a(b))
+	if h < 0 {
+		h = -h
+	}
+	return uint32(h)
+}
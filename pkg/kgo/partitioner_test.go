@@ -0,0 +1,268 @@
+package kgo
+
+import (
+	"errors"
+	"hash/crc32"
+	"hash/fnv"
+	"testing"
+	"time"
+)
+
+func TestPartitionerHashes(t *testing.T) {
+	// murmur2 values are from Kafka's UtilsTest and librdkafka's rdmurmur2
+	// unit tests, fnv1a values are raw 32 bit FNV-1a hashes, checked
+	// against hash/fnv, and crc32 values are the IEEE polynomial's check
+	// values.
+	for _, test := range []struct {
+		key    string
+		murmur int32
+		fnv1a  uint32
+		crc32  uint32
+	}{
+		{"", 275646681, 0x811c9dc5, 0},
+		{"a", -1563381124, 0xe40c292c, 0xe8b7be43},
+		{"21", -973932308, 0x8cf297ec, 0xfd7746b4},
+		{"abc", 479470107, 0x1a47e90b, 0x352441c2},
+		{"foobar", -790332482, 0xbf9cf968, 0x9ef61f95},
+		{"kafka", -798503068, 0x0d33c4e1, 0x5bbc7517},
+		{"123456789", -1822237082, 0xbb86b11c, 0xcbf43926},
+		{"giberish123456789", -1890243828, 0x77a58295, 0x7b3a8e3f},
+		{"a-little-bit-long-string", -985981536, 0xdda30828, 0xee71cd62},
+		{"a-little-bit-longer-string", -1486304829, 0x2e87f629, 0x87bfb12a},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971, 0x1731f50e, 0xe0abb921},
+	} {
+		key := []byte(test.key)
+		if got := int32(murmur2(key)); got != test.murmur {
+			t.Errorf("%q: got murmur2 %d != exp %d", test.key, got, test.murmur)
+		}
+		if got := fnv1a(key); got != test.fnv1a {
+			t.Errorf("%q: got fnv1a %#x != exp %#x", test.key, got, test.fnv1a)
+		}
+		h := fnv.New32a()
+		h.Write(key)
+		if got := h.Sum32(); got != test.fnv1a {
+			t.Errorf("%q: got hash/fnv %#x != exp %#x", test.key, got, test.fnv1a)
+		}
+		if got := crc32.ChecksumIEEE(key); got != test.crc32 {
+			t.Errorf("%q: got crc32 %#x != exp %#x", test.key, got, test.crc32)
+		}
+	}
+}
+
+func TestLibrdkafkaHashers(t *testing.T) {
+	const n = 17
+	for _, test := range []struct {
+		key    string
+		crc32  int
+		murmur int
+		fnv1a  int
+	}{
+		{"21", 10, 13, 14},
+		{"abc", 8, 16, 1},
+		{"foobar", 6, 9, 15},
+		{"kafka", 11, 14, 9},
+	} {
+		key := []byte(test.key)
+		if got := LibrdkafkaCRC32Hasher()(key, n); got != test.crc32 {
+			t.Errorf("%q: got crc32 partition %d != exp %d", test.key, got, test.crc32)
+		}
+		if got := LibrdkafkaMurmur2Hasher()(key, n); got != test.murmur {
+			t.Errorf("%q: got murmur2 partition %d != exp %d", test.key, got, test.murmur)
+		}
+		if got := LibrdkafkaFNV1aHasher()(key, n); got != test.fnv1a {
+			t.Errorf("%q: got fnv1a partition %d != exp %d", test.key, got, test.fnv1a)
+		}
+	}
+}
+
+func TestLibrdkafkaGoldenHashes(t *testing.T) {
+	// These are the expected hashes from librdkafka's own unit tests
+	// (rdmurmur2.c and rdfnv1a.c). The murmur2 values are the Java
+	// client's, and the fnv1a values are after librdkafka takes the
+	// absolute value of the hash, which is what it then mods by the
+	// number of partitions.
+	const (
+		shortUnaligned = "1234"
+		unaligned      = "PreAmbleWillBeRemoved,ThePrePartThatIs"
+	)
+	for _, test := range []struct {
+		key    string
+		murmur uint32
+		fnv1a  uint32
+	}{
+		{"kafka", 0xd067cf64, 0x0d33c4e1},
+		{"giberish123456789", 0x8f552b0c, 0x77a58295},
+		{shortUnaligned, 0x9fc97b14, 0x023bdd03},
+		{shortUnaligned[1:], 0xe7c009ca, 0x2dea3cd2},
+		{shortUnaligned[2:], 0x873930da, 0x740fa83e},
+		{shortUnaligned[3:], 0x5a4b5ca1, 0x310ca263},
+		{unaligned, 0x78424f1c, 0x65cbd69c},
+		{unaligned[1:], 0x4a62b377, 0x6e49c79a},
+		{unaligned[2:], 0xe0e4e09e, 0x69eed356},
+		{unaligned[3:], 0x62b8b43f, 0x6abcc023},
+		{"", 0x106e08d9, 0x7ee3623b},
+	} {
+		key := []byte(test.key)
+		if got := murmur2(key); got != test.murmur {
+			t.Errorf("%q: got murmur2 %#x != exp %#x", test.key, got, test.murmur)
+		}
+		if got := librdkafkaFNV1a(key); got != test.fnv1a {
+			t.Errorf("%q: got librdkafka fnv1a %#x != exp %#x", test.key, got, test.fnv1a)
+		}
+		for n := 1; n <= 64; n++ {
+			if got, exp := LibrdkafkaMurmur2Hasher()(key, n), int((test.murmur&0x7fffffff)%uint32(n)); got != exp {
+				t.Errorf("%q: got murmur2 partition %d != exp %d of %d", test.key, got, exp, n)
+			}
+			if got, exp := LibrdkafkaFNV1aHasher()(key, n), int(test.fnv1a%uint32(n)); got != exp {
+				t.Errorf("%q: got fnv1a partition %d != exp %d of %d", test.key, got, exp, n)
+			}
+		}
+	}
+}
+
+func TestKeyExtractorPartitioners(t *testing.T) {
+	const n = 17
+	hasher := LibrdkafkaCRC32Hasher()
+
+	for _, test := range []struct {
+		name string
+		p    Partitioner
+		r    *Record
+		exp  int // -1 if not consistent
+	}{
+		{
+			name: "header",
+			p:    HeaderKeyPartitioner("h", hasher),
+			r:    &Record{Key: []byte("abc"), Headers: []RecordHeader{{"x", []byte("21")}, {"h", []byte("kafka")}}},
+			exp:  11,
+		},
+		{
+			name: "missing header",
+			p:    HeaderKeyPartitioner("h", hasher),
+			r:    &Record{Key: []byte("abc")},
+			exp:  -1,
+		},
+		{
+			name: "extractor",
+			p:    KeyExtractorPartitioner(func(r *Record) []byte { return r.Value }, hasher),
+			r:    &Record{Key: []byte("abc"), Value: []byte("foobar")},
+			exp:  6,
+		},
+		{
+			name: "empty key as no key",
+			p: KeyExtractorPartitioner(func(r *Record) []byte {
+				if len(r.Key) == 0 {
+					return nil
+				}
+				return r.Key
+			}, hasher),
+			r:   &Record{Key: []byte{}},
+			exp: -1,
+		},
+		{
+			name: "nil extractor uses key",
+			p:    KeyExtractorPartitioner(nil, hasher),
+			r:    &Record{Key: []byte("abc"), Value: []byte("foobar")},
+			exp:  8,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			tp := test.p.ForTopic("t")
+			if consistent := tp.RequiresConsistency(test.r); consistent != (test.exp >= 0) {
+				t.Fatalf("got consistent %v != exp %v", consistent, test.exp >= 0)
+			}
+			if test.exp < 0 {
+				return
+			}
+			if got := tp.Partition(test.r, n); got != test.exp {
+				t.Errorf("got partition %d != exp %d", got, test.exp)
+			}
+		})
+	}
+}
+
+type sliceFeedbackIter []int64 // buffered per partition; index is the partition
+
+func (i *sliceFeedbackIter) Next() (int, int32, int64) {
+	last := len(*i) - 1
+	buffered := (*i)[last]
+	*i = (*i)[:last]
+	return last, int32(last), buffered
+}
+
+func (i *sliceFeedbackIter) Rem() int { return len(*i) }
+
+func TestAvailabilityPartitioner(t *testing.T) {
+	const n = 4
+	tp := AvailabilityPartitioner(1, time.Minute, true, nil).ForTopic("t").(TopicFeedbackPartitioner)
+
+	// With bytes of 1, every record chooses a new partition.
+	partition := func(r *Record, buffered ...int64) int {
+		if len(buffered) == 0 {
+			buffered = make([]int64, n)
+		}
+		iter := sliceFeedbackIter(buffered)
+		return tp.PartitionByFeedback(r, n, &iter)
+	}
+	counts := func() [n]int {
+		var counts [n]int
+		for i := 0; i < 1000; i++ {
+			counts[partition(new(Record))]++
+		}
+		return counts
+	}
+
+	// Erroring partitions are avoided until they succeed.
+	for _, p := range []int32{0, 1, 3} {
+		tp.OnPartitionFeedback(PartitionFeedback{Partition: p, Err: errors.New("down")})
+	}
+	if got, exp := counts(), [n]int{0, 0, 1000, 0}; got != exp {
+		t.Errorf("with errors, got counts %v != exp %v", got, exp)
+	}
+	tp.OnPartitionFeedback(PartitionFeedback{Partition: 3})
+	if got := counts(); got[0] != 0 || got[1] != 0 || got[3] == 0 {
+		t.Errorf("after success, got counts %v, expected only 2 and 3", got)
+	}
+
+	// If everything is erroring, we still choose.
+	tp.OnPartitionFeedback(PartitionFeedback{Partition: 2, Err: errors.New("down")})
+	tp.OnPartitionFeedback(PartitionFeedback{Partition: 3, Err: errors.New("down")})
+	if got := counts(); got[0]+got[1]+got[2]+got[3] != 1000 {
+		t.Errorf("with all errors, got counts %v", got)
+	}
+
+	// Slow and backed up partitions are rarely chosen.
+	for p := int32(0); p < n; p++ {
+		tp.OnPartitionFeedback(PartitionFeedback{Partition: p, Latency: time.Second})
+	}
+	for i := 0; i < 30; i++ { // latency is a moving average
+		tp.OnPartitionFeedback(PartitionFeedback{Partition: 1, Latency: time.Millisecond})
+	}
+	if got := counts(); got[1] < 900 {
+		t.Errorf("got %d picks of the fast partition, expected most of 1000: %v", got[1], got)
+	}
+	var fast int
+	for i := 0; i < 1000; i++ {
+		if partition(new(Record), 0, 1000000, 0, 0) != 1 {
+			fast++
+		}
+	}
+	if fast < 900 {
+		t.Errorf("got %d picks of partitions that are not backed up, expected most of 1000", fast)
+	}
+
+	// Keys are hashed, and stickiness breaks on an error.
+	if got, exp := partition(&Record{Key: []byte("foobar")}), KafkaHasher(murmur2)([]byte("foobar"), n); got != exp {
+		t.Errorf("got keyed partition %d != exp %d", got, exp)
+	}
+	sticky := AvailabilityPartitioner(1<<20, time.Minute, false, nil).ForTopic("t").(TopicFeedbackPartitioner)
+	iter := sliceFeedbackIter(make([]int64, n))
+	first := sticky.PartitionByFeedback(new(Record), n, &iter)
+	sticky.OnPartitionFeedback(PartitionFeedback{Partition: int32(first), Err: errors.New("down")})
+	for i := 0; i < 100; i++ {
+		iter = sliceFeedbackIter(make([]int64, n))
+		if got := sticky.PartitionByFeedback(new(Record), n, &iter); got == first {
+			t.Fatalf("sticky partitioner kept erroring partition %d", first)
+		}
+	}
+}
@@ -157,6 +157,7 @@ func TestRecBatchAppendTo(t *testing.T) {
 
 	// ***Compressed record batch check***
 
+	uncompressedRecords := kbatch.Records
 	compressor, _ = newCompressor(CompressionCodec{codec: 2}) // snappy
 	{
 		kbatch.Attributes |= 0x0002 // snappy
@@ -166,6 +167,29 @@ func TestRecBatchAppendTo(t *testing.T) {
 	fixFields()
 	check()
 
+	// ***Compress once record batch check***
+
+	ourBatch.compressOnce = true
+	check() // compresses on the first write
+	if ourBatch.onceCodec != 2 || ourBatch.onceVersion != version {
+		t.Fatal("expected batch to be compressed once")
+	}
+	check() // reuses the compressed records
+
+	// If compressing did not shrink the records, the batch is written
+	// uncompressed on every try.
+	compressedBatch := kbatch
+	ourBatch.onceRecords, ourBatch.onceCodec = uncompressedRecords, 0
+	kbatch.Attributes &^= 0x0007
+	kbatch.Records = uncompressedRecords
+	fixFields()
+	check()
+	kbatch = compressedBatch
+
+	ourBatch.compressOnce = false
+	ourBatch.onceRecords = nil
+	ourBatch.onceVersion = 0
+
 	// ***As a produce request***
 	txid := "tx"
 	kmsgReq := kmsg.ProduceRequest{
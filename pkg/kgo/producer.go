@@ -35,6 +35,17 @@ type producer struct {
 	unknownTopics   map[string]*unknownTopicProduces
 
 	bufferedRecords int64
+	bufferedBytes   int64
+
+	// batchMu serializes counting the records of ProduceBatch batches, so
+	// that two partially counted batches cannot wait on each other.
+	batchMu sync.Mutex
+
+	// With MaxBufferedBytes, produces that are waiting for buffered bytes
+	// to drop wait on bytesWait, which is closed and cleared whenever
+	// bytes are unbuffered.
+	bytesMu   sync.Mutex
+	bytesWait chan struct{}
 
 	id           atomic.Value
 	producingTxn uint32 // 1 if in txn
@@ -75,6 +86,11 @@ type producer struct {
 	// EndAndBegin; if nothing more was produced to, we ensure we finish
 	// the started txn.
 	readded bool
+
+	// offsetsAdded is set if AddGroupOffsetsToTransaction added offsets
+	// to the current transaction, which begins the transaction in Kafka
+	// even if nothing is produced. This is guarded by txnMu.
+	offsetsAdded bool
 }
 
 // BufferedProduceRecords returns the number of records currently buffered for
@@ -87,6 +103,81 @@ func (cl *Client) BufferedProduceRecords() int64 {
 	return atomic.LoadInt64(&cl.producer.bufferedRecords)
 }
 
+// BufferedProduceBytes returns the number of bytes currently buffered for
+// producing within the client. This is the sum of each buffered record's key,
+// value, and header keys and values, and is what MaxBufferedBytes limits.
+func (cl *Client) BufferedProduceBytes() int64 {
+	return atomic.LoadInt64(&cl.producer.bufferedBytes)
+}
+
+// userSize returns the size of the user provided fields of a record, which is
+// what is tracked for MaxBufferedBytes.
+func (r *Record) userSize() int64 {
+	size := len(r.Key) + len(r.Value)
+	for _, h := range r.Headers {
+		size += len(h.Key) + len(h.Value)
+	}
+	return int64(size)
+}
+
+// bufferBytes buffers a record's bytes, waiting for buffered bytes to drop if
+// MaxBufferedBytes is reached and we can block. On error, the bytes are still
+// buffered: the caller fails the record, which unbuffers them.
+func (p *producer) bufferBytes(ctx context.Context, n int64, block bool) error {
+	cl := p.cl
+	max := cl.cfg.maxBufferedBytes
+	if max == 0 {
+		atomic.AddInt64(&p.bufferedBytes, n)
+		return nil
+	}
+	for {
+		p.bytesMu.Lock()
+		if buffered := atomic.LoadInt64(&p.bufferedBytes); buffered == 0 || buffered+n <= max {
+			atomic.AddInt64(&p.bufferedBytes, n)
+			p.bytesMu.Unlock()
+			return nil
+		}
+		if !block || cl.cfg.manualFlushing {
+			atomic.AddInt64(&p.bufferedBytes, n)
+			p.bytesMu.Unlock()
+			return ErrMaxBuffered
+		}
+		if p.bytesWait == nil {
+			p.bytesWait = make(chan struct{})
+		}
+		wait := p.bytesWait
+		p.bytesMu.Unlock()
+
+		var err error
+		select {
+		case <-wait:
+		case <-cl.ctx.Done():
+			err = ErrClientClosed
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+		if err != nil {
+			atomic.AddInt64(&p.bufferedBytes, n)
+			return err
+		}
+	}
+}
+
+// unbufferBytes unbuffers a finished record's bytes, waking anything waiting
+// to buffer.
+func (p *producer) unbufferBytes(n int64) {
+	atomic.AddInt64(&p.bufferedBytes, -n)
+	if p.cl.cfg.maxBufferedBytes == 0 {
+		return
+	}
+	p.bytesMu.Lock()
+	defer p.bytesMu.Unlock()
+	if p.bytesWait != nil {
+		close(p.bytesWait)
+		p.bytesWait = nil
+	}
+}
+
 type unknownTopicProduces struct {
 	buffered []promisedRec
 	wait     chan error // retriable errors
@@ -308,8 +399,9 @@ func (f *FirstErrPromise) Err() error {
 }
 
 // TryProduce is similar to Produce, but rather than blocking if the client
-// currently has MaxBufferedRecords buffered, this fails immediately with
-// ErrMaxBuffered. See the Produce documentation for more details.
+// currently has MaxBufferedRecords or MaxBufferedBytes buffered, this fails
+// immediately with ErrMaxBuffered. See the Produce documentation for more
+// details.
 func (cl *Client) TryProduce(
 	ctx context.Context,
 	r *Record,
@@ -333,7 +425,8 @@ func (cl *Client) TryProduce(
 // failed with immediately kerr.MessageTooLarge.
 //
 // If the client is configured to automatically flush the client currently has
-// the configured maximum amount of records buffered, Produce will block. The
+// the configured maximum amount of records or bytes buffered, Produce will
+// block. The
 // context can be used to cancel waiting while records flush to make space. In
 // contrast, if flushing is configured, the record will be failed immediately
 // with ErrMaxBuffered (this same behavior can be had with TryProduce).
@@ -376,45 +469,83 @@ func (cl *Client) produce(
 		}
 	}
 
+	if cl.spill != nil && cl.spill.maybeSpill(ctx, r, promise) {
+		return
+	}
+	cl.bufferRecord(ctx, r, promise, block)
+}
+
+// bufferRecord buffers a record in memory, waiting for space if necessary and
+// allowed, and then partitions it.
+func (cl *Client) bufferRecord(
+	ctx context.Context,
+	r *Record,
+	promise func(*Record, error),
+	block bool,
+) {
+	if err := cl.countBuffered(ctx, r, promise, block); err != nil {
+		return
+	}
+
+	p := &cl.producer
+
+	// Neither of the errors below should be hit in applications.
+	if r.Topic == "" {
+		def := cl.cfg.defaultProduceTopic
+		if def == "" {
+			p.promiseRecord(promisedRec{ctx, promise, r}, errNoTopic)
+			return
+		}
+		r.Topic = def
+	}
+	if cl.cfg.txnID != nil && atomic.LoadUint32(&p.producingTxn) != 1 {
+		p.promiseRecord(promisedRec{ctx, promise, r}, errNotInTransaction)
+		return
+	}
+
+	cl.partitionRecord(promisedRec{ctx, promise, r})
+}
+
+// countBuffered counts a record against MaxBufferedRecords and
+// MaxBufferedBytes, waiting for space if necessary and allowed. If this
+// returns an error, the record has been failed with it.
+func (cl *Client) countBuffered(
+	ctx context.Context,
+	r *Record,
+	promise func(*Record, error),
+	block bool,
+) error {
+	p := &cl.producer
 	if atomic.AddInt64(&p.bufferedRecords, 1) > cl.cfg.maxBufferedRecords {
 		// If the client ctx cancels or the produce ctx cancels, we
 		// need to un-count our buffering of this record. We also need
 		// to drain a slot from the waitBuffer chan, which could be
 		// sent to right when we are erroring.
 		drainBuffered := func(err error) {
+			atomic.AddInt64(&p.bufferedBytes, r.userSize()) // unbuffered when failing the record
 			p.promiseRecord(promisedRec{ctx, promise, r}, err)
 			<-p.waitBuffer
 		}
 		if !block || cl.cfg.manualFlushing {
 			drainBuffered(ErrMaxBuffered)
-			return
+			return ErrMaxBuffered
 		}
 		select {
 		case <-p.waitBuffer:
 		case <-cl.ctx.Done():
 			drainBuffered(ErrClientClosed)
-			return
+			return ErrClientClosed
 		case <-ctx.Done():
 			drainBuffered(ctx.Err())
-			return
+			return ctx.Err()
 		}
 	}
 
-	// Neither of the errors below should be hit in applications.
-	if r.Topic == "" {
-		def := cl.cfg.defaultProduceTopic
-		if def == "" {
-			p.promiseRecord(promisedRec{ctx, promise, r}, errNoTopic)
-			return
-		}
-		r.Topic = def
-	}
-	if cl.cfg.txnID != nil && atomic.LoadUint32(&p.producingTxn) != 1 {
-		p.promiseRecord(promisedRec{ctx, promise, r}, errNotInTransaction)
-		return
+	if err := p.bufferBytes(ctx, r.userSize(), block); err != nil {
+		p.promiseRecord(promisedRec{ctx, promise, r}, err)
+		return err
 	}
-
-	cl.partitionRecord(promisedRec{ctx, promise, r})
+	return nil
 }
 
 type batchPromise struct {
@@ -425,6 +556,7 @@ type batchPromise struct {
 	partition  int32
 	recs       []promisedRec
 	err        error
+	uncounted  bool // if the records were never counted as buffered, e.g. they failed while spilled to disk
 }
 
 func (p *producer) promiseBatch(b batchPromise) {
@@ -437,6 +569,10 @@ func (p *producer) promiseRecord(pr promisedRec, err error) {
 	p.promiseBatch(batchPromise{recs: []promisedRec{pr}, err: err})
 }
 
+func (p *producer) promiseUncountedRecord(pr promisedRec, err error) {
+	p.promiseBatch(batchPromise{recs: []promisedRec{pr}, err: err, uncounted: true})
+}
+
 func (p *producer) finishPromises(b batchPromise) {
 	cl := p.cl
 	var more bool
@@ -448,7 +584,7 @@ start:
 		pr.ProducerID = b.pid
 		pr.ProducerEpoch = b.epoch
 		pr.Attrs = b.attrs
-		cl.finishRecordPromise(pr, b.err)
+		cl.finishRecordPromise(pr, b.err, b.uncounted)
 		b.recs[i] = promisedRec{}
 	}
 	p.promisesMu.Unlock()
@@ -462,7 +598,7 @@ start:
 	}
 }
 
-func (cl *Client) finishRecordPromise(pr promisedRec, err error) {
+func (cl *Client) finishRecordPromise(pr promisedRec, err error, uncounted bool) {
 	p := &cl.producer
 
 	if p.hooks != nil {
@@ -474,8 +610,13 @@ func (cl *Client) finishRecordPromise(pr promisedRec, err error) {
 	// We call the promise before finishing the record; this allows users
 	// of Flush to know that all buffered records are completely done
 	// before Flush returns.
+	size := pr.Record.userSize()
 	pr.promise(pr.Record, err)
+	if uncounted {
+		return
+	}
 
+	p.unbufferBytes(size)
 	buffered := atomic.AddInt64(&p.bufferedRecords, -1)
 	if buffered >= cl.cfg.maxBufferedRecords {
 		p.waitBuffer <- struct{}{}
@@ -484,6 +625,9 @@ func (cl *Client) finishRecordPromise(pr promisedRec, err error) {
 		p.mu.Unlock() // nolint:gocritic,staticcheck // We use the lock as a barrier, unlocking immediately is safe.
 		p.c.Broadcast()
 	}
+	if cl.spill != nil {
+		cl.spill.signalSpace()
+	}
 }
 
 // partitionRecord loads the partitions for a topic and produce to them. If
@@ -508,7 +652,10 @@ func (cl *Client) doPartitionRecord(parts *topicPartitions, partsData *topicPart
 	parts.partsMu.Lock()
 	defer parts.partsMu.Unlock()
 	if parts.partitioner == nil {
-		parts.partitioner = cl.cfg.partitioner.ForTopic(pr.Topic)
+		parts.partitioner = cl.producerCfg(pr.Topic).partitioner.ForTopic(pr.Topic)
+		if _, ok := parts.partitioner.(TopicFeedbackPartitioner); ok {
+			atomic.StoreUint32(&parts.feedback, 1)
+		}
 	}
 
 	mapping := partsData.writablePartitions
@@ -520,43 +667,93 @@ func (cl *Client) doPartitionRecord(parts *topicPartitions, partsData *topicPart
 		return
 	}
 
-	var pick int
+	tfp, _ := parts.partitioner.(TopicFeedbackPartitioner)
 	tlp, _ := parts.partitioner.(TopicBackupPartitioner)
-	if tlp != nil {
-		if parts.lb == nil {
-			parts.lb = new(leastBackupInput)
+	partition := func() int {
+		switch {
+		case tfp != nil:
+			if parts.fb == nil {
+				parts.fb = new(feedbackInput)
+			}
+			parts.fb.mapping = mapping
+			return tfp.PartitionByFeedback(pr.Record, len(mapping), parts.fb)
+		case tlp != nil:
+			if parts.lb == nil {
+				parts.lb = new(leastBackupInput)
+			}
+			parts.lb.mapping = mapping
+			return tlp.PartitionByBackup(pr.Record, len(mapping), parts.lb)
+		default:
+			return parts.partitioner.Partition(pr.Record, len(mapping))
 		}
-		parts.lb.mapping = mapping
-		pick = tlp.PartitionByBackup(pr.Record, len(mapping), parts.lb)
-	} else {
-		pick = parts.partitioner.Partition(pr.Record, len(mapping))
 	}
+
+	pick := partition()
 	if pick < 0 || pick >= len(mapping) {
 		cl.producer.promiseRecord(pr, fmt.Errorf("invalid record partitioning choice of %d from %d available", pick, len(mapping)))
 		return
 	}
 
-	partition := mapping[pick]
+	picked := mapping[pick]
 
 	onNewBatch, _ := parts.partitioner.(TopicPartitionerOnNewBatch)
 	abortOnNewBatch := onNewBatch != nil
-	processed := partition.records.bufferRecord(pr, abortOnNewBatch) // KIP-480
+	processed := picked.records.bufferRecord(pr, abortOnNewBatch) // KIP-480
 	if !processed {
 		onNewBatch.OnNewBatch()
 
-		if tlp != nil {
-			parts.lb.mapping = mapping
-			pick = tlp.PartitionByBackup(pr.Record, len(mapping), parts.lb)
-		} else {
-			pick = parts.partitioner.Partition(pr.Record, len(mapping))
-		}
-
+		pick = partition()
 		if pick < 0 || pick >= len(mapping) {
 			cl.producer.promiseRecord(pr, fmt.Errorf("invalid record partitioning choice of %d from %d available", pick, len(mapping)))
 			return
 		}
-		partition = mapping[pick]
-		partition.records.bufferRecord(pr, false) // KIP-480
+		picked = mapping[pick]
+		picked.records.bufferRecord(pr, false) // KIP-480
+	}
+}
+
+// partitionerFeedback passes the result of a produce request to the
+// partitioners of any topics in the request that are feedback partitioners.
+// This must be called without any recBuf mutex held.
+func (cl *Client) partitionerFeedback(batches recBatches, resp kmsg.Response, err error, latency time.Duration) {
+	var (
+		topics = cl.producer.topics.load()
+		errs   map[string]map[int32]error
+	)
+	for _, b := range batches {
+		parts := topics[b.owner.topic]
+		if parts == nil || atomic.LoadUint32(&parts.feedback) == 0 {
+			continue
+		}
+
+		if errs == nil && err == nil {
+			errs = make(map[string]map[int32]error)
+			if resp, ok := resp.(*kmsg.ProduceResponse); ok {
+				for _, t := range resp.Topics {
+					for _, p := range t.Partitions {
+						if perr := kerr.ErrorForCode(p.ErrorCode); perr != nil {
+							if errs[t.Topic] == nil {
+								errs[t.Topic] = make(map[int32]error)
+							}
+							errs[t.Topic][p.Partition] = perr
+						}
+					}
+				}
+			}
+		}
+
+		fb := PartitionFeedback{
+			Partition: b.owner.partition,
+			Latency:   latency,
+			Err:       err,
+		}
+		if fb.Err == nil {
+			fb.Err = errs[b.owner.topic][b.owner.partition]
+		}
+
+		parts.partsMu.Lock()
+		parts.partitioner.(TopicFeedbackPartitioner).OnPartitionFeedback(fb)
+		parts.partsMu.Unlock()
 	}
 }
 
@@ -814,8 +1011,9 @@ func (cl *Client) waitUnknownTopic(
 		after        <-chan time.Time
 	)
 
-	if timeout := cl.cfg.recordTimeout; timeout > 0 {
-		timer := time.NewTimer(cl.cfg.recordTimeout)
+	tcfg := cl.producerCfg(topic)
+	if timeout := tcfg.recordTimeout; timeout > 0 {
+		timer := time.NewTimer(timeout)
 		defer timer.Stop()
 		after = timer.C
 	}
@@ -843,7 +1041,7 @@ func (cl *Client) waitUnknownTopic(
 			}
 			cl.cfg.logger.Log(LogLevelInfo, "new topic metadata wait failed, retrying wait", "topic", topic, "err", retriableErr)
 			tries++
-			if int64(tries) >= cl.cfg.recordRetries {
+			if int64(tries) >= tcfg.recordRetries {
 				err = fmt.Errorf("no partitions available after attempting to refresh metadata %d times, last err: %w", tries, retriableErr)
 			}
 			if cl.cfg.maxUnknownFailures >= 0 && errors.Is(retriableErr, kerr.UnknownTopicOrPartition) {
@@ -897,11 +1095,19 @@ func (cl *Client) Flush(ctx context.Context) error {
 	cl.cfg.logger.Log(LogLevelInfo, "flushing")
 	defer cl.cfg.logger.Log(LogLevelDebug, "flushed")
 
+	// If we have spilled records to disk, we first wait for all of them
+	// to be replayed into memory, and then wait for them to finish below.
+	if cl.spill != nil {
+		if err := cl.spill.waitReplayed(ctx); err != nil {
+			return err
+		}
+	}
+
 	// At this point, if lingering is configured, nothing will _start_ a
 	// linger because the producer's flushing atomic int32 is nonzero. We
 	// must wake anything that could be lingering up, after which all sinks
 	// will loop draining.
-	if cl.cfg.linger > 0 || cl.cfg.manualFlushing {
+	if cl.cfg.linger > 0 || cl.cfg.manualFlushing || len(cl.topicCfgs) > 0 {
 		for _, parts := range p.topics.load() {
 			for _, part := range parts.load().partitions {
 				part.records.unlingerAndManuallyDrain()
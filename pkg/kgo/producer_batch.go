@@ -0,0 +1,217 @@
+package kgo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+)
+
+// PartitionRecords are records to produce to a single topic partition with
+// ProduceBatch.
+type PartitionRecords struct {
+	// Topic is the topic to produce to. If empty, the client's
+	// DefaultProduceTopic is used.
+	Topic string
+	// Partition is the partition to produce to. The partitioner is not
+	// used.
+	Partition int32
+	// Records are the records to produce, in order. Each record's Topic
+	// and Partition fields are set to the fields above, and on success,
+	// each record's attributes and offset are set as they are with
+	// Produce.
+	Records []*Record
+
+	// CompressOnce, if true, encodes and compresses the records with the
+	// topic's producer compression once, the first time the batch is
+	// written in a produce request, and reuses the result if the batch is
+	// retried, rather than encoding and compressing on every try. If
+	// compressing does not shrink the records, the batch is written
+	// uncompressed and compressing is not tried again.
+	//
+	// This does not accept records that are already compressed; the
+	// records are always compressed by the client.
+	CompressOnce bool
+}
+
+var (
+	errNoBatchRecords = errors.New("cannot produce a batch with no records")
+	errBatchSpill     = errors.New("cannot produce a batch with ProducerSpillDir")
+)
+
+// ProduceBatch produces records to a single topic partition as one record
+// batch, calling promise once after every record is finished. If any record
+// failed, promise is called with the first error; records in a batch always
+// succeed or fail together. Unlike Produce, the records are not partitioned
+// individually and are not appended to any other record batch, which avoids
+// per-record partitioning and buffering costs for pipelines that already
+// group records by partition.
+//
+// Batches are produced in order with any other records for the same partition
+// and go through the same produce path as records from Produce: with
+// idempotency, batches have sequence numbers, and with transactions, the
+// partition is added to the transaction and ProduceBatch must be called
+// within a transaction.
+//
+// Every record in a batch is counted against MaxBufferedRecords and
+// MaxBufferedBytes. A batch that can never fit within these limits or within
+// a single produce request is failed immediately. Similar to Produce, this
+// blocks while the client has no space to buffer the batch, as well as while
+// the client loads the topic if this is the first time producing to it. The
+// context can be used to stop waiting, and if the context is canceled after
+// the batch is buffered, the batch can be failed as with Produce.
+//
+// ProduceBatch cannot be used with ProducerSpillDir.
+func (cl *Client) ProduceBatch(
+	ctx context.Context,
+	batch PartitionRecords,
+	promise func(PartitionRecords, error),
+) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if promise == nil {
+		promise = func(PartitionRecords, error) {}
+	}
+	if len(batch.Records) == 0 {
+		promise(batch, errNoBatchRecords)
+		return
+	}
+	if batch.Topic == "" {
+		batch.Topic = cl.cfg.defaultProduceTopic
+	}
+
+	// Record promises are called serially, so we need no lock.
+	var (
+		remaining = len(batch.Records)
+		firstErr  error
+	)
+	recPromise := func(_ *Record, err error) {
+		if err != nil && firstErr == nil {
+			firstErr = err
+		}
+		if remaining--; remaining == 0 {
+			promise(batch, firstErr)
+		}
+	}
+
+	p := &cl.producer
+	var size int64
+	prs := make([]promisedRec, 0, len(batch.Records))
+	for _, r := range batch.Records {
+		r.Topic = batch.Topic
+		r.Partition = batch.Partition
+		if p.hooks != nil {
+			for _, h := range p.hooks.buffered {
+				h.OnProduceRecordBuffered(r)
+			}
+		}
+		size += r.userSize()
+		prs = append(prs, promisedRec{ctx, recPromise, r})
+	}
+
+	var err error
+	switch {
+	case batch.Topic == "":
+		err = errNoTopic
+	case cl.spill != nil:
+		err = errBatchSpill
+	case int64(len(prs)) > cl.cfg.maxBufferedRecords,
+		cl.cfg.maxBufferedBytes > 0 && size > cl.cfg.maxBufferedBytes:
+		err = ErrMaxBuffered
+	}
+	if err != nil {
+		p.promiseBatch(batchPromise{recs: prs, partition: batch.Partition, err: err, uncounted: true})
+		return
+	}
+
+	p.batchMu.Lock()
+	for i, pr := range prs {
+		// If counting fails, the record is failed; we fail all
+		// records before it and all uncounted records after it.
+		if err := cl.countBuffered(ctx, pr.Record, recPromise, true); err != nil {
+			p.batchMu.Unlock()
+			if i > 0 {
+				p.promiseBatch(batchPromise{recs: prs[:i], partition: batch.Partition, err: err})
+			}
+			if i < len(prs)-1 {
+				// Finishing promises clears and pools the slice, so
+				// this must not share the slice above.
+				uncounted := append([]promisedRec(nil), prs[i+1:]...)
+				p.promiseBatch(batchPromise{recs: uncounted, partition: batch.Partition, err: err, uncounted: true})
+			}
+			return
+		}
+	}
+	p.batchMu.Unlock()
+
+	if cl.cfg.txnID != nil && atomic.LoadUint32(&p.producingTxn) != 1 {
+		p.promiseBatch(batchPromise{recs: prs, partition: batch.Partition, err: errNotInTransaction})
+		return
+	}
+
+	partsData, err := cl.waitBatchPartitions(ctx, batch.Topic)
+	if err == nil && (batch.Partition < 0 || int(batch.Partition) >= len(partsData.partitions)) {
+		err = fmt.Errorf("unable to produce batch to partition %d of topic %s with %d partitions", batch.Partition, batch.Topic, len(partsData.partitions))
+	}
+	if err != nil {
+		p.promiseBatch(batchPromise{recs: prs, partition: batch.Partition, err: err})
+		return
+	}
+	partsData.partitions[batch.Partition].records.bufferBatch(prs, batch.CompressOnce)
+}
+
+// waitBatchPartitions returns the partitions of a topic for ProduceBatch,
+// waiting for metadata to load the topic if necessary.
+func (cl *Client) waitBatchPartitions(ctx context.Context, topic string) (*topicPartitionsData, error) {
+	p := &cl.producer
+
+	var after <-chan time.Time
+	if timeout := cl.producerCfg(topic).recordTimeout; timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		after = timer.C
+	}
+
+	for tries := 0; ; tries++ {
+		parts, exists := p.topics.load()[topic]
+		if !exists {
+			p.topicsMu.Lock()
+			if parts, exists = p.topics.load()[topic]; !exists {
+				p.topics.storeTopics([]string{topic})
+				parts = p.topics.load()[topic]
+			}
+			p.topicsMu.Unlock()
+		}
+
+		v := parts.load()
+		if len(v.partitions) > 0 {
+			return v, nil
+		}
+		if v.loadErr != nil && !kerr.IsRetriable(v.loadErr) {
+			return nil, v.loadErr
+		}
+
+		if tries == 0 {
+			cl.cfg.logger.Log(LogLevelInfo, "producing a batch to a new topic for the first time, fetching metadata to learn its partitions", "topic", topic)
+		}
+		cl.triggerUpdateMetadataNow("forced load because we are producing a batch to a topic with unknown partitions")
+
+		backoff := time.NewTimer(cl.cfg.retryBackoff(tries))
+		select {
+		case <-backoff.C:
+		case <-ctx.Done():
+			backoff.Stop()
+			return nil, ctx.Err()
+		case <-cl.ctx.Done():
+			backoff.Stop()
+			return nil, ErrClientClosed
+		case <-after:
+			backoff.Stop()
+			return nil, ErrRecordTimeout
+		}
+	}
+}
@@ -0,0 +1,693 @@
+package kgo
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kbin"
+)
+
+// The spill log is a sequence of frames, each of which is a big endian uint32
+// payload length, the payload, and a big endian crc32c of the payload. Each
+// payload is one record. Positions in the log only ever increase, and the log
+// is split into segment files named by the position of their first frame. The
+// position file contains a big endian int64: the position in the log before
+// which every record has been finished. Segments entirely before this position
+// are deleted.
+const (
+	spillPosFile       = "spill.pos"
+	spillSegmentPrefix = "spill-"
+	spillSegmentSuffix = ".log"
+
+	spillSegmentBytes = 64 << 20
+	maxSpillFrame     = 1 << 30
+)
+
+var errSpillCorrupt = errors.New("corrupt spill log frame")
+
+// spilledPromise is the original record, context, and promise of a record
+// spilled by this client. While the record is spilled, its key, value, and
+// headers are only on disk; they are restored into the original record when
+// it is replayed, keeping the record pointer (and its Context) the same from
+// Produce through the promise.
+type spilledPromise struct {
+	r       *Record
+	ctx     context.Context
+	promise func(*Record, error)
+}
+
+// pendingSpill is a record appended to the pending write, with the size of its
+// frame.
+type pendingSpill struct {
+	sp   spilledPromise
+	size int64
+}
+
+// spillWrite is a batch of pending frames that is written at once. done is
+// closed once the write finishes, and err is the write's error, if any.
+type spillWrite struct {
+	done chan struct{}
+	err  error
+}
+
+type spillSegment struct {
+	base int64 // the log position of the start of this segment
+	f    *os.File
+}
+
+// spillEntry is a replayed record that is not yet finished.
+type spillEntry struct {
+	next int64 // the log position after this record
+	done bool
+}
+
+// spill is the on disk overflow buffer for ProducerSpillDir.
+type spill struct {
+	cl *Client
+
+	segmentBytes int64
+	pos          *os.File
+
+	wake  chan struct{} // signals the replay loop that records were spilled
+	space chan struct{} // signals the replay loop that buffered records finished
+	done  chan struct{} // closed when the replay loop quits
+
+	mu sync.Mutex
+
+	// spilling is true while any spilled record has not been replayed.
+	// While spilling, all produced records are spilled to keep ordering.
+	spilling bool
+	replayed chan struct{} // closed when not spilling
+	closed   bool
+
+	segments   []*spillSegment // in order; the last segment is written to
+	end        int64           // the end of the written log
+	read       int64           // the log position of the next record to replay
+	checkpoint int64           // every record before this position is finished
+	inflight   []*spillEntry   // replayed records from checkpoint to read, in order
+
+	// Records are encoded into pending under the mutex, and one goroutine
+	// writes everything pending without holding the mutex. Producers wait
+	// for the write containing their record.
+	pending     []byte
+	pendingRecs []pendingSpill
+	write       *spillWrite   // the write that will contain pending
+	writing     chan struct{} // non-nil while writing; closed when the writer quits
+
+	promises map[int64]spilledPromise // records spilled by this client, by log position
+}
+
+func newSpill(cl *Client) (*spill, error) {
+	dir := cl.cfg.spillDir
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("unable to create spill dir: %w", err)
+	}
+	pos, err := os.OpenFile(filepath.Join(dir, spillPosFile), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open spill position: %w", err)
+	}
+
+	s := &spill{
+		cl: cl,
+
+		segmentBytes: spillSegmentBytes,
+		pos:          pos,
+
+		wake:  make(chan struct{}, 1),
+		space: make(chan struct{}, 1),
+		done:  make(chan struct{}),
+
+		replayed: make(chan struct{}),
+		write:    &spillWrite{done: make(chan struct{})},
+		promises: make(map[int64]spilledPromise),
+	}
+	if err := s.restore(); err != nil {
+		s.closeFiles()
+		return nil, err
+	}
+	return s, nil
+}
+
+// restore opens the log segments, loads the position of the first unfinished
+// record, and finds the end of the log, truncating any partially written
+// trailing record.
+func (s *spill) restore() error {
+	dir := s.cl.cfg.spillDir
+
+	var b [8]byte
+	if _, err := s.pos.ReadAt(b[:], 0); err == nil {
+		s.checkpoint = int64(binary.BigEndian.Uint64(b[:]))
+	} else if err != io.EOF {
+		return fmt.Errorf("unable to read spill position: %w", err)
+	}
+	if s.checkpoint < 0 {
+		s.checkpoint = 0
+	}
+
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("unable to open spill dir: %w", err)
+	}
+	names, err := d.Readdirnames(-1)
+	d.Close()
+	if err != nil {
+		return fmt.Errorf("unable to read spill dir: %w", err)
+	}
+	var bases []int64
+	for _, name := range names {
+		if !strings.HasPrefix(name, spillSegmentPrefix) || !strings.HasSuffix(name, spillSegmentSuffix) {
+			continue
+		}
+		base, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(name, spillSegmentPrefix), spillSegmentSuffix), 10, 64)
+		if err != nil || base < 0 {
+			continue
+		}
+		bases = append(bases, base)
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	for _, base := range bases {
+		f, err := os.OpenFile(s.segmentPath(base), os.O_RDWR, 0o644)
+		if err != nil {
+			return fmt.Errorf("unable to open spill log segment: %w", err)
+		}
+		s.segments = append(s.segments, &spillSegment{base, f})
+	}
+
+	// If we crashed after saving the checkpoint but before deleting the
+	// segments before it, we delete them now.
+	for len(s.segments) > 1 && s.segments[1].base <= s.checkpoint {
+		s.removeSegment(0)
+	}
+	if len(s.segments) > 0 && s.checkpoint < s.segments[0].base {
+		s.cl.cfg.logger.Log(LogLevelWarn, "spill position is before the first spill log segment, skipping to the segment", "dir", dir)
+		s.checkpoint = s.segments[0].base
+	}
+
+	s.end = s.checkpoint
+	var restored int
+	for {
+		_, next, err := s.readAt(s.segmentAt(s.end), s.end)
+		if err != nil {
+			break
+		}
+		s.end = next
+		restored++
+	}
+	if err := s.truncateAfterEnd(); err != nil {
+		return err
+	}
+
+	s.read = s.checkpoint
+	if restored > 0 {
+		s.cl.cfg.logger.Log(LogLevelInfo, "restored spilled records, replaying", "dir", dir, "records", restored)
+		s.spilling = true
+	} else {
+		close(s.replayed)
+	}
+	s.persist()
+	return nil
+}
+
+// truncateAfterEnd drops anything in the log after the last whole record,
+// which can only be a record that was partially written before a crash.
+func (s *spill) truncateAfterEnd() error {
+	var truncated bool
+	for len(s.segments) > 0 {
+		last := s.segments[len(s.segments)-1]
+		if last.base <= s.end {
+			fi, err := last.f.Stat()
+			if err != nil {
+				return fmt.Errorf("unable to stat spill log segment: %w", err)
+			}
+			if last.base+fi.Size() > s.end {
+				truncated = true
+				if err := last.f.Truncate(s.end - last.base); err != nil {
+					return fmt.Errorf("unable to truncate spill log segment: %w", err)
+				}
+			}
+			break
+		}
+		truncated = true
+		s.removeSegment(len(s.segments) - 1)
+	}
+	if truncated {
+		s.cl.cfg.logger.Log(LogLevelWarn, "truncated partially written record from spill log", "dir", s.cl.cfg.spillDir)
+	}
+	return nil
+}
+
+func (s *spill) segmentPath(base int64) string {
+	return filepath.Join(s.cl.cfg.spillDir, fmt.Sprintf("%s%020d%s", spillSegmentPrefix, base, spillSegmentSuffix))
+}
+
+// segmentAt returns the segment containing a log position, if any.
+func (s *spill) segmentAt(at int64) *spillSegment {
+	for i := len(s.segments) - 1; i >= 0; i-- {
+		if seg := s.segments[i]; seg.base <= at {
+			return seg
+		}
+	}
+	return nil
+}
+
+// writeSegment returns the segment to write at the end of the log, starting a
+// new segment if there is none or the last is full. This must be called with
+// the mutex held.
+func (s *spill) writeSegment() (*spillSegment, error) {
+	if n := len(s.segments); n > 0 {
+		if last := s.segments[n-1]; s.end-last.base < s.segmentBytes {
+			return last, nil
+		}
+	}
+	f, err := os.OpenFile(s.segmentPath(s.end), os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create spill log segment: %w", err)
+	}
+	seg := &spillSegment{s.end, f}
+	s.segments = append(s.segments, seg)
+	return seg, nil
+}
+
+func (s *spill) removeSegment(i int) {
+	seg := s.segments[i]
+	seg.f.Close()
+	if err := os.Remove(seg.f.Name()); err != nil {
+		s.cl.cfg.logger.Log(LogLevelWarn, "unable to remove finished spill log segment", "dir", s.cl.cfg.spillDir, "err", err)
+	}
+	s.segments = append(s.segments[:i], s.segments[i+1:]...)
+}
+
+// dropFinishedSegments removes segments that every record is finished in: all
+// segments before the one containing the checkpoint, and every segment if the
+// whole log is finished and nothing is being spilled.
+func (s *spill) dropFinishedSegments() {
+	for len(s.segments) > 1 && s.segments[1].base <= s.checkpoint {
+		s.removeSegment(0)
+	}
+	if !s.spilling && s.writing == nil && len(s.pendingRecs) == 0 && s.checkpoint == s.end {
+		for len(s.segments) > 0 {
+			s.removeSegment(0)
+		}
+	}
+}
+
+// hasSpace returns whether the client can buffer a record in memory without
+// waiting.
+func (s *spill) hasSpace(r *Record) bool {
+	cl := s.cl
+	p := &cl.producer
+	if atomic.LoadInt64(&p.bufferedRecords) >= cl.cfg.maxBufferedRecords {
+		return false
+	}
+	if max := cl.cfg.maxBufferedBytes; max > 0 {
+		if buffered := atomic.LoadInt64(&p.bufferedBytes); buffered > 0 && buffered+r.userSize() > max {
+			return false
+		}
+	}
+	return true
+}
+
+// maybeSpill spills a record to disk if the client cannot buffer it in memory
+// or if earlier records are spilled, returning whether the record was spilled.
+func (s *spill) maybeSpill(ctx context.Context, r *Record, promise func(*Record, error)) bool {
+	cl := s.cl
+	p := &cl.producer
+
+	s.mu.Lock()
+
+	if s.closed {
+		s.mu.Unlock()
+		p.promiseUncountedRecord(promisedRec{ctx, promise, r}, ErrClientClosed)
+		return true
+	}
+
+	if !s.spilling && s.hasSpace(r) {
+		s.mu.Unlock()
+		return false
+	}
+
+	if r.Timestamp.IsZero() {
+		r.Timestamp = time.Now()
+	}
+	start := len(s.pending)
+	s.pending = append(s.pending, 0, 0, 0, 0)
+	s.pending = appendSpilledRecord(s.pending, r)
+	binary.BigEndian.PutUint32(s.pending[start:], uint32(len(s.pending)-start-4))
+	s.pending = kbin.AppendUint32(s.pending, crc32.Checksum(s.pending[start+4:], crc32c))
+	s.pendingRecs = append(s.pendingRecs, pendingSpill{
+		sp:   spilledPromise{r, ctx, promise},
+		size: int64(len(s.pending) - start),
+	})
+
+	if !s.spilling {
+		cl.cfg.logger.Log(LogLevelInfo, "producer buffer is full, spilling records to disk", "dir", cl.cfg.spillDir)
+		s.spilling = true
+		s.replayed = make(chan struct{})
+	}
+
+	w := s.write
+	if s.writing == nil {
+		s.writing = make(chan struct{})
+		go s.writePending()
+	}
+	s.mu.Unlock()
+
+	<-w.done
+	return true
+}
+
+// writePending writes pending records to the log until nothing is pending,
+// writing everything that is pending at once. Records are only readable by
+// the replay loop once they are written.
+func (s *spill) writePending() {
+	cl := s.cl
+	p := &cl.producer
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.pendingRecs) > 0 {
+		buf, recs, w := s.pending, s.pendingRecs, s.write
+		s.pending, s.pendingRecs = nil, nil
+		s.write = &spillWrite{done: make(chan struct{})}
+
+		at := s.end
+		seg, err := s.writeSegment()
+		if err == nil {
+			s.mu.Unlock()
+			_, err = seg.f.WriteAt(buf, at-seg.base)
+			s.mu.Lock()
+			if err != nil {
+				seg.f.Truncate(at - seg.base) // drop anything partially written
+			}
+		}
+
+		if err != nil {
+			cl.cfg.logger.Log(LogLevelError, "unable to spill records to disk", "dir", cl.cfg.spillDir, "records", len(recs), "err", err)
+			w.err = fmt.Errorf("unable to spill record: %w", err)
+			for _, pr := range recs {
+				p.promiseUncountedRecord(promisedRec{pr.sp.ctx, pr.sp.promise, pr.sp.r}, w.err)
+			}
+		} else {
+			for _, pr := range recs {
+				s.promises[at] = pr.sp
+				at += pr.size
+
+				// The record is now on disk; we do not
+				// keep its data in memory until replaying.
+				r := pr.sp.r
+				r.Key, r.Value, r.Headers = nil, nil, nil
+			}
+			s.end = at
+		}
+		close(w.done)
+
+		select {
+		case s.wake <- struct{}{}:
+		default:
+		}
+	}
+
+	close(s.writing)
+	s.writing = nil
+}
+
+// signalSpace is called whenever a buffered record finishes, waking the replay
+// loop if it is waiting for space.
+func (s *spill) signalSpace() {
+	select {
+	case s.space <- struct{}{}:
+	default:
+	}
+}
+
+// waitSpace waits until the client can buffer a replayed record without
+// blocking, returning false if the client is closed first. We wait here rather
+// than blocking while buffering: if the client is closed while we wait, close
+// fails this record along with every later spilled record, in order.
+func (s *spill) waitSpace(ctx context.Context, r *Record) bool {
+	for !s.hasSpace(r) {
+		select {
+		case <-s.space:
+		case <-ctx.Done():
+			return true // buffering fails the record with the context error
+		case <-s.cl.ctx.Done():
+			return false
+		}
+	}
+	return true
+}
+
+// loop replays spilled records into the client in order, waiting while the
+// client has no space to buffer them.
+func (s *spill) loop() {
+	defer close(s.done)
+
+	cl := s.cl
+	for {
+		s.mu.Lock()
+		for s.read == s.end {
+			if s.spilling && s.writing == nil && len(s.pendingRecs) == 0 {
+				cl.cfg.logger.Log(LogLevelInfo, "all spilled records replayed, no longer spilling", "dir", cl.cfg.spillDir)
+				s.spilling = false
+				close(s.replayed)
+				s.persist()
+			}
+			s.mu.Unlock()
+			select {
+			case <-s.wake:
+			case <-cl.ctx.Done():
+				return
+			}
+			s.mu.Lock()
+		}
+		at := s.read
+		seg := s.segmentAt(at)
+		sp, ok := s.promises[at]
+		s.mu.Unlock()
+
+		// Segments at or after the read position are never removed
+		// while we run, so we can read without the mutex.
+		r, next, err := s.readAt(seg, at)
+		if err != nil {
+			// We wrote this record ourselves, so this should
+			// only happen if the disk is failing. We retry
+			// rather than drop records.
+			cl.cfg.logger.Log(LogLevelError, "unable to read spilled record, retrying in 1s", "dir", cl.cfg.spillDir, "err", err)
+			select {
+			case <-time.After(time.Second):
+			case <-cl.ctx.Done():
+				return
+			}
+			continue
+		}
+
+		// Records restored from a prior process use the restored
+		// promise; records spilled by this client are restored into
+		// the original record.
+		if ok {
+			sp.r.Key, sp.r.Value, sp.r.Headers = r.Key, r.Value, r.Headers
+			r = sp.r
+		} else {
+			sp = spilledPromise{ctx: context.Background(), promise: cl.cfg.spillRestored}
+			if sp.promise == nil {
+				sp.promise = noPromise
+			}
+		}
+
+		if !s.waitSpace(sp.ctx, r) {
+			return
+		}
+
+		s.mu.Lock()
+		delete(s.promises, at)
+		s.read = next
+		entry := &spillEntry{next: next}
+		s.inflight = append(s.inflight, entry)
+		s.mu.Unlock()
+
+		// Records restored from a prior process have not had the
+		// buffered hook called.
+		if hooks := cl.producer.hooks; !ok && hooks != nil {
+			for _, h := range hooks.buffered {
+				h.OnProduceRecordBuffered(r)
+			}
+		}
+		cl.bufferRecord(sp.ctx, r, func(r *Record, err error) {
+			s.finish(entry, err)
+			sp.promise(r, err)
+		}, true)
+	}
+}
+
+// finish marks a replayed record as finished, advancing the checkpoint past
+// every leading finished record.
+func (s *spill) finish(entry *spillEntry, err error) {
+	if err == ErrClientClosed {
+		return // left in the log to be replayed by the next client
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.closed {
+		return
+	}
+	entry.done = true
+	var advanced bool
+	for len(s.inflight) > 0 && s.inflight[0].done {
+		s.checkpoint = s.inflight[0].next
+		s.inflight = s.inflight[1:]
+		advanced = true
+	}
+	if advanced {
+		s.persist()
+	}
+}
+
+// persist saves the checkpoint and then removes any segment that every record
+// is finished in.
+func (s *spill) persist() {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], uint64(s.checkpoint))
+	if _, err := s.pos.WriteAt(b[:], 0); err != nil {
+		s.cl.cfg.logger.Log(LogLevelWarn, "unable to save spill position", "dir", s.cl.cfg.spillDir, "err", err)
+		return
+	}
+	s.dropFinishedSegments()
+}
+
+// waitReplayed waits until every spilled record has been replayed into the
+// client.
+func (s *spill) waitReplayed(ctx context.Context) error {
+	s.mu.Lock()
+	replayed := s.replayed
+	s.mu.Unlock()
+
+	select {
+	case <-replayed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-s.cl.ctx.Done():
+		return ErrClientClosed
+	}
+}
+
+// close, called when the client is closed after buffered records are failed,
+// fails the promises of records this client spilled that were not replayed,
+// in log order. The records remain in the log.
+func (s *spill) close() {
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.closed = true
+	for s.writing != nil {
+		writing := s.writing
+		s.mu.Unlock()
+		<-writing
+		s.mu.Lock()
+	}
+
+	positions := make([]int64, 0, len(s.promises))
+	for at := range s.promises {
+		positions = append(positions, at)
+	}
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	for _, at := range positions {
+		sp := s.promises[at]
+		if r, _, err := s.readAt(s.segmentAt(at), at); err == nil {
+			sp.r.Key, sp.r.Value, sp.r.Headers = r.Key, r.Value, r.Headers
+		}
+		s.cl.producer.promiseUncountedRecord(promisedRec{sp.ctx, sp.promise, sp.r}, ErrClientClosed)
+	}
+	s.promises = nil
+
+	s.closeFiles()
+}
+
+func (s *spill) closeFiles() {
+	for _, seg := range s.segments {
+		seg.f.Close()
+	}
+	s.pos.Close()
+}
+
+// readAt reads the record at a log position in a segment, returning it and
+// the position of the next record.
+func (s *spill) readAt(seg *spillSegment, at int64) (*Record, int64, error) {
+	if seg == nil {
+		return nil, 0, io.EOF
+	}
+	off := at - seg.base
+	var size [4]byte
+	if _, err := seg.f.ReadAt(size[:], off); err != nil {
+		return nil, 0, err
+	}
+	n := int64(binary.BigEndian.Uint32(size[:]))
+	if n > maxSpillFrame {
+		return nil, 0, errSpillCorrupt
+	}
+	frame := make([]byte, n+4)
+	if _, err := seg.f.ReadAt(frame, off+4); err != nil {
+		if err == io.EOF {
+			err = errSpillCorrupt
+		}
+		return nil, 0, err
+	}
+	payload, crc := frame[:n], frame[n:]
+	if crc32.Checksum(payload, crc32c) != binary.BigEndian.Uint32(crc) {
+		return nil, 0, errSpillCorrupt
+	}
+	r, err := decodeSpilledRecord(payload)
+	return r, at + 4 + n + 4, err
+}
+
+func appendSpilledRecord(dst []byte, r *Record) []byte {
+	dst = kbin.AppendString(dst, r.Topic)
+	dst = kbin.AppendInt32(dst, r.Partition)
+	dst = kbin.AppendInt64(dst, r.Timestamp.UnixNano()/1e6)
+	dst = kbin.AppendNullableBytes(dst, r.Key)
+	dst = kbin.AppendNullableBytes(dst, r.Value)
+	dst = kbin.AppendArrayLen(dst, len(r.Headers))
+	for _, h := range r.Headers {
+		dst = kbin.AppendString(dst, h.Key)
+		dst = kbin.AppendNullableBytes(dst, h.Value)
+	}
+	return dst
+}
+
+func decodeSpilledRecord(payload []byte) (*Record, error) {
+	b := kbin.Reader{Src: payload}
+	r := &Record{
+		Topic:     b.String(),
+		Partition: b.Int32(),
+		Timestamp: time.Unix(0, b.Int64()*1e6),
+		Key:       b.NullableBytes(),
+		Value:     b.NullableBytes(),
+	}
+	for n := b.ArrayLen(); n > 0 && b.Ok(); n-- {
+		r.Headers = append(r.Headers, RecordHeader{
+			Key:   b.String(),
+			Value: b.NullableBytes(),
+		})
+	}
+	if err := b.Complete(); err != nil || len(b.Src) > 0 {
+		return nil, errSpillCorrupt
+	}
+	return r, nil
+}
@@ -0,0 +1,319 @@
+package kgo
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMaxBufferedBytes(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"), // nothing is produced; records stay buffered
+		MaxBufferedBytes(10),
+		RecordDeliveryTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make(chan error, 3)
+	promise := func(_ *Record, err error) { errs <- err }
+
+	// Records are unbuffered just after their promise is called.
+	buffered := func(exp int64) int64 {
+		for i := 0; i < 100 && cl.BufferedProduceBytes() != exp; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		return cl.BufferedProduceBytes()
+	}
+
+	cl.TryProduce(context.Background(), &Record{Topic: "foo", Key: []byte("k"), Value: []byte("value")}, promise)
+	if got := cl.BufferedProduceBytes(); got != 6 {
+		t.Errorf("got buffered bytes %d != exp 6", got)
+	}
+
+	cl.TryProduce(context.Background(), &Record{Topic: "foo", Value: []byte("12345")}, promise)
+	if err := <-errs; err != ErrMaxBuffered {
+		t.Errorf("got err %v != ErrMaxBuffered", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	cl.Produce(ctx, &Record{Topic: "foo", Headers: []RecordHeader{{Key: "h", Value: []byte("1234")}}}, promise)
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("got err %v != context.DeadlineExceeded", err)
+	}
+
+	// Failed records are unbuffered, leaving only our first record.
+	if got := buffered(6); got != 6 {
+		t.Errorf("got buffered bytes %d != exp 6 after failures", got)
+	}
+
+	cl.Close()
+	if err := <-errs; err == nil {
+		t.Error("expected error for buffered record after closing")
+	}
+	if got := buffered(0); got != 0 {
+		t.Errorf("got buffered bytes %d != exp 0 after close", got)
+	}
+}
+
+func TestProducerSpillDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgo-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"), // nothing is produced; records are spilled
+		MaxBufferedRecords(1),
+		ProducerSpillDir(dir),
+		RecordDeliveryTimeout(time.Minute),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type result struct {
+		r   *Record
+		err error
+	}
+	results := make(chan result, 3)
+	promise := func(r *Record, err error) { results <- result{r, err} }
+	var produced []*Record
+	for _, v := range []string{"1", "2", "3"} {
+		r := &Record{
+			Topic:   "foo",
+			Key:     []byte("k"),
+			Value:   []byte(v),
+			Headers: []RecordHeader{{Key: "h", Value: []byte(v)}},
+		}
+		produced = append(produced, r)
+		cl.TryProduce(context.Background(), r, promise)
+	}
+	select {
+	case res := <-results:
+		t.Fatalf("unexpected promise before close: %v", res.err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	cl.Close()
+	for i := 0; i < 3; i++ {
+		res := <-results
+		if res.err != ErrClientClosed {
+			t.Errorf("got err %v != ErrClientClosed", res.err)
+		}
+		if res.r != produced[i] {
+			t.Errorf("promise %d: got a different record than was produced", i)
+		}
+		if exp := strconv.Itoa(i + 1); string(res.r.Value) != exp || len(res.r.Headers) != 1 || string(res.r.Headers[0].Value) != exp {
+			t.Errorf("promise %d: got record value %q headers %v, exp value and header %q", i, res.r.Value, res.r.Headers, exp)
+		}
+	}
+
+	// The first record was buffered in memory; the rest remain on disk
+	// to be replayed by the next client.
+	s, err := newSpill(&Client{cfg: cfg{spillDir: dir, logger: new(nopLogger)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.closeFiles()
+
+	var got []string
+	for at := s.read; at < s.end; {
+		r, next, err := s.readAt(s.segmentAt(at), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r.Topic != "foo" || string(r.Key) != "k" || len(r.Headers) != 1 || string(r.Headers[0].Value) != string(r.Value) || r.Timestamp.IsZero() {
+			t.Errorf("unexpected restored record %+v", r)
+		}
+		got = append(got, string(r.Value))
+		at = next
+	}
+	if len(got) != 2 || got[0] != "2" || got[1] != "3" {
+		t.Errorf("got restored values %v != exp [2 3]", got)
+	}
+	if !s.spilling {
+		t.Error("expected restored spill to be spilling")
+	}
+}
+
+func TestProducerSpillReplaysOriginalRecords(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgo-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"), // nothing is produced; records time out
+		MaxBufferedRecords(1),
+		ProducerSpillDir(dir),
+		RecordDeliveryTimeout(time.Second),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	promised := make(chan bool, 3)
+	var produced []*Record
+	for i := 0; i < 3; i++ {
+		r := &Record{Topic: "foo", Value: []byte("v")}
+		produced = append(produced, r)
+		cl.TryProduce(context.Background(), r, func(got *Record, _ error) {
+			promised <- got == r && string(got.Value) == "v"
+		})
+	}
+	// The first record is buffered in memory, and the second and third
+	// are replayed from the spill once the prior records time out.
+	for i := 0; i < 3; i++ {
+		select {
+		case same := <-promised:
+			if !same {
+				t.Errorf("promise %d: got a different or empty record than was produced", i)
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatal("timed out waiting for spilled records to be replayed")
+		}
+	}
+}
+
+func TestProducerSpillSegments(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kgo-spill")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	newTestSpill := func() *spill {
+		s, err := newSpill(&Client{cfg: cfg{spillDir: dir, logger: new(nopLogger)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	segments := func() int {
+		matches, err := filepath.Glob(filepath.Join(dir, spillSegmentPrefix+"*"+spillSegmentSuffix))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(matches)
+	}
+
+	// With no space to buffer, every record is spilled. Each record
+	// fills a segment, so every record starts a new segment.
+	s := newTestSpill()
+	s.segmentBytes = 1
+	var produced []*Record
+	for i := 0; i < 5; i++ {
+		r := &Record{Topic: "foo", Value: []byte(strconv.Itoa(i))}
+		produced = append(produced, r)
+		if !s.maybeSpill(context.Background(), r, nil) {
+			t.Fatal("record was not spilled")
+		}
+		if r.Value != nil {
+			t.Errorf("record %d: value is still in memory after spilling", i)
+		}
+	}
+	if n := segments(); n != 5 {
+		t.Errorf("got %d segments != exp 5", n)
+	}
+
+	// Finishing replayed records removes the segments before the
+	// checkpoint.
+	for i := 0; i < 3; i++ {
+		r, next, err := s.readAt(s.segmentAt(s.read), s.read)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(r.Value) != strconv.Itoa(i) {
+			t.Errorf("replay %d: got value %q", i, r.Value)
+		}
+		s.read = next
+		entry := &spillEntry{next: next}
+		s.inflight = append(s.inflight, entry)
+		s.finish(entry, nil)
+	}
+	if n := segments(); n != 2 {
+		t.Errorf("got %d segments != exp 2 after finishing three records", n)
+	}
+	s.closeFiles()
+
+	// The unfinished records are restored by the next spill.
+	s = newTestSpill()
+	defer s.closeFiles()
+	var got []string
+	for at := s.read; at < s.end; {
+		r, next, err := s.readAt(s.segmentAt(at), at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(r.Value))
+		at = next
+	}
+	if len(got) != 2 || got[0] != "3" || got[1] != "4" {
+		t.Errorf("got restored values %v != exp [3 4]", got)
+	}
+}
+
+func TestProduceBatch(t *testing.T) {
+	cl, err := NewClient(
+		SeedBrokers("127.0.0.1:1"), // the topic is never loaded
+		MaxBufferedRecords(2),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cl.Close()
+
+	errs := make(chan error, 1)
+	promise := func(b PartitionRecords, err error) {
+		if b.Topic != "foo" && len(b.Records) > 0 {
+			t.Errorf("got batch topic %q != exp foo", b.Topic)
+		}
+		errs <- err
+	}
+	recs := func(n int) []*Record {
+		var rs []*Record
+		for i := 0; i < n; i++ {
+			rs = append(rs, &Record{Value: []byte("v")})
+		}
+		return rs
+	}
+
+	cl.ProduceBatch(context.Background(), PartitionRecords{Topic: "foo"}, promise)
+	if err := <-errs; err != errNoBatchRecords {
+		t.Errorf("got err %v != errNoBatchRecords", err)
+	}
+
+	cl.ProduceBatch(context.Background(), PartitionRecords{Topic: "foo", Records: recs(3)}, promise)
+	if err := <-errs; err != ErrMaxBuffered {
+		t.Errorf("got err %v != ErrMaxBuffered", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	batch := PartitionRecords{Topic: "foo", Partition: 1, Records: recs(2)}
+	cl.ProduceBatch(ctx, batch, promise)
+	if err := <-errs; err != context.DeadlineExceeded {
+		t.Errorf("got err %v != context.DeadlineExceeded", err)
+	}
+	for _, r := range batch.Records {
+		if r.Topic != "foo" || r.Partition != 1 {
+			t.Errorf("got record topic %q partition %d != exp foo 1", r.Topic, r.Partition)
+		}
+	}
+	for i := 0; i < 100 && cl.BufferedProduceRecords() != 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := cl.BufferedProduceRecords(); got != 0 {
+		t.Errorf("got buffered records %d != exp 0 after failures", got)
+	}
+}
@@ -369,6 +369,14 @@ func (fs Fetches) IsClientClosed() bool {
 	return len(fs) == 1 && len(fs[0].Topics) == 1 && len(fs[0].Topics[0].Partitions) == 1 && errors.Is(fs[0].Topics[0].Partitions[0].Err, ErrClientClosed)
 }
 
+// IsConsumedToEnd returns whether the fetches includes an error indicating
+// that, with ConsumeUntilEnd, every partition has been consumed to its end.
+//
+// This function is useful to break out of a poll loop for bounded consuming.
+func (fs Fetches) IsConsumedToEnd() bool {
+	return len(fs) == 1 && len(fs[0].Topics) == 1 && len(fs[0].Topics[0].Partitions) == 1 && errors.Is(fs[0].Topics[0].Partitions[0].Err, ErrConsumedToEnd)
+}
+
 // Err returns the first error in all fetches, if any. This can be used to
 // quickly check if the client is closed or your poll context was canceled, or
 // to check if there's some other error that requires deeper investigation with
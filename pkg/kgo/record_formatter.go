@@ -3,6 +3,7 @@ package kgo
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/hex"
@@ -79,6 +80,7 @@ func (f *RecordFormatter) AppendPartitionRecord(b []byte, p *FetchPartition, r *
 //     %d    timestamp (date, formatting described below)
 //     %x    producer id
 //     %y    producer epoch
+//     %j    the entire record as a JSON object (described below)
 //
 // For AppendPartitionRecord, the formatter also undersands the following three
 // formatting options:
@@ -197,8 +199,49 @@ func (f *RecordFormatter) AppendPartitionRecord(b []byte, p *FetchPartition, r *
 // multiple times. If the parser needs more data than available, or if the more
 // input remains after '$', an error message will be appended.
 //
-func NewRecordFormatter(layout string) (*RecordFormatter, error) {
+// Schemas
+//
+// Keys and values that are encoded according to the schema registry wire
+// format have two further formatting options:
+//
+//     %v{schemaid}    print the schema ID from the wire format header
+//     %v{schema}      print the value decoded as single line JSON
+//
+// Printing decoded values requires a SchemaCodec, which is provided with the
+// FormatSchemaCodec option. If a key or value cannot be decoded, an error
+// message is appended.
+//
+// JSON
+//
+// The %j verb prints the record as a single line JSON object with the
+// following fields, properly escaped:
+//
+//     {"topic":"foo","partition":0,"offset":3,"timestamp":1650000000000,
+//      "headers":[{"key":"h","value":"v"}],"key":"k","value":"v"}
+//
+// The timestamp is in milliseconds and omitted if zero, and null keys,
+// values, and header values are printed as JSON null. By default, keys,
+// values, and header values are printed as JSON strings, which is lossy if
+// they are not valid UTF-8. The encoding can be changed for all three at
+// once, or for any individually:
+//
+//     %j{base64}                        base64 encode keys, values, and headers
+//     %j{key=text,value=hex}            print keys as text and values as hex
+//     %j{value=json}                    embed values directly if valid JSON
+//     %j{key=text,value=schema}         decode values with the SchemaCodec
+//
+// The "schema" encoding can only be used for keys and values, requires the
+// FormatSchemaCodec option, and adds a "key_schema_id" or "value_schema_id"
+// field. Payloads that cannot be decoded are printed as base64 without the
+// schema ID field. With the "json" encoding, payloads that are not valid JSON
+// are printed as base64 with a "key_base64", "value_base64", or header
+// "base64" field set to true, and valid JSON is compacted when read back.
+// For example, "%j{base64}\n" prints JSON lines that a RecordReader with the
+// same layout reads back losslessly.
+//
+func NewRecordFormatter(layout string, opts ...FormatOpt) (*RecordFormatter, error) {
 	var f RecordFormatter
+	cfg := newFormatCfg(opts)
 
 	var literal []byte // non-formatted raw text to output
 	var i int
@@ -341,6 +384,15 @@ func NewRecordFormatter(layout string) (*RecordFormatter, error) {
 				case strings.HasPrefix(layout, "hex}"):
 					appendFn = appendHex
 					layout = layout[len("hex}"):]
+				case escaped != 't' && strings.HasPrefix(layout, "schemaid}"):
+					appendFn = appendSchemaID(escaped)
+					layout = layout[len("schemaid}"):]
+				case escaped != 't' && strings.HasPrefix(layout, "schema}"):
+					if cfg.codec == nil {
+						return nil, fmt.Errorf("%%%s{schema} requires a schema codec, see FormatSchemaCodec", string(escaped))
+					}
+					appendFn = appendSchemaJSON(escaped, cfg.codec)
+					layout = layout[len("schema}"):]
 				case strings.HasPrefix(layout, "unpack"):
 					unpack, rem, err := nomOpenClose(layout[len("unpack"):])
 					if err != nil {
@@ -404,7 +456,7 @@ func NewRecordFormatter(layout string) (*RecordFormatter, error) {
 
 			spec := layout[:at-1]
 			layout = layout[at:]
-			inf, err := NewRecordFormatter(spec)
+			inf, err := NewRecordFormatter(spec, opts...)
 			if err != nil {
 				return nil, fmt.Errorf("invalid header specification %q: %v", spec, err)
 			}
@@ -419,6 +471,18 @@ func NewRecordFormatter(layout string) (*RecordFormatter, error) {
 				return b
 			})
 
+		case 'j':
+			encs, n, err := parseJSONLayout(layout, isOpenBrace, cfg.codec)
+			if err != nil {
+				return nil, err
+			}
+			handledBrace = isOpenBrace
+			layout = layout[n:]
+			codec := cfg.codec
+			f.fns = append(f.fns, func(b []byte, _ *FetchPartition, r *Record) []byte {
+				return writeR(b, r, func(b []byte, r *Record) []byte { return appendJSONRecord(b, r, encs, codec) })
+			})
+
 		case 'd':
 			// For datetime parsing, we support plain millis in any
 			// number format, strftime, or go formatting. We
@@ -507,6 +571,26 @@ func appendHex(dst, src []byte) []byte {
 	return fin
 }
 
+func appendSchemaID(escaped byte) func([]byte, []byte) []byte {
+	return func(dst, src []byte) []byte {
+		id, ok := schemaHeaderID(src)
+		if !ok {
+			return append(dst, fmt.Sprintf("%%!%s(schemaid: invalid header)", string(escaped))...)
+		}
+		return strconv.AppendInt(dst, int64(id), 10)
+	}
+}
+
+func appendSchemaJSON(escaped byte, codec SchemaCodec) func([]byte, []byte) []byte {
+	return func(dst, src []byte) []byte {
+		b, err := codec.AppendJSON(context.Background(), dst, src)
+		if err != nil {
+			return append(dst, fmt.Sprintf("%%!%s(schema: %v)", string(escaped), err)...)
+		}
+		return b
+	}
+}
+
 // nomOpenClose extracts a middle section from a string beginning with repeated
 // delimiters and returns it as with remaining (past end delimiters) string.
 func nomOpenClose(src string) (middle, remaining string, err error) {
@@ -821,7 +905,8 @@ func writeNumByte(b []byte, n int64) []byte { u := uint64(n); return append(b, b
 
 // RecordReader reads records from an io.Reader.
 type RecordReader struct {
-	r *bufio.Reader
+	r   *bufio.Reader
+	cfg formatCfg
 
 	buf []byte
 	fns []readParse
@@ -861,6 +946,7 @@ type RecordReader struct {
 //     %d    timestamp
 //     %x    producer id
 //     %y    producer epoch
+//     %j    an entire record as a JSON object
 //
 // If using length / number verbs (i.e., "sized" verbs), they must occur before
 // what they are sizing.
@@ -920,8 +1006,28 @@ type RecordReader struct {
 //
 //     %k{re[\d*]}%v{re[\s+]}
 //
-func NewRecordReader(reader io.Reader, layout string) (*RecordReader, error) {
-	r := &RecordReader{r: bufio.NewReader(reader)}
+// Schemas
+//
+// Keys and values can be read as JSON and encoded according to the schema
+// registry wire format with a SchemaCodec, which is provided with the
+// FormatSchemaCodec option. The schema ID to encode with can either be given
+// in the layout, or be read as a number before the JSON:
+//
+//     %v{schema[123]}           read JSON and encode it with schema ID 123
+//     %v{schemaid} %v{schema}   read a schema ID, then JSON to encode with it
+//
+// This mirrors the RecordFormatter's schema options, such that a layout that
+// prints records can read them back.
+//
+// JSON
+//
+// The %j verb reads a JSON object as printed by the RecordFormatter's %j verb,
+// accepting the same encoding modifiers. The JSON object is read until the
+// following delimiter, so JSON lines are read with "%j\n", or for example
+// "%j{base64}\n". Fields that are missing from the object are left unset.
+//
+func NewRecordReader(reader io.Reader, layout string, opts ...FormatOpt) (*RecordReader, error) {
+	r := &RecordReader{r: bufio.NewReader(reader), cfg: newFormatCfg(opts)}
 	if err := r.parseReadLayout(layout); err != nil {
 		return nil, err
 	}
@@ -992,6 +1098,11 @@ func (r *RecordReader) parseReadLayout(layout string) error {
 		valueSize  = new(uint64)
 		headersNum = new(uint64)
 
+		// If reading schema IDs, we read the ID into one of these, and
+		// the schema encoding reads the ID when encoding.
+		keyID, valueID       = new(uint64), new(uint64)
+		readKeyID, readValID bool
+
 		bits parseRecordBits
 
 		literal    []byte // raw literal we are currently working on
@@ -1150,6 +1261,20 @@ func (r *RecordReader) parseReadLayout(layout string) error {
 			r.fns = append(r.fns, fn)
 
 		case 't', 'k', 'v':
+			if isOpenBrace && escaped != 't' && strings.HasPrefix(layout, "schemaid}") {
+				handledBrace = true
+				layout = layout[len("schemaid}"):]
+				dst := keyID
+				if escaped == 'k' {
+					readKeyID = true
+				} else {
+					dst, readValID = valueID, true
+				}
+				fn, _, _ := r.parseReadSize("ascii", dst, false)
+				r.fns = append(r.fns, fn)
+				break
+			}
+
 			var decodeFn func([]byte) ([]byte, error)
 			var re *regexp.Regexp
 			if handledBrace = isOpenBrace; handledBrace {
@@ -1160,6 +1285,37 @@ func (r *RecordReader) parseReadLayout(layout string) error {
 				case strings.HasPrefix(layout, "hex}"):
 					decodeFn = decodeHex
 					layout = layout[len("hex}"):]
+				case escaped != 't' && strings.HasPrefix(layout, "schema"):
+					if r.cfg.codec == nil {
+						return fmt.Errorf("%%%s{schema} requires a schema codec, see FormatSchemaCodec", string(escaped))
+					}
+					id, read := keyID, readKeyID
+					if escaped == 'v' {
+						id, read = valueID, readValID
+					}
+					rem := layout[len("schema"):]
+					if len(rem) > 0 && rem[0] != '}' {
+						idstr, rem2, err := nomOpenClose(rem)
+						if err != nil {
+							return fmt.Errorf("schema parse err: %v", err)
+						}
+						fixed, err := strconv.ParseUint(idstr, 10, 31)
+						if err != nil {
+							return fmt.Errorf("invalid schema id %q: %v", idstr, err)
+						}
+						id, read, rem = &fixed, true, rem2
+					}
+					if len(rem) == 0 || rem[0] != '}' {
+						return fmt.Errorf("schema missing closing } in %q", layout)
+					}
+					if !read {
+						return fmt.Errorf("%%%s{schema} requires a schema ID, either with %%%s{schemaid} before it or %%%s{schema[id]}", string(escaped), string(escaped), string(escaped))
+					}
+					layout = rem[1:]
+					codec := r.cfg.codec
+					decodeFn = func(b []byte) ([]byte, error) {
+						return codec.EncodeJSON(context.Background(), int(*id), b)
+					}
 				case strings.HasPrefix(layout, "re"):
 					restr, rem, err := nomOpenClose(layout[len("re"):])
 					if err != nil {
@@ -1219,6 +1375,18 @@ func (r *RecordReader) parseReadLayout(layout string) error {
 			}
 			r.fns = append(r.fns, fn)
 
+		case 'j':
+			encs, n, err := parseJSONLayout(layout, isOpenBrace, r.cfg.codec)
+			if err != nil {
+				return err
+			}
+			handledBrace = isOpenBrace
+			layout = layout[n:]
+			codec := r.cfg.codec
+			r.fns = append(r.fns, readParse{parse: func(b []byte, rec *Record) error {
+				return parseJSONRecord(b, rec, encs, codec)
+			}})
+
 		case 'h':
 			bits.set(parsesHeaders)
 			if !bits.has(parsesHeadersNum) {
@@ -1624,6 +1792,56 @@ func (r *RecordReader) readDelim(d []byte) error {
 // COMMON //
 ////////////
 
+// SchemaCodec converts between schema encoded payloads and JSON, allowing a
+// RecordFormatter to print, and a RecordReader to parse, keys and values that
+// are encoded according to the schema registry wire format. The sr package's
+// AvroSerde and JSONSerde, as well as the srproto package's Serde, implement
+// this interface.
+type SchemaCodec interface {
+	// AppendJSON decodes the schema encoded b and appends its value as
+	// single line JSON to dst.
+	AppendJSON(ctx context.Context, dst, b []byte) ([]byte, error)
+
+	// EncodeJSON encodes a JSON value with the schema for the given ID.
+	EncodeJSON(ctx context.Context, id int, json []byte) ([]byte, error)
+}
+
+// FormatOpt is an option for NewRecordFormatter or NewRecordReader.
+type FormatOpt interface {
+	apply(*formatCfg)
+}
+
+type formatOpt struct{ fn func(*formatCfg) }
+
+func (o formatOpt) apply(cfg *formatCfg) { o.fn(cfg) }
+
+type formatCfg struct {
+	codec SchemaCodec
+}
+
+func newFormatCfg(opts []FormatOpt) formatCfg {
+	var cfg formatCfg
+	for _, opt := range opts {
+		opt.apply(&cfg)
+	}
+	return cfg
+}
+
+// FormatSchemaCodec sets the codec to use for the "schema" key and value
+// modifiers in a RecordFormatter or RecordReader layout.
+func FormatSchemaCodec(codec SchemaCodec) FormatOpt {
+	return formatOpt{func(cfg *formatCfg) { cfg.codec = codec }}
+}
+
+// schemaHeaderID returns the schema ID from the schema registry wire format
+// header of b.
+func schemaHeaderID(b []byte) (int, bool) {
+	if len(b) < 5 || b[0] != 0 {
+		return 0, false
+	}
+	return int(binary.BigEndian.Uint32(b[1:5])), true
+}
+
 func parseLayoutSlash(layout string) (byte, int, error) {
 	if len(layout) == 0 {
 		return 0, 0, errors.New("invalid slash escape at end of delim string")
@@ -0,0 +1,91 @@
+package kgo
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"sync/atomic"
+)
+
+// AvroOCFSchema is the Avro schema of records written by a formatter returned
+// from NewAvroOCFFormatter.
+const AvroOCFSchema = `{"type":"record","name":"Record","namespace":"kgo","fields":[` +
+	`{"name":"topic","type":"string"},` +
+	`{"name":"partition","type":"int"},` +
+	`{"name":"offset","type":"long"},` +
+	`{"name":"timestamp","type":{"type":"long","logicalType":"timestamp-millis"}},` +
+	`{"name":"headers","type":{"type":"array","items":{"type":"record","name":"Header","fields":[` +
+	`{"name":"key","type":"string"},` +
+	`{"name":"value","type":["null","bytes"]}]}}},` +
+	`{"name":"key","type":["null","bytes"]},` +
+	`{"name":"value","type":["null","bytes"]}]}`
+
+// NewAvroOCFFormatter returns a RecordFormatter that formats records as an
+// Avro object container file using the AvroOCFSchema, such that dumped
+// records can be read by any Avro tooling.
+//
+// The first call to AppendRecord or AppendPartitionRecord writes the file
+// header, and every call writes one uncompressed block containing the one
+// record. Because the header is written once, all output of the formatter
+// must go to the same file in the order it was appended; a formatter must not
+// be used concurrently or for more than one file.
+func NewAvroOCFFormatter() *RecordFormatter {
+	var marker [16]byte
+	rand.Read(marker[:])
+	var wroteHeader int32
+
+	var f RecordFormatter
+	f.fns = append(f.fns, func(b []byte, _ *FetchPartition, r *Record) []byte {
+		if atomic.CompareAndSwapInt32(&wroteHeader, 0, 1) {
+			b = append(b, "Obj\x01"...)
+			b = appendAvroLong(b, 2) // metadata map block of two entries
+			b = appendAvroBytes(b, []byte("avro.schema"))
+			b = appendAvroBytes(b, []byte(AvroOCFSchema))
+			b = appendAvroBytes(b, []byte("avro.codec"))
+			b = appendAvroBytes(b, []byte("null"))
+			b = appendAvroLong(b, 0) // end of map
+			b = append(b, marker[:]...)
+		}
+		if r == nil {
+			return b
+		}
+
+		var data []byte
+		data = appendAvroBytes(data, []byte(r.Topic))
+		data = appendAvroLong(data, int64(r.Partition))
+		data = appendAvroLong(data, r.Offset)
+		data = appendAvroLong(data, r.Timestamp.UnixNano()/1e6)
+		if len(r.Headers) > 0 {
+			data = appendAvroLong(data, int64(len(r.Headers)))
+			for _, h := range r.Headers {
+				data = appendAvroBytes(data, []byte(h.Key))
+				data = appendAvroNullableBytes(data, h.Value)
+			}
+		}
+		data = appendAvroLong(data, 0) // end of headers array
+		data = appendAvroNullableBytes(data, r.Key)
+		data = appendAvroNullableBytes(data, r.Value)
+
+		b = appendAvroLong(b, 1) // one record in this block
+		b = appendAvroLong(b, int64(len(data)))
+		b = append(b, data...)
+		return append(b, marker[:]...)
+	})
+	return &f
+}
+
+func appendAvroLong(b []byte, n int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], n)]...)
+}
+
+func appendAvroBytes(b, v []byte) []byte {
+	return append(appendAvroLong(b, int64(len(v))), v...)
+}
+
+// appendAvroNullableBytes appends v as the union ["null","bytes"].
+func appendAvroNullableBytes(b, v []byte) []byte {
+	if v == nil {
+		return appendAvroLong(b, 0)
+	}
+	return appendAvroBytes(appendAvroLong(b, 1), v)
+}
@@ -0,0 +1,256 @@
+package kgo
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// jsonEncoding is how a key, value, or header value is encoded in a JSON
+// record.
+type jsonEncoding uint8
+
+const (
+	jsonEncText jsonEncoding = iota
+	jsonEncBase64
+	jsonEncHex
+	jsonEncJSON
+	jsonEncSchema
+)
+
+// jsonEncodings is the parsed %j modifier, specifying how each of a record's
+// key, value, and header values are encoded.
+type jsonEncodings struct {
+	key, value, headers jsonEncoding
+}
+
+// jsonRecord is the JSON representation of a record used by %j.
+type jsonRecord struct {
+	Topic         string             `json:"topic"`
+	Partition     int32              `json:"partition"`
+	Offset        int64              `json:"offset"`
+	Timestamp     *int64             `json:"timestamp,omitempty"`
+	Headers       []jsonRecordHeader `json:"headers"`
+	KeySchemaID   *int               `json:"key_schema_id,omitempty"`
+	KeyBase64     bool               `json:"key_base64,omitempty"`
+	Key           json.RawMessage    `json:"key"`
+	ValueSchemaID *int               `json:"value_schema_id,omitempty"`
+	ValueBase64   bool               `json:"value_base64,omitempty"`
+	Value         json.RawMessage    `json:"value"`
+}
+
+type jsonRecordHeader struct {
+	Key    string          `json:"key"`
+	Base64 bool            `json:"base64,omitempty"`
+	Value  json.RawMessage `json:"value"`
+}
+
+// parseJSONEncodings parses the inside of a %j{...} modifier, which is a
+// comma delimited list of either a bare encoding, applying to the key, value,
+// and header values, or "key=", "value=", or "headers=" followed by an
+// encoding.
+func parseJSONEncodings(spec string, codec SchemaCodec) (jsonEncodings, error) {
+	var encs jsonEncodings
+	for _, field := range strings.Split(spec, ",") {
+		which, name := "", field
+		if eq := strings.IndexByte(field, '='); eq != -1 {
+			which, name = field[:eq], field[eq+1:]
+		}
+		var enc jsonEncoding
+		switch name {
+		case "text":
+			enc = jsonEncText
+		case "base64":
+			enc = jsonEncBase64
+		case "hex":
+			enc = jsonEncHex
+		case "json":
+			enc = jsonEncJSON
+		case "schema":
+			if codec == nil {
+				return encs, errors.New("%j schema encoding requires a schema codec, see FormatSchemaCodec")
+			}
+			enc = jsonEncSchema
+		default:
+			return encs, fmt.Errorf("unknown %%j encoding %q", name)
+		}
+		switch which {
+		case "":
+			if enc == jsonEncSchema {
+				return encs, errors.New("%j schema encoding can only be used for keys and values")
+			}
+			encs = jsonEncodings{enc, enc, enc}
+		case "key":
+			encs.key = enc
+		case "value":
+			encs.value = enc
+		case "headers":
+			if enc == jsonEncSchema {
+				return encs, errors.New("%j schema encoding can only be used for keys and values")
+			}
+			encs.headers = enc
+		default:
+			return encs, fmt.Errorf("unknown %%j field %q", which)
+		}
+	}
+	return encs, nil
+}
+
+// parseJSONLayout parses an optional %j{...} modifier at the start of layout,
+// returning the encodings and how much of the layout was consumed.
+func parseJSONLayout(layout string, isOpenBrace bool, codec SchemaCodec) (jsonEncodings, int, error) {
+	if !isOpenBrace {
+		return jsonEncodings{}, 0, nil
+	}
+	end := strings.IndexByte(layout, '}')
+	if end == -1 {
+		return jsonEncodings{}, 0, fmt.Errorf("%%j missing closing } in %q", layout)
+	}
+	encs, err := parseJSONEncodings(layout[:end], codec)
+	return encs, end + 1, err
+}
+
+// marshalJSON marshals v without escaping HTML characters and without a
+// trailing newline.
+func marshalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// jsonEncode encodes b as a JSON value. Schema encoded payloads that cannot be
+// decoded fall back to base64, which is signified by not returning a schema ID.
+// Payloads that are not valid JSON for the json encoding fall back to base64,
+// which is signified by returning true.
+func jsonEncode(b []byte, enc jsonEncoding, codec SchemaCodec) (json.RawMessage, *int, bool) {
+	if b == nil {
+		return json.RawMessage("null"), nil, false
+	}
+	switch enc {
+	case jsonEncBase64:
+		raw, _ := json.Marshal(b)
+		return raw, nil, false
+	case jsonEncHex:
+		raw, _ := json.Marshal(hex.EncodeToString(b))
+		return raw, nil, false
+	case jsonEncJSON:
+		if json.Valid(b) {
+			return json.RawMessage(b), nil, false
+		}
+		raw, _, _ := jsonEncode(b, jsonEncBase64, codec)
+		return raw, nil, true
+	case jsonEncSchema:
+		if id, ok := schemaHeaderID(b); ok {
+			if js, err := codec.AppendJSON(context.Background(), nil, b); err == nil && json.Valid(js) {
+				return js, &id, false
+			}
+		}
+		return jsonEncode(b, jsonEncBase64, codec)
+	}
+	raw, _ := marshalJSON(string(b))
+	return raw, nil, false
+}
+
+// jsonDecode decodes a JSON value that was encoded with jsonEncode.
+func jsonDecode(raw json.RawMessage, enc jsonEncoding, id *int, isBase64 bool, codec SchemaCodec) ([]byte, error) {
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return nil, nil
+	}
+	if isBase64 {
+		enc = jsonEncBase64
+	}
+	switch enc {
+	case jsonEncBase64:
+		var b []byte
+		err := json.Unmarshal(raw, &b)
+		if b == nil && err == nil {
+			b = []byte{}
+		}
+		return b, err
+	case jsonEncHex:
+		var s string
+		if err := json.Unmarshal(raw, &s); err != nil {
+			return nil, err
+		}
+		b, err := hex.DecodeString(s)
+		if b == nil && err == nil {
+			b = []byte{}
+		}
+		return b, err
+	case jsonEncJSON:
+		var buf bytes.Buffer
+		err := json.Compact(&buf, raw)
+		return buf.Bytes(), err
+	case jsonEncSchema:
+		if id == nil {
+			return jsonDecode(raw, jsonEncBase64, nil, false, codec)
+		}
+		return codec.EncodeJSON(context.Background(), *id, raw)
+	}
+	var s string
+	err := json.Unmarshal(raw, &s)
+	return []byte(s), err
+}
+
+// appendJSONRecord appends r as a single line JSON object to b.
+func appendJSONRecord(b []byte, r *Record, encs jsonEncodings, codec SchemaCodec) []byte {
+	jr := jsonRecord{
+		Topic:     r.Topic,
+		Partition: r.Partition,
+		Offset:    r.Offset,
+		Headers:   make([]jsonRecordHeader, 0, len(r.Headers)),
+	}
+	if !r.Timestamp.IsZero() { // a zero timestamp is omitted so that it is read back as zero
+		ts := r.Timestamp.UnixNano() / 1e6
+		jr.Timestamp = &ts
+	}
+	for _, h := range r.Headers {
+		v, _, isBase64 := jsonEncode(h.Value, encs.headers, codec)
+		jr.Headers = append(jr.Headers, jsonRecordHeader{h.Key, isBase64, v})
+	}
+	jr.Key, jr.KeySchemaID, jr.KeyBase64 = jsonEncode(r.Key, encs.key, codec)
+	jr.Value, jr.ValueSchemaID, jr.ValueBase64 = jsonEncode(r.Value, encs.value, codec)
+	js, err := marshalJSON(&jr)
+	if err != nil {
+		return append(b, fmt.Sprintf("%%!j(%v)", err)...)
+	}
+	return append(b, js...)
+}
+
+// parseJSONRecord parses a JSON object written by appendJSONRecord into r.
+func parseJSONRecord(b []byte, r *Record, encs jsonEncodings, codec SchemaCodec) error {
+	var jr jsonRecord
+	if err := json.Unmarshal(b, &jr); err != nil {
+		return fmt.Errorf("invalid JSON record: %v", err)
+	}
+	r.Topic = jr.Topic
+	r.Partition = jr.Partition
+	r.Offset = jr.Offset
+	if jr.Timestamp != nil {
+		r.Timestamp = time.Unix(0, *jr.Timestamp*1e6)
+	}
+	for _, h := range jr.Headers {
+		v, err := jsonDecode(h.Value, encs.headers, nil, h.Base64, codec)
+		if err != nil {
+			return fmt.Errorf("invalid JSON record header %q: %v", h.Key, err)
+		}
+		r.Headers = append(r.Headers, RecordHeader{Key: h.Key, Value: v})
+	}
+	var err error
+	if r.Key, err = jsonDecode(jr.Key, encs.key, jr.KeySchemaID, jr.KeyBase64, codec); err != nil {
+		return fmt.Errorf("invalid JSON record key: %v", err)
+	}
+	if r.Value, err = jsonDecode(jr.Value, encs.value, jr.ValueSchemaID, jr.ValueBase64, codec); err != nil {
+		return fmt.Errorf("invalid JSON record value: %v", err)
+	}
+	return nil
+}
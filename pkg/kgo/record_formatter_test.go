@@ -1,6 +1,8 @@
 package kgo
 
 import (
+	"context"
+	"encoding/binary"
 	"errors"
 	"io"
 	"reflect"
@@ -546,6 +548,278 @@ func TestRecordReader(t *testing.T) {
 	}
 }
 
+// rawJSONCodec encodes JSON as is after the schema registry wire format
+// header.
+type rawJSONCodec struct{}
+
+func (rawJSONCodec) AppendJSON(_ context.Context, dst, b []byte) ([]byte, error) {
+	if _, ok := schemaHeaderID(b); !ok {
+		return dst, errors.New("bad header")
+	}
+	return append(dst, b[5:]...), nil
+}
+
+func (rawJSONCodec) EncodeJSON(_ context.Context, id int, json []byte) ([]byte, error) {
+	return append([]byte{0, 0, 0, byte(id >> 8), byte(id)}, json...), nil
+}
+
+func TestRecordFormatterSchema(t *testing.T) {
+	if _, err := NewRecordFormatter("%v{schema}"); err == nil {
+		t.Error("expected error using a schema verb without a codec")
+	}
+	if _, err := NewRecordReader(strings.NewReader(""), "%v{schema}", FormatSchemaCodec(rawJSONCodec{})); err == nil {
+		t.Error("expected error reading a schema verb without a schema id")
+	}
+
+	recs := []*Record{
+		{Key: []byte("k1"), Value: []byte("\x00\x00\x00\x01\x02{\"a\":1}")},
+		{Key: []byte("k2"), Value: []byte("\x00\x00\x00\x00\x07[true]")},
+	}
+	const layout = "%k %v{schemaid} %v{schema}\n"
+	f, err := NewRecordFormatter(layout, FormatSchemaCodec(rawJSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []byte
+	for _, r := range recs {
+		out = f.AppendRecord(out, r)
+	}
+	if exp := "k1 258 {\"a\":1}\nk2 7 [true]\n"; string(out) != exp {
+		t.Errorf("got %q != exp %q", out, exp)
+	}
+	if got := f.AppendRecord(nil, &Record{Value: []byte("x")}); !strings.Contains(string(got), "%!v(schemaid: invalid header)") {
+		t.Errorf("got %q, expected invalid header error", got)
+	}
+
+	r, err := NewRecordReader(strings.NewReader(string(out)), layout, FormatSchemaCodec(rawJSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range recs {
+		got, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("%d: unable to read record: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%d: got %q %q != exp %q %q", i, got.Key, got.Value, exp.Key, exp.Value)
+		}
+	}
+
+	r, err = NewRecordReader(strings.NewReader(`{"b":2}`), "%v{schema[3]}", FormatSchemaCodec(rawJSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := r.ReadRecord(); err != nil || string(got.Value) != "\x00\x00\x00\x00\x03{\"b\":2}" {
+		t.Errorf("got %q (err %v) != exp schema 3 encoding", got.Value, err)
+	}
+}
+
+func TestRecordFormatterJSON(t *testing.T) {
+	recs := []*Record{
+		{
+			Topic:     "foo",
+			Partition: 2,
+			Offset:    10,
+			Timestamp: time.Unix(1650000000, 123e6),
+			Headers:   []RecordHeader{{Key: "h\"1", Value: []byte("\x00\xff")}, {Key: "h2"}},
+			Key:       []byte("<key>\n"),
+			Value:     []byte("\x00\x01\x02"),
+		},
+		{Topic: "bar", Key: []byte{}, Timestamp: time.Unix(0, 0)},
+	}
+
+	for _, test := range []struct {
+		layout string
+		exp    string
+	}{
+		{
+			layout: "%j{base64}\n",
+			exp: `{"topic":"foo","partition":2,"offset":10,"timestamp":1650000000123,"headers":[{"key":"h\"1","value":"AP8="},{"key":"h2","value":null}],"key":"PGtleT4K","value":"AAEC"}` + "\n" +
+				`{"topic":"bar","partition":0,"offset":0,"timestamp":0,"headers":[],"key":"","value":null}` + "\n",
+		},
+		{
+			layout: "%j{key=text,value=hex,headers=hex}\n",
+			exp: `{"topic":"foo","partition":2,"offset":10,"timestamp":1650000000123,"headers":[{"key":"h\"1","value":"00ff"},{"key":"h2","value":null}],"key":"<key>\n","value":"000102"}` + "\n" +
+				`{"topic":"bar","partition":0,"offset":0,"timestamp":0,"headers":[],"key":"","value":null}` + "\n",
+		},
+	} {
+		t.Run(test.layout, func(t *testing.T) {
+			f, err := NewRecordFormatter(test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out []byte
+			for _, r := range recs {
+				out = f.AppendRecord(out, r)
+			}
+			if string(out) != test.exp {
+				t.Errorf("got\n%s\n!= exp\n%s", out, test.exp)
+			}
+
+			r, err := NewRecordReader(strings.NewReader(string(out)), test.layout)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, exp := range recs {
+				got, err := r.ReadRecord()
+				if err != nil {
+					t.Fatalf("%d: unable to read record: %v", i, err)
+				}
+				if !got.Timestamp.Equal(exp.Timestamp) {
+					t.Errorf("%d: got timestamp %v != exp %v", i, got.Timestamp, exp.Timestamp)
+				}
+				got.Timestamp = exp.Timestamp
+				if !reflect.DeepEqual(got, exp) {
+					t.Errorf("%d: got %#v != exp %#v", i, got, exp)
+				}
+			}
+			if _, err := r.ReadRecord(); !errors.Is(err, io.EOF) {
+				t.Errorf("got err %v != io.EOF after exhausting records", err)
+			}
+		})
+	}
+
+	// Values that are valid JSON are embedded, values that are not are
+	// base64 encoded and flagged, and schema encoded values are decoded
+	// with their schema ID recorded.
+	const layout = "%j{key=json,value=schema,headers=json}\n"
+	f, err := NewRecordFormatter(layout, FormatSchemaCodec(rawJSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemaRecs := []*Record{
+		{Key: []byte(`{"a": 1}`), Value: []byte("\x00\x00\x00\x00\x03[true]")},
+		{Key: []byte("not json"), Value: []byte("bad"), Headers: []RecordHeader{{Key: "h", Value: []byte("\xff")}}},
+		{Key: []byte(`"not json"`)},
+	}
+	var out []byte
+	for _, r := range schemaRecs {
+		out = f.AppendRecord(out, r)
+	}
+	exp := `{"topic":"","partition":0,"offset":0,"headers":[],"key":{"a":1},"value_schema_id":3,"value":[true]}` + "\n" +
+		`{"topic":"","partition":0,"offset":0,"headers":[{"key":"h","base64":true,"value":"/w=="}],"key_base64":true,"key":"bm90IGpzb24=","value":"YmFk"}` + "\n" +
+		`{"topic":"","partition":0,"offset":0,"headers":[],"key":"not json","value":null}` + "\n"
+	if string(out) != exp {
+		t.Errorf("got\n%s\n!= exp\n%s", out, exp)
+	}
+	r, err := NewRecordReader(strings.NewReader(string(out)), layout, FormatSchemaCodec(rawJSONCodec{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, exp := range [][3]string{
+		{`{"a":1}`, "\x00\x00\x00\x00\x03[true]"},
+		{"not json", "bad", "\xff"},
+		{`"not json"`, ""},
+	} {
+		got, err := r.ReadRecord()
+		if err != nil {
+			t.Fatalf("%d: unable to read record: %v", i, err)
+		}
+		if string(got.Key) != exp[0] || string(got.Value) != exp[1] {
+			t.Errorf("%d: got %q %q != exp %q %q", i, got.Key, got.Value, exp[0], exp[1])
+		}
+		if exp[2] != "" && (len(got.Headers) != 1 || string(got.Headers[0].Value) != exp[2]) {
+			t.Errorf("%d: got headers %v != exp value %q", i, got.Headers, exp[2])
+		}
+	}
+
+	for _, layout := range []string{"%j{schema}", "%j{value=schema}", "%j{key=bad}", "%j{foo=text}", "%j{text"} {
+		if _, err := NewRecordFormatter(layout); err == nil {
+			t.Errorf("expected error for layout %q", layout)
+		}
+	}
+}
+
+func TestAvroOCFFormatter(t *testing.T) {
+	f := NewAvroOCFFormatter()
+	recs := []*Record{
+		{Topic: "foo", Partition: 1, Offset: 2, Timestamp: time.Unix(3, 0), Headers: []RecordHeader{{Key: "h", Value: []byte("v")}}, Value: []byte("val")},
+		{Topic: "bar", Key: []byte("key"), Timestamp: time.Unix(0, 0)},
+	}
+	var out []byte
+	for _, r := range recs {
+		out = f.AppendRecord(out, r)
+	}
+
+	b := out
+	readLong := func() int64 {
+		n, size := binary.Varint(b)
+		if size <= 0 {
+			t.Fatal("invalid long")
+		}
+		b = b[size:]
+		return n
+	}
+	readBytes := func() []byte {
+		n := readLong()
+		v := b[:n]
+		b = b[n:]
+		return v
+	}
+	readNullable := func() []byte {
+		if readLong() == 0 {
+			return nil
+		}
+		return readBytes()
+	}
+
+	if string(b[:4]) != "Obj\x01" {
+		t.Fatalf("invalid magic %q", b[:4])
+	}
+	b = b[4:]
+	meta := make(map[string]string)
+	for n := readLong(); n > 0; n = readLong() {
+		for ; n > 0; n-- {
+			k := readBytes()
+			meta[string(k)] = string(readBytes())
+		}
+	}
+	if meta["avro.schema"] != AvroOCFSchema || meta["avro.codec"] != "null" {
+		t.Fatalf("unexpected metadata %v", meta)
+	}
+	marker := append([]byte(nil), b[:16]...)
+	b = b[16:]
+
+	for i, exp := range recs {
+		if n := readLong(); n != 1 {
+			t.Fatalf("%d: got block count %d != exp 1", i, n)
+		}
+		size := readLong()
+		end := len(b) - int(size)
+		got := &Record{
+			Topic:     string(readBytes()),
+			Partition: int32(readLong()),
+			Offset:    readLong(),
+			Timestamp: time.Unix(0, readLong()*1e6),
+		}
+		for n := readLong(); n > 0; n = readLong() {
+			for ; n > 0; n-- {
+				k := readBytes()
+				got.Headers = append(got.Headers, RecordHeader{Key: string(k), Value: readNullable()})
+			}
+		}
+		got.Key = readNullable()
+		got.Value = readNullable()
+		if len(b) != end {
+			t.Fatalf("%d: block size %d does not match the encoded record", i, size)
+		}
+		if !got.Timestamp.Equal(exp.Timestamp) {
+			t.Errorf("%d: got timestamp %v != exp %v", i, got.Timestamp, exp.Timestamp)
+		}
+		got.Timestamp = exp.Timestamp
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("%d: got %#v != exp %#v", i, got, exp)
+		}
+		if string(b[:16]) != string(marker) {
+			t.Fatalf("%d: missing sync marker", i)
+		}
+		b = b[16:]
+	}
+	if len(b) != 0 {
+		t.Errorf("unexpected %d trailing bytes", len(b))
+	}
+}
+
 func BenchmarkFormatter(b *testing.B) {
 	buf := make([]byte, 1024)
 	r := &Record{
@@ -88,6 +88,8 @@ func (s *sink) createReq(id int64, epoch int16) (*produceRequest, *kmsg.AddParti
 		hasHook:    s.cl.producer.hasHookBatchWritten,
 		compressor: s.cl.compressor,
 
+		topicCompressors: s.cl.topicCompressors,
+
 		wireLength:      s.cl.baseProduceRequestLength(), // start length with no topics
 		wireLengthLimit: s.cl.cfg.maxBrokerWriteBytes,
 	}
@@ -374,7 +376,9 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 	} else {
 		requestId = s.cl.ctx.Value("requestId").(string)
 	}
+	start := time.Now()
 	s.doSequenced(req, requestId, func(br *broker, resp kmsg.Response, err error) {
+		latency := time.Since(start)
 		s.cl.producer.decInflight()
 		authRequestId := "empty-auth-request-id"
 		if br.cxnProduce != nil {
@@ -383,6 +387,7 @@ func (s *sink) produce(sem <-chan struct{}) bool {
 		}
 		s.handleReqResp(br, req, resp, requestId, authRequestId, err)
 		batches.eachOwnerLocked((*recBatch).decInflight)
+		s.cl.partitionerFeedback(batches, resp, err, latency)
 		<-sem
 	})
 	return moreToDrain
@@ -740,7 +745,7 @@ func (s *sink) handleReqRespBatch(
 	case kerr.IsRetriable(err) &&
 		!failUnknown &&
 		err != kerr.CorruptMessage &&
-		batch.tries < s.cl.cfg.recordRetries:
+		batch.tries < batch.owner.cfg.recordRetries:
 
 		if debug {
 			fmt.Fprintf(b, "retrying@%d,%d(%s)}, ", baseOffset, nrec, err)
@@ -852,7 +857,7 @@ func (s *sink) handleReqRespBatch(
 				"partition", partition,
 				"err", err,
 				"err_is_retriable", kerr.IsRetriable(err),
-				"max_retries_reached", !failUnknown && batch.tries >= s.cl.cfg.recordRetries,
+				"max_retries_reached", !failUnknown && batch.tries >= batch.owner.cfg.recordRetries,
 				"requestId", requestId,
 				"authRequestId", authRequestId,
 			)
@@ -935,7 +940,7 @@ func (s *sink) handleRetryBatches(
 		}
 
 		if canFail || s.cl.cfg.disableIdempotency {
-			if err := batch.maybeFailErr(&s.cl.cfg); err != nil {
+			if err := batch.maybeFailErr(batch.owner.cfg); err != nil {
 				batch.owner.failAllRecords(err)
 				return
 			}
@@ -1019,7 +1024,8 @@ func (s *sink) removeRecBuf(rm *recBuf) {
 // drained by a sink. This is only not drained if the partition has a load
 // error and thus does not a have a sink to be drained into.
 type recBuf struct {
-	cl *Client // for cfg, record finishing
+	cl  *Client // for cfg, record finishing
+	cfg *cfg    // the producer cfg for this topic, see ProducerTopicOverrides
 
 	topic     string
 	partition int32
@@ -1174,7 +1180,7 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 		recBuf.batches = append(recBuf.batches, newBatch)
 	}
 
-	if recBuf.cl.cfg.linger == 0 {
+	if recBuf.cfg.linger == 0 {
 		if onDrainBatch {
 			recBuf.sink.maybeDrain()
 		}
@@ -1195,6 +1201,47 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 	return true
 }
 
+// bufferBatch buffers records from ProduceBatch as one new batch that nothing
+// is appended to. If the records do not fit in a single batch, they are all
+// failed with kerr.MessageTooLarge.
+func (recBuf *recBuf) bufferBatch(prs []promisedRec, compressOnce bool) {
+	recBuf.mu.Lock()
+	defer recBuf.mu.Unlock()
+
+	now := time.Now()
+	for _, pr := range prs {
+		if pr.Timestamp.IsZero() {
+			pr.Timestamp = now
+		}
+		pr.Timestamp = pr.Timestamp.Truncate(time.Millisecond)
+	}
+
+	if recBuf.purged {
+		recBuf.cl.producer.promiseBatch(batchPromise{recs: prs, partition: recBuf.partition, err: errPurged})
+		return
+	}
+
+	produceVersion := atomic.LoadInt32(&recBuf.sink.produceVersion)
+	batch := recBuf.newRecordBatch()
+	for _, pr := range prs {
+		if appended, _ := batch.tryBuffer(pr, produceVersion, recBuf.maxRecordBatchBytes, false); !appended {
+			recBuf.cl.producer.promiseBatch(batchPromise{recs: prs, partition: recBuf.partition, err: kerr.MessageTooLarge})
+			return
+		}
+	}
+	batch.whole = true
+	batch.compressOnce = compressOnce
+
+	recBuf.batches = append(recBuf.batches, batch)
+
+	// Nothing more can be added to this batch, so there is no reason to
+	// linger: whatever is lingering before us drains now as well.
+	recBuf.lockedStopLinger()
+	recBuf.sink.maybeDrain()
+
+	atomic.AddInt64(&recBuf.buffered, int64(len(prs)))
+}
+
 // Stops lingering, potentially restarting it, and returns whether there is
 // more to drain.
 //
@@ -1203,7 +1250,7 @@ func (recBuf *recBuf) bufferRecord(pr promisedRec, abortOnNewBatch bool) bool {
 // lingering, then we are flushing and also indicate there is more to drain.
 func (recBuf *recBuf) tryStopLingerForDraining() bool {
 	recBuf.lockedStopLinger()
-	canLinger := recBuf.cl.cfg.linger == 0
+	canLinger := recBuf.cfg.linger == 0
 	moreToDrain := !canLinger && len(recBuf.batches) > recBuf.batchDrainIdx ||
 		canLinger && (len(recBuf.batches) > recBuf.batchDrainIdx+1 ||
 			len(recBuf.batches) == recBuf.batchDrainIdx+1 && !recBuf.lockedMaybeStartLinger())
@@ -1215,7 +1262,7 @@ func (recBuf *recBuf) lockedMaybeStartLinger() bool {
 	if atomic.LoadInt32(&recBuf.cl.producer.flushing) == 1 {
 		return false
 	}
-	recBuf.lingering = time.AfterFunc(recBuf.cl.cfg.linger, recBuf.sink.maybeDrain)
+	recBuf.lingering = time.AfterFunc(recBuf.cfg.linger, recBuf.sink.maybeDrain)
 	return true
 }
 
@@ -1255,7 +1302,7 @@ func (recBuf *recBuf) bumpRepeatedLoadErr(err error) {
 		return
 	}
 
-	batch0Fail := batch0.maybeFailErr(&recBuf.cl.cfg) != nil // timeout, retries, or aborting
+	batch0Fail := batch0.maybeFailErr(recBuf.cfg) != nil // timeout, retries, or aborting
 
 	okNet := !isRetriableBrokerErr(err) && !isDialErr(err) // we can fail if this is *not* a network error
 	retriableKerr := kerr.IsRetriable(err)                 // we fail if this is not a retriable kerr,
@@ -1360,6 +1407,22 @@ type recBatch struct {
 	firstTimestamp    int64 // since unix epoch, in millis
 	maxTimestampDelta int32
 
+	// whole is set for batches from ProduceBatch, which are buffered as a
+	// unit and are never appended to.
+	whole bool
+
+	// compressOnce is set for ProduceBatch batches that are encoded and
+	// compressed once, the first time they are written, rather than on
+	// every try. Once encoded, these are the encoded records (compressed
+	// unless compressing did not shrink them), the codec used (0 if not
+	// compressed), the length of the records before compression, and the
+	// produce version they were encoded for (0 if not yet encoded).
+	compressOnce bool
+	onceRecords  []byte
+	onceCodec    int8
+	onceFrom     int
+	onceVersion  int16
+
 	mu      sync.Mutex    // guards appendTo's reading of records against failAllRecords emptying it
 	records []promisedRec // record w/ length, ts calculated
 }
@@ -1408,6 +1471,28 @@ func (b *recBatch) appendRecord(pr promisedRec, nums recordNumbers) {
 	b.records = append(b.records, pr)
 }
 
+// encodeOnce encodes and compresses the records of a ProduceBatch batch for a
+// produce version, such that produce requests do not encode and compress the
+// batch on every try. If compressing does not shrink the records, the
+// uncompressed records are kept, and we do not try compressing again.
+func (b *recBatch) encodeOnce(compressor *compressor, produceVersion int16) {
+	var records []byte
+	for i, pr := range b.records {
+		records = pr.appendTo(records, int32(i))
+	}
+	b.onceRecords, b.onceCodec, b.onceFrom, b.onceVersion = records, 0, len(records), produceVersion
+
+	w := sliceWriters.Get().(*sliceWriter)
+	defer sliceWriters.Put(w)
+
+	compressed, codec := compressor.compress(w, records, produceVersion)
+	if compressed == nil || len(compressed) >= len(records) {
+		return
+	}
+	b.onceRecords = append([]byte(nil), compressed...)
+	b.onceCodec = codec
+}
+
 // newRecordBatch returns a new record batch for a topic and partition.
 func (recBuf *recBuf) newRecordBatch() *recBatch {
 	const recordBatchOverhead = 4 + // array len
@@ -1512,7 +1597,8 @@ type produceRequest struct {
 	metrics produceMetrics
 	hasHook bool
 
-	compressor *compressor
+	compressor       *compressor
+	topicCompressors map[string]*compressor // see ProducerTopicOverrides
 
 	// wireLength is initially the size of sending a produce request,
 	// including the request header, with no topics. We start with the
@@ -1579,7 +1665,7 @@ func (p *produceRequest) tryAddBatch(produceVersion int32, recBuf *recBuf, batch
 
 	if recBuf.batches[0] == batch {
 		if !p.idempotent() || batch.canFailFromLoadErrs {
-			if err := batch.maybeFailErr(&batch.owner.cl.cfg); err != nil {
+			if err := batch.maybeFailErr(batch.owner.cfg); err != nil {
 				recBuf.failAllRecords(err)
 				return false
 			}
@@ -1734,7 +1820,7 @@ func (cl *Client) maxRecordBatchBytesForTopic(topic string) int32 {
 	wireLengthLimit := cl.cfg.maxBrokerWriteBytes
 
 	recordBatchLimit := wireLengthLimit - minOnePartitionBatchLength
-	if cfgLimit := cl.cfg.maxRecordBatchBytes; cfgLimit < recordBatchLimit {
+	if cfgLimit := cl.producerCfg(topic).maxRecordBatchBytes; cfgLimit < recordBatchLimit {
 		recordBatchLimit = cfgLimit
 	}
 	return recordBatchLimit
@@ -1842,7 +1928,7 @@ func (b *recBatch) tryBuffer(pr promisedRec, produceVersion, maxBatchBytes int32
 	batchWireLength, _ := b.wireLengthForProduceVersion(produceVersion)
 	newBatchLength := batchWireLength + nums.wireLength()
 
-	if b.tries != 0 || newBatchLength > maxBatchBytes {
+	if b.tries != 0 || b.whole || newBatchLength > maxBatchBytes {
 		return false, false
 	}
 	if abortOnNewBatch {
@@ -1903,6 +1989,11 @@ func (p *produceRequest) AppendTo(dst []byte) []byte {
 			p.metrics[topic] = tmetrics
 		}
 
+		compressor := p.compressor
+		if tcompressor, ok := p.topicCompressors[topic]; ok {
+			compressor = tcompressor
+		}
+
 		for partition, batch := range partitions {
 			dst = kbin.AppendInt32(dst, partition)
 			batch.mu.Lock()
@@ -1917,9 +2008,9 @@ func (p *produceRequest) AppendTo(dst []byte) []byte {
 			}
 			var pmetrics ProduceBatchMetrics
 			if p.version < 3 {
-				dst, pmetrics = batch.appendToAsMessageSet(dst, uint8(p.version), p.compressor)
+				dst, pmetrics = batch.appendToAsMessageSet(dst, uint8(p.version), compressor)
 			} else {
-				dst, pmetrics = batch.appendTo(dst, p.version, p.producerID, p.producerEpoch, p.txnID != nil, p.compressor)
+				dst, pmetrics = batch.appendTo(dst, p.version, p.producerID, p.producerEpoch, p.txnID != nil, compressor)
 			}
 			batch.mu.Unlock()
 			if p.hasHook {
@@ -2031,12 +2122,48 @@ func (b seqRecBatch) appendTo(
 
 	dst = kbin.AppendArrayLen(dst, len(b.records))
 	recordsAt := len(dst)
+
+	// update the few record batch fields we already wrote once our
+	// records are compressed
+	compressed := func(uncompressedLen, compressedLen int, codec int8) {
+		m.CompressedBytes = compressedLen
+		m.CompressionType = uint8(codec)
+
+		savings := int32(uncompressedLen - compressedLen)
+		nullableBytesLen -= savings
+		batchLen -= savings
+		b.attrs |= int16(codec)
+		if !flexible {
+			kbin.AppendInt32(dst[:nullableBytesLenAt], nullableBytesLen)
+		}
+		kbin.AppendInt32(dst[:batchLenAt], batchLen)
+		kbin.AppendInt16(dst[:attrsAt], b.attrs)
+	}
+
+	m.NumRecords = len(b.records)
+
+	// A CompressOnce batch from ProduceBatch is encoded the first time
+	// it is written, once we know the produce version, and is reused as
+	// is on retries (unless the version changes).
+	if b.compressOnce && compressor != nil {
+		if b.onceVersion != version {
+			b.encodeOnce(compressor, version)
+		}
+		dst = append(dst, b.onceRecords...)
+		m.UncompressedBytes = b.onceFrom
+		m.CompressedBytes = b.onceFrom
+		if b.onceCodec != 0 {
+			compressed(b.onceFrom, len(b.onceRecords), b.onceCodec)
+		}
+		kbin.AppendInt32(dst[:crcStart], int32(crc32.Checksum(dst[crcStart+4:], crc32c)))
+		return dst, m
+	}
+
 	for i, pr := range b.records {
 		dst = pr.appendTo(dst, int32(i))
 	}
 
 	toCompress := dst[recordsAt:]
-	m.NumRecords = len(b.records)
 	m.UncompressedBytes = len(toCompress)
 	m.CompressedBytes = m.UncompressedBytes
 
@@ -2044,25 +2171,13 @@ func (b seqRecBatch) appendTo(
 		w := sliceWriters.Get().(*sliceWriter)
 		defer sliceWriters.Put(w)
 
-		compressed, codec := compressor.compress(w, toCompress, version)
-		if compressed != nil && // nil would be from an error
-			len(compressed) < len(toCompress) {
+		compressedRecs, codec := compressor.compress(w, toCompress, version)
+		if compressedRecs != nil && // nil would be from an error
+			len(compressedRecs) < len(toCompress) {
 			// our compressed was shorter: copy over
-			copy(dst[recordsAt:], compressed)
-			dst = dst[:recordsAt+len(compressed)]
-			m.CompressedBytes = len(compressed)
-			m.CompressionType = uint8(codec)
-
-			// update the few record batch fields we already wrote
-			savings := int32(len(toCompress) - len(compressed))
-			nullableBytesLen -= savings
-			batchLen -= savings
-			b.attrs |= int16(codec)
-			if !flexible {
-				kbin.AppendInt32(dst[:nullableBytesLenAt], nullableBytesLen)
-			}
-			kbin.AppendInt32(dst[:batchLenAt], batchLen)
-			kbin.AppendInt16(dst[:attrsAt], b.attrs)
+			copy(dst[recordsAt:], compressedRecs)
+			dst = dst[:recordsAt+len(compressedRecs)]
+			compressed(len(toCompress), len(compressedRecs), codec)
 		}
 	}
 
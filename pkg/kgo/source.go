@@ -124,6 +124,11 @@ type cursor struct {
 	// request or when the source is stopped.
 	useState uint32
 
+	// drained is an atomic that is 1 if, with ConsumeUntilEnd, the cursor
+	// has been consumed to its end offset. This is published when the
+	// cursor offset is set so that polling can check for completion.
+	drained uint32
+
 	topicPartitionData // updated in metadata when session is stopped
 
 	// cursorOffset is our epoch/offset that we are consuming. When a fetch
@@ -149,6 +154,17 @@ type cursorOffset struct {
 	// See kmsg.OffsetForLeaderEpochResponseTopicPartition for more
 	// details.
 	lastConsumedEpoch int32
+
+	// If using ConsumeUntilEnd, end is the offset this cursor stops at.
+	// The end is captured from the first fetch response after the cursor
+	// is assigned, and endKnown is false until then.
+	end      int64
+	endKnown bool
+}
+
+// atEnd returns whether the offset has been consumed to its end.
+func (o cursorOffset) atEnd() bool {
+	return o.endKnown && o.offset >= o.end
 }
 
 // use, for fetch requests, freezes a view of the cursorOffset.
@@ -194,6 +210,24 @@ func (c *cursor) allowUsable() {
 // after.
 func (c *cursor) setOffset(o cursorOffset) {
 	c.cursorOffset = o
+
+	var drained uint32
+	if o.atEnd() {
+		drained = 1
+	}
+	if atomic.SwapUint32(&c.drained, drained) != drained && drained == 1 {
+		// This can be called while polling with the sources ready
+		// mutex held, so we wake any poll in a goroutine.
+		consumer := &c.source.cl.consumer
+		consumer.addConsumedToEnd(c.topic, c.partition)
+		go consumer.wakeConsumedToEnd()
+	}
+}
+
+// isDrained returns whether, with ConsumeUntilEnd, the cursor has been
+// consumed to its end.
+func (c *cursor) isDrained() bool {
+	return atomic.LoadUint32(&c.drained) == 1
 }
 
 // cursorOffsetNext is updated while processing a fetch response.
@@ -392,10 +426,10 @@ func (s *source) takeNBuffered(n int) (Fetch, int, bool) {
 			}
 
 			lastReturnedRecord := rp.Records[len(rp.Records)-1]
-			pCursor.from.setOffset(cursorOffset{
-				offset:            lastReturnedRecord.Offset + 1,
-				lastConsumedEpoch: lastReturnedRecord.LeaderEpoch,
-			})
+			taken := pCursor.cursorOffset
+			taken.offset = lastReturnedRecord.Offset + 1
+			taken.lastConsumedEpoch = lastReturnedRecord.LeaderEpoch
+			pCursor.from.setOffset(taken)
 		}
 
 		if len(t.Partitions) == 0 {
@@ -448,7 +482,7 @@ func (s *source) createReq() *fetchRequest {
 	for i := 0; i < len(s.cursors); i++ {
 		c := s.cursors[cursorIdx]
 		cursorIdx = (cursorIdx + 1) % len(s.cursors)
-		if !c.usable() || paused.has(c.topic, c.partition) {
+		if !c.usable() || paused.has(c.topic, c.partition) || c.isDrained() {
 			continue
 		}
 		req.addCursor(c)
@@ -803,6 +837,9 @@ func (s *source) handleReqResp(br *broker, req *fetchRequest, resp *kmsg.FetchRe
 			}
 
 			fp := partOffset.processRespPartition(br, rp, s.cl.decompressor, s.cl.cfg.hooks)
+			if s.cl.cfg.untilEnd && fp.Err == nil {
+				partOffset.trimToEnd(&fp, &s.cl.cfg)
+			}
 			if fp.Err != nil {
 				updateMeta = true
 				updateWhy.add(topic, partition, fp.Err)
@@ -1058,6 +1095,32 @@ func (o *cursorOffsetNext) processRespPartition(br *broker, rp *kmsg.FetchRespon
 	return fp
 }
 
+// trimToEnd, for ConsumeUntilEnd, captures the partition's end offset if it
+// is not yet known and drops any records at or past the end. With
+// ConsumeTimeRange, the first record at or past the end timestamp also ends
+// the partition.
+func (o *cursorOffsetNext) trimToEnd(fp *FetchPartition, cfg *cfg) {
+	if !o.endKnown {
+		o.end = fp.HighWatermark
+		if cfg.isolationLevel == 1 && fp.LastStableOffset >= 0 {
+			o.end = fp.LastStableOffset
+		}
+		o.endKnown = true
+	}
+	for i, r := range fp.Records {
+		if cfg.untilTime && r.Offset < o.end && !r.Attrs.IsControl() && r.Timestamp.UnixNano()/1e6 >= cfg.untilMilli {
+			o.end = r.Offset
+		}
+		if r.Offset >= o.end {
+			fp.Records = fp.Records[:i]
+			break
+		}
+	}
+	if o.offset > o.end {
+		o.offset = o.end
+	}
+}
+
 type aborter map[int64][]int64
 
 func buildAborter(rp *kmsg.FetchResponseTopicPartition) aborter {
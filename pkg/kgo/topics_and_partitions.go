@@ -187,6 +187,11 @@ type topicPartitions struct {
 	partsMu     sync.Mutex
 	partitioner TopicPartitioner
 	lb          *leastBackupInput // for partitioning if the partitioner is a LoadTopicPartitioner
+	fb          *feedbackInput    // for partitioning if the partitioner is a TopicFeedbackPartitioner
+
+	// feedback is set to 1 once the partitioner is created if it is a
+	// TopicFeedbackPartitioner, allowing sinks to skip locking partsMu.
+	feedback uint32
 }
 
 func (t *topicPartitions) load() *topicPartitionsData { return t.v.Load().(*topicPartitionsData) }
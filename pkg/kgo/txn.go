@@ -786,6 +786,13 @@ func (cl *Client) EndTransaction(ctx context.Context, commit TransactionEndTry)
 		}
 	}
 
+	// Offsets added with AddGroupOffsetsToTransaction begin the
+	// transaction as well.
+	if cl.producer.offsetsAdded {
+		cl.producer.offsetsAdded = false
+		anyAdded = true
+	}
+
 	// If the user previously used EndAndBeginTransaction with
 	// EndBeginTxnUnsafe, we may have to end a transaction even though
 	// nothing may be in it.
@@ -975,6 +982,94 @@ func (cl *Client) commitTransactionOffsets(
 	return g
 }
 
+// AddGroupOffsetsToTransaction adds offsets for a group to the current
+// transaction, such that the offsets are committed if and only if the
+// transaction is committed. Metadata, if non-nil, is the metadata to commit
+// with each offset.
+//
+// Unlike GroupTransactSession, the group does not need to be consumed by this
+// client: offsets are committed with no member ID and no generation, which
+// Kafka only allows if the group has no active members. This is useful to
+// track progress consuming from a different cluster within the transaction
+// that produces to this cluster, which is what the kmirror package does. If
+// this client is consuming in a group, use GroupTransactSession instead.
+//
+// This returns the first error encountered adding the offsets; the
+// transaction should be aborted if this returns an error.
+func (cl *Client) AddGroupOffsetsToTransaction(
+	ctx context.Context,
+	group string,
+	offsets map[string]map[int32]EpochOffset,
+	metadata map[string]map[int32]string,
+) error {
+	if cl.cfg.txnID == nil {
+		return errNotTransactional
+	}
+
+	cl.producer.txnMu.Lock()
+	defer cl.producer.txnMu.Unlock()
+
+	if !cl.producer.inTxn {
+		return errNotInTransaction
+	}
+	if len(offsets) == 0 {
+		return nil
+	}
+
+	if err := cl.addOffsetsToTxn(ctx, group); err != nil {
+		return err
+	}
+	cl.producer.offsetsAdded = true
+
+	id, epoch, err := cl.producerID()
+	if err != nil {
+		return err
+	}
+	req := kmsg.NewPtrTxnOffsetCommitRequest()
+	req.TransactionalID = *cl.cfg.txnID
+	req.Group = group
+	req.ProducerID = id
+	req.ProducerEpoch = epoch
+	req.Generation = -1
+	for topic, partitions := range offsets {
+		reqTopic := kmsg.NewTxnOffsetCommitRequestTopic()
+		reqTopic.Topic = topic
+		for partition, eo := range partitions {
+			reqPartition := kmsg.NewTxnOffsetCommitRequestTopicPartition()
+			reqPartition.Partition = partition
+			reqPartition.Offset = eo.Offset
+			reqPartition.LeaderEpoch = eo.Epoch
+			if meta, ok := metadata[topic][partition]; ok {
+				reqPartition.Metadata = &meta
+			}
+			reqTopic.Partitions = append(reqTopic.Partitions, reqPartition)
+		}
+		req.Topics = append(req.Topics, reqTopic)
+	}
+
+	cl.cfg.logger.Log(LogLevelInfo, "adding group offsets to transaction",
+		"txn", *cl.cfg.txnID,
+		"group", group,
+		"offsets", offsets,
+	)
+	var resp *kmsg.TxnOffsetCommitResponse
+	err = cl.doWithConcurrentTransactions("TxnOffsetCommit", func() error {
+		resp, err = req.RequestWith(ctx, cl)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return fmt.Errorf("unable to add offsets for topic %s partition %d to transaction: %w", t.Topic, p.Partition, err)
+			}
+		}
+	}
+	return nil
+}
+
 // Ties a transactional producer to a group. Since this requires a producer ID,
 // this initializes one if it is not yet initialized. This would only be the
 // case if trying to commit before any records have been sent.
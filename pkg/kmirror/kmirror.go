@@ -0,0 +1,538 @@
+// Package kmirror mirrors topics from one Kafka cluster to another, exactly
+// once.
+//
+// A Mirror consumes records from a source client and produces them with a
+// transactional destination client to the same partition of the same (or a
+// renamed) topic, keeping each record's key, value, headers, and timestamp.
+// Every poll of mirrored records is produced in a transaction that also
+// commits checkpoints of the source offsets that were mirrored to a checkpoint
+// group in the destination cluster. If the transaction commits, both the
+// records and the checkpoints are visible; if it aborts, neither are, and the
+// mirror rewinds the source to the last checkpoints. A restarted mirror
+// continues from its checkpoints, meaning read committed consumers of the
+// destination see each source record exactly once.
+//
+// This is the same consume-transform-produce loop that kgo's
+// GroupTransactSession provides, but GroupTransactSession commits offsets to
+// the group it consumes in and thus cannot span two clusters. Instead, the
+// checkpoints are added to the destination transaction with
+// kgo.Client.AddGroupOffsetsToTransaction.
+//
+// Checkpoints are committed for the destination topic and partition, with the
+// destination offset after the last mirrored record as the committed offset
+// and the source topic and offset as the commit metadata. This allows
+// translating source offsets to destination offsets; see Checkpoint. Because
+// checkpoints are keyed by destination topic, renames must not map two source
+// topics to the same destination topic.
+package kmirror
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// DefaultCheckpointGroup is the destination group that checkpoints are
+// committed to if CheckpointGroup is not used.
+const DefaultCheckpointGroup = "kmirror"
+
+// Checkpoint is the mirroring progress of a source partition.
+//
+// A checkpoint is only committed once a record has been mirrored to the
+// destination partition. If every record of a source partition so far has been
+// filtered, nothing is committed for the partition, and the filtered records
+// are consumed (and filtered) again after a restart.
+type Checkpoint struct {
+	// Source is the offset of the next source record to mirror.
+	Source int64
+	// Destination is the offset in the destination partition after the
+	// last mirrored record.
+	Destination int64
+}
+
+// checkpointMetadata is the JSON commit metadata of a checkpoint, recording
+// the source position that the committed destination offset corresponds to.
+type checkpointMetadata struct {
+	SourceTopic  string `json:"source_topic"`
+	SourceOffset int64  `json:"source_offset"`
+}
+
+// abortTimeout bounds aborting a failed transaction, which cannot use the
+// context passed to Run because that context may be why the transaction
+// failed.
+const abortTimeout = 30 * time.Second
+
+// AbortError is returned from Run if mirroring failed and the transaction
+// could not be aborted. Both errors can be checked with errors.Is and
+// errors.As.
+type AbortError struct {
+	// Err is why mirroring failed.
+	Err error
+	// AbortErr is why aborting the transaction failed.
+	AbortErr error
+}
+
+func (e *AbortError) Error() string {
+	return fmt.Sprintf("kmirror: unable to mirror (%v), and %v", e.Err, e.AbortErr)
+}
+
+// Unwrap returns the mirroring error.
+func (e *AbortError) Unwrap() error { return e.Err }
+
+// Is returns whether the aborting error is target; errors.Is checks the
+// mirroring error through Unwrap.
+func (e *AbortError) Is(target error) bool { return errors.Is(e.AbortErr, target) }
+
+// As returns whether the aborting error can be assigned to target; errors.As
+// checks the mirroring error through Unwrap.
+func (e *AbortError) As(target interface{}) bool { return errors.As(e.AbortErr, target) }
+
+// source is the subset of the source client that a Mirror uses.
+type source interface {
+	PollFetches(context.Context) kgo.Fetches
+	SetOffsets(map[string]map[int32]kgo.EpochOffset)
+}
+
+// destination is the subset of the destination client that a Mirror uses.
+type destination interface {
+	BeginTransaction() error
+	ProduceBatch(context.Context, kgo.PartitionRecords, func(kgo.PartitionRecords, error))
+	Flush(context.Context) error
+	AddGroupOffsetsToTransaction(context.Context, string, map[string]map[int32]kgo.EpochOffset, map[string]map[int32]string) error
+	AbortBufferedRecords(context.Context) error
+	EndTransaction(context.Context, kgo.TransactionEndTry) error
+}
+
+// Opt is an option to configure a Mirror.
+type Opt interface {
+	apply(*cfg)
+}
+
+type opt struct{ fn func(*cfg) }
+
+func (o opt) apply(cfg *cfg) { o.fn(cfg) }
+
+type cfg struct {
+	group     string
+	rename    func(string) string
+	filter    func(*kgo.Record) bool
+	transform func(*kgo.Record)
+}
+
+// CheckpointGroup sets the group in the destination cluster that source
+// offsets are checkpointed to, overriding the default DefaultCheckpointGroup.
+// Every mirror must use its own group, and no client may consume in the
+// group.
+func CheckpointGroup(group string) Opt {
+	return opt{func(cfg *cfg) { cfg.group = group }}
+}
+
+// RenameTopics mirrors records from source topics into differently named
+// destination topics. Topics that are not in the map keep their name. This
+// replaces any RenameTopicsFn.
+func RenameTopics(from2to map[string]string) Opt {
+	return opt{func(cfg *cfg) {
+		cfg.rename = func(topic string) string {
+			if to, ok := from2to[topic]; ok {
+				return to
+			}
+			return topic
+		}
+	}}
+}
+
+// RenameTopicsFn mirrors records from source topics into the destination
+// topics returned from fn, which is useful for rules such as prefixing every
+// topic with the source cluster's name. This replaces any RenameTopics.
+func RenameTopicsFn(fn func(string) string) Opt {
+	return opt{func(cfg *cfg) { cfg.rename = fn }}
+}
+
+// Filter mirrors only source records for which fn returns true. Source
+// offsets of filtered records are still checkpointed once any record of their
+// partition has been mirrored; see Checkpoint.
+func Filter(fn func(*kgo.Record) bool) Opt {
+	return opt{func(cfg *cfg) { cfg.filter = fn }}
+}
+
+// TransformRecords calls fn with every record before it is produced to the
+// destination, allowing the key, value, headers, or timestamp to be rewritten.
+// The record passed to fn is a copy of the source record with its topic
+// already renamed; changes to the topic or partition are ignored.
+func TransformRecords(fn func(*kgo.Record)) Opt {
+	return opt{func(cfg *cfg) { cfg.transform = fn }}
+}
+
+// Mirror mirrors records from a source client to a destination client.
+type Mirror struct {
+	src source
+	dst destination
+	cfg cfg
+
+	// committed are the checkpoints that were last committed, and next is
+	// the next source offset to mirror for every partition consumed.
+	// Partitions without a checkpoint are rewound to start, the first
+	// offset consumed.
+	committed map[string]map[int32]Checkpoint
+	next      map[string]map[int32]int64
+	start     map[string]map[int32]int64
+}
+
+// New returns a Mirror that consumes from src and produces to dst, loading
+// any existing checkpoints from the destination cluster.
+//
+// The source client should directly consume the topics to mirror (that is,
+// it must not consume in a group), and should use the read committed
+// isolation level if the source topics are produced to transactionally. As
+// the source client already exists, it cannot be told to begin consuming at
+// the checkpoints: partitions with checkpoints are moved to their checkpoints
+// with SetOffsets once they are first consumed, and records before a
+// checkpoint are skipped. To avoid consuming skipped records, LoadCheckpoints
+// can be used before creating the source client to start consuming at the
+// checkpoints with kgo.ConsumePartitions.
+//
+// The destination client must have a transactional ID and must not be used
+// for anything else while mirroring.
+func New(ctx context.Context, src, dst *kgo.Client, opts ...Opt) (*Mirror, error) {
+	m := &Mirror{
+		src: src,
+		dst: dst,
+		cfg: cfg{
+			group:  DefaultCheckpointGroup,
+			rename: func(topic string) string { return topic },
+		},
+		next:  make(map[string]map[int32]int64),
+		start: make(map[string]map[int32]int64),
+	}
+	for _, opt := range opts {
+		opt.apply(&m.cfg)
+	}
+	if m.cfg.group == "" {
+		return nil, errors.New("kmirror: invalid empty checkpoint group")
+	}
+
+	committed, err := LoadCheckpoints(ctx, dst, m.cfg.group)
+	if err != nil {
+		return nil, err
+	}
+	m.committed = committed
+	return m, nil
+}
+
+// LoadCheckpoints loads the checkpoints committed to group in the cluster
+// that cl talks to, keyed by source topic and partition. Committed offsets
+// without checkpoint metadata are ignored.
+func LoadCheckpoints(ctx context.Context, cl *kgo.Client, group string) (map[string]map[int32]Checkpoint, error) {
+	for {
+		req := kmsg.NewPtrOffsetFetchRequest()
+		req.Group = group
+		req.RequireStable = true // wait for any pending transactional checkpoints
+		resp, err := req.RequestWith(ctx, cl)
+		if err == nil {
+			err = kerr.ErrorForCode(resp.ErrorCode)
+		}
+		if errors.Is(err, kerr.UnstableOffsetCommit) {
+			select {
+			case <-time.After(100 * time.Millisecond):
+				continue
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("kmirror: unable to load checkpoints: %w", err)
+		}
+		return checkpointsFromResponse(resp)
+	}
+}
+
+// checkpointsFromResponse returns the checkpoints in an offset fetch response,
+// keyed by the source topic in each commit's metadata.
+func checkpointsFromResponse(resp *kmsg.OffsetFetchResponse) (map[string]map[int32]Checkpoint, error) {
+	checkpoints := make(map[string]map[int32]Checkpoint)
+	for _, t := range resp.Topics {
+		for _, p := range t.Partitions {
+			if err := kerr.ErrorForCode(p.ErrorCode); err != nil {
+				return nil, fmt.Errorf("kmirror: unable to load checkpoint for %s[%d]: %w", t.Topic, p.Partition, err)
+			}
+			if p.Offset < 0 || p.Metadata == nil {
+				continue
+			}
+			var meta checkpointMetadata
+			if err := json.Unmarshal([]byte(*p.Metadata), &meta); err != nil || meta.SourceTopic == "" {
+				continue
+			}
+			if checkpoints[meta.SourceTopic] == nil {
+				checkpoints[meta.SourceTopic] = make(map[int32]Checkpoint)
+			}
+			checkpoints[meta.SourceTopic][p.Partition] = Checkpoint{
+				Source:      meta.SourceOffset,
+				Destination: p.Offset,
+			}
+		}
+	}
+	return checkpoints, nil
+}
+
+// Checkpoints returns the checkpoints that were last committed, keyed by
+// source topic and partition.
+func (m *Mirror) Checkpoints() map[string]map[int32]Checkpoint {
+	checkpoints := make(map[string]map[int32]Checkpoint, len(m.committed))
+	for t, ps := range m.committed {
+		checkpoints[t] = make(map[int32]Checkpoint, len(ps))
+		for p, c := range ps {
+			checkpoints[t][p] = c
+		}
+	}
+	return checkpoints
+}
+
+// Run mirrors until the context is canceled or an error occurs, returning the
+// error. If a transaction fails, it is aborted and the source is rewound to
+// the last checkpoints before returning; Run can be called again to continue
+// mirroring if the error is temporary.
+//
+// Run must not be called concurrently.
+func (m *Mirror) Run(ctx context.Context) error {
+	for {
+		fs := m.src.PollFetches(ctx)
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if fs.IsClientClosed() {
+			return kgo.ErrClientClosed
+		}
+		var err error
+		fs.EachError(func(t string, p int32, ferr error) {
+			if err == nil && !errors.Is(ferr, kgo.ErrConsumedToEnd) {
+				err = fmt.Errorf("kmirror: unable to consume %s[%d]: %w", t, p, ferr)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if err := m.mirror(ctx, fs); err != nil {
+			return err
+		}
+	}
+}
+
+// pending is what is being mirrored from one source partition in a
+// transaction.
+type pending struct {
+	topic     string
+	partition int32
+	next      int64         // the source offset to checkpoint
+	records   []*kgo.Record // the records to produce
+}
+
+// mirror mirrors one poll of fetches in a transaction.
+func (m *Mirror) mirror(ctx context.Context, fs kgo.Fetches) error {
+	var (
+		pendings []*pending
+		seek     = make(map[string]map[int32]kgo.EpochOffset)
+	)
+	fs.EachPartition(func(p kgo.FetchTopicPartition) {
+		next, seen := m.next[p.Topic][p.Partition]
+		if !seen && len(p.Records) > 0 {
+			next = p.Records[0].Offset
+			setOffset(m.start, p.Topic, p.Partition, next)
+			if c, ok := m.committed[p.Topic][p.Partition]; ok && c.Source > next {
+				next = c.Source
+				if seek[p.Topic] == nil {
+					seek[p.Topic] = make(map[int32]kgo.EpochOffset)
+				}
+				seek[p.Topic][p.Partition] = kgo.EpochOffset{Epoch: -1, Offset: next}
+			}
+			setOffset(m.next, p.Topic, p.Partition, next)
+		}
+
+		var (
+			last    *kgo.Record
+			records []*kgo.Record
+		)
+		for _, r := range p.Records {
+			if r.Offset < next {
+				continue
+			}
+			last = r
+			if m.cfg.filter != nil && !m.cfg.filter(r) {
+				continue
+			}
+			records = append(records, m.mirrored(r))
+		}
+		if last == nil {
+			return
+		}
+		setOffset(m.next, p.Topic, p.Partition, last.Offset+1)
+		pendings = append(pendings, &pending{
+			topic:     p.Topic,
+			partition: p.Partition,
+			next:      last.Offset + 1,
+			records:   records,
+		})
+	})
+	if len(seek) > 0 {
+		m.src.SetOffsets(seek)
+	}
+	if len(pendings) == 0 {
+		return nil
+	}
+
+	if err := m.dst.BeginTransaction(); err != nil {
+		m.rewind()
+		return fmt.Errorf("kmirror: unable to begin transaction: %w", err)
+	}
+
+	var (
+		wg         sync.WaitGroup
+		mu         sync.Mutex
+		produceErr error
+	)
+	for _, pend := range pendings {
+		if len(pend.records) == 0 {
+			continue
+		}
+		wg.Add(1)
+		m.dst.ProduceBatch(ctx, kgo.PartitionRecords{
+			Topic:     m.cfg.rename(pend.topic),
+			Partition: pend.partition,
+			Records:   pend.records,
+		}, func(_ kgo.PartitionRecords, err error) {
+			defer wg.Done()
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil && produceErr == nil {
+				produceErr = err
+			}
+		})
+	}
+	if err := m.dst.Flush(ctx); err != nil && produceErr == nil {
+		produceErr = err
+	}
+	if produceErr == nil {
+		wg.Wait()
+	}
+
+	checkpoints := make(map[string]map[int32]Checkpoint)
+	offsets := make(map[string]map[int32]kgo.EpochOffset)
+	metadata := make(map[string]map[int32]string)
+	for _, pend := range pendings {
+		c := Checkpoint{Source: pend.next, Destination: -1}
+		if prior, ok := m.committed[pend.topic][pend.partition]; ok {
+			c.Destination = prior.Destination
+		}
+		if n := len(pend.records); n > 0 {
+			c.Destination = pend.records[n-1].Offset + 1
+		}
+		if c.Destination < 0 {
+			continue // nothing mirrored to this partition yet; see Checkpoint
+		}
+		meta, _ := json.Marshal(checkpointMetadata{SourceTopic: pend.topic, SourceOffset: c.Source}) // cannot fail
+
+		// Checkpoints are committed for the destination partition,
+		// which exists because we produce to it, with no leader epoch:
+		// any epoch we know of is from the source cluster.
+		dstTopic := m.cfg.rename(pend.topic)
+		if checkpoints[pend.topic] == nil {
+			checkpoints[pend.topic] = make(map[int32]Checkpoint)
+		}
+		if offsets[dstTopic] == nil {
+			offsets[dstTopic] = make(map[int32]kgo.EpochOffset)
+			metadata[dstTopic] = make(map[int32]string)
+		}
+		checkpoints[pend.topic][pend.partition] = c
+		offsets[dstTopic][pend.partition] = kgo.EpochOffset{Epoch: -1, Offset: c.Destination}
+		metadata[dstTopic][pend.partition] = string(meta)
+	}
+
+	if produceErr == nil && len(offsets) > 0 {
+		produceErr = m.dst.AddGroupOffsetsToTransaction(ctx, m.cfg.group, offsets, metadata)
+	}
+
+	if produceErr != nil {
+		// We may have failed because ctx is done, so we abort with our
+		// own context: if we did not end the transaction, the next Run
+		// would fail to begin one.
+		actx, cancel := context.WithTimeout(context.Background(), abortTimeout)
+		defer cancel()
+		var abortErr error
+		if err := m.dst.AbortBufferedRecords(actx); err != nil {
+			abortErr = fmt.Errorf("unable to abort buffered records: %w", err)
+		} else {
+			wg.Wait()
+			if err := m.dst.EndTransaction(actx, kgo.TryAbort); err != nil {
+				abortErr = fmt.Errorf("unable to abort transaction: %w", err)
+			}
+		}
+		m.rewind()
+		if abortErr != nil {
+			return &AbortError{Err: produceErr, AbortErr: abortErr}
+		}
+		return fmt.Errorf("kmirror: unable to mirror, aborted transaction: %w", produceErr)
+	}
+
+	if err := m.dst.EndTransaction(ctx, kgo.TryCommit); err != nil {
+		m.rewind()
+		return fmt.Errorf("kmirror: unable to commit transaction: %w", err)
+	}
+	for t, ps := range checkpoints {
+		if m.committed[t] == nil {
+			m.committed[t] = make(map[int32]Checkpoint)
+		}
+		for p, c := range ps {
+			m.committed[t][p] = c
+		}
+	}
+	return nil
+}
+
+// mirrored returns the destination record for a source record.
+func (m *Mirror) mirrored(r *kgo.Record) *kgo.Record {
+	mr := &kgo.Record{
+		Key:       r.Key,
+		Value:     r.Value,
+		Timestamp: r.Timestamp,
+		Topic:     m.cfg.rename(r.Topic),
+		Partition: r.Partition,
+	}
+	if len(r.Headers) > 0 {
+		mr.Headers = append([]kgo.RecordHeader(nil), r.Headers...)
+	}
+	if m.cfg.transform != nil {
+		m.cfg.transform(mr)
+	}
+	return mr
+}
+
+// rewind resets the source to the last checkpoints after a failed
+// transaction.
+func (m *Mirror) rewind() {
+	seek := make(map[string]map[int32]kgo.EpochOffset, len(m.next))
+	for t, ps := range m.next {
+		seek[t] = make(map[int32]kgo.EpochOffset, len(ps))
+		for p := range ps {
+			to := m.start[t][p]
+			if c, ok := m.committed[t][p]; ok && c.Source > to {
+				to = c.Source
+			}
+			ps[p] = to
+			seek[t][p] = kgo.EpochOffset{Epoch: -1, Offset: to}
+		}
+	}
+	m.src.SetOffsets(seek)
+}
+
+func setOffset(offsets map[string]map[int32]int64, topic string, partition int32, offset int64) {
+	if offsets[topic] == nil {
+		offsets[topic] = make(map[int32]int64)
+	}
+	offsets[topic][partition] = offset
+}
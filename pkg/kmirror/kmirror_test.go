@@ -0,0 +1,316 @@
+package kmirror
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twmb/franz-go/pkg/kerr"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+func TestMirrored(t *testing.T) {
+	src := &kgo.Record{
+		Topic:     "foo",
+		Partition: 3,
+		Offset:    10,
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Timestamp: time.Unix(1, 0),
+		Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}},
+	}
+
+	for _, test := range []struct {
+		name string
+		opts []Opt
+		exp  *kgo.Record
+	}{
+		{
+			name: "keeps topic partition and contents",
+			exp: &kgo.Record{
+				Topic:     "foo",
+				Partition: 3,
+				Key:       []byte("k"),
+				Value:     []byte("v"),
+				Timestamp: time.Unix(1, 0),
+				Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}},
+			},
+		},
+		{
+			name: "renames and transforms",
+			opts: []Opt{
+				RenameTopics(map[string]string{"foo": "bar"}),
+				TransformRecords(func(r *kgo.Record) {
+					r.Headers = append(r.Headers, kgo.RecordHeader{Key: "from", Value: []byte("src")})
+				}),
+			},
+			exp: &kgo.Record{
+				Topic:     "bar",
+				Partition: 3,
+				Key:       []byte("k"),
+				Value:     []byte("v"),
+				Timestamp: time.Unix(1, 0),
+				Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}, {Key: "from", Value: []byte("src")}},
+			},
+		},
+		{
+			name: "renames with a function",
+			opts: []Opt{RenameTopicsFn(func(topic string) string { return "src." + topic })},
+			exp: &kgo.Record{
+				Topic:     "src.foo",
+				Partition: 3,
+				Key:       []byte("k"),
+				Value:     []byte("v"),
+				Timestamp: time.Unix(1, 0),
+				Headers:   []kgo.RecordHeader{{Key: "h", Value: []byte("hv")}},
+			},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			m := &Mirror{cfg: cfg{rename: func(topic string) string { return topic }}}
+			for _, opt := range test.opts {
+				opt.apply(&m.cfg)
+			}
+			got := m.mirrored(src)
+			if !reflect.DeepEqual(got, test.exp) {
+				t.Errorf("got %+v != exp %+v", got, test.exp)
+			}
+			if len(src.Headers) != 1 {
+				t.Error("source record headers were modified")
+			}
+		})
+	}
+}
+
+type fakeSource struct {
+	seeks []map[string]map[int32]kgo.EpochOffset
+}
+
+func (*fakeSource) PollFetches(context.Context) kgo.Fetches { return nil }
+
+func (s *fakeSource) SetOffsets(seek map[string]map[int32]kgo.EpochOffset) {
+	s.seeks = append(s.seeks, seek)
+}
+
+// fakeDestination assigns offsets to produced records and records the last
+// transaction's checkpoints and how it ended.
+type fakeDestination struct {
+	next     map[string]map[int32]int64
+	addErr   error
+	abortErr error
+
+	produced []*kgo.Record
+	offsets  map[string]map[int32]kgo.EpochOffset
+	metadata map[string]map[int32]string
+	ended    []kgo.TransactionEndTry
+}
+
+func (*fakeDestination) BeginTransaction() error { return nil }
+
+func (d *fakeDestination) ProduceBatch(_ context.Context, batch kgo.PartitionRecords, promise func(kgo.PartitionRecords, error)) {
+	for _, r := range batch.Records {
+		r.Topic, r.Partition = batch.Topic, batch.Partition
+		r.Offset = d.next[batch.Topic][batch.Partition]
+		d.next[batch.Topic][batch.Partition]++
+		d.produced = append(d.produced, r)
+	}
+	promise(batch, nil)
+}
+
+func (*fakeDestination) Flush(context.Context) error { return nil }
+
+func (d *fakeDestination) AddGroupOffsetsToTransaction(_ context.Context, _ string, offsets map[string]map[int32]kgo.EpochOffset, metadata map[string]map[int32]string) error {
+	d.offsets, d.metadata = offsets, metadata
+	return d.addErr
+}
+
+func (*fakeDestination) AbortBufferedRecords(ctx context.Context) error { return ctx.Err() }
+
+func (d *fakeDestination) EndTransaction(ctx context.Context, commit kgo.TransactionEndTry) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	d.ended = append(d.ended, commit)
+	if !commit {
+		return d.abortErr
+	}
+	return nil
+}
+
+// offsetFetch returns the response a broker would return after the last
+// transaction committed.
+func (d *fakeDestination) offsetFetch() *kmsg.OffsetFetchResponse {
+	resp := kmsg.NewPtrOffsetFetchResponse()
+	for topic, ps := range d.offsets {
+		rt := kmsg.NewOffsetFetchResponseTopic()
+		rt.Topic = topic
+		for partition, eo := range ps {
+			meta := d.metadata[topic][partition]
+			rp := kmsg.NewOffsetFetchResponseTopicPartition()
+			rp.Partition = partition
+			rp.Offset = eo.Offset
+			rp.LeaderEpoch = eo.Epoch
+			rp.Metadata = &meta
+			rt.Partitions = append(rt.Partitions, rp)
+		}
+		resp.Topics = append(resp.Topics, rt)
+	}
+	return resp
+}
+
+func TestMirror(t *testing.T) {
+	rec := func(partition int32, offset int64, value string) *kgo.Record {
+		return &kgo.Record{Topic: "foo", Partition: partition, Offset: offset, LeaderEpoch: 7, Value: []byte(value)}
+	}
+	fetches := func(ps ...kgo.FetchPartition) kgo.Fetches {
+		return kgo.Fetches{{Topics: []kgo.FetchTopic{{Topic: "foo", Partitions: ps}}}}
+	}
+	values := func(rs []*kgo.Record) []string {
+		var vs []string
+		for _, r := range rs {
+			vs = append(vs, string(r.Value))
+		}
+		return vs
+	}
+
+	src := new(fakeSource)
+	dst := &fakeDestination{next: map[string]map[int32]int64{"bar": {0: 100, 1: 50}}}
+	m := &Mirror{
+		src: src,
+		dst: dst,
+		cfg: cfg{
+			group:  "g",
+			rename: func(topic string) string { return map[string]string{"foo": "bar"}[topic] },
+			filter: func(r *kgo.Record) bool { return string(r.Value) != "skip" },
+		},
+		committed: map[string]map[int32]Checkpoint{"foo": {0: {Source: 6, Destination: 100}}},
+		next:      make(map[string]map[int32]int64),
+		start:     make(map[string]map[int32]int64),
+	}
+
+	// Partition 0 starts before its checkpoint, and partition 1 has only
+	// filtered records and thus nothing to checkpoint.
+	if err := m.mirror(context.Background(), fetches(
+		kgo.FetchPartition{Partition: 0, Records: []*kgo.Record{rec(0, 5, "a"), rec(0, 6, "b"), rec(0, 7, "skip")}},
+		kgo.FetchPartition{Partition: 1, Records: []*kgo.Record{rec(1, 3, "skip")}},
+	)); err != nil {
+		t.Fatalf("unexpected mirror err: %v", err)
+	}
+	if exp := []map[string]map[int32]kgo.EpochOffset{{"foo": {0: {Epoch: -1, Offset: 6}}}}; !reflect.DeepEqual(src.seeks, exp) {
+		t.Errorf("got seeks %v != exp %v", src.seeks, exp)
+	}
+	if got, exp := values(dst.produced), []string{"b"}; !reflect.DeepEqual(got, exp) {
+		t.Errorf("got produced %v != exp %v", got, exp)
+	}
+	if exp := map[string]map[int32]kgo.EpochOffset{"bar": {0: {Epoch: -1, Offset: 101}}}; !reflect.DeepEqual(dst.offsets, exp) {
+		t.Errorf("got committed offsets %v != exp %v", dst.offsets, exp)
+	}
+	if exp := map[string]map[int32]string{"bar": {0: `{"source_topic":"foo","source_offset":8}`}}; !reflect.DeepEqual(dst.metadata, exp) {
+		t.Errorf("got committed metadata %v != exp %v", dst.metadata, exp)
+	}
+	if exp := []kgo.TransactionEndTry{kgo.TryCommit}; !reflect.DeepEqual(dst.ended, exp) {
+		t.Errorf("got ended %v != exp %v", dst.ended, exp)
+	}
+	exp := map[string]map[int32]Checkpoint{"foo": {0: {Source: 8, Destination: 101}}}
+	if got := m.Checkpoints(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got checkpoints %v != exp %v", got, exp)
+	}
+
+	// Loading what was committed to the renamed topic returns the
+	// checkpoints keyed by source topic.
+	if got, err := checkpointsFromResponse(dst.offsetFetch()); err != nil || !reflect.DeepEqual(got, exp) {
+		t.Errorf("got loaded checkpoints %v (err %v) != exp %v", got, err, exp)
+	}
+
+	// If the transaction fails, it is aborted and every partition is
+	// rewound to its checkpoint or, without one, where it started.
+	dst.addErr = errors.New("boom")
+	src.seeks = nil
+	if err := m.mirror(context.Background(), fetches(
+		kgo.FetchPartition{Partition: 0, Records: []*kgo.Record{rec(0, 8, "c")}},
+		kgo.FetchPartition{Partition: 1, Records: []*kgo.Record{rec(1, 4, "d")}},
+	)); !errors.Is(err, dst.addErr) {
+		t.Fatalf("got mirror err %v != exp %v", err, dst.addErr)
+	}
+	if exp := []kgo.TransactionEndTry{kgo.TryCommit, kgo.TryAbort}; !reflect.DeepEqual(dst.ended, exp) {
+		t.Errorf("got ended %v != exp %v", dst.ended, exp)
+	}
+	if exp := []map[string]map[int32]kgo.EpochOffset{{"foo": {
+		0: {Epoch: -1, Offset: 8},
+		1: {Epoch: -1, Offset: 3},
+	}}}; !reflect.DeepEqual(src.seeks, exp) {
+		t.Errorf("got rewind seeks %v != exp %v", src.seeks, exp)
+	}
+	if got := m.Checkpoints(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("got checkpoints after abort %v != exp %v", got, exp)
+	}
+
+	// The transaction is aborted even if it failed because the context
+	// was canceled, and failing to abort returns both errors.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dst.addErr = ctx.Err()
+	dst.abortErr = errors.New("abort boom")
+	var abortErr *AbortError
+	if err := m.mirror(ctx, fetches(
+		kgo.FetchPartition{Partition: 0, Records: []*kgo.Record{rec(0, 8, "c")}},
+	)); !errors.As(err, &abortErr) || !errors.Is(err, context.Canceled) || !errors.Is(err, dst.abortErr) {
+		t.Fatalf("got mirror err %v != exp canceled and failed abort", err)
+	}
+	if exp := []kgo.TransactionEndTry{kgo.TryCommit, kgo.TryAbort, kgo.TryAbort}; !reflect.DeepEqual(dst.ended, exp) {
+		t.Errorf("got ended %v != exp %v", dst.ended, exp)
+	}
+
+	// Records before the rewound offset are mirrored again.
+	dst.addErr, dst.abortErr = nil, nil
+	if err := m.mirror(context.Background(), fetches(
+		kgo.FetchPartition{Partition: 1, Records: []*kgo.Record{rec(1, 3, "skip"), rec(1, 4, "d")}},
+	)); err != nil {
+		t.Fatalf("unexpected mirror err: %v", err)
+	}
+	if exp := map[string]map[int32]string{"bar": {1: `{"source_topic":"foo","source_offset":5}`}}; !reflect.DeepEqual(dst.metadata, exp) {
+		t.Errorf("got committed metadata %v != exp %v", dst.metadata, exp)
+	}
+}
+
+func TestLoadCheckpointsResponse(t *testing.T) {
+	meta := func(s string) *string { return &s }
+	partition := func(p int32, offset int64, metadata *string) kmsg.OffsetFetchResponseTopicPartition {
+		rp := kmsg.NewOffsetFetchResponseTopicPartition()
+		rp.Partition = p
+		rp.Offset = offset
+		rp.Metadata = metadata
+		return rp
+	}
+	resp := kmsg.NewPtrOffsetFetchResponse()
+	resp.Topics = []kmsg.OffsetFetchResponseTopic{
+		{Topic: "src.foo", Partitions: []kmsg.OffsetFetchResponseTopicPartition{
+			partition(0, 10, meta(`{"source_topic":"foo","source_offset":3}`)),
+			partition(1, -1, meta(`{"source_topic":"foo","source_offset":4}`)),
+			partition(2, 10, nil),
+			partition(3, 10, meta("12")), // not a checkpoint
+		}},
+		{Topic: "src.bar", Partitions: []kmsg.OffsetFetchResponseTopicPartition{
+			partition(0, 20, meta(`{"source_topic":"bar","source_offset":0}`)),
+		}},
+	}
+	got, err := checkpointsFromResponse(resp)
+	if err != nil {
+		t.Fatalf("unexpected err: %v", err)
+	}
+	exp := map[string]map[int32]Checkpoint{
+		"foo": {0: {Source: 3, Destination: 10}},
+		"bar": {0: {Source: 0, Destination: 20}},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %v != exp %v", got, exp)
+	}
+
+	resp.Topics[1].Partitions[0].ErrorCode = kerr.GroupAuthorizationFailed.Code
+	if _, err := checkpointsFromResponse(resp); !errors.Is(err, kerr.GroupAuthorizationFailed) {
+		t.Errorf("got err %v != exp %v", err, kerr.GroupAuthorizationFailed)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net/url"
 	"sort"
+	"strings"
 	"sync"
 	"sync/atomic"
 )
@@ -141,6 +142,101 @@ func (cl *Client) Subjects(ctx context.Context, deleted HideShowDeleted) ([]stri
 	return subjects, cl.get(ctx, path, &subjects)
 }
 
+// DefaultContext is the context that subjects belong to if they are not
+// qualified with a context.
+const DefaultContext = "."
+
+// ContextSubject returns the subject qualified with the given context, i.e.
+// ":.context:subject". Contexts begin with a dot; the dot is added if it is
+// missing. The default context (either "" or ".") returns the subject
+// unchanged.
+func ContextSubject(context, subject string) string {
+	if context == "" || context == DefaultContext {
+		return subject
+	}
+	if !strings.HasPrefix(context, ".") {
+		context = "." + context
+	}
+	return ":" + context + ":" + subject
+}
+
+// SplitContextSubject splits a possibly context qualified subject into its
+// context and unqualified subject. An unqualified subject is in the
+// DefaultContext.
+func SplitContextSubject(qualified string) (context, subject string) {
+	if strings.HasPrefix(qualified, ":.") {
+		if end := strings.IndexByte(qualified[1:], ':'); end >= 0 {
+			return qualified[1 : end+1], qualified[end+2:]
+		}
+	}
+	return DefaultContext, qualified
+}
+
+// Contexts returns the contexts in the registry. The default context is
+// always present.
+func (cl *Client) Contexts(ctx context.Context) ([]string, error) {
+	// GET /contexts
+	var contexts []string
+	defer func() { sort.Strings(contexts) }()
+	return contexts, cl.get(ctx, "/contexts", &contexts)
+}
+
+// ContextSubjects returns the subjects in the given context. The returned
+// subjects are context qualified, unless the context is the default context.
+func (cl *Client) ContextSubjects(ctx context.Context, context string, deleted HideShowDeleted) ([]string, error) {
+	// GET /subjects?subjectPrefix={prefix}&deleted={x}
+	var subjects []string
+	query := url.Values{"subjectPrefix": []string{ContextSubject(context, "")}}
+	if context == "" || context == DefaultContext {
+		query.Set("subjectPrefix", ":.:")
+	}
+	if deleted {
+		query.Set("deleted", "true")
+	}
+	return subjects, cl.get(ctx, "/subjects?"+query.Encode(), &subjects)
+}
+
+// AllOrLatest is a typed bool indicating whether listing schemas should return
+// all versions of each subject, or only the latest.
+type AllOrLatest bool
+
+const (
+	// AllVersions returns all versions of each subject.
+	AllVersions = false
+	// LatestOnly returns only the latest version of each subject.
+	LatestOnly = true
+)
+
+// ListSchemas returns the schemas in all subjects that begin with the given
+// prefix, which can be empty to list schemas in all subjects (of the default
+// context). A context qualified prefix (see ContextSubject) lists schemas in
+// subjects of that context.
+func (cl *Client) ListSchemas(ctx context.Context, subjectPrefix string, deleted HideShowDeleted, versions AllOrLatest) ([]SubjectSchema, error) {
+	// GET /schemas?subjectPrefix={prefix}&deleted={x}&latestOnly={y}
+	var schemas []SubjectSchema
+	query := make(url.Values)
+	if subjectPrefix != "" {
+		query.Set("subjectPrefix", subjectPrefix)
+	}
+	if deleted {
+		query.Set("deleted", "true")
+	}
+	if versions == LatestOnly {
+		query.Set("latestOnly", "true")
+	}
+	path := "/schemas"
+	if len(query) > 0 {
+		path += "?" + query.Encode()
+	}
+	defer func() {
+		sort.Slice(schemas, func(i, j int) bool {
+			l, r := schemas[i], schemas[j]
+			return l.Subject < r.Subject || l.Subject == r.Subject && l.Version < r.Version
+		})
+	}()
+	return schemas, cl.get(ctx, path, &schemas)
+}
+
 // SchemaTextByID returns the actual text of a schema.
 //
 // For example, if the schema for an ID is
@@ -152,14 +248,21 @@ func (cl *Client) Subjects(ctx context.Context, deleted HideShowDeleted) ([]stri
 //     {"type":"boolean"}
 //
 func (cl *Client) SchemaTextByID(ctx context.Context, id int) (string, error) {
-	// GET /schemas/ids/{id}
-	var s Schema
-	if err := cl.get(ctx, fmt.Sprintf("/schemas/ids/%d", id), &s); err != nil {
+	s, err := cl.SchemaByID(ctx, id)
+	if err != nil {
 		return "", err
 	}
 	return s.Schema, nil
 }
 
+// SchemaByID returns the schema for a given schema ID, including its type and
+// any references.
+func (cl *Client) SchemaByID(ctx context.Context, id int) (Schema, error) {
+	// GET /schemas/ids/{id}
+	var s Schema
+	return s, cl.get(ctx, fmt.Sprintf("/schemas/ids/%d", id), &s)
+}
+
 func pathSubject(subject string) string            { return fmt.Sprintf("/subjects/%s", url.PathEscape(subject)) }
 func pathSubjectWithVersion(subject string) string { return pathSubject(subject) + "/versions" }
 func pathSubjectVersion(subject string, version int) string {
@@ -222,6 +325,7 @@ func (cl *Client) Schemas(ctx context.Context, subject string, deleted HideShowD
 		wg           sync.WaitGroup
 		cctx, cancel = context.WithCancel(ctx)
 	)
+	defer cancel()
 	for i := range versions {
 		version := versions[i]
 		slot := i
@@ -333,6 +437,7 @@ func (cl *Client) SchemaReferences(ctx context.Context, subject string, version
 		wg           sync.WaitGroup
 		cctx, cancel = context.WithCancel(ctx)
 	)
+	defer cancel()
 	for i := range ids {
 		id := ids[i]
 		wg.Add(1)
@@ -379,6 +484,7 @@ func (cl *Client) SchemaUsagesByID(ctx context.Context, id int, deleted HideShow
 		wg           sync.WaitGroup
 		cctx, cancel = context.WithCancel(ctx)
 	)
+	defer cancel()
 	for i := range subjectVersions {
 		sv := subjectVersions[i]
 		slot := i
@@ -536,7 +642,8 @@ func (cl *Client) ResetCompatibilityLevel(ctx context.Context, subjects ...strin
 
 // CheckCompatibility checks if a schema is compatible with the given version
 // that exists. You can use -1 to check compatibility with the latest version,
-// and -2 to check compatibility against all versions.
+// and -2 to check compatibility against all versions. To check compatibility
+// without a registry, see CheckCompatibilityOffline.
 func (cl *Client) CheckCompatibility(ctx context.Context, subject string, version int, s Schema) (bool, error) {
 	// POST /compatibility/subjects/{subject}/versions/{version}?reason=true
 	// POST /compatibility/subjects/{subject}/versions?reason=true
@@ -614,7 +721,7 @@ func (cl *Client) SetMode(ctx context.Context, mode Mode, force bool, subjects .
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			var m modeResponse
+			m := modeResponse{mode}
 			err := cl.put(ctx, pathMode(subject, force), m, &m)
 			results[slot] = ModeResult{
 				Subject: subject,
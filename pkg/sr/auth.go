@@ -0,0 +1,119 @@
+package sr
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"sync"
+	"time"
+)
+
+// bearerToken caches a token from a token function. Refreshes are serialized
+// so that many requests failing at once only fetch one new token.
+type bearerToken struct {
+	fn func(context.Context) (string, error)
+
+	mu    sync.Mutex
+	token string
+}
+
+func (b *bearerToken) get(ctx context.Context) (string, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == "" {
+		token, err := b.fn(ctx)
+		if err != nil {
+			return "", err
+		}
+		b.token = token
+	}
+	return b.token, nil
+}
+
+// expire drops the cached token if it is still the given token, which was
+// rejected. If the token was already refreshed, we keep the new token.
+func (b *bearerToken) expire(token string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.token == token {
+		b.token = ""
+	}
+}
+
+// CertReloader loads a client certificate and key from files, and reloads
+// them whenever the files change. This allows using mTLS with certificates
+// that are rotated on disk:
+//
+//     r, err := sr.NewCertReloader("client.pem", "client-key.pem", time.Minute)
+//     // handle err
+//     cl, err := sr.NewClient(sr.DialTLSConfig(&tls.Config{
+//             GetClientCertificate: r.GetClientCertificate,
+//     }))
+//
+// Certificates are only presented when new connections are established;
+// existing connections continue to use the certificate they were opened with.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	modTime time.Time
+	checked time.Time
+}
+
+// NewCertReloader returns a CertReloader that has loaded the key pair in
+// certFile and keyFile. The files are checked for modifications at most once
+// per interval.
+func NewCertReloader(certFile, keyFile string, interval time.Duration) (*CertReloader, error) {
+	r := &CertReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+	if err := r.reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetClientCertificate returns the current certificate, reloading it if the
+// files have changed since it was loaded. If reloading fails (for example, if
+// the certificate was written but the key is not yet written), the previously
+// loaded certificate is returned. This function is meant to be used as the
+// GetClientCertificate field of a tls.Config.
+func (r *CertReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= r.interval {
+		r.reload() // on error, we keep our existing certificate
+	}
+	return r.cert, nil
+}
+
+// reload loads the key pair if either file was modified since our last load,
+// and must be called with the mutex held (or before the reloader is shared).
+func (r *CertReloader) reload() error {
+	r.checked = time.Now()
+	var modTime time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(file)
+		if err != nil {
+			return err
+		}
+		if fi.ModTime().After(modTime) {
+			modTime = fi.ModTime()
+		}
+	}
+	if r.cert != nil && !modTime.After(r.modTime) {
+		return nil
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
@@ -0,0 +1,135 @@
+package sr
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestBearerTokenRefresh(t *testing.T) {
+	var tokens int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Tenant") != "blue" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(`{"error_code":400,"message":"missing tenant"}`))
+			return
+		}
+		// Only the second token is valid.
+		if r.Header.Get("Authorization") != "Bearer token-2" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error_code":401,"message":"unauthorized"}`))
+			return
+		}
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(
+		URLs(srv.URL),
+		BearerToken(func(context.Context) (string, error) {
+			return fmt.Sprintf("token-%d", atomic.AddInt32(&tokens, 1)), nil
+		}),
+		RequestHeaders(func(_ context.Context, h http.Header) error {
+			h.Set("X-Tenant", "blue")
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if subjects, err := cl.Subjects(context.Background(), HideDeleted); err != nil || len(subjects) != 1 {
+			t.Fatalf("got %v (err %v) != exp [foo]", subjects, err)
+		}
+	}
+	if n := atomic.LoadInt32(&tokens); n != 2 {
+		t.Errorf("token function called %d times != exp 2", n)
+	}
+}
+
+func writeTestKeyPair(t *testing.T, certFile, keyFile, cn string, mod time.Time) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, block := range map[string]*pem.Block{
+		certFile: {Type: "CERTIFICATE", Bytes: der},
+		keyFile:  {Type: "EC PRIVATE KEY", Bytes: keyDER},
+	} {
+		if err := ioutil.WriteFile(file, pem.EncodeToMemory(block), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(file, mod, mod); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	now := time.Now()
+	writeTestKeyPair(t, certFile, keyFile, "first", now)
+
+	r, err := NewCertReloader(certFile, keyFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commonName := func() string {
+		cert, err := r.GetClientCertificate(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed.Subject.CommonName
+	}
+	if cn := commonName(); cn != "first" {
+		t.Errorf("got %q != exp first", cn)
+	}
+
+	writeTestKeyPair(t, certFile, keyFile, "second", now.Add(time.Minute))
+	if cn := commonName(); cn != "second" {
+		t.Errorf("got %q != exp second after rotation", cn)
+	}
+
+	// A broken rotation keeps the old certificate.
+	if err := ioutil.WriteFile(keyFile, []byte("garbage"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes(keyFile, now.Add(2*time.Minute), now.Add(2*time.Minute))
+	if cn := commonName(); cn != "second" {
+		t.Errorf("got %q != exp second after broken rotation", cn)
+	}
+}
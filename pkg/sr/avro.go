@@ -0,0 +1,1811 @@
+package sr
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// This file contains a small, dependency free implementation of the Avro
+// specification: schema parsing, binary encoding and decoding, and schema
+// resolution between a writer and reader schema.
+//
+//     https://avro.apache.org/docs/current/spec.html
+//
+// Values are decoded into the following generic Go types:
+//
+//     null     nil
+//     boolean  bool
+//     int      int32
+//     long     int64
+//     float    float32
+//     double   float64
+//     bytes    []byte
+//     string   string
+//     record   map[string]interface{}
+//     enum     string
+//     array    []interface{}
+//     map      map[string]interface{}
+//     fixed    []byte
+//     union    the value of the chosen branch
+//
+// Logical types are decoded as their underlying type.
+
+type avroKind uint8
+
+const (
+	avroNull avroKind = iota
+	avroBoolean
+	avroInt
+	avroLong
+	avroFloat
+	avroDouble
+	avroBytes
+	avroString
+	avroRecord
+	avroEnum
+	avroArray
+	avroMap
+	avroUnion
+	avroFixed
+)
+
+func (k avroKind) String() string {
+	switch k {
+	case avroNull:
+		return "null"
+	case avroBoolean:
+		return "boolean"
+	case avroInt:
+		return "int"
+	case avroLong:
+		return "long"
+	case avroFloat:
+		return "float"
+	case avroDouble:
+		return "double"
+	case avroBytes:
+		return "bytes"
+	case avroString:
+		return "string"
+	case avroRecord:
+		return "record"
+	case avroEnum:
+		return "enum"
+	case avroArray:
+		return "array"
+	case avroMap:
+		return "map"
+	case avroUnion:
+		return "union"
+	case avroFixed:
+		return "fixed"
+	default:
+		return "unknown"
+	}
+}
+
+var avroPrimitives = map[string]avroKind{
+	"null":    avroNull,
+	"boolean": avroBoolean,
+	"int":     avroInt,
+	"long":    avroLong,
+	"float":   avroFloat,
+	"double":  avroDouble,
+	"bytes":   avroBytes,
+	"string":  avroString,
+}
+
+type avroField struct {
+	name    string
+	aliases []string
+	typ     *avroSchema
+	hasDef  bool
+	def     interface{} // default converted to the generic type of typ
+}
+
+// avroSchema is a parsed Avro schema.
+type avroSchema struct {
+	kind    avroKind
+	name    string   // full name, for named types
+	aliases []string // full names, for named types
+	logical string
+
+	fields     []avroField // record
+	symbols    []string    // enum
+	enumDef    string      // enum, if hasEnumDef
+	hasEnumDef bool
+
+	items    *avroSchema   // array
+	values   *avroSchema   // map
+	branches []*avroSchema // union
+	size     int           // fixed
+}
+
+func (s *avroSchema) isNamed() bool {
+	return s.kind == avroRecord || s.kind == avroEnum || s.kind == avroFixed
+}
+
+// shortName returns the unqualified name of a named schema.
+func (s *avroSchema) shortName() string {
+	return s.name[strings.LastIndexByte(s.name, '.')+1:]
+}
+
+// avroNames contains named types that are known while parsing. Names can be
+// shared across multiple parses, which is how references are resolved: a
+// referenced schema is parsed first, and its names are then available to the
+// referencing schema.
+type avroNames map[string]*avroSchema
+
+// parseAvroSchema parses an Avro schema, registering any named types into
+// names. The names map may be nil.
+func parseAvroSchema(text string, names avroNames) (*avroSchema, error) {
+	var raw interface{}
+	dec := json.NewDecoder(strings.NewReader(text))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("invalid avro schema json: %w", err)
+	}
+	if names == nil {
+		names = make(avroNames)
+	}
+	p := &avroParser{names: names}
+	return p.parse(raw, "")
+}
+
+type avroParser struct {
+	names avroNames
+}
+
+func avroFullName(name, namespace string) string {
+	if strings.IndexByte(name, '.') >= 0 || namespace == "" {
+		return name
+	}
+	return namespace + "." + name
+}
+
+func (p *avroParser) lookup(name, namespace string) (*avroSchema, bool) {
+	if s, ok := p.names[avroFullName(name, namespace)]; ok {
+		return s, true
+	}
+	s, ok := p.names[name] // null namespace
+	return s, ok
+}
+
+func (p *avroParser) parse(raw interface{}, namespace string) (*avroSchema, error) {
+	switch t := raw.(type) {
+	case string:
+		if k, ok := avroPrimitives[t]; ok {
+			return &avroSchema{kind: k}, nil
+		}
+		if s, ok := p.lookup(t, namespace); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", t)
+
+	case []interface{}:
+		u := &avroSchema{kind: avroUnion}
+		seen := make(map[string]bool)
+		for _, b := range t {
+			s, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if s.kind == avroUnion {
+				return nil, errors.New("avro unions cannot immediately contain other unions")
+			}
+			key := s.kind.String()
+			if s.isNamed() {
+				key = s.name
+			}
+			if seen[key] {
+				return nil, fmt.Errorf("avro union contains duplicate type %q", key)
+			}
+			seen[key] = true
+			u.branches = append(u.branches, s)
+		}
+		return u, nil
+
+	case map[string]interface{}:
+		return p.parseComplex(t, namespace)
+
+	default:
+		return nil, fmt.Errorf("invalid avro schema element %v", raw)
+	}
+}
+
+func (p *avroParser) parseComplex(m map[string]interface{}, namespace string) (*avroSchema, error) {
+	typ, ok := m["type"]
+	if !ok {
+		return nil, errors.New("avro schema object is missing \"type\"")
+	}
+	styp, ok := typ.(string)
+	if !ok {
+		// {"type": {"type": "string"}} or {"type": [...]}: the object
+		// is simply a wrapper.
+		return p.parse(typ, namespace)
+	}
+	logical, _ := m["logicalType"].(string)
+
+	if k, ok := avroPrimitives[styp]; ok {
+		return &avroSchema{kind: k, logical: logical}, nil
+	}
+
+	switch styp {
+	case "record", "error", "enum", "fixed":
+		name, _ := m["name"].(string)
+		if name == "" {
+			return nil, fmt.Errorf("avro %s is missing a name", styp)
+		}
+		if ns, ok := m["namespace"].(string); ok && strings.IndexByte(name, '.') < 0 {
+			namespace = ns
+		}
+		s := &avroSchema{logical: logical, name: avroFullName(name, namespace)}
+		if idx := strings.LastIndexByte(s.name, '.'); idx >= 0 {
+			namespace = s.name[:idx]
+		} else {
+			namespace = ""
+		}
+		if _, exists := p.names[s.name]; exists {
+			return nil, fmt.Errorf("avro type %q is defined more than once", s.name)
+		}
+		if aliases, ok := m["aliases"].([]interface{}); ok {
+			for _, a := range aliases {
+				if sa, ok := a.(string); ok {
+					s.aliases = append(s.aliases, avroFullName(sa, namespace))
+				}
+			}
+		}
+		// We register the name before parsing fields so that records
+		// can be recursive.
+		p.names[s.name] = s
+
+		switch styp {
+		case "record", "error":
+			s.kind = avroRecord
+			return s, p.parseFields(s, m, namespace)
+
+		case "enum":
+			s.kind = avroEnum
+			syms, _ := m["symbols"].([]interface{})
+			seen := make(map[string]bool)
+			for _, sym := range syms {
+				ssym, ok := sym.(string)
+				if !ok {
+					return nil, fmt.Errorf("avro enum %q has non-string symbol %v", s.name, sym)
+				}
+				if seen[ssym] {
+					return nil, fmt.Errorf("avro enum %q has duplicate symbol %q", s.name, ssym)
+				}
+				seen[ssym] = true
+				s.symbols = append(s.symbols, ssym)
+			}
+			if def, ok := m["default"].(string); ok {
+				if !seen[def] {
+					return nil, fmt.Errorf("avro enum %q default %q is not a symbol", s.name, def)
+				}
+				s.enumDef, s.hasEnumDef = def, true
+			}
+			return s, nil
+
+		default:
+			s.kind = avroFixed
+			size, err := avroJSONInt(m["size"])
+			if err != nil || size < 0 {
+				return nil, fmt.Errorf("avro fixed %q has invalid size", s.name)
+			}
+			s.size = int(size)
+			return s, nil
+		}
+
+	case "array":
+		items, err := p.parse(m["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: avroArray, logical: logical, items: items}, nil
+
+	case "map":
+		values, err := p.parse(m["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroSchema{kind: avroMap, logical: logical, values: values}, nil
+
+	default:
+		if s, ok := p.lookup(styp, namespace); ok {
+			return s, nil
+		}
+		return nil, fmt.Errorf("unknown avro type %q", styp)
+	}
+}
+
+func (p *avroParser) parseFields(s *avroSchema, m map[string]interface{}, namespace string) error {
+	fields, ok := m["fields"].([]interface{})
+	if !ok {
+		return fmt.Errorf("avro record %q is missing fields", s.name)
+	}
+	seen := make(map[string]bool)
+	for _, rawf := range fields {
+		fm, ok := rawf.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("avro record %q has an invalid field", s.name)
+		}
+		var f avroField
+		f.name, _ = fm["name"].(string)
+		if f.name == "" {
+			return fmt.Errorf("avro record %q has a field with no name", s.name)
+		}
+		if seen[f.name] {
+			return fmt.Errorf("avro record %q has duplicate field %q", s.name, f.name)
+		}
+		seen[f.name] = true
+		if aliases, ok := fm["aliases"].([]interface{}); ok {
+			for _, a := range aliases {
+				if sa, ok := a.(string); ok {
+					f.aliases = append(f.aliases, sa)
+				}
+			}
+		}
+		typ, err := p.parse(fm["type"], namespace)
+		if err != nil {
+			return fmt.Errorf("avro record %q field %q: %w", s.name, f.name, err)
+		}
+		f.typ = typ
+		if def, ok := fm["default"]; ok {
+			f.def, err = avroDefault(typ, def)
+			if err != nil {
+				return fmt.Errorf("avro record %q field %q has invalid default: %w", s.name, f.name, err)
+			}
+			f.hasDef = true
+		}
+		s.fields = append(s.fields, f)
+	}
+	return nil
+}
+
+func avroJSONInt(v interface{}) (int64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return 0, fmt.Errorf("expected number, got %v", v)
+	}
+	return n.Int64()
+}
+
+// avroDefault converts a JSON default to the generic Go type for s. Per the
+// spec, union defaults correspond to the first branch of the union, and bytes
+// and fixed defaults are strings whose code points are byte values.
+func avroDefault(s *avroSchema, v interface{}) (interface{}, error) {
+	switch s.kind {
+	case avroNull:
+		if v != nil {
+			return nil, errors.New("null default must be null")
+		}
+		return nil, nil
+	case avroBoolean:
+		b, ok := v.(bool)
+		if !ok {
+			return nil, errors.New("boolean default must be a boolean")
+		}
+		return b, nil
+	case avroInt:
+		n, err := avroJSONInt(v)
+		if err != nil || n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, errors.New("int default must be a 32 bit integer")
+		}
+		return int32(n), nil
+	case avroLong:
+		n, err := avroJSONInt(v)
+		if err != nil {
+			return nil, errors.New("long default must be an integer")
+		}
+		return n, nil
+	case avroFloat, avroDouble:
+		n, ok := v.(json.Number)
+		if !ok {
+			return nil, fmt.Errorf("%s default must be a number", s.kind)
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, err
+		}
+		if s.kind == avroFloat {
+			return float32(f), nil
+		}
+		return f, nil
+	case avroBytes, avroFixed:
+		str, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("%s default must be a string", s.kind)
+		}
+		b := make([]byte, 0, len(str))
+		for _, r := range str {
+			if r > 255 {
+				return nil, fmt.Errorf("%s default contains non-byte code point", s.kind)
+			}
+			b = append(b, byte(r))
+		}
+		if s.kind == avroFixed && len(b) != s.size {
+			return nil, fmt.Errorf("fixed default has length %d != size %d", len(b), s.size)
+		}
+		return b, nil
+	case avroString:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.New("string default must be a string")
+		}
+		return str, nil
+	case avroEnum:
+		str, ok := v.(string)
+		if !ok {
+			return nil, errors.New("enum default must be a string")
+		}
+		for _, sym := range s.symbols {
+			if sym == str {
+				return str, nil
+			}
+		}
+		return nil, fmt.Errorf("enum default %q is not a symbol", str)
+	case avroArray:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return nil, errors.New("array default must be an array")
+		}
+		out := make([]interface{}, 0, len(arr))
+		for _, e := range arr {
+			d, err := avroDefault(s.items, e)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, d)
+		}
+		return out, nil
+	case avroMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("map default must be an object")
+		}
+		out := make(map[string]interface{}, len(m))
+		for k, e := range m {
+			d, err := avroDefault(s.values, e)
+			if err != nil {
+				return nil, err
+			}
+			out[k] = d
+		}
+		return out, nil
+	case avroRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, errors.New("record default must be an object")
+		}
+		out := make(map[string]interface{}, len(s.fields))
+		for _, f := range s.fields {
+			fv, ok := m[f.name]
+			if !ok {
+				if !f.hasDef {
+					return nil, fmt.Errorf("record default is missing field %q", f.name)
+				}
+				out[f.name] = f.def
+				continue
+			}
+			d, err := avroDefault(f.typ, fv)
+			if err != nil {
+				return nil, err
+			}
+			out[f.name] = d
+		}
+		return out, nil
+	case avroUnion:
+		if len(s.branches) == 0 {
+			return nil, errors.New("union default with no branches")
+		}
+		return avroDefault(s.branches[0], v)
+	default:
+		return nil, fmt.Errorf("unknown avro kind %d", s.kind)
+	}
+}
+
+////////////
+// DECODE //
+////////////
+
+var errAvroShort = errors.New("avro: unexpected end of input")
+
+type avroReader struct {
+	b []byte
+}
+
+func (r *avroReader) long() (int64, error) {
+	u, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		return 0, errAvroShort
+	}
+	r.b = r.b[n:]
+	return int64(u>>1) ^ -int64(u&1), nil
+}
+
+func (r *avroReader) int() (int32, error) {
+	l, err := r.long()
+	if err != nil {
+		return 0, err
+	}
+	if l < math.MinInt32 || l > math.MaxInt32 {
+		return 0, fmt.Errorf("avro: int %d overflows 32 bits", l)
+	}
+	return int32(l), nil
+}
+
+func (r *avroReader) fixed(n int) ([]byte, error) {
+	if n < 0 || len(r.b) < n {
+		return nil, errAvroShort
+	}
+	b := r.b[:n:n]
+	r.b = r.b[n:]
+	return b, nil
+}
+
+func (r *avroReader) bytes() ([]byte, error) {
+	l, err := r.long()
+	if err != nil {
+		return nil, err
+	}
+	if l < 0 || l > int64(len(r.b)) {
+		return nil, errAvroShort
+	}
+	return r.fixed(int(l))
+}
+
+// avroMaxItems is the most items we decode in one array or map. Items of
+// zero width types take no input, so their count cannot be bounded by the
+// remaining input, but we protect against absurd counts from corrupt input.
+const avroMaxItems = 1 << 24
+
+// blockCount reads the count of the next block of an array or map, adding it
+// to total, the count of items in the array or map so far. Negative counts
+// are followed by the block's byte size, which we do not need. Items that are
+// not zero width take at least one byte, meaning the count is also bounded by
+// the remaining input. Map items are never zero width, as keys take at least
+// one byte.
+func (r *avroReader) blockCount(total *int64, zeroWidth bool) (int64, error) {
+	n, err := r.long()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		if _, err := r.long(); err != nil {
+			return 0, err
+		}
+		n = -n
+	}
+	if n < 0 || n > avroMaxItems-*total {
+		return 0, fmt.Errorf("avro: item count %d is too large", uint64(n)+uint64(*total))
+	}
+	if n > int64(len(r.b)) && !zeroWidth {
+		return 0, errAvroShort
+	}
+	*total += n
+	return n, nil
+}
+
+// avroZeroWidth returns whether values of s can be encoded in zero bytes.
+func avroZeroWidth(s *avroSchema, seen map[*avroSchema]bool) bool {
+	switch s.kind {
+	case avroNull:
+		return true
+	case avroFixed:
+		return s.size == 0
+	case avroRecord:
+		if seen[s] {
+			return false // a record containing itself cannot be zero width
+		}
+		if seen == nil {
+			seen = make(map[*avroSchema]bool)
+		}
+		seen[s] = true
+		for _, f := range s.fields {
+			if !avroZeroWidth(f.typ, seen) {
+				return false
+			}
+		}
+		return true
+	default:
+		return false
+	}
+}
+
+// avroMatches returns whether a writer schema can be resolved into a reader
+// schema at the top level, per the "Schema Resolution" section of the spec.
+// Unions are not considered here.
+func avroMatches(w, r *avroSchema) bool {
+	switch r.kind {
+	case avroRecord, avroEnum:
+		return w.kind == r.kind && avroNamesMatch(w, r)
+	case avroFixed:
+		return w.kind == r.kind && avroNamesMatch(w, r) && w.size == r.size
+	case avroLong:
+		return w.kind == avroLong || w.kind == avroInt
+	case avroFloat:
+		return w.kind == avroFloat || w.kind == avroInt || w.kind == avroLong
+	case avroDouble:
+		return w.kind == avroDouble || w.kind == avroFloat || w.kind == avroInt || w.kind == avroLong
+	case avroString:
+		return w.kind == avroString || w.kind == avroBytes
+	case avroBytes:
+		return w.kind == avroBytes || w.kind == avroString
+	default:
+		return w.kind == r.kind
+	}
+}
+
+// avroNamesMatch returns if two named schemas have the same unqualified name,
+// or if the reader has an alias for the writer's name.
+func avroNamesMatch(w, r *avroSchema) bool {
+	if w.shortName() == r.shortName() {
+		return true
+	}
+	for _, a := range r.aliases {
+		if a == w.name || a[strings.LastIndexByte(a, '.')+1:] == w.shortName() {
+			return true
+		}
+	}
+	return false
+}
+
+// avroResolveBranch returns the first branch of a reader union that a
+// non-union writer schema resolves into.
+func avroResolveBranch(w *avroSchema, r *avroSchema) (*avroSchema, bool) {
+	// Exact kind matches are preferred over promotions.
+	for _, b := range r.branches {
+		if b.kind == w.kind && avroMatches(w, b) {
+			return b, true
+		}
+	}
+	for _, b := range r.branches {
+		if avroMatches(w, b) {
+			return b, true
+		}
+	}
+	return nil, false
+}
+
+// avroDecode decodes a value written with the writer schema w into the
+// generic type for the reader schema r. If r is nil, this decodes with w.
+func avroDecode(rd *avroReader, w, r *avroSchema) (interface{}, error) {
+	if r == nil {
+		r = w
+	}
+
+	if w.kind == avroUnion {
+		idx, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(w.branches)) {
+			return nil, fmt.Errorf("avro: union index %d out of range", idx)
+		}
+		wb := w.branches[idx]
+		if r == w {
+			return avroDecode(rd, wb, wb)
+		}
+		return avroDecode(rd, wb, r)
+	}
+	if r.kind == avroUnion {
+		rb, ok := avroResolveBranch(w, r)
+		if !ok {
+			return nil, fmt.Errorf("avro: writer type %s does not match any reader union branch", avroTypeName(w))
+		}
+		return avroDecode(rd, w, rb)
+	}
+	if !avroMatches(w, r) {
+		return nil, fmt.Errorf("avro: writer type %s does not match reader type %s", avroTypeName(w), avroTypeName(r))
+	}
+
+	switch w.kind {
+	case avroNull:
+		return nil, nil
+
+	case avroBoolean:
+		b, err := rd.fixed(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+
+	case avroInt, avroLong:
+		l, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		switch r.kind {
+		case avroInt:
+			if l < math.MinInt32 || l > math.MaxInt32 {
+				return nil, fmt.Errorf("avro: int %d overflows 32 bits", l)
+			}
+			return int32(l), nil
+		case avroFloat:
+			return float32(l), nil
+		case avroDouble:
+			return float64(l), nil
+		default:
+			return l, nil
+		}
+
+	case avroFloat:
+		b, err := rd.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		f := math.Float32frombits(binary.LittleEndian.Uint32(b))
+		if r.kind == avroDouble {
+			return float64(f), nil
+		}
+		return f, nil
+
+	case avroDouble:
+		b, err := rd.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+
+	case avroBytes, avroString:
+		b, err := rd.bytes()
+		if err != nil {
+			return nil, err
+		}
+		if r.kind == avroString {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+
+	case avroFixed:
+		b, err := rd.fixed(w.size)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+
+	case avroEnum:
+		idx, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(w.symbols)) {
+			return nil, fmt.Errorf("avro: enum %q index %d out of range", w.name, idx)
+		}
+		sym := w.symbols[idx]
+		if r == w {
+			return sym, nil
+		}
+		for _, rsym := range r.symbols {
+			if rsym == sym {
+				return sym, nil
+			}
+		}
+		if r.hasEnumDef {
+			return r.enumDef, nil
+		}
+		return nil, fmt.Errorf("avro: enum %q symbol %q is unknown to the reader", r.name, sym)
+
+	case avroArray:
+		var (
+			arr       []interface{}
+			total     int64
+			zeroWidth = avroZeroWidth(w.items, nil)
+		)
+		for {
+			n, err := rd.blockCount(&total, zeroWidth)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			for i := int64(0); i < n; i++ {
+				v, err := avroDecode(rd, w.items, r.items)
+				if err != nil {
+					return nil, err
+				}
+				arr = append(arr, v)
+			}
+		}
+		if arr == nil {
+			arr = []interface{}{}
+		}
+		return arr, nil
+
+	case avroMap:
+		var (
+			m     = make(map[string]interface{})
+			total int64
+		)
+		for {
+			n, err := rd.blockCount(&total, false)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			for i := int64(0); i < n; i++ {
+				k, err := rd.bytes()
+				if err != nil {
+					return nil, err
+				}
+				v, err := avroDecode(rd, w.values, r.values)
+				if err != nil {
+					return nil, err
+				}
+				m[string(k)] = v
+			}
+		}
+		return m, nil
+
+	case avroRecord:
+		return avroDecodeRecord(rd, w, r)
+
+	default:
+		return nil, fmt.Errorf("avro: unknown kind %d", w.kind)
+	}
+}
+
+func avroDecodeRecord(rd *avroReader, w, r *avroSchema) (interface{}, error) {
+	m := make(map[string]interface{}, len(r.fields))
+	if w == r {
+		for _, f := range w.fields {
+			v, err := avroDecode(rd, f.typ, f.typ)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = v
+		}
+		return m, nil
+	}
+
+	set := make([]bool, len(r.fields))
+	for _, wf := range w.fields {
+		ri := avroReaderField(wf, r)
+		if ri < 0 {
+			// The reader does not know this field: we decode
+			// and drop it.
+			if _, err := avroDecode(rd, wf.typ, nil); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rf := r.fields[ri]
+		v, err := avroDecode(rd, wf.typ, rf.typ)
+		if err != nil {
+			return nil, fmt.Errorf("field %q: %w", rf.name, err)
+		}
+		m[rf.name] = v
+		set[ri] = true
+	}
+	for i, rf := range r.fields {
+		if set[i] {
+			continue
+		}
+		if !rf.hasDef {
+			return nil, fmt.Errorf("avro: reader field %q in %q has no default and is missing from the writer", rf.name, r.name)
+		}
+		m[rf.name] = avroCopyDefault(rf.def)
+	}
+	return m, nil
+}
+
+// avroReaderField returns the index of the reader field that corresponds to
+// the writer field, matching names and reader field aliases.
+func avroReaderField(wf avroField, r *avroSchema) int {
+	for i, rf := range r.fields {
+		if rf.name == wf.name {
+			return i
+		}
+	}
+	for i, rf := range r.fields {
+		for _, a := range rf.aliases {
+			if a == wf.name {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// avroCopyDefault deep copies mutable default values so that users cannot
+// modify the defaults stored in a parsed schema.
+func avroCopyDefault(v interface{}) interface{} {
+	switch t := v.(type) {
+	case []byte:
+		return append([]byte(nil), t...)
+	case []interface{}:
+		dup := make([]interface{}, len(t))
+		for i, e := range t {
+			dup[i] = avroCopyDefault(e)
+		}
+		return dup
+	case map[string]interface{}:
+		dup := make(map[string]interface{}, len(t))
+		for k, e := range t {
+			dup[k] = avroCopyDefault(e)
+		}
+		return dup
+	default:
+		return v
+	}
+}
+
+func avroTypeName(s *avroSchema) string {
+	if s.isNamed() {
+		return fmt.Sprintf("%s %q", s.kind, s.name)
+	}
+	return s.kind.String()
+}
+
+////////////
+// ENCODE //
+////////////
+
+// avroEncode appends v encoded with the schema s to b. The value can either
+// be of the generic types that decoding returns, or Go types: structs encode
+// as records (using "avro" struct tags for field names), and pointers and
+// interfaces are followed.
+func avroEncode(b []byte, s *avroSchema, v interface{}) ([]byte, error) {
+	return avroEncodeValue(b, s, reflect.ValueOf(v))
+}
+
+func avroIndirect(v reflect.Value) reflect.Value {
+	for v.IsValid() && (v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface) {
+		if v.IsNil() {
+			return reflect.Value{}
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+func avroEncodeValue(b []byte, s *avroSchema, v reflect.Value) ([]byte, error) {
+	v = avroIndirect(v)
+
+	if s.kind == avroUnion {
+		idx := avroUnionBranch(s, v)
+		if idx < 0 {
+			return nil, fmt.Errorf("avro: value of type %s does not match any union branch", avroGoType(v))
+		}
+		b = appendVarint(b, int64(idx))
+		return avroEncodeValue(b, s.branches[idx], v)
+	}
+
+	if !v.IsValid() {
+		if s.kind == avroNull {
+			return b, nil
+		}
+		return nil, fmt.Errorf("avro: cannot encode nil as %s", avroTypeName(s))
+	}
+
+	mismatch := func() ([]byte, error) {
+		return nil, fmt.Errorf("avro: cannot encode %s as %s", v.Type(), avroTypeName(s))
+	}
+
+	switch s.kind {
+	case avroNull:
+		return mismatch()
+
+	case avroBoolean:
+		if v.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		if v.Bool() {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+
+	case avroInt, avroLong:
+		var l int64
+		switch v.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			l = v.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			u := v.Uint()
+			if u > math.MaxInt64 {
+				return nil, fmt.Errorf("avro: %d overflows %s", u, s.kind)
+			}
+			l = int64(u)
+		default:
+			return mismatch()
+		}
+		if s.kind == avroInt && (l < math.MinInt32 || l > math.MaxInt32) {
+			return nil, fmt.Errorf("avro: %d overflows int", l)
+		}
+		return appendVarint(b, l), nil
+
+	case avroFloat, avroDouble:
+		var f float64
+		switch v.Kind() {
+		case reflect.Float32, reflect.Float64:
+			f = v.Float()
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			f = float64(v.Int())
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			f = float64(v.Uint())
+		default:
+			return mismatch()
+		}
+		if s.kind == avroFloat {
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(f)))
+			return append(b, buf[:]...), nil
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		return append(b, buf[:]...), nil
+
+	case avroBytes, avroString:
+		switch {
+		case v.Kind() == reflect.String:
+			str := v.String()
+			b = appendVarint(b, int64(len(str)))
+			return append(b, str...), nil
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			raw := v.Bytes()
+			b = appendVarint(b, int64(len(raw)))
+			return append(b, raw...), nil
+		default:
+			return mismatch()
+		}
+
+	case avroFixed:
+		switch {
+		case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+			raw := v.Bytes()
+			if len(raw) != s.size {
+				return nil, fmt.Errorf("avro: fixed %q requires %d bytes, got %d", s.name, s.size, len(raw))
+			}
+			return append(b, raw...), nil
+		case v.Kind() == reflect.Array && v.Type().Elem().Kind() == reflect.Uint8:
+			if v.Len() != s.size {
+				return nil, fmt.Errorf("avro: fixed %q requires %d bytes, got %d", s.name, s.size, v.Len())
+			}
+			for i := 0; i < v.Len(); i++ {
+				b = append(b, byte(v.Index(i).Uint()))
+			}
+			return b, nil
+		default:
+			return mismatch()
+		}
+
+	case avroEnum:
+		switch v.Kind() {
+		case reflect.String:
+			sym := v.String()
+			for i, ssym := range s.symbols {
+				if ssym == sym {
+					return appendVarint(b, int64(i)), nil
+				}
+			}
+			return nil, fmt.Errorf("avro: %q is not a symbol of enum %q", sym, s.name)
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			idx := v.Int()
+			if idx < 0 || idx >= int64(len(s.symbols)) {
+				return nil, fmt.Errorf("avro: enum %q index %d out of range", s.name, idx)
+			}
+			return appendVarint(b, idx), nil
+		default:
+			return mismatch()
+		}
+
+	case avroArray:
+		if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+			return mismatch()
+		}
+		if n := v.Len(); n > 0 {
+			b = appendVarint(b, int64(n))
+			for i := 0; i < n; i++ {
+				var err error
+				if b, err = avroEncodeValue(b, s.items, v.Index(i)); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(b, 0), nil
+
+	case avroMap:
+		if v.Kind() != reflect.Map || v.Type().Key().Kind() != reflect.String {
+			return mismatch()
+		}
+		if n := v.Len(); n > 0 {
+			b = appendVarint(b, int64(n))
+			iter := v.MapRange()
+			for iter.Next() {
+				k := iter.Key().String()
+				b = appendVarint(b, int64(len(k)))
+				b = append(b, k...)
+				var err error
+				if b, err = avroEncodeValue(b, s.values, iter.Value()); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(b, 0), nil
+
+	case avroRecord:
+		return avroEncodeRecord(b, s, v)
+
+	default:
+		return nil, fmt.Errorf("avro: unknown kind %d", s.kind)
+	}
+}
+
+func avroEncodeRecord(b []byte, s *avroSchema, v reflect.Value) ([]byte, error) {
+	switch {
+	case v.Kind() == reflect.Map && v.Type().Key().Kind() == reflect.String:
+		for _, f := range s.fields {
+			fv := v.MapIndex(reflect.ValueOf(f.name).Convert(v.Type().Key()))
+			var err error
+			switch {
+			case fv.IsValid():
+				b, err = avroEncodeValue(b, f.typ, fv)
+			case f.hasDef:
+				b, err = avroEncode(b, f.typ, f.def)
+			default:
+				err = errors.New("missing value and the field has no default")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("avro: record %q field %q: %w", s.name, f.name, err)
+			}
+		}
+		return b, nil
+
+	case v.Kind() == reflect.Struct:
+		fields := avroStructFields(v.Type())
+		for _, f := range s.fields {
+			idx, ok := fields.lookup(f.name)
+			var err error
+			switch {
+			case ok:
+				b, err = avroEncodeValue(b, f.typ, v.FieldByIndex(idx))
+			case f.hasDef:
+				b, err = avroEncode(b, f.typ, f.def)
+			default:
+				err = fmt.Errorf("struct %s has no corresponding field and the field has no default", v.Type())
+			}
+			if err != nil {
+				return nil, fmt.Errorf("avro: record %q field %q: %w", s.name, f.name, err)
+			}
+		}
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("avro: cannot encode %s as %s", v.Type(), avroTypeName(s))
+	}
+}
+
+// avroUnionBranch returns the index of the first union branch that can
+// encode v, or -1.
+func avroUnionBranch(s *avroSchema, v reflect.Value) int {
+	if !v.IsValid() {
+		for i, b := range s.branches {
+			if b.kind == avroNull {
+				return i
+			}
+		}
+		return -1
+	}
+	// We first look for the most natural match, and then fall back to
+	// any branch that can encode the value.
+	for i, b := range s.branches {
+		if avroNaturalKind(v) == b.kind {
+			return i
+		}
+	}
+	for i, b := range s.branches {
+		if b.kind == avroUnion || b.kind == avroNull {
+			continue
+		}
+		if _, err := avroEncodeValue(nil, b, v); err == nil {
+			return i
+		}
+	}
+	return -1
+}
+
+// avroNaturalKind returns the Avro kind that the Go value most naturally
+// corresponds to, which is the kind that decoding produces.
+func avroNaturalKind(v reflect.Value) avroKind {
+	switch v.Kind() {
+	case reflect.Bool:
+		return avroBoolean
+	case reflect.Int32, reflect.Int16, reflect.Int8, reflect.Uint16, reflect.Uint8:
+		return avroInt
+	case reflect.Int, reflect.Int64, reflect.Uint32, reflect.Uint, reflect.Uint64:
+		return avroLong
+	case reflect.Float32:
+		return avroFloat
+	case reflect.Float64:
+		return avroDouble
+	case reflect.String:
+		return avroString
+	case reflect.Slice:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return avroBytes
+		}
+		return avroArray
+	case reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return avroFixed
+		}
+		return avroArray
+	case reflect.Map:
+		return avroMap
+	case reflect.Struct:
+		return avroRecord
+	default:
+		return avroNull
+	}
+}
+
+func avroGoType(v reflect.Value) string {
+	if !v.IsValid() {
+		return "nil"
+	}
+	return v.Type().String()
+}
+
+/////////////////
+// GO MAPPINGS //
+/////////////////
+
+type avroFieldIndex map[string][]int
+
+func (f avroFieldIndex) lookup(name string) ([]int, bool) {
+	if idx, ok := f[name]; ok {
+		return idx, true
+	}
+	idx, ok := f[strings.ToLower(name)]
+	return idx, ok
+}
+
+// avroStructFields maps avro field names to struct field indices. A field's
+// name is its "avro" struct tag if present, otherwise the field name. Fields
+// are also indexed by their lowercased name for case insensitive matching, and
+// fields tagged "-" are skipped.
+func avroStructFields(t reflect.Type) avroFieldIndex {
+	fields := make(avroFieldIndex)
+	var exact []string
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.PkgPath != "" { // unexported
+			continue
+		}
+		name := sf.Name
+		if tag, ok := sf.Tag.Lookup("avro"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields[name] = sf.Index
+		exact = append(exact, name)
+	}
+	for _, name := range exact {
+		lower := strings.ToLower(name)
+		if _, exists := fields[lower]; !exists {
+			fields[lower] = fields[name]
+		}
+	}
+	return fields
+}
+
+// avroAssign assigns a generic decoded value into dst, converting types as
+// necessary.
+func avroAssign(dst reflect.Value, src interface{}) error {
+	if dst.Kind() == reflect.Interface && dst.NumMethod() == 0 {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+		} else {
+			dst.Set(reflect.ValueOf(src))
+		}
+		return nil
+	}
+	if dst.Kind() == reflect.Ptr {
+		if src == nil {
+			dst.Set(reflect.Zero(dst.Type()))
+			return nil
+		}
+		if dst.IsNil() {
+			dst.Set(reflect.New(dst.Type().Elem()))
+		}
+		return avroAssign(dst.Elem(), src)
+	}
+	if src == nil {
+		dst.Set(reflect.Zero(dst.Type()))
+		return nil
+	}
+
+	mismatch := func() error {
+		return fmt.Errorf("avro: cannot assign %T into %s", src, dst.Type())
+	}
+
+	switch s := src.(type) {
+	case bool:
+		if dst.Kind() != reflect.Bool {
+			return mismatch()
+		}
+		dst.SetBool(s)
+
+	case int32, int64:
+		n := reflect.ValueOf(s).Int()
+		switch dst.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if dst.OverflowInt(n) {
+				return fmt.Errorf("avro: %d overflows %s", n, dst.Type())
+			}
+			dst.SetInt(n)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			if n < 0 || dst.OverflowUint(uint64(n)) {
+				return fmt.Errorf("avro: %d overflows %s", n, dst.Type())
+			}
+			dst.SetUint(uint64(n))
+		case reflect.Float32, reflect.Float64:
+			dst.SetFloat(float64(n))
+		default:
+			return mismatch()
+		}
+
+	case float32, float64:
+		if dst.Kind() != reflect.Float32 && dst.Kind() != reflect.Float64 {
+			return mismatch()
+		}
+		dst.SetFloat(reflect.ValueOf(s).Float())
+
+	case string:
+		switch {
+		case dst.Kind() == reflect.String:
+			dst.SetString(s)
+		case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8:
+			dst.SetBytes([]byte(s))
+		default:
+			return mismatch()
+		}
+
+	case []byte:
+		switch {
+		case dst.Kind() == reflect.Slice && dst.Type().Elem().Kind() == reflect.Uint8:
+			dst.SetBytes(s)
+		case dst.Kind() == reflect.Array && dst.Type().Elem().Kind() == reflect.Uint8:
+			if dst.Len() != len(s) {
+				return fmt.Errorf("avro: cannot assign %d bytes into %s", len(s), dst.Type())
+			}
+			reflect.Copy(dst, reflect.ValueOf(s))
+		case dst.Kind() == reflect.String:
+			dst.SetString(string(s))
+		default:
+			return mismatch()
+		}
+
+	case []interface{}:
+		switch dst.Kind() {
+		case reflect.Slice:
+			dst.Set(reflect.MakeSlice(dst.Type(), len(s), len(s)))
+		case reflect.Array:
+			if dst.Len() != len(s) {
+				return fmt.Errorf("avro: cannot assign %d elements into %s", len(s), dst.Type())
+			}
+		default:
+			return mismatch()
+		}
+		for i, e := range s {
+			if err := avroAssign(dst.Index(i), e); err != nil {
+				return err
+			}
+		}
+
+	case map[string]interface{}:
+		switch dst.Kind() {
+		case reflect.Map:
+			if dst.Type().Key().Kind() != reflect.String {
+				return mismatch()
+			}
+			m := reflect.MakeMapWithSize(dst.Type(), len(s))
+			for k, e := range s {
+				ev := reflect.New(dst.Type().Elem()).Elem()
+				if err := avroAssign(ev, e); err != nil {
+					return err
+				}
+				m.SetMapIndex(reflect.ValueOf(k).Convert(dst.Type().Key()), ev)
+			}
+			dst.Set(m)
+		case reflect.Struct:
+			fields := avroStructFields(dst.Type())
+			for k, e := range s {
+				idx, ok := fields.lookup(k)
+				if !ok {
+					continue
+				}
+				if err := avroAssign(dst.FieldByIndex(idx), e); err != nil {
+					return fmt.Errorf("field %q: %w", k, err)
+				}
+			}
+		default:
+			return mismatch()
+		}
+
+	default:
+		return mismatch()
+	}
+	return nil
+}
+
+//////////
+// JSON //
+//////////
+
+// The functions below convert between the Avro binary encoding and the Avro
+// JSON encoding, which is described in the spec. Bytes and fixed values are
+// strings whose code points are the byte values, and non-null union values
+// are wrapped in an object whose single key is the name of the branch type.
+// Floats that have no JSON representation are written as the strings "NaN",
+// "Infinity", and "-Infinity".
+
+// avroBranchName returns the name of a union branch in the JSON encoding.
+func avroBranchName(s *avroSchema) string {
+	if s.isNamed() {
+		return s.name
+	}
+	return s.kind.String()
+}
+
+func avroAppendJSONString(dst []byte, s string) []byte {
+	b, _ := json.Marshal(s)
+	return append(dst, b...)
+}
+
+func avroAppendJSONFloat(dst []byte, f float64, bits int) []byte {
+	switch {
+	case math.IsNaN(f):
+		return append(dst, `"NaN"`...)
+	case math.IsInf(f, 1):
+		return append(dst, `"Infinity"`...)
+	case math.IsInf(f, -1):
+		return append(dst, `"-Infinity"`...)
+	}
+	return strconv.AppendFloat(dst, f, 'g', -1, bits)
+}
+
+func avroAppendJSONBytes(dst, b []byte) []byte {
+	rs := make([]rune, len(b))
+	for i, c := range b {
+		rs[i] = rune(c)
+	}
+	return avroAppendJSONString(dst, string(rs))
+}
+
+// avroAppendJSON decodes a value written with s and appends it to dst in the
+// Avro JSON encoding.
+func avroAppendJSON(dst []byte, rd *avroReader, s *avroSchema) ([]byte, error) {
+	switch s.kind {
+	case avroNull:
+		return append(dst, "null"...), nil
+
+	case avroBoolean:
+		b, err := rd.fixed(1)
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendBool(dst, b[0] != 0), nil
+
+	case avroInt, avroLong:
+		l, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		return strconv.AppendInt(dst, l, 10), nil
+
+	case avroFloat:
+		b, err := rd.fixed(4)
+		if err != nil {
+			return nil, err
+		}
+		return avroAppendJSONFloat(dst, float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 32), nil
+
+	case avroDouble:
+		b, err := rd.fixed(8)
+		if err != nil {
+			return nil, err
+		}
+		return avroAppendJSONFloat(dst, math.Float64frombits(binary.LittleEndian.Uint64(b)), 64), nil
+
+	case avroString:
+		b, err := rd.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return avroAppendJSONString(dst, string(b)), nil
+
+	case avroBytes:
+		b, err := rd.bytes()
+		if err != nil {
+			return nil, err
+		}
+		return avroAppendJSONBytes(dst, b), nil
+
+	case avroFixed:
+		b, err := rd.fixed(s.size)
+		if err != nil {
+			return nil, err
+		}
+		return avroAppendJSONBytes(dst, b), nil
+
+	case avroEnum:
+		idx, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.symbols)) {
+			return nil, fmt.Errorf("avro: enum %q index %d out of range", s.name, idx)
+		}
+		return avroAppendJSONString(dst, s.symbols[idx]), nil
+
+	case avroArray, avroMap:
+		open, close := byte('['), byte(']')
+		if s.kind == avroMap {
+			open, close = '{', '}'
+		}
+		dst = append(dst, open)
+		var (
+			first     = true
+			total     int64
+			zeroWidth = s.kind == avroArray && avroZeroWidth(s.items, nil)
+		)
+		for {
+			n, err := rd.blockCount(&total, zeroWidth)
+			if err != nil {
+				return nil, err
+			}
+			if n == 0 {
+				break
+			}
+			for ; n > 0; n-- {
+				if !first {
+					dst = append(dst, ',')
+				}
+				first = false
+				if s.kind == avroArray {
+					if dst, err = avroAppendJSON(dst, rd, s.items); err != nil {
+						return nil, err
+					}
+					continue
+				}
+				k, err := rd.bytes()
+				if err != nil {
+					return nil, err
+				}
+				dst = append(avroAppendJSONString(dst, string(k)), ':')
+				if dst, err = avroAppendJSON(dst, rd, s.values); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(dst, close), nil
+
+	case avroRecord:
+		dst = append(dst, '{')
+		for i, f := range s.fields {
+			if i > 0 {
+				dst = append(dst, ',')
+			}
+			dst = append(avroAppendJSONString(dst, f.name), ':')
+			var err error
+			if dst, err = avroAppendJSON(dst, rd, f.typ); err != nil {
+				return nil, err
+			}
+		}
+		return append(dst, '}'), nil
+
+	case avroUnion:
+		idx, err := rd.long()
+		if err != nil {
+			return nil, err
+		}
+		if idx < 0 || idx >= int64(len(s.branches)) {
+			return nil, fmt.Errorf("avro: union index %d out of range", idx)
+		}
+		b := s.branches[idx]
+		if b.kind == avroNull {
+			return append(dst, "null"...), nil
+		}
+		dst = append(avroAppendJSONString(append(dst, '{'), avroBranchName(b)), ':')
+		if dst, err = avroAppendJSON(dst, rd, b); err != nil {
+			return nil, err
+		}
+		return append(dst, '}'), nil
+
+	default:
+		return nil, fmt.Errorf("avro: unknown kind %d", s.kind)
+	}
+}
+
+// avroEncodeJSON appends the binary encoding of v, which is a value in the
+// Avro JSON encoding decoded with decodeJSON, to b.
+func avroEncodeJSON(b []byte, s *avroSchema, v interface{}) ([]byte, error) {
+	mismatch := func() ([]byte, error) {
+		return nil, fmt.Errorf("avro: cannot encode JSON %s as %s", jsonTypeOf(v), avroTypeName(s))
+	}
+	switch s.kind {
+	case avroNull:
+		if v != nil {
+			return mismatch()
+		}
+		return b, nil
+
+	case avroBoolean:
+		bl, ok := v.(bool)
+		if !ok {
+			return mismatch()
+		}
+		if bl {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+
+	case avroInt, avroLong:
+		n, err := avroJSONInt(v)
+		if err != nil {
+			return mismatch()
+		}
+		if s.kind == avroInt && (n < math.MinInt32 || n > math.MaxInt32) {
+			return nil, fmt.Errorf("avro: %d overflows int", n)
+		}
+		return appendVarint(b, n), nil
+
+	case avroFloat, avroDouble:
+		var f float64
+		switch t := v.(type) {
+		case json.Number:
+			var err error
+			if f, err = t.Float64(); err != nil {
+				return nil, err
+			}
+		case string:
+			switch t {
+			case "NaN":
+				f = math.NaN()
+			case "Infinity":
+				f = math.Inf(1)
+			case "-Infinity":
+				f = math.Inf(-1)
+			default:
+				return mismatch()
+			}
+		default:
+			return mismatch()
+		}
+		if s.kind == avroFloat {
+			var buf [4]byte
+			binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(f)))
+			return append(b, buf[:]...), nil
+		}
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		return append(b, buf[:]...), nil
+
+	case avroString:
+		str, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		b = appendVarint(b, int64(len(str)))
+		return append(b, str...), nil
+
+	case avroBytes, avroFixed:
+		raw, err := avroDefault(s, v) // bytes defaults use the JSON encoding
+		if err != nil {
+			return nil, fmt.Errorf("avro: %v", err)
+		}
+		if s.kind == avroBytes {
+			b = appendVarint(b, int64(len(raw.([]byte))))
+		}
+		return append(b, raw.([]byte)...), nil
+
+	case avroEnum:
+		sym, ok := v.(string)
+		if !ok {
+			return mismatch()
+		}
+		for i, ssym := range s.symbols {
+			if ssym == sym {
+				return appendVarint(b, int64(i)), nil
+			}
+		}
+		return nil, fmt.Errorf("avro: %q is not a symbol of enum %q", sym, s.name)
+
+	case avroArray:
+		arr, ok := v.([]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if len(arr) > 0 {
+			b = appendVarint(b, int64(len(arr)))
+			for _, e := range arr {
+				var err error
+				if b, err = avroEncodeJSON(b, s.items, e); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(b, 0), nil
+
+	case avroMap:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		if len(m) > 0 {
+			b = appendVarint(b, int64(len(m)))
+			for k, e := range m {
+				b = appendVarint(b, int64(len(k)))
+				b = append(b, k...)
+				var err error
+				if b, err = avroEncodeJSON(b, s.values, e); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(b, 0), nil
+
+	case avroRecord:
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return mismatch()
+		}
+		for _, f := range s.fields {
+			fv, ok := m[f.name]
+			var err error
+			switch {
+			case ok:
+				b, err = avroEncodeJSON(b, f.typ, fv)
+			case f.hasDef:
+				b, err = avroEncode(b, f.typ, f.def)
+			default:
+				err = errors.New("missing value and the field has no default")
+			}
+			if err != nil {
+				return nil, fmt.Errorf("avro: record %q field %q: %w", s.name, f.name, err)
+			}
+		}
+		return b, nil
+
+	case avroUnion:
+		if v == nil {
+			for i, br := range s.branches {
+				if br.kind == avroNull {
+					return appendVarint(b, int64(i)), nil
+				}
+			}
+			return mismatch()
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok || len(m) != 1 {
+			return nil, fmt.Errorf("avro: union value must be null or an object with a single branch name key, got JSON %s", jsonTypeOf(v))
+		}
+		for name, bv := range m {
+			for i, br := range s.branches {
+				if avroBranchName(br) == name {
+					return avroEncodeJSON(appendVarint(b, int64(i)), br, bv)
+				}
+			}
+			return nil, fmt.Errorf("avro: union has no branch %q", name)
+		}
+		return b, nil
+
+	default:
+		return nil, fmt.Errorf("avro: unknown kind %d", s.kind)
+	}
+}
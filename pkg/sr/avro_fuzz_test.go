@@ -0,0 +1,52 @@
+//go:build go1.18
+// +build go1.18
+
+package sr
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+// FuzzAvroDecode ensures that decoding malformed input, both directly and
+// through schema resolution, returns errors rather than panicking or
+// allocating without bound.
+func FuzzAvroDecode(f *testing.F) {
+	type pair struct{ w, r *avroSchema }
+	var pairs []pair
+	for _, p := range []struct{ w, r string }{
+		{testAvroUser, testAvroUser},
+		{`{"type":"record","name":"R","fields":[{"name":"r","type":["null","R"]},{"name":"s","type":{"type":"array","items":"R"}}]}`, ""},
+		{`{"type":"array","items":{"type":"map","values":["null","bytes","double"]}}`, ""},
+		{`{"type":"array","items":"null"}`, ""},
+		{`{"type":"map","values":{"type":"fixed","name":"F","size":0}}`, ""},
+		{`["int","string",{"type":"enum","name":"E","symbols":["A","B"]}]`, `["long","bytes",{"type":"enum","name":"E","symbols":["B"],"default":"B"}]`},
+		{`{"type":"record","name":"R","fields":[{"name":"a","type":"int"},{"name":"b","type":"float"}]}`, `{"type":"record","name":"R","fields":[{"name":"b","type":"double"},{"name":"c","type":"string","default":"x"}]}`},
+	} {
+		w, err := parseAvroSchema(p.w, make(avroNames))
+		if err != nil {
+			f.Fatalf("%s: %v", p.w, err)
+		}
+		r := w
+		if p.r != "" {
+			if r, err = parseAvroSchema(p.r, make(avroNames)); err != nil {
+				f.Fatalf("%s: %v", p.r, err)
+			}
+		}
+		pairs = append(pairs, pair{w, r})
+	}
+
+	var buf [binary.MaxVarintLen64]byte
+	f.Add(uint8(0), []byte{})
+	f.Add(uint8(1), []byte{0, 1, 2, 0, 0})
+	f.Add(uint8(2), buf[:binary.PutVarint(buf[:], -avroMaxItems)])
+	f.Add(uint8(3), buf[:binary.PutVarint(buf[:], avroMaxItems)])
+	f.Add(uint8(5), []byte{2, 6, 'a', 'b', 'c'})
+	f.Add(uint8(6), []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x01})
+
+	f.Fuzz(func(t *testing.T, which uint8, in []byte) {
+		p := pairs[int(which)%len(pairs)]
+		avroDecode(&avroReader{in}, p.w, p.r)
+		avroAppendJSON(nil, &avroReader{in}, p.w)
+	})
+}
@@ -0,0 +1,299 @@
+package sr
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const testAvroUser = `{
+	"type": "record",
+	"name": "User",
+	"namespace": "test",
+	"fields": [
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "email", "type": ["null", "string"], "default": null},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attrs", "type": {"type": "map", "values": "long"}},
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}}
+	]
+}`
+
+type testUser struct {
+	Name  string
+	Age   int
+	Email *string
+	Tags  []string
+	Attrs map[string]int64
+	Kind  string
+	Hash  [2]byte
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	schema, err := parseAvroSchema(testAvroUser, nil)
+	if err != nil {
+		t.Fatalf("unable to parse: %v", err)
+	}
+
+	email := "foo@example.com"
+	for _, in := range []testUser{
+		{Name: "foo", Age: 30, Email: &email, Tags: []string{"a", "b"}, Attrs: map[string]int64{"x": -1}, Kind: "B", Hash: [2]byte{1, 2}},
+		{Name: "", Age: -5, Tags: []string{}, Attrs: map[string]int64{}, Kind: "A"},
+	} {
+		b, err := avroEncode(nil, schema, in)
+		if err != nil {
+			t.Fatalf("unable to encode: %v", err)
+		}
+		decoded, err := avroDecode(&avroReader{b}, schema, nil)
+		if err != nil {
+			t.Fatalf("unable to decode: %v", err)
+		}
+		var out testUser
+		if err := avroAssign(reflect.ValueOf(&out).Elem(), decoded); err != nil {
+			t.Fatalf("unable to assign: %v", err)
+		}
+		if !reflect.DeepEqual(in, out) {
+			t.Errorf("round trip mismatch:\nin:  %#v\nout: %#v", in, out)
+		}
+
+		// Re-encoding the generic value should produce the same bytes.
+		b2, err := avroEncode(nil, schema, decoded)
+		if err != nil {
+			t.Fatalf("unable to encode generic: %v", err)
+		}
+		if string(b) != string(b2) {
+			t.Errorf("generic encoding mismatch: %x != %x", b, b2)
+		}
+	}
+}
+
+func TestAvroResolution(t *testing.T) {
+	writer, err := parseAvroSchema(`{"type":"record","name":"R","fields":[
+		{"name":"a","type":"int"},
+		{"name":"dropped","type":"string"},
+		{"name":"e","type":{"type":"enum","name":"E","symbols":["X","Y","Z"]}},
+		{"name":"u","type":["null","int"]}
+	]}`, nil)
+	if err != nil {
+		t.Fatalf("unable to parse writer: %v", err)
+	}
+	reader, err := parseAvroSchema(`{"type":"record","name":"R","fields":[
+		{"name":"renamed","aliases":["a"],"type":"double"},
+		{"name":"added","type":"string","default":"def"},
+		{"name":"e","type":{"type":"enum","name":"E","symbols":["X","Y"],"default":"X"}},
+		{"name":"u","type":["null","long"]}
+	]}`, nil)
+	if err != nil {
+		t.Fatalf("unable to parse reader: %v", err)
+	}
+
+	b, err := avroEncode(nil, writer, map[string]interface{}{
+		"a":       int32(3),
+		"dropped": "gone",
+		"e":       "Z",
+		"u":       int32(7),
+	})
+	if err != nil {
+		t.Fatalf("unable to encode: %v", err)
+	}
+	got, err := avroDecode(&avroReader{b}, writer, reader)
+	if err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+	exp := map[string]interface{}{
+		"renamed": float64(3),
+		"added":   "def",
+		"e":       "X",
+		"u":       int64(7),
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Errorf("got %#v != exp %#v", got, exp)
+	}
+
+	// A reader field with no default that the writer does not have is an
+	// error.
+	strict, err := parseAvroSchema(`{"type":"record","name":"R","fields":[{"name":"missing","type":"int"}]}`, nil)
+	if err != nil {
+		t.Fatalf("unable to parse strict reader: %v", err)
+	}
+	if _, err := avroDecode(&avroReader{b}, writer, strict); err == nil {
+		t.Error("expected error decoding into reader missing a default")
+	}
+}
+
+func TestAvroSerde(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			atomic.AddInt32(&fetches, 1)
+			json.NewEncoder(w).Encode(Schema{
+				Schema:     `{"type":"record","name":"Outer","fields":[{"name":"in","type":"test.Inner"}]}`,
+				References: []SchemaReference{{Name: "test.Inner", Subject: "inner", Version: 1}},
+			})
+		case "/subjects/inner/versions/1":
+			json.NewEncoder(w).Encode(SubjectSchema{
+				Subject: "inner",
+				Version: 1,
+				ID:      2,
+				Schema:  Schema{Schema: `{"type":"record","name":"Inner","namespace":"test","fields":[{"name":"v","type":"long"}]}`},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ResponseError{ErrorCode: 40403, Message: "not found"})
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(URLs(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serde := NewAvroSerde(cl)
+	ctx := context.Background()
+
+	type inner struct {
+		V int64 `avro:"v"`
+	}
+	type outer struct {
+		In inner `avro:"in"`
+	}
+
+	b, err := serde.Encode(ctx, 1, outer{inner{-42}})
+	if err != nil {
+		t.Fatalf("unable to encode: %v", err)
+	}
+	var out outer
+	if err := serde.Decode(ctx, b, &out); err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+	if out.In.V != -42 {
+		t.Errorf("got %d != exp -42", out.In.V)
+	}
+	var generic interface{}
+	if err := serde.Decode(ctx, b, &generic); err != nil {
+		t.Fatalf("unable to decode generic: %v", err)
+	}
+	if exp := map[string]interface{}{"in": map[string]interface{}{"v": int64(-42)}}; !reflect.DeepEqual(generic, exp) {
+		t.Errorf("got %#v != exp %#v", generic, exp)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("schema fetched %d times, expected once", n)
+	}
+
+	if err := serde.Decode(ctx, []byte{0, 0, 0, 0, 9}, &out); err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected not found error for unknown ID, got %v", err)
+	}
+}
+
+func TestAvroJSON(t *testing.T) {
+	schema, err := parseAvroSchema(`{
+		"type": "record",
+		"name": "R",
+		"namespace": "test",
+		"fields": [
+			{"name": "b", "type": "bytes"},
+			{"name": "f", "type": {"type": "fixed", "name": "F", "size": 2}},
+			{"name": "e", "type": {"type": "enum", "name": "E", "symbols": ["X", "Y"]}},
+			{"name": "u", "type": ["null", "string", "test.E"]},
+			{"name": "n", "type": ["null", "long"]},
+			{"name": "a", "type": {"type": "array", "items": "double"}},
+			{"name": "m", "type": {"type": "map", "values": "int"}},
+			{"name": "d", "type": "string", "default": "def"}
+		]
+	}`, make(avroNames))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in := `{"b":"\u0000ÿ","f":"ab","e":"Y","u":{"test.E":"X"},"n":null,"a":[1.5,"NaN"],"m":{"k":3}}`
+	v, err := decodeJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := avroEncodeJSON(nil, schema, v)
+	if err != nil {
+		t.Fatalf("unable to encode: %v", err)
+	}
+	out, err := avroAppendJSON(nil, &avroReader{b}, schema)
+	if err != nil {
+		t.Fatalf("unable to decode: %v", err)
+	}
+	exp := `{"b":"\u0000ÿ","f":"ab","e":"Y","u":{"test.E":"X"},"n":null,"a":[1.5,"NaN"],"m":{"k":3},"d":"def"}`
+	if string(out) != exp {
+		t.Errorf("got %s\nexp %s", out, exp)
+	}
+
+	for _, bad := range []string{
+		`{"b":"","f":"abc","e":"X","u":null,"n":null,"a":[],"m":{}}`,       // fixed size
+		`{"b":"","f":"ab","e":"Z","u":null,"n":null,"a":[],"m":{}}`,        // enum symbol
+		`{"b":"","f":"ab","e":"X","u":"str","n":null,"a":[],"m":{}}`,       // unwrapped union
+		`{"b":"","f":"ab","e":"X","u":{"int":1},"n":null,"a":[],"m":{}}`,   // unknown branch
+		`{"b":"","f":"ab","e":"X","u":null,"n":null,"a":[],"m":{"k":1.5}}`, // int
+	} {
+		v, _ := decodeJSON([]byte(bad))
+		if _, err := avroEncodeJSON(nil, schema, v); err == nil {
+			t.Errorf("expected error encoding %s", bad)
+		}
+	}
+}
+
+func TestAvroBlockCounts(t *testing.T) {
+	longs := func(ns ...int64) []byte {
+		var b []byte
+		for _, n := range ns {
+			var buf [binary.MaxVarintLen64]byte
+			b = append(b, buf[:binary.PutVarint(buf[:], n)]...)
+		}
+		return b
+	}
+
+	// Item counts are capped per array or map, not per block.
+	rd := &avroReader{longs(avroMaxItems/2, -avroMaxItems/2, 2, 1)}
+	var total int64
+	for i, exp := range []bool{true, true, false} {
+		_, err := rd.blockCount(&total, true)
+		if (err == nil) != exp {
+			t.Errorf("block %d: got err %v, exp ok? %v", i, err, exp)
+		}
+	}
+
+	// Items that are not zero width are bounded by the remaining input.
+	for _, test := range []struct {
+		schema string
+		in     []byte
+		ok     bool
+	}{
+		{`{"type":"array","items":"null"}`, longs(3, 0), true},
+		{`{"type":"array","items":{"type":"record","name":"R","fields":[{"name":"f","type":{"type":"fixed","name":"F","size":0}}]}}`, longs(3, 0), true},
+		{`{"type":"array","items":"long"}`, longs(1000, 0), false},
+		{`{"type":"array","items":{"type":"record","name":"R","fields":[{"name":"n","type":"null"},{"name":"i","type":"int"}]}}`, longs(1000, 0), false},
+		{`{"type":"map","values":"null"}`, longs(1000, 0), false},
+	} {
+		schema, err := parseAvroSchema(test.schema, make(avroNames))
+		if err != nil {
+			t.Fatalf("%s: %v", test.schema, err)
+		}
+		_, decodeErr := avroDecode(&avroReader{test.in}, schema, schema)
+		_, jsonErr := avroAppendJSON(nil, &avroReader{test.in}, schema)
+		if (decodeErr == nil) != test.ok || (jsonErr == nil) != test.ok {
+			t.Errorf("%s: got decode err %v, json err %v, exp ok? %v", test.schema, decodeErr, jsonErr, test.ok)
+		}
+	}
+
+	recursive, err := parseAvroSchema(`{"type":"record","name":"R","fields":[{"name":"r","type":["null","R"]},{"name":"s","type":"R"}]}`, make(avroNames))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avroZeroWidth(recursive, nil) {
+		t.Error("recursive record is unexpectedly zero width")
+	}
+}
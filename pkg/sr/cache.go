@@ -0,0 +1,204 @@
+package sr
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// respCache caches successful response bodies and coalesces concurrent
+// identical requests. Responses for immutable lookups are kept until they are
+// evicted, while responses for lookups that can change (the latest version of a
+// subject, configs) are cached for a ttl. Entries are evicted least recently
+// used first once the cached bodies exceed maxBytes.
+type respCache struct {
+	ttl      time.Duration
+	maxBytes int64
+
+	mu      sync.Mutex
+	entries map[cacheKey]*list.Element // element values are *cacheEntry
+	lru     list.List                  // front is the most recently used
+	bytes   int64
+
+	flights flights
+}
+
+// cacheKey identifies a request. Request bodies can be entire schemas, so we
+// key on a hash of the body rather than the body itself.
+type cacheKey struct {
+	method string
+	path   string
+	body   [sha256.Size]byte
+}
+
+type cacheEntry struct {
+	key     cacheKey
+	body    []byte
+	expires time.Time // zero if the entry never expires
+}
+
+func (e *cacheEntry) size() int64 { return int64(len(e.key.path) + len(e.body)) }
+
+type cacheability uint8
+
+const (
+	cacheNone      cacheability = iota // not cached, but coalesced if a GET
+	cacheImmutable                     // cached forever
+	cacheExpiring                      // cached for the ttl
+)
+
+func newRespCache(ttl time.Duration, maxBytes int64) *respCache {
+	return &respCache{
+		ttl:      ttl,
+		maxBytes: maxBytes,
+		entries:  make(map[cacheKey]*list.Element),
+	}
+}
+
+// cacheabilityOf returns how the response for a request can be cached.
+//
+// Schema IDs are immutable, as are specific versions of a subject. Looking up
+// or creating a schema in a subject returns the same result for the same
+// schema, so we consider these immutable as well (deleting the subject drops
+// them from the cache). The latest version of a subject (which can also be
+// requested as version -1) and configs can change.
+func cacheabilityOf(method, path string) cacheability {
+	if idx := strings.IndexByte(path, '?'); idx >= 0 {
+		path = path[:idx]
+	}
+	parts := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	switch method {
+	case http.MethodGet:
+		switch {
+		case parts[0] == "schemas" && len(parts) >= 3 && parts[1] == "ids":
+			if len(parts) == 3 || len(parts) == 4 && parts[3] == "schema" {
+				return cacheImmutable
+			}
+		case parts[0] == "subjects" && len(parts) >= 4 && parts[2] == "versions":
+			if len(parts) > 5 || len(parts) == 5 && parts[4] != "schema" {
+				return cacheNone
+			}
+			if parts[3] == "latest" || parts[3] == "-1" {
+				return cacheExpiring
+			}
+			return cacheImmutable
+		case parts[0] == "config":
+			return cacheExpiring
+		}
+	case http.MethodPost:
+		if parts[0] == "subjects" && (len(parts) == 2 || len(parts) == 3 && parts[2] == "versions") {
+			return cacheImmutable
+		}
+	}
+	return cacheNone
+}
+
+// do returns the cached body for a request, or issues the request with fn,
+// sharing the request with any concurrent identical request.
+func (c *respCache) do(ctx context.Context, method, path string, reqBody []byte, fn func(context.Context) ([]byte, error)) ([]byte, error) {
+	kind := cacheabilityOf(method, path)
+	if kind == cacheNone && method != http.MethodGet {
+		body, err := fn(ctx)
+		if err == nil && (method == http.MethodPut || method == http.MethodDelete) {
+			c.invalidate(method, path)
+		}
+		return body, err
+	}
+
+	key := cacheKey{method: method, path: path, body: sha256.Sum256(reqBody)}
+	if body, ok := c.get(key); ok {
+		return body, nil
+	}
+	v, err := c.flights.do(ctx, key, func(ctx context.Context) (interface{}, error) {
+		// An identical request may have finished just before we
+		// started ours.
+		if body, ok := c.get(key); ok {
+			return body, nil
+		}
+		body, err := fn(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if method == http.MethodPost && strings.HasSuffix(strings.SplitN(path, "?", 2)[0], "/versions") {
+			c.invalidate(method, path) // creating a schema can change "latest"
+		}
+		switch {
+		case kind == cacheImmutable:
+			c.put(&cacheEntry{key: key, body: body})
+		case kind == cacheExpiring && c.ttl > 0:
+			c.put(&cacheEntry{key: key, body: body, expires: time.Now().Add(c.ttl)})
+		}
+		return body, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+// get returns the cached body for key, if it is cached and not expired.
+func (c *respCache) get(key cacheKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*cacheEntry)
+	if !e.expires.IsZero() && !time.Now().Before(e.expires) {
+		c.remove(el)
+		return nil, false
+	}
+	c.lru.MoveToFront(el)
+	return e.body, true
+}
+
+// put caches an entry, evicting the least recently used entries until the
+// cache fits within maxBytes. An entry larger than maxBytes is not cached.
+func (c *respCache) put(e *cacheEntry) {
+	if e.size() > c.maxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[e.key]; ok {
+		c.remove(el)
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	c.bytes += e.size()
+	for c.bytes > c.maxBytes {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *respCache) remove(el *list.Element) {
+	e := c.lru.Remove(el).(*cacheEntry)
+	delete(c.entries, e.key)
+	c.bytes -= e.size()
+}
+
+// invalidate drops cache entries that a successful write may have changed: all
+// expiring entries, and for deletes within a subject, every entry for that
+// subject.
+func (c *respCache) invalidate(method, path string) {
+	if idx := strings.IndexByte(path, '?'); idx >= 0 {
+		path = path[:idx]
+	}
+	var subject string
+	if parts := strings.Split(strings.TrimPrefix(path, "/"), "/"); method == http.MethodDelete && parts[0] == "subjects" && len(parts) >= 2 {
+		subject = "/subjects/" + parts[1]
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, el := range c.entries {
+		e := el.Value.(*cacheEntry)
+		if path := e.key.path; !e.expires.IsZero() || subject != "" && (path == subject || strings.HasPrefix(path, subject+"/") || strings.HasPrefix(path, subject+"?")) {
+			c.remove(el)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package sr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheability(t *testing.T) {
+	for _, test := range []struct {
+		method string
+		path   string
+		exp    cacheability
+	}{
+		{"GET", "/schemas/ids/3", cacheImmutable},
+		{"GET", "/schemas/ids/3/schema", cacheImmutable},
+		{"GET", "/schemas/ids/3/versions", cacheNone},
+		{"GET", "/subjects/foo/versions/2", cacheImmutable},
+		{"GET", "/subjects/foo/versions/2?deleted=true", cacheImmutable},
+		{"GET", "/subjects/foo/versions/latest", cacheExpiring},
+		{"GET", "/subjects/foo/versions/-1", cacheExpiring},
+		{"GET", "/subjects/foo/versions/-1/schema", cacheExpiring},
+		{"GET", "/subjects/foo/versions/2/referencedby", cacheNone},
+		{"GET", "/subjects/foo/versions", cacheNone},
+		{"GET", "/config/foo?defaultToGlobal=true", cacheExpiring},
+		{"GET", "/subjects", cacheNone},
+		{"POST", "/subjects/foo", cacheImmutable},
+		{"POST", "/subjects/foo/versions", cacheImmutable},
+		{"POST", "/compatibility/subjects/foo/versions/1", cacheNone},
+		{"PUT", "/config/foo", cacheNone},
+	} {
+		if got := cacheabilityOf(test.method, test.path); got != test.exp {
+			t.Errorf("%s %s: got %d != exp %d", test.method, test.path, got, test.exp)
+		}
+	}
+}
+
+func TestCacheResponses(t *testing.T) {
+	var (
+		hits    = make(map[string]int)
+		mu      sync.Mutex
+		release = make(chan struct{})
+		entered int32
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.Method+" "+r.URL.Path]++
+		mu.Unlock()
+		switch r.URL.Path {
+		case "/subjects":
+			atomic.AddInt32(&entered, 1)
+			<-release
+			json.NewEncoder(w).Encode([]string{"foo"})
+		case "/schemas/ids/1":
+			json.NewEncoder(w).Encode(Schema{Schema: `"int"`})
+		case "/subjects/foo/versions/latest":
+			json.NewEncoder(w).Encode(SubjectSchema{Subject: "foo", Version: 1, ID: 1})
+		case "/subjects/foo":
+			if r.Method == http.MethodDelete {
+				json.NewEncoder(w).Encode([]int{1})
+				return
+			}
+			json.NewEncoder(w).Encode(SubjectSchema{Subject: "foo", Version: 1, ID: 1})
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(URLs(srv.URL), CacheResponses(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// Concurrent identical requests share one http request, even if the
+	// response is not cached.
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if subjects, err := cl.Subjects(ctx, HideDeleted); err != nil || len(subjects) != 1 {
+				t.Errorf("got %v (err %v) != exp [foo]", subjects, err)
+			}
+		}()
+	}
+	for atomic.LoadInt32(&entered) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond) // give the other goroutines time to wait
+	close(release)
+	wg.Wait()
+
+	for i := 0; i < 3; i++ {
+		if _, err := cl.SchemaByID(ctx, 1); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cl.SchemaByVersion(ctx, "foo", -1, HideDeleted); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := cl.LookupSchema(ctx, "foo", Schema{Schema: `"int"`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := cl.DeleteSubject(ctx, "foo", SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.LookupSchema(ctx, "foo", Schema{Schema: `"int"`}); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for req, exp := range map[string]int{
+		"GET /subjects":                     1,
+		"GET /schemas/ids/1":                1,
+		"GET /subjects/foo/versions/latest": 1,
+		"POST /subjects/foo":                2, // once before and once after the delete
+		"DELETE /subjects/foo":              1,
+	} {
+		if hits[req] != exp {
+			t.Errorf("%s: got %d requests != exp %d", req, hits[req], exp)
+		}
+	}
+}
+
+func TestCacheResponsesEviction(t *testing.T) {
+	var (
+		hits = make(map[string]int)
+		mu   sync.Mutex
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		mu.Unlock()
+		json.NewEncoder(w).Encode(Schema{Schema: `"int"`})
+	}))
+	defer srv.Close()
+
+	// Each cached response is a bit over 30 bytes, so only two fit.
+	cl, err := NewClient(URLs(srv.URL), CacheResponses(time.Hour), CacheResponsesMaxBytes(80))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for _, id := range []int{1, 2, 1, 3, 1, 2} {
+		if _, err := cl.SchemaByID(ctx, id); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for path, exp := range map[string]int{
+		"/schemas/ids/1": 1, // kept because it was recently used
+		"/schemas/ids/2": 2, // evicted when 3 was cached
+		"/schemas/ids/3": 1,
+	} {
+		if hits[path] != exp {
+			t.Errorf("%s: got %d requests != exp %d", path, hits[path], exp)
+		}
+	}
+}
+
+func TestFlightsLeaderCanceled(t *testing.T) {
+	var (
+		fs      flights
+		calls   int32
+		entered = make(chan struct{})
+	)
+	fn := func(ctx context.Context) (interface{}, error) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			close(entered)
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return 1, nil
+	}
+
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		_, err := fs.do(leaderCtx, 0, fn)
+		done <- err
+	}()
+	<-entered
+
+	waited := make(chan interface{})
+	go func() {
+		v, err := fs.do(context.Background(), 0, fn)
+		if err != nil {
+			t.Errorf("waiter got err %v, exp the leader's cancelation to not be shared", err)
+		}
+		waited <- v
+	}()
+	time.Sleep(20 * time.Millisecond) // give the waiter time to wait
+	cancel()
+
+	if err := <-done; err != context.Canceled {
+		t.Errorf("leader got err %v != exp context.Canceled", err)
+	}
+	if v := <-waited; v != 1 {
+		t.Errorf("waiter got %v != exp 1", v)
+	}
+}
@@ -6,10 +6,21 @@
 // encoding/decoding, you must register IDs and values to how to encode or
 // decode them.
 //
-// The client does not automatically cache schemas, instead, the Serde type is
+// By default, the client does not cache schemas, instead, the Serde type is
 // used for the actual caching of IDs to how to encode/decode the IDs. The
 // Client type itself simply speaks http to your schema registry and returns
-// the results.
+// the results. The CacheResponses option enables caching of immutable lookups
+// and coalescing of concurrent identical requests.
+//
+// For Avro, the AvroSerde type does provide schema auto-discovery: unknown IDs
+// are fetched through a Client, parsed, and cached. The JSONSerde type does the
+// same for JSON schemas, validating values against their schema, and the srproto
+// package provides the same for protobuf. srproto is its own module so that
+// users of sr do not need to depend on google.golang.org/protobuf.
+//
+// CheckCompatibilityOffline implements the registry's compatibility rules
+// locally, which allows checking schema changes without a registry. For
+// tests, the srfake package provides an in-memory registry.
 //
 // To read more about the schema registry, see the following:
 //
@@ -25,6 +36,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"sync/atomic"
 	"time"
 )
@@ -54,7 +66,22 @@ type Client struct {
 		pass string
 	}
 
-	normalize bool
+	bearer  *bearerToken
+	headers func(context.Context, http.Header) error
+
+	normalize      bool
+	cacheResponses bool
+	cacheTTL       time.Duration
+	cacheMaxBytes  int64
+	cache          *respCache
+
+	retries       int
+	retryBackoff  func(int) time.Duration
+	timeout       time.Duration
+	breakFailures int
+	breakInterval time.Duration
+	health        *urlHealth
+	onRequest     []func(RequestOutcome)
 
 	serdes atomic.Value // map[reflect.Type]serde
 }
@@ -65,6 +92,13 @@ func NewClient(opts ...Opt) (*Client, error) {
 		urls:   []string{"http://localhost:8081"},
 		httpcl: &http.Client{Timeout: 5 * time.Second},
 		ua:     "franz-go",
+
+		cacheMaxBytes: 32 << 20,
+
+		retries:       0,
+		retryBackoff:  defaultRetryBackoff(),
+		breakFailures: 3,
+		breakInterval: 10 * time.Second,
 	}
 
 	for _, opt := range opts {
@@ -74,6 +108,10 @@ func NewClient(opts ...Opt) (*Client, error) {
 	if len(cl.urls) == 0 {
 		return nil, errors.New("unable to create client with no URLs")
 	}
+	cl.health = newURLHealth(len(cl.urls), cl.breakFailures, cl.breakInterval)
+	if cl.cacheResponses {
+		cl.cache = newRespCache(cl.cacheTTL, cl.cacheMaxBytes)
+	}
 
 	return cl, nil
 }
@@ -95,24 +133,155 @@ func (cl *Client) delete(ctx context.Context, path string, into interface{}) err
 }
 
 func (cl *Client) do(ctx context.Context, method, path string, v interface{}, into interface{}) error {
-	urls := cl.urls
+	var marshaled []byte
+	if v != nil {
+		var err error
+		if marshaled, err = json.Marshal(v); err != nil {
+			return fmt.Errorf("unable to encode body for %s %q: %w", method, path, err)
+		}
+	}
 
-start:
-	url := fmt.Sprintf("%s%s", urls[0], path)
-	urls = urls[1:]
+	send := func(ctx context.Context) ([]byte, error) { return cl.send(ctx, method, path, marshaled) }
+	var (
+		body []byte
+		err  error
+	)
+	if cl.cache != nil {
+		body, err = cl.cache.do(ctx, method, path, marshaled, send)
+	} else {
+		body, err = send(ctx)
+	}
+	if err != nil {
+		return err
+	}
 
-	var reqBody io.Reader
-	if v != nil {
-		marshaled, err := json.Marshal(v)
-		if err != nil {
-			return fmt.Errorf("unable to encode body for %s %q: %w", method, url, err)
+	if into != nil {
+		if err := json.Unmarshal(body, into); err != nil {
+			return fmt.Errorf("unable to decode ok response body from %s %q: %w", method, path, err)
+		}
+	}
+	return nil
+}
+
+// send issues a request, retrying retriable failures with backoff, and
+// returns the body of a successful response.
+//
+// Each attempt goes to the best URL: a healthy URL that has not yet been tried
+// in this round, then an unhealthy URL that has not been tried, then anything.
+// A failure fails over immediately if an untried healthy URL remains, and
+// otherwise is retried after backing off. Failing over does not count as a
+// retry, and once retries are exhausted, we still try every URL that has not
+// been tried in the final round before giving up.
+func (cl *Client) send(ctx context.Context, method, path string, marshaled []byte) ([]byte, error) {
+	if cl.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cl.timeout)
+		defer cancel()
+	}
+
+	tried := make([]bool, len(cl.urls))
+	var backoffs int
+	for attempt := 0; ; attempt++ {
+		idx, probe := cl.health.pick(tried)
+		tried[idx] = true
+
+		start := time.Now()
+		body, resp, transportErr, err := cl.attempt(ctx, method, cl.urls[idx]+path, marshaled)
+		latency := time.Since(start)
+
+		var status int
+		var retryAfter string
+		if resp != nil {
+			status = resp.StatusCode
+			retryAfter = resp.Header.Get("Retry-After")
+		}
+		// Only failing to talk to the URL makes it unhealthy; failing to
+		// build the request (e.g. getting a token) is not the URL's fault
+		// and is not retried. A POST that reached the registry may have
+		// been applied, so we only retry POSTs that failed to send.
+		unhealthy := transportErr && ctx.Err() == nil
+		retriable := unhealthy || method != http.MethodPost && (status >= 500 || status == http.StatusTooManyRequests)
+		cl.health.record(idx, probe, unhealthy)
+		for _, fn := range cl.onRequest {
+			fn(RequestOutcome{
+				Method:     method,
+				URL:        cl.urls[idx] + path,
+				Attempt:    attempt,
+				Latency:    latency,
+				StatusCode: status,
+				Err:        err,
+				Retriable:  retriable,
+			})
+		}
+
+		if !retriable {
+			return body, err
 		}
+
+		// If a healthy URL remains untried, we fail over immediately.
+		if cl.health.untriedHealthy(tried) {
+			continue
+		}
+		if backoffs >= cl.retries {
+			if untried(tried) {
+				continue
+			}
+			return body, err
+		}
+		backoffs++
+		backoff := cl.retryBackoff(backoffs)
+		if secs, perr := strconv.Atoi(retryAfter); perr == nil && secs >= 0 {
+			// We honor Retry-After only up to our own longest
+			// backoff, so that a registry cannot block a caller
+			// without a deadline indefinitely.
+			backoff = time.Duration(secs) * time.Second
+			if max := cl.retryBackoff(cl.retries); backoff > max {
+				backoff = max
+			}
+		}
+		if deadline, ok := ctx.Deadline(); ok {
+			if remaining := time.Until(deadline); backoff > remaining {
+				backoff = remaining
+			}
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, err
+		case <-timer.C:
+		}
+		for i := range tried {
+			tried[i] = false
+		}
+	}
+}
+
+func untried(tried []bool) bool {
+	for _, t := range tried {
+		if !t {
+			return true
+		}
+	}
+	return false
+}
+
+// attempt issues one request to one URL, refreshing the bearer token and
+// retrying once if the registry rejects our token. This returns the response
+// (with its body consumed) if the registry responded at all, and whether the
+// error, if any, came from failing to talk to the URL.
+func (cl *Client) attempt(ctx context.Context, method, url string, marshaled []byte) ([]byte, *http.Response, bool, error) {
+	refreshed := false
+
+send:
+	var reqBody io.Reader
+	if marshaled != nil {
 		reqBody = bytes.NewReader(marshaled)
 	}
 
 	req, err := http.NewRequestWithContext(ctx, method, url, reqBody)
 	if err != nil {
-		return fmt.Errorf("unable to create request for %s %q: %v", method, url, err)
+		return nil, nil, false, fmt.Errorf("unable to create request for %s %q: %v", method, url, err)
 	}
 	req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
 	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
@@ -120,19 +289,36 @@ start:
 	if cl.basicAuth != nil {
 		req.SetBasicAuth(cl.basicAuth.user, cl.basicAuth.pass)
 	}
+	var token string
+	if cl.bearer != nil {
+		if token, err = cl.bearer.get(ctx); err != nil {
+			return nil, nil, false, fmt.Errorf("unable to get bearer token for %s %q: %w", method, url, err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if cl.headers != nil {
+		if err := cl.headers(ctx, req.Header); err != nil {
+			return nil, nil, false, fmt.Errorf("unable to set headers for %s %q: %w", method, url, err)
+		}
+	}
 
 	resp, err := cl.httpcl.Do(req)
 	if err != nil {
-		if len(urls) == 0 {
-			return fmt.Errorf("unable to %s %q: %w", method, url, err)
-		}
-		goto start
+		return nil, nil, true, fmt.Errorf("unable to %s %q: %w", method, url, err)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	resp.Body.Close()
 	if err != nil {
-		return fmt.Errorf("unable to read response body from %s %q: %w", method, url, err)
+		return nil, nil, true, fmt.Errorf("unable to read response body from %s %q: %w", method, url, err)
+	}
+
+	// If our token was rejected, it may have expired or been revoked:
+	// we refresh it and retry once.
+	if resp.StatusCode == http.StatusUnauthorized && cl.bearer != nil && !refreshed {
+		cl.bearer.expire(token)
+		refreshed = true
+		goto send
 	}
 
 	if resp.StatusCode != 200 {
@@ -141,15 +327,9 @@ start:
 			URL:    url,
 		}
 		if err := json.Unmarshal(body, e); err != nil {
-			return fmt.Errorf("unable to decode erroring response body from %s %q: %w", method, url, err)
+			return nil, resp, false, fmt.Errorf("unable to decode erroring response body (status %d) from %s %q: %w", resp.StatusCode, method, url, err)
 		}
-		return e
+		return nil, resp, false, e
 	}
-
-	if into != nil {
-		if err := json.Unmarshal(body, into); err != nil {
-			return fmt.Errorf("unable to decode ok response body from %s %q: %w", method, url, err)
-		}
-	}
-	return nil
+	return body, resp, false, nil
 }
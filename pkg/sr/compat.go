@@ -0,0 +1,973 @@
+package sr
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"sort"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/sr/protofile"
+)
+
+// This file contains an offline implementation of the registry's schema
+// compatibility checks.
+//
+// Compatibility is defined in terms of a reader and a writer. A schema is
+// BACKWARD compatible with a previous schema if the new schema can read data
+// written with the previous schema, and FORWARD compatible if the previous
+// schema can read data written with the new schema. FULL is both. The
+// TRANSITIVE variants check against every previous schema rather than only
+// the latest.
+//
+// For Avro, the rules are the Avro schema resolution rules. For protobuf, the
+// rules follow the registry: messages cannot be removed, the package cannot
+// change, field types can only change within wire compatible groups, required
+// fields cannot be added, and existing fields cannot be moved into a oneof
+// together. For JSON schemas, the rules require that every value valid for
+// the writer is valid for the reader, as far as that can be determined from
+// the schemas' keywords.
+
+// Incompatibility describes one reason that a schema is incompatible with a
+// previous schema.
+type Incompatibility struct {
+	// Previous is the index of the previous schema (in the slice passed
+	// to CheckCompatibilityOffline) that this incompatibility is with.
+	Previous int
+
+	// Direction is the direction of the failed check: CompatBackward if
+	// the new schema cannot read data written with the previous schema,
+	// or CompatForward if the previous schema cannot read data written
+	// with the new schema.
+	Direction CompatibilityLevel
+
+	// Path is the location of the incompatibility within the reading
+	// schema: a JSON pointer for Avro and JSON schemas, or a message and
+	// field name for protobuf.
+	Path string
+
+	// Message describes the incompatibility.
+	Message string
+}
+
+func (i Incompatibility) String() string {
+	path := i.Path
+	if path == "" {
+		path = "/"
+	}
+	return fmt.Sprintf("%s incompatible with previous schema %d at %s: %s", i.Direction, i.Previous, path, i.Message)
+}
+
+// CheckCompatibilityOffline checks whether schema is compatible with the
+// previous schemas, which must be ordered from oldest to newest, according to
+// the given compatibility level. This is a local version of the registry's
+// compatibility check, and does not need a registry.
+//
+// If the schema or any previous schema has references, resolve is called to
+// get the referenced schemas; resolve can be nil if no schema has
+// references. Every schema must have the same SchemaType.
+//
+// This returns all incompatibilities that were found; the schema is
+// compatible if there are none. An error is returned if any schema cannot be
+// parsed or the level is unknown.
+func CheckCompatibilityOffline(level CompatibilityLevel, schema Schema, previous []Schema, resolve func(SchemaReference) (Schema, error)) ([]Incompatibility, error) {
+	var backward, forward, transitive bool
+	switch level {
+	case CompatNone:
+		return nil, nil
+	case CompatBackward:
+		backward = true
+	case CompatBackwardTransitive:
+		backward, transitive = true, true
+	case CompatForward:
+		forward = true
+	case CompatForwardTransitive:
+		forward, transitive = true, true
+	case CompatFull:
+		backward, forward = true, true
+	case CompatFullTransitive:
+		backward, forward, transitive = true, true, true
+	default:
+		return nil, fmt.Errorf("unknown compatibility level %d", level)
+	}
+
+	start := 0
+	if !transitive && len(previous) > 0 {
+		start = len(previous) - 1
+	}
+
+	parse := func(s Schema) (interface{}, error) {
+		if s.Type != schema.Type {
+			return nil, fmt.Errorf("cannot check compatibility of %s schema with %s schema", schema.Type, s.Type)
+		}
+		switch s.Type {
+		case TypeAvro:
+			return compatParseAvro(s, resolve)
+		case TypeProtobuf:
+			return compatParseProto(s, resolve)
+		case TypeJSON:
+			return compatParseJSON(s, resolve)
+		default:
+			return nil, fmt.Errorf("unknown schema type %d", s.Type)
+		}
+	}
+	parsed, err := parse(schema)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse schema: %w", err)
+	}
+
+	var incompats []Incompatibility
+	for i := start; i < len(previous); i++ {
+		prev, err := parse(previous[i])
+		if err != nil {
+			return nil, fmt.Errorf("unable to parse previous schema %d: %w", i, err)
+		}
+		check := func(dir CompatibilityLevel, r, w interface{}) {
+			add := func(path, format string, args ...interface{}) {
+				incompats = append(incompats, Incompatibility{
+					Previous:  i,
+					Direction: dir,
+					Path:      path,
+					Message:   fmt.Sprintf(format, args...),
+				})
+			}
+			switch schema.Type {
+			case TypeAvro:
+				avroCompat(r.(*avroSchema), w.(*avroSchema), "", make(map[[2]*avroSchema]bool), add)
+			case TypeProtobuf:
+				protoCompat(r.(*protofile.File), w.(*protofile.File), add)
+			case TypeJSON:
+				rn, wn := r.(jsonNode), w.(jsonNode)
+				(&jsonCompatCheck{seen: make(map[string]bool)}).check(rn, wn, "", add)
+			}
+		}
+		if backward {
+			check(CompatBackward, parsed, prev)
+		}
+		if forward {
+			check(CompatForward, prev, parsed)
+		}
+	}
+	return incompats, nil
+}
+
+// compatAdd records an incompatibility at a path.
+type compatAdd func(path, format string, args ...interface{})
+
+// compatReferences returns the schemas for all references of s, recursively,
+// with dependencies before the schemas that depend on them.
+func compatReferences(s Schema, resolve func(SchemaReference) (Schema, error)) ([]SchemaReference, []Schema, error) {
+	var (
+		refs    []SchemaReference
+		schemas []Schema
+		seen    = make(map[SchemaReference]bool)
+	)
+	var walk func(Schema) error
+	walk = func(s Schema) error {
+		for _, ref := range s.References {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			if resolve == nil {
+				return fmt.Errorf("schema has reference %q, but no resolve function was provided", ref.Name)
+			}
+			rs, err := resolve(ref)
+			if err != nil {
+				return fmt.Errorf("unable to resolve reference %q: %w", ref.Name, err)
+			}
+			if err := walk(rs); err != nil {
+				return err
+			}
+			refs = append(refs, ref)
+			schemas = append(schemas, rs)
+		}
+		return nil
+	}
+	return refs, schemas, walk(s)
+}
+
+//////////
+// AVRO //
+//////////
+
+func compatParseAvro(s Schema, resolve func(SchemaReference) (Schema, error)) (interface{}, error) {
+	_, deps, err := compatReferences(s, resolve)
+	if err != nil {
+		return nil, err
+	}
+	names := make(avroNames)
+	for _, dep := range deps {
+		if _, err := parseAvroSchema(dep.Schema, names); err != nil {
+			return nil, err
+		}
+	}
+	return parseAvroSchema(s.Schema, names)
+}
+
+// avroCompat checks that data written with w can be read with r.
+func avroCompat(r, w *avroSchema, path string, seen map[[2]*avroSchema]bool, add compatAdd) {
+	if w.kind == avroUnion {
+		for i, b := range w.branches {
+			if r.kind == avroUnion {
+				if _, ok := avroResolveBranch(b, r); !ok {
+					add(path, "reader union has no branch for writer union branch %d (%s)", i, avroTypeName(b))
+					continue
+				}
+			}
+			avroCompat(r, b, path, seen, add)
+		}
+		return
+	}
+	if r.kind == avroUnion {
+		b, ok := avroResolveBranch(w, r)
+		if !ok {
+			add(path, "reader union has no branch for writer type %s", avroTypeName(w))
+			return
+		}
+		avroCompat(b, w, path, seen, add)
+		return
+	}
+
+	if !avroMatches(w, r) {
+		switch {
+		case w.kind == r.kind && w.kind == avroFixed && avroNamesMatch(w, r):
+			add(path, "reader fixed size %d does not match writer fixed size %d", r.size, w.size)
+		case w.kind == r.kind && w.isNamed():
+			add(path, "reader %s does not match writer %s by name or alias", avroTypeName(r), avroTypeName(w))
+		default:
+			add(path, "reader type %s cannot read writer type %s", avroTypeName(r), avroTypeName(w))
+		}
+		return
+	}
+
+	key := [2]*avroSchema{r, w}
+	if seen[key] {
+		return
+	}
+	seen[key] = true
+
+	switch r.kind {
+	case avroRecord:
+		for _, rf := range r.fields {
+			fpath := path + "/fields/" + escapePointer(rf.name)
+			wf, ok := avroWriterField(rf, w)
+			if !ok {
+				if !rf.hasDef {
+					add(fpath, "reader field %q has no default and is missing from the writer", rf.name)
+				}
+				continue
+			}
+			avroCompat(rf.typ, wf.typ, fpath, seen, add)
+		}
+	case avroEnum:
+		if r.hasEnumDef {
+			return
+		}
+		for _, sym := range w.symbols {
+			var found bool
+			for _, rsym := range r.symbols {
+				found = found || rsym == sym
+			}
+			if !found {
+				add(path+"/symbols", "writer symbol %q is missing from the reader enum, which has no default", sym)
+			}
+		}
+	case avroArray:
+		avroCompat(r.items, w.items, path+"/items", seen, add)
+	case avroMap:
+		avroCompat(r.values, w.values, path+"/values", seen, add)
+	}
+}
+
+// avroWriterField returns the writer field that corresponds to the reader
+// field, matching names and reader field aliases.
+func avroWriterField(rf avroField, w *avroSchema) (avroField, bool) {
+	for _, wf := range w.fields {
+		if wf.name == rf.name {
+			return wf, true
+		}
+	}
+	for _, wf := range w.fields {
+		for _, a := range rf.aliases {
+			if a == wf.name {
+				return wf, true
+			}
+		}
+	}
+	return avroField{}, false
+}
+
+//////////////
+// PROTOBUF //
+//////////////
+
+// protoWellKnown contains the symbols of the well known types, which are
+// provided by the registry and are never references.
+var protoWellKnown = map[string]protofile.Kind{
+	"google.protobuf.Any":           protofile.KindMessage,
+	"google.protobuf.Api":           protofile.KindMessage,
+	"google.protobuf.Method":        protofile.KindMessage,
+	"google.protobuf.Mixin":         protofile.KindMessage,
+	"google.protobuf.Duration":      protofile.KindMessage,
+	"google.protobuf.Empty":         protofile.KindMessage,
+	"google.protobuf.FieldMask":     protofile.KindMessage,
+	"google.protobuf.SourceContext": protofile.KindMessage,
+	"google.protobuf.Struct":        protofile.KindMessage,
+	"google.protobuf.Value":         protofile.KindMessage,
+	"google.protobuf.ListValue":     protofile.KindMessage,
+	"google.protobuf.NullValue":     protofile.KindEnum,
+	"google.protobuf.Timestamp":     protofile.KindMessage,
+	"google.protobuf.Type":          protofile.KindMessage,
+	"google.protobuf.Field":         protofile.KindMessage,
+	"google.protobuf.Enum":          protofile.KindMessage,
+	"google.protobuf.EnumValue":     protofile.KindMessage,
+	"google.protobuf.Option":        protofile.KindMessage,
+	"google.protobuf.Syntax":        protofile.KindEnum,
+	"google.protobuf.DoubleValue":   protofile.KindMessage,
+	"google.protobuf.FloatValue":    protofile.KindMessage,
+	"google.protobuf.Int64Value":    protofile.KindMessage,
+	"google.protobuf.UInt64Value":   protofile.KindMessage,
+	"google.protobuf.Int32Value":    protofile.KindMessage,
+	"google.protobuf.UInt32Value":   protofile.KindMessage,
+	"google.protobuf.BoolValue":     protofile.KindMessage,
+	"google.protobuf.StringValue":   protofile.KindMessage,
+	"google.protobuf.BytesValue":    protofile.KindMessage,
+}
+
+func compatParseProto(s Schema, resolve func(SchemaReference) (Schema, error)) (interface{}, error) {
+	_, deps, err := compatReferences(s, resolve)
+	if err != nil {
+		return nil, err
+	}
+	syms := make(map[string]protofile.Kind)
+	for name, kind := range protoWellKnown {
+		syms[name] = kind
+	}
+	for _, dep := range deps {
+		f, err := protofile.Parse(dep.Schema)
+		if err != nil {
+			return nil, err
+		}
+		for name, kind := range f.Symbols() {
+			syms[name] = kind
+		}
+	}
+	f, err := protofile.Parse(s.Schema)
+	if err != nil {
+		return nil, err
+	}
+	if err := f.Resolve(syms); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// protoMessages returns every message in the file by full name, excluding
+// synthesized map entries.
+func protoMessages(f *protofile.File) map[string]*protofile.Message {
+	ms := make(map[string]*protofile.Message)
+	var add func([]*protofile.Message)
+	add = func(msgs []*protofile.Message) {
+		for _, m := range msgs {
+			if !m.MapEntry {
+				ms[m.FullName] = m
+			}
+			add(m.Messages)
+		}
+	}
+	add(f.Messages)
+	return ms
+}
+
+// protoWireGroup returns the group of wire compatible scalar types that a
+// scalar type belongs to.
+func protoWireGroup(typ string) string {
+	switch typ {
+	case "int32", "uint32", "int64", "uint64", "bool":
+		return "varint"
+	case "sint32", "sint64":
+		return "zigzag"
+	case "fixed32", "sfixed32":
+		return "fixed32"
+	case "fixed64", "sfixed64":
+		return "fixed64"
+	case "string", "bytes":
+		return "bytes"
+	default:
+		return typ
+	}
+}
+
+// protoCompat checks that data written with w can be read with r.
+func protoCompat(r, w *protofile.File, add compatAdd) {
+	if r.Package != w.Package {
+		add("", "package changed from %q to %q", w.Package, r.Package)
+	}
+	rms, wms := protoMessages(r), protoMessages(w)
+	names := make([]string, 0, len(wms))
+	for name := range wms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rm, ok := rms[name]
+		if !ok {
+			add(name, "message %s is missing from the reader", name)
+			continue
+		}
+		protoMessageCompat(rm, wms[name], add)
+	}
+}
+
+func protoFieldType(f *protofile.Field) string {
+	if f.FullType != "" {
+		return f.FullType
+	}
+	return f.Type
+}
+
+func protoMessageCompat(r, w *protofile.Message, add compatAdd) {
+	wfields := make(map[int32]*protofile.Field)
+	for _, wf := range w.Fields {
+		wfields[wf.Number] = wf
+	}
+	rfields := make(map[int32]*protofile.Field)
+	for _, rf := range r.Fields {
+		rfields[rf.Number] = rf
+	}
+
+	for _, rf := range r.Fields {
+		path := r.FullName + "." + rf.Name
+		wf, ok := wfields[rf.Number]
+		if !ok {
+			if rf.Label == protofile.LabelRequired {
+				add(path, "required field %s (%d) is missing from the writer", rf.Name, rf.Number)
+			}
+			continue
+		}
+		if rf.Label == protofile.LabelRequired && wf.Label != protofile.LabelRequired {
+			add(path, "field %s (%d) is required by the reader but not the writer", rf.Name, rf.Number)
+		}
+		if (rf.Label == protofile.LabelRepeated) != (wf.Label == protofile.LabelRepeated) {
+			add(path, "field %s (%d) changed between repeated and singular", rf.Name, rf.Number)
+			continue
+		}
+
+		switch {
+		case (rf.Map == nil) != (wf.Map == nil):
+			add(path, "field %s (%d) changed between a map and a non-map", rf.Name, rf.Number)
+		case rf.Map != nil:
+			for i, which := range []string{"key", "value"} {
+				rt, wt := protoFieldType(rf.Map.Fields[i]), protoFieldType(wf.Map.Fields[i])
+				if protoWireGroup(rt) != protoWireGroup(wt) {
+					add(path, "map field %s (%d) %s type changed from %s to %s", rf.Name, rf.Number, which, wt, rt)
+				}
+			}
+		case rf.Kind != wf.Kind:
+			add(path, "field %s (%d) changed kind from %s to %s", rf.Name, rf.Number, protoFieldType(wf), protoFieldType(rf))
+		case rf.Kind == protofile.KindScalar:
+			if protoWireGroup(rf.Type) != protoWireGroup(wf.Type) {
+				add(path, "field %s (%d) changed type from %s to the wire incompatible %s", rf.Name, rf.Number, wf.Type, rf.Type)
+			}
+		default:
+			if rt, wt := protoFieldType(rf), protoFieldType(wf); rt != wt {
+				add(path, "field %s (%d) changed type from %s to %s", rf.Name, rf.Number, wt, rt)
+			}
+		}
+	}
+
+	// Moving multiple existing fields into a oneof, or moving a field
+	// into a oneof that already existed, means values that were set
+	// together can no longer be read together.
+	for oi, oneof := range r.Oneofs {
+		var moved []string
+		existed := false
+		for _, rf := range r.Fields {
+			if rf.Oneof != oi || rf.Proto3Optional {
+				continue
+			}
+			wf, ok := wfields[rf.Number]
+			if !ok {
+				continue
+			}
+			if wf.Oneof >= 0 && !wf.Proto3Optional {
+				existed = existed || w.Oneofs[wf.Oneof] == oneof
+				continue
+			}
+			moved = append(moved, rf.Name)
+		}
+		path := r.FullName + "." + oneof
+		switch {
+		case len(moved) > 0 && existed:
+			add(path, "fields %s were moved into the existing oneof %s", strings.Join(moved, ", "), oneof)
+		case len(moved) > 1:
+			add(path, "multiple fields %s were moved into the oneof %s", strings.Join(moved, ", "), oneof)
+		}
+	}
+}
+
+/////////////////
+// JSON SCHEMA //
+/////////////////
+
+// jsonNode is a schema within a set of JSON schema documents.
+type jsonNode struct {
+	c   *jsonCompiler // for documents and reference resolution
+	doc string
+	ptr string
+	v   interface{}
+}
+
+func compatParseJSON(s Schema, resolve func(SchemaReference) (Schema, error)) (interface{}, error) {
+	refs, deps, err := compatReferences(s, resolve)
+	if err != nil {
+		return nil, err
+	}
+	texts := make(map[string]string)
+	for i, ref := range refs {
+		texts[ref.Name] = deps[i].Schema
+	}
+	// Compiling validates the schema and its references; we check
+	// compatibility on the raw documents.
+	if _, err := compileJSONSchema(s.Schema, texts); err != nil {
+		return nil, err
+	}
+	c := &jsonCompiler{docs: make(map[string]interface{})}
+	for name, text := range texts {
+		c.docs[name], _ = decodeJSON([]byte(text))
+	}
+	c.docs[""], _ = decodeJSON([]byte(s.Schema))
+	return jsonNode{c: c, v: c.docs[""]}, nil
+}
+
+// deref follows $ref until reaching a schema without one.
+func (n jsonNode) deref() jsonNode {
+	for i := 0; i < 32; i++ {
+		m, ok := n.v.(map[string]interface{})
+		if !ok {
+			return n
+		}
+		ref, ok := m["$ref"].(string)
+		if !ok {
+			return n
+		}
+		doc, ptr, err := n.c.resolveRef(n.doc, ref)
+		if err != nil {
+			return n
+		}
+		v, err := jsonPointer(n.c.docs[doc], ptr)
+		if err != nil {
+			return n
+		}
+		n = jsonNode{n.c, doc, ptr, v}
+	}
+	return n
+}
+
+// child returns the schema at the given keyword path within n, or nil v if
+// it does not exist.
+func (n jsonNode) child(keys ...string) jsonNode {
+	v := n.v
+	ptr := n.ptr
+	for _, k := range keys {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			v = nil
+			break
+		}
+		v = m[k]
+		ptr += "/" + escapePointer(k)
+	}
+	return jsonNode{n.c, n.doc, ptr, v}
+}
+
+func (n jsonNode) index(i int) jsonNode {
+	arr, _ := n.v.([]interface{})
+	var v interface{}
+	if i < len(arr) {
+		v = arr[i]
+	}
+	return jsonNode{n.c, n.doc, fmt.Sprintf("%s/%d", n.ptr, i), v}
+}
+
+func (n jsonNode) obj() map[string]interface{} {
+	m, _ := n.v.(map[string]interface{})
+	return m
+}
+
+// acceptsAll returns whether a schema accepts every value: true, an empty
+// object, or an object with only annotation keywords.
+func (n jsonNode) acceptsAll() bool {
+	switch t := n.v.(type) {
+	case bool:
+		return t
+	case map[string]interface{}:
+		for k := range t {
+			switch k {
+			case "$schema", "$id", "id", "title", "description", "default", "examples", "$comment", "definitions", "$defs", "format":
+			default:
+				return false
+			}
+		}
+		return true
+	case nil:
+		return true
+	}
+	return false
+}
+
+func (n jsonNode) rejectsAll() bool {
+	b, ok := n.v.(bool)
+	return ok && !b
+}
+
+type jsonCompatCheck struct {
+	seen map[string]bool
+}
+
+// trial returns whether w is compatible with r, without reporting.
+func (c *jsonCompatCheck) trial(r, w jsonNode) bool {
+	var failed bool
+	(&jsonCompatCheck{seen: make(map[string]bool)}).check(r, w, "", func(string, string, ...interface{}) { failed = true })
+	return !failed
+}
+
+// check checks that every value valid for w is valid for r. The path is the
+// JSON pointer to r.
+func (c *jsonCompatCheck) check(r, w jsonNode, path string, add compatAdd) {
+	r, w = r.deref(), w.deref()
+	key := r.doc + "#" + r.ptr + "|" + w.doc + "#" + w.ptr
+	if c.seen[key] {
+		return
+	}
+	c.seen[key] = true
+
+	switch {
+	case r.acceptsAll(), w.rejectsAll():
+		return
+	case r.rejectsAll():
+		add(path, "reader rejects all values")
+		return
+	case w.acceptsAll():
+		add(path, "reader restricts values, but the writer accepts any value")
+		return
+	}
+	rm, wm := r.obj(), w.obj()
+
+	// Combinators: every writer branch must be readable by the reader, and
+	// a writer value must be readable by at least one reader branch.
+	for _, kw := range []string{"anyOf", "oneOf"} {
+		if _, ok := wm[kw]; ok {
+			for i := range wm[kw].([]interface{}) {
+				c.check(r, w.child(kw).index(i), path, add)
+			}
+			return
+		}
+	}
+	for _, kw := range []string{"anyOf", "oneOf"} {
+		if branches, ok := rm[kw].([]interface{}); ok {
+			var matched bool
+			for i := range branches {
+				if c.trial(r.child(kw).index(i), w) {
+					matched = true
+					break
+				}
+			}
+			if !matched {
+				add(path+"/"+kw, "no reader %s branch accepts the writer", kw)
+			}
+			return
+		}
+	}
+	if branches, ok := rm["allOf"].([]interface{}); ok {
+		for i := range branches {
+			c.check(r.child("allOf").index(i), w, fmt.Sprintf("%s/allOf/%d", path, i), add)
+		}
+	}
+	if not, ok := rm["not"]; ok && !jsonEqual(not, wm["not"]) {
+		add(path+"/not", "reader not schema differs from the writer")
+	}
+
+	c.checkType(rm, wm, path, add)
+	c.checkEnum(rm, wm, path, add)
+	c.checkNumber(rm, wm, path, add)
+	c.checkString(rm, wm, path, add)
+	c.checkArray(r, w, path, add)
+	c.checkObject(r, w, path, add)
+}
+
+func jsonTypes(m map[string]interface{}) []string {
+	switch t := m["type"].(type) {
+	case string:
+		return []string{t}
+	case []interface{}:
+		var types []string
+		for _, e := range t {
+			if s, ok := e.(string); ok {
+				types = append(types, s)
+			}
+		}
+		return types
+	}
+	return nil
+}
+
+func (*jsonCompatCheck) checkType(rm, wm map[string]interface{}, path string, add compatAdd) {
+	rtypes, wtypes := jsonTypes(rm), jsonTypes(wm)
+	if rtypes == nil {
+		return
+	}
+	if wtypes == nil {
+		add(path+"/type", "reader restricts the type to %s, but the writer allows any type", strings.Join(rtypes, ", "))
+		return
+	}
+	for _, wt := range wtypes {
+		var ok bool
+		for _, rt := range rtypes {
+			ok = ok || rt == wt || rt == "number" && wt == "integer"
+		}
+		if !ok {
+			add(path+"/type", "writer type %s is not allowed by the reader", wt)
+		}
+	}
+}
+
+func (*jsonCompatCheck) checkEnum(rm, wm map[string]interface{}, path string, add compatAdd) {
+	var wvals []interface{}
+	if c, ok := wm["const"]; ok {
+		wvals = []interface{}{c}
+	} else if e, ok := wm["enum"].([]interface{}); ok {
+		wvals = e
+	}
+
+	var rvals []interface{}
+	kw := "enum"
+	if c, ok := rm["const"]; ok {
+		rvals, kw = []interface{}{c}, "const"
+	} else if e, ok := rm["enum"].([]interface{}); ok {
+		rvals = e
+	} else {
+		return
+	}
+	if wvals == nil {
+		add(path+"/"+kw, "reader restricts values with %s, but the writer does not", kw)
+		return
+	}
+	for _, wv := range wvals {
+		var ok bool
+		for _, rv := range rvals {
+			ok = ok || jsonEqual(rv, wv)
+		}
+		if !ok {
+			b, _ := json.Marshal(wv)
+			add(path+"/"+kw, "writer value %s is not allowed by the reader", b)
+		}
+	}
+}
+
+func jsonKeywordRat(m map[string]interface{}, kw string) *big.Rat {
+	n, ok := m[kw].(json.Number)
+	if !ok {
+		return nil
+	}
+	r, _ := jsonRat(n)
+	return r
+}
+
+// jsonBound returns the effective lower or upper numeric bound of a schema,
+// and whether the bound is exclusive, handling draft-04 boolean exclusive
+// keywords.
+func jsonBound(m map[string]interface{}, lower bool) (*big.Rat, bool) {
+	inclusive, exclusive := "maximum", "exclusiveMaximum"
+	if lower {
+		inclusive, exclusive = "minimum", "exclusiveMinimum"
+	}
+	if b, _ := m[exclusive].(bool); b {
+		return jsonKeywordRat(m, inclusive), true
+	}
+	if excl := jsonKeywordRat(m, exclusive); excl != nil {
+		return excl, true
+	}
+	return jsonKeywordRat(m, inclusive), false
+}
+
+func (*jsonCompatCheck) checkNumber(rm, wm map[string]interface{}, path string, add compatAdd) {
+	for _, lower := range []bool{true, false} {
+		rb, rexcl := jsonBound(rm, lower)
+		if rb == nil {
+			continue
+		}
+		wb, wexcl := jsonBound(wm, lower)
+		which, cmp := "maximum", 1
+		if lower {
+			which, cmp = "minimum", -1
+		}
+		switch {
+		case wb == nil:
+			add(path, "reader adds a %s of %s that the writer does not have", which, rb.RatString())
+		case wb.Cmp(rb) == cmp, wb.Cmp(rb) == 0 && rexcl && !wexcl:
+			add(path, "reader %s of %s is narrower than the writer %s of %s", which, rb.RatString(), which, wb.RatString())
+		}
+	}
+	if rmul := jsonKeywordRat(rm, "multipleOf"); rmul != nil {
+		wmul := jsonKeywordRat(wm, "multipleOf")
+		if wmul == nil || !new(big.Rat).Quo(wmul, rmul).IsInt() {
+			add(path+"/multipleOf", "writer values are not all multiples of the reader multipleOf %s", rmul.RatString())
+		}
+	}
+}
+
+// checkLimits checks min and max count keywords, such as minLength and
+// maxLength.
+func checkLimits(rm, wm map[string]interface{}, path, minKw, maxKw string, add compatAdd) {
+	if rmin := jsonKeywordRat(rm, minKw); rmin != nil && rmin.Sign() > 0 {
+		if wmin := jsonKeywordRat(wm, minKw); wmin == nil || wmin.Cmp(rmin) < 0 {
+			add(path+"/"+minKw, "reader %s of %s is narrower than the writer", minKw, rmin.RatString())
+		}
+	}
+	if rmax := jsonKeywordRat(rm, maxKw); rmax != nil {
+		if wmax := jsonKeywordRat(wm, maxKw); wmax == nil || wmax.Cmp(rmax) > 0 {
+			add(path+"/"+maxKw, "reader %s of %s is narrower than the writer", maxKw, rmax.RatString())
+		}
+	}
+}
+
+func (*jsonCompatCheck) checkString(rm, wm map[string]interface{}, path string, add compatAdd) {
+	checkLimits(rm, wm, path, "minLength", "maxLength", add)
+	if rp, ok := rm["pattern"].(string); ok {
+		if wp, _ := wm["pattern"].(string); wp != rp {
+			add(path+"/pattern", "reader pattern %q differs from the writer pattern %q", rp, wp)
+		}
+	}
+}
+
+func (c *jsonCompatCheck) checkArray(r, w jsonNode, path string, add compatAdd) {
+	rm, wm := r.obj(), w.obj()
+	checkLimits(rm, wm, path, "minItems", "maxItems", add)
+	if ru, _ := rm["uniqueItems"].(bool); ru {
+		if wu, _ := wm["uniqueItems"].(bool); !wu {
+			add(path+"/uniqueItems", "reader requires unique items, but the writer does not")
+		}
+	}
+
+	// We normalize draft 2020-12 prefixItems / items and earlier tuple
+	// items / additionalItems into a tuple and a schema for the rest.
+	tuple := func(n jsonNode) ([]jsonNode, jsonNode) {
+		m := n.obj()
+		if _, ok := m["prefixItems"]; ok {
+			arr, _ := m["prefixItems"].([]interface{})
+			var prefix []jsonNode
+			for i := range arr {
+				prefix = append(prefix, n.child("prefixItems").index(i))
+			}
+			return prefix, n.child("items")
+		}
+		if arr, ok := m["items"].([]interface{}); ok {
+			var prefix []jsonNode
+			for i := range arr {
+				prefix = append(prefix, n.child("items").index(i))
+			}
+			return prefix, n.child("additionalItems")
+		}
+		return nil, n.child("items")
+	}
+	rprefix, rrest := tuple(r)
+	wprefix, wrest := tuple(w)
+	rpath := func(n jsonNode) string { return path + strings.TrimPrefix(n.ptr, r.ptr) }
+
+	n := len(rprefix)
+	if len(wprefix) > n {
+		n = len(wprefix)
+	}
+	for i := 0; i < n; i++ {
+		rn, wn := rrest, wrest
+		if i < len(rprefix) {
+			rn = rprefix[i]
+		}
+		if i < len(wprefix) {
+			wn = wprefix[i]
+		}
+		c.check(rn, wn, rpath(rn), add)
+	}
+	c.check(rrest, wrest, rpath(rrest), add)
+}
+
+func jsonRequired(m map[string]interface{}) map[string]bool {
+	req := make(map[string]bool)
+	if arr, ok := m["required"].([]interface{}); ok {
+		for _, e := range arr {
+			if s, ok := e.(string); ok {
+				req[s] = true
+			}
+		}
+	}
+	return req
+}
+
+func (c *jsonCompatCheck) checkObject(r, w jsonNode, path string, add compatAdd) {
+	rm, wm := r.obj(), w.obj()
+	checkLimits(rm, wm, path, "minProperties", "maxProperties", add)
+
+	rreq, wreq := jsonRequired(rm), jsonRequired(wm)
+	var names []string
+	for name := range rreq {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !wreq[name] {
+			add(path+"/required", "property %q is required by the reader but not by the writer", name)
+		}
+	}
+
+	rprops, _ := rm["properties"].(map[string]interface{})
+	wprops, _ := wm["properties"].(map[string]interface{})
+	raddl, waddl := r.child("additionalProperties"), w.child("additionalProperties")
+
+	names = names[:0]
+	for name := range wprops {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		wp := w.child("properties", name)
+		if _, ok := rprops[name]; ok {
+			c.check(r.child("properties", name), wp, path+"/properties/"+escapePointer(name), add)
+			continue
+		}
+		if raddl.rejectsAll() {
+			add(path+"/properties", "property %q was removed, but the reader does not allow additional properties", name)
+			continue
+		}
+		c.check(raddl, wp, path+"/additionalProperties", add)
+	}
+
+	names = names[:0]
+	for name := range rprops {
+		if _, ok := wprops[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		rp := r.child("properties", name)
+		switch {
+		case waddl.rejectsAll():
+		case waddl.acceptsAll():
+			if !rp.deref().acceptsAll() {
+				add(path+"/properties/"+escapePointer(name), "property %q was added, but the writer allows any additional properties", name)
+			}
+		default:
+			c.check(rp, waddl, path+"/properties/"+escapePointer(name), add)
+		}
+	}
+
+	if _, ok := rm["additionalProperties"]; ok && !raddl.acceptsAll() {
+		if waddl.acceptsAll() {
+			add(path+"/additionalProperties", "reader restricts additional properties, but the writer does not")
+		} else {
+			c.check(raddl, waddl, path+"/additionalProperties", add)
+		}
+	}
+}
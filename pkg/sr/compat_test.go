@@ -0,0 +1,251 @@
+package sr
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestCheckCompatibilityOffline(t *testing.T) {
+	type incompat struct {
+		dir  CompatibilityLevel
+		path string
+	}
+	for _, test := range []struct {
+		name  string
+		typ   SchemaType
+		level CompatibilityLevel
+		prev  []string
+		next  string
+		exp   []incompat
+	}{
+		// Avro
+		{
+			name:  "avro add field with default",
+			typ:   TypeAvro,
+			level: CompatFull,
+			prev:  []string{`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`},
+			next:  `{"type":"record","name":"r","fields":[{"name":"a","type":"int"},{"name":"b","type":"string","default":""}]}`,
+		},
+		{
+			name:  "avro add field without default",
+			typ:   TypeAvro,
+			level: CompatFull,
+			prev:  []string{`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`},
+			next:  `{"type":"record","name":"r","fields":[{"name":"a","type":"int"},{"name":"b","type":"string"}]}`,
+			exp:   []incompat{{CompatBackward, "/fields/b"}},
+		},
+		{
+			name:  "avro promotion",
+			typ:   TypeAvro,
+			level: CompatFull,
+			prev:  []string{`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`},
+			next:  `{"type":"record","name":"r","fields":[{"name":"a","type":"long"}]}`,
+			exp:   []incompat{{CompatForward, "/fields/a"}},
+		},
+		{
+			name:  "avro renamed field with alias",
+			typ:   TypeAvro,
+			level: CompatBackward,
+			prev:  []string{`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`},
+			next:  `{"type":"record","name":"r","fields":[{"name":"b","aliases":["a"],"type":"int"}]}`,
+		},
+		{
+			name:  "avro enum symbol added",
+			typ:   TypeAvro,
+			level: CompatFull,
+			prev:  []string{`{"type":"enum","name":"e","symbols":["A","B"]}`},
+			next:  `{"type":"enum","name":"e","symbols":["A","B","C"]}`,
+			exp:   []incompat{{CompatForward, "/symbols"}},
+		},
+		{
+			name:  "avro union narrowed",
+			typ:   TypeAvro,
+			level: CompatBackward,
+			prev:  []string{`["null","string","int"]`},
+			next:  `["null","string"]`,
+			exp:   []incompat{{CompatBackward, ""}},
+		},
+
+		// Protobuf
+		{
+			name:  "proto add field and wire compatible change",
+			typ:   TypeProtobuf,
+			level: CompatFull,
+			prev:  []string{`syntax = "proto3"; message M { int32 a = 1; }`},
+			next:  `syntax = "proto3"; message M { int64 a = 1; string b = 2; }`,
+		},
+		{
+			name:  "proto wire incompatible change",
+			typ:   TypeProtobuf,
+			level: CompatBackward,
+			prev:  []string{`syntax = "proto3"; message M { int32 a = 1; }`},
+			next:  `syntax = "proto3"; message M { string a = 1; }`,
+			exp:   []incompat{{CompatBackward, "M.a"}},
+		},
+		{
+			name:  "proto message removed",
+			typ:   TypeProtobuf,
+			level: CompatBackward,
+			prev:  []string{`syntax = "proto3"; message M { int32 a = 1; } message N { int32 b = 1; }`},
+			next:  `syntax = "proto3"; message M { int32 a = 1; }`,
+			exp:   []incompat{{CompatBackward, "N"}},
+		},
+		{
+			name:  "proto fields moved into oneof",
+			typ:   TypeProtobuf,
+			level: CompatBackward,
+			prev:  []string{`syntax = "proto3"; message M { int32 a = 1; string b = 2; }`},
+			next:  `syntax = "proto3"; message M { oneof o { int32 a = 1; string b = 2; } }`,
+			exp:   []incompat{{CompatBackward, "M.o"}},
+		},
+		{
+			name:  "proto map value changed",
+			typ:   TypeProtobuf,
+			level: CompatBackward,
+			prev:  []string{`syntax = "proto3"; message M { map<string, int32> m = 1; }`},
+			next:  `syntax = "proto3"; message M { map<string, string> m = 1; }`,
+			exp:   []incompat{{CompatBackward, "M.m"}},
+		},
+		{
+			name:  "proto required added",
+			typ:   TypeProtobuf,
+			level: CompatBackward,
+			prev:  []string{`syntax = "proto2"; message M { optional int32 a = 1; }`},
+			next:  `syntax = "proto2"; message M { optional int32 a = 1; required int32 b = 2; }`,
+			exp:   []incompat{{CompatBackward, "M.b"}},
+		},
+
+		// JSON
+		{
+			name:  "json open model add optional property",
+			typ:   TypeJSON,
+			level: CompatBackward,
+			prev:  []string{`{"type":"object","properties":{"a":{"type":"string"}}}`},
+			next:  `{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"integer"}}}`,
+			exp:   []incompat{{CompatBackward, "/properties/b"}},
+		},
+		{
+			name:  "json closed model add optional property",
+			typ:   TypeJSON,
+			level: CompatFull,
+			prev:  []string{`{"type":"object","properties":{"a":{"type":"string"}},"additionalProperties":false}`},
+			next:  `{"type":"object","properties":{"a":{"type":"string"},"b":{"type":"integer"}},"additionalProperties":false}`,
+			exp:   []incompat{{CompatForward, "/properties"}},
+		},
+		{
+			name:  "json widen type",
+			typ:   TypeJSON,
+			level: CompatFull,
+			prev:  []string{`{"type":"object","properties":{"a":{"type":"integer"}}}`},
+			next:  `{"type":"object","properties":{"a":{"type":"number"}}}`,
+			exp:   []incompat{{CompatForward, "/properties/a/type"}},
+		},
+		{
+			name:  "json new required and narrower bound",
+			typ:   TypeJSON,
+			level: CompatBackward,
+			prev:  []string{`{"type":"object","properties":{"a":{"type":"integer","minimum":0}},"required":[]}`},
+			next:  `{"type":"object","properties":{"a":{"type":"integer","minimum":5}},"required":["a"]}`,
+			exp:   []incompat{{CompatBackward, "/required"}, {CompatBackward, "/properties/a"}},
+		},
+		{
+			name:  "json refs and enums",
+			typ:   TypeJSON,
+			level: CompatBackward,
+			prev:  []string{`{"$ref":"#/definitions/e","definitions":{"e":{"enum":["a","b"]}}}`},
+			next:  `{"$ref":"#/definitions/e","definitions":{"e":{"enum":["a"]}}}`,
+			exp:   []incompat{{CompatBackward, "/enum"}},
+		},
+		{
+			name:  "json union",
+			typ:   TypeJSON,
+			level: CompatFull,
+			prev:  []string{`{"type":"string"}`},
+			next:  `{"oneOf":[{"type":"string"},{"type":"null"}]}`,
+			exp:   []incompat{{CompatForward, "/type"}},
+		},
+
+		// Transitivity
+		{
+			name:  "non-transitive only checks latest",
+			typ:   TypeAvro,
+			level: CompatBackward,
+			prev: []string{
+				`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`,
+				`{"type":"record","name":"r","fields":[{"name":"b","type":"int"}]}`,
+			},
+			next: `{"type":"record","name":"r","fields":[{"name":"b","type":"int"}]}`,
+		},
+		{
+			name:  "transitive checks all",
+			typ:   TypeAvro,
+			level: CompatBackwardTransitive,
+			prev: []string{
+				`{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`,
+				`{"type":"record","name":"r","fields":[{"name":"b","type":"int"}]}`,
+			},
+			next: `{"type":"record","name":"r","fields":[{"name":"b","type":"int"}]}`,
+			exp:  []incompat{{CompatBackward, "/fields/b"}},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var prev []Schema
+			for _, p := range test.prev {
+				prev = append(prev, Schema{Schema: p, Type: test.typ})
+			}
+			got, err := CheckCompatibilityOffline(test.level, Schema{Schema: test.next, Type: test.typ}, prev, nil)
+			if err != nil {
+				t.Fatalf("unexpected err: %v", err)
+			}
+			if len(got) != len(test.exp) {
+				t.Fatalf("got %d incompatibilities %v != exp %d", len(got), got, len(test.exp))
+			}
+			for i, exp := range test.exp {
+				if got[i].Direction != exp.dir || got[i].Path != exp.path {
+					t.Errorf("#%d: got %s %q != exp %s %q (%s)", i, got[i].Direction, got[i].Path, exp.dir, exp.path, got[i].Message)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCompatibilityOfflineReferences(t *testing.T) {
+	refs := map[string]string{
+		"b.proto": `syntax = "proto3"; package b; message B { int32 x = 1; }`,
+	}
+	resolve := func(ref SchemaReference) (Schema, error) {
+		s, ok := refs[ref.Name]
+		if !ok {
+			return Schema{}, errors.New("not found")
+		}
+		return Schema{Schema: s, Type: TypeProtobuf}, nil
+	}
+	withRef := func(s string) Schema {
+		return Schema{
+			Schema:     s,
+			Type:       TypeProtobuf,
+			References: []SchemaReference{{Name: "b.proto", Subject: "b", Version: 1}},
+		}
+	}
+
+	prev := withRef(`syntax = "proto3"; import "b.proto"; message M { b.B b = 1; }`)
+	next := withRef(`syntax = "proto3"; import "b.proto"; import "google/protobuf/timestamp.proto"; message M { b.B b = 1; google.protobuf.Timestamp ts = 2; }`)
+	got, err := CheckCompatibilityOffline(CompatFullTransitive, next, []Schema{prev}, resolve)
+	if err != nil || len(got) != 0 {
+		t.Fatalf("got %v (err %v) != exp compatible", got, err)
+	}
+
+	next = withRef(`syntax = "proto3"; import "google/protobuf/timestamp.proto"; message M { google.protobuf.Timestamp b = 1; }`)
+	got, err = CheckCompatibilityOffline(CompatBackward, next, []Schema{prev}, resolve)
+	if err != nil || len(got) != 1 || !strings.Contains(got[0].Message, "changed type") {
+		t.Fatalf("got %v (err %v) != exp changed type", got, err)
+	}
+
+	if _, err := CheckCompatibilityOffline(CompatBackward, next, []Schema{prev}, nil); err == nil {
+		t.Error("expected error resolving references without a resolve function")
+	}
+	if _, err := CheckCompatibilityOffline(CompatBackward, Schema{Schema: `{`, Type: TypeAvro}, nil, nil); err == nil {
+		t.Error("expected error for an unparseable schema")
+	}
+}
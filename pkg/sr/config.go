@@ -1,6 +1,7 @@
 package sr
 
 import (
+	"context"
 	"crypto/tls"
 	"net"
 	"net/http"
@@ -44,7 +45,8 @@ func URLs(urls ...string) Opt {
 	}}
 }
 
-// DialTLSConfig sets a tls.Config to use in a the default http client.
+// DialTLSConfig sets a tls.Config to use in a the default http client. For
+// mTLS with certificates that are rotated on disk, see CertReloader.
 func DialTLSConfig(c *tls.Config) Opt {
 	return opt{func(cl *Client) {
 		cl.httpcl = &http.Client{
@@ -75,6 +77,29 @@ func Normalize() Opt {
 	return opt{func(cl *Client) { cl.normalize = true }}
 }
 
+// CacheResponses enables caching responses from the schema registry, and
+// coalesces concurrent identical requests into one http request.
+//
+// Immutable lookups are cached until evicted: schemas by ID, schemas by
+// subject and (numeric) version, and the result of looking up or creating a
+// schema in a subject. Lookups that can change, i.e. the latest version of a
+// subject and compatibility configs, are cached for the given ttl; a ttl of
+// zero disables caching these lookups. Only successful responses are cached.
+// Successful writes through this client drop any cached entries they may have
+// changed; writes made through other clients are only seen once the ttl
+// expires. The cache is bounded by CacheResponsesMaxBytes.
+func CacheResponses(ttl time.Duration) Opt {
+	return opt{func(cl *Client) { cl.cacheResponses, cl.cacheTTL = true, ttl }}
+}
+
+// CacheResponsesMaxBytes sets the maximum size of the response bodies cached
+// with CacheResponses, overriding the default 32MiB. Once the cache is full,
+// the least recently used responses are evicted. Responses larger than this
+// are not cached.
+func CacheResponsesMaxBytes(n int64) Opt {
+	return opt{func(cl *Client) { cl.cacheMaxBytes = n }}
+}
+
 // BasicAuth sets basic authorization to use for every request.
 func BasicAuth(user, pass string) Opt {
 	return opt{func(cl *Client) {
@@ -84,3 +109,84 @@ func BasicAuth(user, pass string) Opt {
 		}{user, pass}
 	}}
 }
+
+// BearerToken sets a function that returns an OAuth bearer token to use for
+// every request, in the Authorization header.
+//
+// The returned token is cached and reused until the registry rejects it with
+// 401 Unauthorized, at which point the function is called again for a new
+// token and the request is retried once. Calls to the function are
+// serialized, so many requests failing at once only fetch one new token. The
+// function should return an error if it cannot get a token; the error is
+// returned from the request.
+func BearerToken(fn func(context.Context) (string, error)) Opt {
+	return opt{func(cl *Client) { cl.bearer = &bearerToken{fn: fn} }}
+}
+
+// RequestHeaders sets a function that is called before every request to add
+// or modify request headers. This can be used for custom authentication
+// schemes, or for headers required by proxies in front of the registry. The
+// function is called after the default headers (and any authorization) are
+// set, and is called again for every retry of a request. If the function
+// returns an error, the request fails with the error.
+func RequestHeaders(fn func(context.Context, http.Header) error) Opt {
+	return opt{func(cl *Client) { cl.headers = fn }}
+}
+
+// RequestRetries sets the number of times a request is retried after a
+// retriable failure, overriding the default of 0. Transport errors are
+// retriable, as are 5xx and 429 Too Many Requests responses to requests other
+// than POSTs; a POST that reached the registry may have been applied, so it is
+// only retried if it failed to send. Failing to build a request (e.g. from
+// BearerToken or RequestHeaders) is not retriable. After a retriable failure,
+// the request fails over immediately to a healthy URL that has not yet been
+// tried, if one exists; otherwise, the retry happens after backing off.
+// Failing over does not count as a retry: every URL is tried before a request
+// fails, no matter the number of retries.
+//
+// Before retries were configurable, a request was only ever sent to the next
+// URL after a transport error and was never retried after backing off; the
+// default of 0 keeps that behavior, other than also failing over on retriable
+// responses.
+func RequestRetries(n int) Opt {
+	return opt{func(cl *Client) { cl.retries = n }}
+}
+
+// RetryBackoffFn sets the backoff strategy for how long to backoff for a given
+// amount of retries, overriding the default jittery exponential backoff that
+// ranges from 100ms min to 2s max. If the registry responds with a
+// Retry-After header (in seconds), the header is used instead, but never for
+// longer than the backoff for the last retry. Backoffs never wait past the
+// request's context deadline.
+func RetryBackoffFn(backoff func(int) time.Duration) Opt {
+	return opt{func(cl *Client) { cl.retryBackoff = backoff }}
+}
+
+// RequestTimeout sets an overall deadline for every call, including all
+// retries and backoffs, overriding the default of no overall deadline. The
+// http client timeout (5s by default) still applies to each individual
+// attempt.
+func RequestTimeout(timeout time.Duration) Opt {
+	return opt{func(cl *Client) { cl.timeout = timeout }}
+}
+
+// CircuitBreaker sets how many consecutive failures to talk to a URL
+// (transport errors, not error responses) mark it as unhealthy, and how long
+// until an unhealthy URL is probed, overriding the default of 3 failures and
+// 10s.
+//
+// Requests avoid unhealthy URLs while any URL is healthy. Once the probe
+// interval passes, one request is allowed to the unhealthy URL as a probe: if
+// it succeeds, the URL is healthy again; otherwise, it is probed again after
+// another interval. If every URL is unhealthy, requests still go to them.
+// Using failures <= 0 disables circuit breaking.
+func CircuitBreaker(failures int, probeInterval time.Duration) Opt {
+	return opt{func(cl *Client) { cl.breakFailures, cl.breakInterval = failures, probeInterval }}
+}
+
+// OnRequest adds a function that is called after every attempt of every
+// request with the outcome of the attempt, which can be used for metrics or
+// logging. The function is called synchronously and should not block.
+func OnRequest(fn func(RequestOutcome)) Opt {
+	return opt{func(cl *Client) { cl.onRequest = append(cl.onRequest, fn) }}
+}
@@ -157,3 +157,97 @@ func (m *Mode) UnmarshalText(text []byte) error {
 	}
 	return nil
 }
+
+// ExporterContextType as an enum representing how a schema exporter maps
+// exported subjects into contexts in the destination registry.
+type ExporterContextType int
+
+const (
+	// ExporterContextAuto exports subjects into an automatically created
+	// context in the destination, named after the source cluster.
+	ExporterContextAuto ExporterContextType = iota
+	// ExporterContextCustom exports subjects into the context named in the
+	// exporter's Context field.
+	ExporterContextCustom
+	// ExporterContextNone exports subjects into the default context.
+	ExporterContextNone
+)
+
+func (t ExporterContextType) String() string {
+	switch t {
+	case ExporterContextAuto:
+		return "AUTO"
+	case ExporterContextCustom:
+		return "CUSTOM"
+	case ExporterContextNone:
+		return "NONE"
+	default:
+		return ""
+	}
+}
+
+func (t ExporterContextType) MarshalText() ([]byte, error) {
+	s := t.String()
+	if s == "" {
+		return nil, fmt.Errorf("unknown exporter context type %d", t)
+	}
+	return []byte(s), nil
+}
+
+func (t *ExporterContextType) UnmarshalText(text []byte) error {
+	switch s := strings.ToUpper(string(text)); s {
+	default:
+		return fmt.Errorf("unknown exporter context type %q", s)
+	case "", "AUTO":
+		*t = ExporterContextAuto
+	case "CUSTOM":
+		*t = ExporterContextCustom
+	case "NONE":
+		*t = ExporterContextNone
+	}
+	return nil
+}
+
+// ExporterState as an enum representing the state of a schema exporter.
+type ExporterState int
+
+const (
+	ExporterStarting ExporterState = iota
+	ExporterRunning
+	ExporterPaused
+)
+
+func (s ExporterState) String() string {
+	switch s {
+	case ExporterStarting:
+		return "STARTING"
+	case ExporterRunning:
+		return "RUNNING"
+	case ExporterPaused:
+		return "PAUSED"
+	default:
+		return ""
+	}
+}
+
+func (s ExporterState) MarshalText() ([]byte, error) {
+	str := s.String()
+	if str == "" {
+		return nil, fmt.Errorf("unknown exporter state %d", s)
+	}
+	return []byte(str), nil
+}
+
+func (s *ExporterState) UnmarshalText(text []byte) error {
+	switch str := strings.ToUpper(string(text)); str {
+	default:
+		return fmt.Errorf("unknown exporter state %q", str)
+	case "STARTING":
+		*s = ExporterStarting
+	case "RUNNING":
+		*s = ExporterRunning
+	case "PAUSED":
+		*s = ExporterPaused
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package sr
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+)
+
+// This file implements the schema exporter endpoints, which are used for
+// schema linking: continuously exporting schemas from one registry to
+// another.
+
+// Exporter is a schema exporter, which exports schemas in the given subjects
+// to a destination registry.
+type Exporter struct {
+	// Name is the name of the exporter.
+	Name string `json:"name"`
+
+	// ContextType is how exported subjects are mapped into contexts in the
+	// destination registry.
+	ContextType ExporterContextType `json:"contextType,omitempty"`
+
+	// Context is the destination context, if ContextType is
+	// ExporterContextCustom.
+	Context string `json:"context,omitempty"`
+
+	// Subjects are the subjects to export. A subject can be a context
+	// qualified wildcard, such as ":.ctx:*".
+	Subjects []string `json:"subjects,omitempty"`
+
+	// SubjectRenameFormat, if non-empty, renames subjects in the
+	// destination. The format must contain "${subject}", which is replaced
+	// with the source subject; for example, "dc1-${subject}".
+	SubjectRenameFormat string `json:"subjectRenameFormat,omitempty"`
+
+	// Config is the configuration for the exporter, which must at least
+	// contain "schema.registry.url" for the destination registry.
+	Config map[string]string `json:"config,omitempty"`
+}
+
+// ExporterStatus is the status of a schema exporter.
+type ExporterStatus struct {
+	// Name is the name of the exporter.
+	Name string `json:"name"`
+	// State is the current state of the exporter.
+	State ExporterState `json:"state"`
+	// Offset is the offset of the last exported schema in the source
+	// registry's schemas topic.
+	Offset int64 `json:"offset"`
+	// Timestamp is the time of the last export, in milliseconds since the
+	// unix epoch.
+	Timestamp int64 `json:"ts"`
+	// Trace is the error trace if the exporter is failing.
+	Trace string `json:"trace,omitempty"`
+}
+
+func pathExporter(name string) string { return fmt.Sprintf("/exporters/%s", url.PathEscape(name)) }
+
+// exporterName is the response for endpoints that return the exporter name.
+type exporterName struct {
+	Name string `json:"name"`
+}
+
+// Exporters returns the names of all schema exporters.
+func (cl *Client) Exporters(ctx context.Context) ([]string, error) {
+	// GET /exporters
+	var names []string
+	defer func() { sort.Strings(names) }()
+	return names, cl.get(ctx, "/exporters", &names)
+}
+
+// CreateExporter creates a new schema exporter, which begins exporting
+// schemas immediately.
+func (cl *Client) CreateExporter(ctx context.Context, e Exporter) error {
+	// POST /exporters
+	return cl.post(ctx, "/exporters", e, new(exporterName))
+}
+
+// DescribeExporter returns the exporter with the given name.
+func (cl *Client) DescribeExporter(ctx context.Context, name string) (Exporter, error) {
+	// GET /exporters/{name}
+	var e Exporter
+	return e, cl.get(ctx, pathExporter(name), &e)
+}
+
+// UpdateExporter updates the exporter with the name in e. Zero fields in e are
+// left unchanged. The exporter must be paused before it can be updated.
+func (cl *Client) UpdateExporter(ctx context.Context, e Exporter) error {
+	// PUT /exporters/{name}
+	return cl.put(ctx, pathExporter(e.Name), e, new(exporterName))
+}
+
+// DeleteExporter deletes the exporter with the given name. The exporter must
+// be paused before it can be deleted.
+func (cl *Client) DeleteExporter(ctx context.Context, name string) error {
+	// DELETE /exporters/{name}
+	return cl.delete(ctx, pathExporter(name), nil)
+}
+
+// PauseExporter pauses the exporter with the given name.
+func (cl *Client) PauseExporter(ctx context.Context, name string) error {
+	// PUT /exporters/{name}/pause
+	return cl.put(ctx, pathExporter(name)+"/pause", nil, new(exporterName))
+}
+
+// ResumeExporter resumes the paused exporter with the given name.
+func (cl *Client) ResumeExporter(ctx context.Context, name string) error {
+	// PUT /exporters/{name}/resume
+	return cl.put(ctx, pathExporter(name)+"/resume", nil, new(exporterName))
+}
+
+// ResetExporter resets the offset of the paused exporter with the given name,
+// causing it to export all schemas again when resumed.
+func (cl *Client) ResetExporter(ctx context.Context, name string) error {
+	// PUT /exporters/{name}/reset
+	return cl.put(ctx, pathExporter(name)+"/reset", nil, new(exporterName))
+}
+
+// ExporterStatus returns the status of the exporter with the given name.
+func (cl *Client) ExporterStatus(ctx context.Context, name string) (ExporterStatus, error) {
+	// GET /exporters/{name}/status
+	var s ExporterStatus
+	return s, cl.get(ctx, pathExporter(name)+"/status", &s)
+}
+
+// ExporterConfig returns the configuration of the exporter with the given
+// name.
+func (cl *Client) ExporterConfig(ctx context.Context, name string) (map[string]string, error) {
+	// GET /exporters/{name}/config
+	var config map[string]string
+	return config, cl.get(ctx, pathExporter(name)+"/config", &config)
+}
+
+// SetExporterConfig updates the configuration of the exporter with the given
+// name. Keys in config are added or replaced; other keys are left unchanged.
+// The exporter must be paused before its config can be updated.
+func (cl *Client) SetExporterConfig(ctx context.Context, name string, config map[string]string) error {
+	// PUT /exporters/{name}/config
+	return cl.put(ctx, pathExporter(name)+"/config", config, new(exporterName))
+}
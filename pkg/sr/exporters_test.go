@@ -0,0 +1,106 @@
+package sr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestContextSubject(t *testing.T) {
+	for _, test := range []struct {
+		context, subject, qualified string
+	}{
+		{"", "foo", "foo"},
+		{".", "foo", "foo"},
+		{".prod", "foo", ":.prod:foo"},
+		{"prod", "foo", ":.prod:foo"},
+	} {
+		if got := ContextSubject(test.context, test.subject); got != test.qualified {
+			t.Errorf("ContextSubject(%q, %q): got %q != exp %q", test.context, test.subject, got, test.qualified)
+		}
+	}
+	for _, test := range []struct {
+		qualified, context, subject string
+	}{
+		{"foo", ".", "foo"},
+		{":.prod:foo", ".prod", "foo"},
+		{":.prod:a:b", ".prod", "a:b"},
+		{":.:foo", ".", "foo"},
+	} {
+		if context, subject := SplitContextSubject(test.qualified); context != test.context || subject != test.subject {
+			t.Errorf("SplitContextSubject(%q): got %q, %q != exp %q, %q", test.qualified, context, subject, test.context, test.subject)
+		}
+	}
+}
+
+func TestExporters(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Method+" "+r.URL.RequestURI())
+		switch r.URL.Path {
+		case "/exporters/ex":
+			if r.Method == http.MethodGet {
+				w.Write([]byte(`{"name":"ex","contextType":"CUSTOM","context":"dst","subjects":[":.src:*"],"config":{"schema.registry.url":"http://dst"}}`))
+				return
+			}
+			w.Write([]byte(`{"name":"ex"}`))
+		case "/exporters/ex/status":
+			w.Write([]byte(`{"name":"ex","state":"PAUSED","offset":9,"ts":100}`))
+		case "/schemas":
+			w.Write([]byte(`[{"subject":"b","version":1,"id":2,"schema":"\"int\""},{"subject":"a","version":1,"id":1,"schema":"\"int\""}]`))
+		default:
+			w.Write([]byte(`{"name":"ex"}`))
+		}
+	}))
+	defer srv.Close()
+	cl, err := NewClient(URLs(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	exp := Exporter{
+		Name:        "ex",
+		ContextType: ExporterContextCustom,
+		Context:     "dst",
+		Subjects:    []string{":.src:*"},
+		Config:      map[string]string{"schema.registry.url": "http://dst"},
+	}
+	if err := cl.CreateExporter(ctx, exp); err != nil {
+		t.Fatal(err)
+	}
+	if e, err := cl.DescribeExporter(ctx, "ex"); err != nil || !reflect.DeepEqual(e, exp) {
+		t.Errorf("got %+v (err %v) != exp %+v", e, err, exp)
+	}
+	if err := cl.PauseExporter(ctx, "ex"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := cl.ExporterStatus(ctx, "ex"); err != nil || s.State != ExporterPaused || s.Offset != 9 {
+		t.Errorf("got %+v (err %v) != exp paused at offset 9", s, err)
+	}
+	schemas, err := cl.ListSchemas(ctx, ":.src:", HideDeleted, LatestOnly)
+	if err != nil || len(schemas) != 2 || schemas[0].Subject != "a" {
+		t.Errorf("got %+v (err %v) != exp sorted schemas", schemas, err)
+	}
+
+	expReqs := []string{
+		"POST /exporters",
+		"GET /exporters/ex",
+		"PUT /exporters/ex/pause",
+		"GET /exporters/ex/status",
+		"GET /schemas?latestOnly=true&subjectPrefix=%3A.src%3A",
+	}
+	if !reflect.DeepEqual(got, expReqs) {
+		t.Errorf("got requests %v != exp %v", got, expReqs)
+	}
+
+	var body map[string]interface{}
+	b, _ := json.Marshal(Exporter{Name: "ex"})
+	json.Unmarshal(b, &body)
+	if _, ok := body["contextType"]; ok {
+		t.Error("unexpected contextType in update of only the name")
+	}
+}
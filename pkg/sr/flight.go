@@ -0,0 +1,65 @@
+package sr
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// flights coalesces concurrent calls with the same key into one call. The
+// zero value is ready to use.
+type flights struct {
+	mu       sync.Mutex
+	inflight map[interface{}]*flight
+}
+
+type flight struct {
+	done chan struct{}
+	v    interface{}
+	err  error
+}
+
+// do calls fn for key, or waits for and shares the result of a call for key
+// that is already in flight.
+//
+// If the call we waited on failed only because the caller that issued it had
+// its context canceled, we issue the call again with our own context rather
+// than failing with someone else's cancelation.
+func (fs *flights) do(ctx context.Context, key interface{}, fn func(context.Context) (interface{}, error)) (interface{}, error) {
+	for {
+		fs.mu.Lock()
+		if fs.inflight == nil {
+			fs.inflight = make(map[interface{}]*flight)
+		}
+		f, waiting := fs.inflight[key]
+		if !waiting {
+			f = &flight{done: make(chan struct{})}
+			fs.inflight[key] = f
+		}
+		fs.mu.Unlock()
+
+		if !waiting {
+			f.v, f.err = fn(ctx)
+
+			fs.mu.Lock()
+			delete(fs.inflight, key)
+			fs.mu.Unlock()
+			close(f.done)
+			return f.v, f.err
+		}
+
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if isContextErr(f.err) && ctx.Err() == nil {
+			continue
+		}
+		return f.v, f.err
+	}
+}
+
+func isContextErr(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -0,0 +1,166 @@
+package sr
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// RequestOutcome describes one attempt of a request to one schema registry
+// URL, and is passed to functions registered with OnRequest.
+type RequestOutcome struct {
+	// Method is the http method of the request.
+	Method string
+	// URL is the full URL that was requested.
+	URL string
+	// Attempt is the zero based attempt number of this request; a request
+	// that is retried twice has attempts 0, 1, and 2.
+	Attempt int
+	// Latency is how long the attempt took, from issuing the request to
+	// reading the full response.
+	Latency time.Duration
+	// StatusCode is the http status code of the response, or 0 if the
+	// registry did not respond.
+	StatusCode int
+	// Err is the error for the attempt, if any. This is a *ResponseError
+	// if the registry responded with an error.
+	Err error
+	// Retriable is whether the attempt failed with a retriable error: a
+	// transport error, or for requests other than POSTs, a 5xx status or
+	// 429 Too Many Requests. A retriable attempt is retried unless
+	// retries are exhausted.
+	Retriable bool
+}
+
+func defaultRetryBackoff() func(int) time.Duration {
+	var rngMu sync.Mutex
+	rng := rand.New(rand.NewSource(time.Now().UnixNano()))
+	return func(fails int) time.Duration {
+		const (
+			min = 100 * time.Millisecond
+			max = 2 * time.Second
+		)
+		if fails <= 0 {
+			return min
+		}
+		if fails > 10 {
+			return max
+		}
+
+		backoff := min * time.Duration(1<<(fails-1))
+
+		rngMu.Lock()
+		jitter := 0.8 + 0.4*rng.Float64()
+		rngMu.Unlock()
+
+		backoff = time.Duration(float64(backoff) * jitter)
+
+		if backoff > max {
+			return max
+		}
+		return backoff
+	}
+}
+
+// urlHealth tracks the health of each URL, acting as a circuit breaker: after
+// enough consecutive failures, a URL is considered unhealthy and is avoided.
+// Once the probe interval passes, one request is allowed through to the URL
+// as a probe; if it succeeds, the URL is healthy again, and otherwise it
+// remains unhealthy for another interval.
+type urlHealth struct {
+	failures int
+	interval time.Duration
+
+	mu     sync.Mutex
+	states []urlState
+}
+
+type urlState struct {
+	consecutive int
+	openUntil   time.Time // zero if healthy
+	probing     bool
+}
+
+func newURLHealth(n, failures int, interval time.Duration) *urlHealth {
+	return &urlHealth{
+		failures: failures,
+		interval: interval,
+		states:   make([]urlState, n),
+	}
+}
+
+// usable returns whether a URL is healthy or can be probed, and whether using
+// it would be a probe. This must be called with the mutex held.
+func (h *urlHealth) usable(i int, now time.Time) (usable, probe bool) {
+	s := &h.states[i]
+	switch {
+	case s.openUntil.IsZero():
+		return true, false
+	case !s.probing && !now.Before(s.openUntil):
+		return true, true
+	default:
+		return false, false
+	}
+}
+
+// pick returns the URL to use for the next attempt of a request, preferring
+// usable URLs that have not been tried in this request, then unusable URLs
+// that have not been tried, then usable URLs, in configured order. If the URL
+// is picked as a probe, the caller must record the outcome.
+func (h *urlHealth) pick(tried []bool) (int, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	best, bestScore, bestProbe := 0, 4, false
+	for i := range h.states {
+		usable, probe := h.usable(i, now)
+		score := 0
+		if !usable {
+			score++
+		}
+		if tried[i] {
+			score += 2
+		}
+		if score < bestScore {
+			best, bestScore, bestProbe = i, score, probe
+		}
+	}
+	if bestProbe {
+		h.states[best].probing = true
+	}
+	return best, bestProbe
+}
+
+// untriedHealthy returns whether any usable URL has not been tried.
+func (h *urlHealth) untriedHealthy(tried []bool) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	now := time.Now()
+	for i := range h.states {
+		if usable, _ := h.usable(i, now); usable && !tried[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// record records the outcome of an attempt to a URL.
+func (h *urlHealth) record(i int, probe, failed bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	s := &h.states[i]
+	if probe {
+		s.probing = false
+	}
+	if !failed {
+		*s = urlState{}
+		return
+	}
+	s.consecutive++
+	if h.failures > 0 && s.consecutive >= h.failures {
+		s.openUntil = time.Now().Add(h.interval)
+	}
+}
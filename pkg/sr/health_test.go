@@ -0,0 +1,272 @@
+package sr
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetryAndCircuitBreaker(t *testing.T) {
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler) // closes the connection without a response
+	}))
+	bad.Config.ErrorLog = log.New(io.Discard, "", 0)
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer good.Close()
+
+	var (
+		mu       sync.Mutex
+		outcomes []string
+	)
+	cl, err := NewClient(
+		URLs(bad.URL, good.URL),
+		CircuitBreaker(2, 50*time.Millisecond),
+		RetryBackoffFn(func(int) time.Duration { return 0 }),
+		OnRequest(func(o RequestOutcome) {
+			mu.Lock()
+			defer mu.Unlock()
+			which := "good"
+			if strings.HasPrefix(o.URL, bad.URL) {
+				which = "bad"
+			}
+			outcomes = append(outcomes, which)
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	call := func() []string {
+		mu.Lock()
+		outcomes = nil
+		mu.Unlock()
+		if _, err := cl.Subjects(context.Background(), HideDeleted); err != nil {
+			t.Fatalf("unexpected err: %v", err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return outcomes
+	}
+
+	for i, exp := range []string{
+		"bad good", // first failure on bad
+		"bad good", // second failure on bad opens the circuit
+		"good",     // bad is avoided
+	} {
+		if got := strings.Join(call(), " "); got != exp {
+			t.Errorf("call %d: got attempts %q != exp %q", i, got, exp)
+		}
+	}
+	time.Sleep(60 * time.Millisecond)
+	if got := strings.Join(call(), " "); got != "bad good" {
+		t.Errorf("got attempts %q != exp bad probe then good", got)
+	}
+	if got := strings.Join(call(), " "); got != "good" {
+		t.Errorf("got attempts %q != exp good after failed probe", got)
+	}
+}
+
+func TestFailoverAllURLs(t *testing.T) {
+	var reqs int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error_code":50301,"message":"unavailable"}`))
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer good.Close()
+
+	urls := []string{bad.URL, bad.URL, bad.URL, bad.URL, bad.URL, good.URL}
+	cl, err := NewClient(URLs(urls...), RequestRetries(0), CircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.Subjects(context.Background(), HideDeleted); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if n := atomic.LoadInt32(&reqs); n != 5 {
+		t.Errorf("got %d bad requests != exp 5", n)
+	}
+}
+
+func TestRequestBuildErrorsNotRetried(t *testing.T) {
+	var reqs, calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&reqs, 1)
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer srv.Close()
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer other.Close()
+
+	headerErr := errors.New("no headers")
+	cl, err := NewClient(
+		URLs(srv.URL, other.URL),
+		CircuitBreaker(1, time.Minute),
+		RequestHeaders(func(context.Context, http.Header) error {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return headerErr
+			}
+			return nil
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.Subjects(context.Background(), HideDeleted); !errors.Is(err, headerErr) {
+		t.Errorf("got err %v != exp header err", err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("got %d header calls != exp 1", n)
+	}
+	// The first URL must not have been marked unhealthy.
+	if _, err := cl.Subjects(context.Background(), HideDeleted); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if n := atomic.LoadInt32(&reqs); n != 1 {
+		t.Errorf("got %d requests != exp 1", n)
+	}
+}
+
+func TestRetryTooManyRequests(t *testing.T) {
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&reqs, 1) <= 2 {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error_code":429,"message":"slow down"}`))
+			return
+		}
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(URLs(srv.URL), RequestRetries(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cl.Subjects(context.Background(), HideDeleted); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if n := atomic.LoadInt32(&reqs); n != 3 {
+		t.Errorf("got %d requests != exp 3", n)
+	}
+
+	atomic.StoreInt32(&reqs, 0)
+	cl, err = NewClient(URLs(srv.URL), RequestRetries(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var re *ResponseError
+	if _, err := cl.Subjects(context.Background(), HideDeleted); !errors.As(err, &re) || re.ErrorCode != 429 {
+		t.Errorf("got err %v != exp 429 after exhausting retries", err)
+	}
+}
+
+func TestRetryAfterCapped(t *testing.T) {
+	var reqs int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&reqs, 1) == 1 {
+			w.Header().Set("Retry-After", "86400")
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"error_code":429,"message":"slow down"}`))
+			return
+		}
+		w.Write([]byte(`["foo"]`))
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(
+		URLs(srv.URL),
+		RequestRetries(1),
+		RetryBackoffFn(func(int) time.Duration { return 10 * time.Millisecond }),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := cl.Subjects(context.Background(), HideDeleted); err != nil {
+		t.Errorf("unexpected err: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call took %v, exp Retry-After capped to the backoff", elapsed)
+	}
+}
+
+func TestErrorResponses(t *testing.T) {
+	var bads int32
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&bads, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"error_code":50301,"message":"unavailable"}`))
+	}))
+	defer bad.Close()
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":1}`))
+	}))
+	defer good.Close()
+
+	cl, err := NewClient(URLs(bad.URL, good.URL), RequestRetries(3), CircuitBreaker(1, time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	// A GET fails over on a 5xx response, but the response does not
+	// mark the URL unhealthy: the next GET tries it first again.
+	for i := 0; i < 2; i++ {
+		if _, err := cl.SchemaByID(ctx, 1); err != nil {
+			t.Errorf("unexpected err: %v", err)
+		}
+		if n := atomic.LoadInt32(&bads); n != int32(i+1) {
+			t.Errorf("GET %d: got %d bad requests != exp %d", i, n, i+1)
+		}
+	}
+
+	// A POST that reached the registry is not retried.
+	var re *ResponseError
+	if _, err := cl.CreateSchema(ctx, "foo", Schema{Schema: `"int"`}); !errors.As(err, &re) || re.ErrorCode != 50301 {
+		t.Errorf("got err %v != exp 50301", err)
+	}
+	if n := atomic.LoadInt32(&bads); n != 3 {
+		t.Errorf("got %d bad requests != exp 3 after POST", n)
+	}
+}
+
+func TestRequestTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.Write([]byte(`[]`))
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(URLs(srv.URL), RequestTimeout(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := cl.Subjects(context.Background(), HideDeleted); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got err %v != exp deadline exceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("call took %v, longer than the timeout allows", elapsed)
+	}
+}
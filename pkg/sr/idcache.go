@@ -0,0 +1,52 @@
+package sr
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+)
+
+// idCache caches values parsed from schemas by schema ID. Concurrent lookups
+// of the same missing ID share one fetch, and failed fetches are not cached.
+type idCache struct {
+	ids atomic.Value // map[int]interface{}
+
+	mu      sync.Mutex // serializes storing new IDs
+	flights flights
+}
+
+func (c *idCache) loadIDs() map[int]interface{} {
+	ids := c.ids.Load()
+	if ids == nil {
+		return nil
+	}
+	return ids.(map[int]interface{})
+}
+
+// get returns the cached value for id, or calls fetch to fetch it.
+func (c *idCache) get(ctx context.Context, id int, fetch func(context.Context, int) (interface{}, error)) (interface{}, error) {
+	if v, ok := c.loadIDs()[id]; ok {
+		return v, nil
+	}
+	return c.flights.do(ctx, id, func(ctx context.Context) (interface{}, error) {
+		// A fetch for this ID may have finished just before we
+		// started ours.
+		if v, ok := c.loadIDs()[id]; ok {
+			return v, nil
+		}
+		v, err := fetch(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		dup := make(map[int]interface{})
+		for k, v := range c.loadIDs() {
+			dup[k] = v
+		}
+		dup[id] = v
+		c.ids.Store(dup)
+		return v, nil
+	})
+}
@@ -0,0 +1,866 @@
+package sr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// This file contains a dependency free JSON Schema validator. It supports the
+// validation keywords of draft-04 through draft 2020-12 that are commonly used
+// with the schema registry:
+//
+//     type, enum, const
+//     properties, patternProperties, additionalProperties, required,
+//     propertyNames, minProperties, maxProperties, dependencies,
+//     dependentRequired, dependentSchemas
+//     items, prefixItems, additionalItems, contains, minItems, maxItems,
+//     uniqueItems
+//     minimum, maximum, exclusiveMinimum, exclusiveMaximum, multipleOf
+//     minLength, maxLength, pattern
+//     allOf, anyOf, oneOf, not, if, then, else
+//     $ref, definitions, $defs
+//
+// Annotation keywords (e.g. title, description, format) are ignored. A $ref
+// can point within the same document with a JSON pointer fragment, or to a
+// referenced schema by its reference name, optionally followed by a fragment.
+// Schemas that can apply themselves to the same value without descending into
+// it, such as {"$ref":"#"}, fail to compile rather than recursing forever.
+
+// ValidationError is returned when a JSON value does not validate against its
+// JSON schema.
+type ValidationError struct {
+	// Path is the JSON pointer to the value that failed validation, for
+	// example "/items/0/name". The path is empty if the top level value
+	// failed validation.
+	Path string
+	// Keyword is the schema keyword that failed, e.g. "required".
+	Keyword string
+	// Message describes the failure.
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	path := e.Path
+	if path == "" {
+		path = "(root)"
+	}
+	return fmt.Sprintf("json schema validation failed at %s: %s: %s", path, e.Keyword, e.Message)
+}
+
+// jsonSchema is a compiled JSON schema.
+type jsonSchema struct {
+	always *bool // for true and false schemas
+
+	ref *jsonSchema
+
+	types      []string
+	enum       []interface{}
+	hasConst   bool
+	constValue interface{}
+
+	properties           map[string]*jsonSchema
+	patternProperties    []jsonPatternSchema
+	additionalProperties *jsonSchema
+	required             []string
+	propertyNames        *jsonSchema
+	minProperties        int
+	maxProperties        int // -1 if unset
+	dependentRequired    map[string][]string
+	dependentSchemas     map[string]*jsonSchema
+
+	items       *jsonSchema   // applies to all items past prefixItems
+	prefixItems []*jsonSchema // tuple validation
+	contains    *jsonSchema
+	minItems    int
+	maxItems    int // -1 if unset
+	uniqueItems bool
+
+	minimum          *big.Rat
+	maximum          *big.Rat
+	exclusiveMinimum *big.Rat
+	exclusiveMaximum *big.Rat
+	multipleOf       *big.Rat
+
+	minLength int
+	maxLength int // -1 if unset
+	pattern   *regexp.Regexp
+
+	allOf, anyOf, oneOf []*jsonSchema
+	not                 *jsonSchema
+	ifs, thens, elses   *jsonSchema
+}
+
+type jsonPatternSchema struct {
+	re *regexp.Regexp
+	s  *jsonSchema
+}
+
+// decodeJSON decodes JSON text into generic values, using json.Number for
+// numbers so that we do not lose precision.
+func decodeJSON(b []byte) (interface{}, error) {
+	dec := json.NewDecoder(strings.NewReader(string(b)))
+	dec.UseNumber()
+	var v interface{}
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	if dec.More() {
+		return nil, errors.New("invalid trailing data after json value")
+	}
+	return v, nil
+}
+
+// jsonCompiler compiles schemas, resolving references across documents.
+type jsonCompiler struct {
+	docs     map[string]interface{} // raw documents by name; the root is ""
+	compiled map[string]*jsonSchema // by document name and pointer
+}
+
+// compileJSONSchema compiles the root schema text. refs contains the text of
+// referenced schemas by reference name.
+func compileJSONSchema(text string, refs map[string]string) (*jsonSchema, error) {
+	c := &jsonCompiler{
+		docs:     make(map[string]interface{}),
+		compiled: make(map[string]*jsonSchema),
+	}
+	for name, text := range refs {
+		doc, err := decodeJSON([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("reference %q is not valid json: %w", name, err)
+		}
+		c.docs[name] = doc
+	}
+	doc, err := decodeJSON([]byte(text))
+	if err != nil {
+		return nil, fmt.Errorf("schema is not valid json: %w", err)
+	}
+	c.docs[""] = doc
+	s, err := c.compileAt("", "")
+	if err != nil {
+		return nil, err
+	}
+	if err := checkJSONCycles(s); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// checkJSONCycles returns an error if any schema reachable from root can
+// reach itself only through keywords that apply to the same value, such as
+// $ref or allOf. Validating against such a schema would never terminate.
+// Cycles through keywords that descend into the value (properties, items, ...)
+// are fine, since the value is finite.
+func checkJSONCycles(root *jsonSchema) error {
+	// We first collect every reachable schema, and then look for a cycle
+	// in the graph of same value edges.
+	var (
+		all  []*jsonSchema
+		seen = map[*jsonSchema]bool{root: true}
+	)
+	for queue := []*jsonSchema{root}; len(queue) > 0; {
+		s := queue[0]
+		queue = queue[1:]
+		all = append(all, s)
+		for _, sub := range append(s.inPlace(), s.descending()...) {
+			if sub != nil && !seen[sub] {
+				seen[sub] = true
+				queue = append(queue, sub)
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[*jsonSchema]uint8, len(all))
+	var visit func(*jsonSchema) bool
+	visit = func(s *jsonSchema) bool {
+		switch state[s] {
+		case visiting:
+			return false
+		case done:
+			return true
+		}
+		state[s] = visiting
+		for _, sub := range s.inPlace() {
+			if sub != nil && !visit(sub) {
+				return false
+			}
+		}
+		state[s] = done
+		return true
+	}
+	for _, s := range all {
+		if !visit(s) {
+			return errors.New("schema has a $ref cycle that does not descend into the value being validated")
+		}
+	}
+	return nil
+}
+
+// inPlace returns the subschemas that validate the same value as s.
+func (s *jsonSchema) inPlace() []*jsonSchema {
+	subs := []*jsonSchema{s.ref, s.not, s.ifs, s.thens, s.elses}
+	subs = append(subs, s.allOf...)
+	subs = append(subs, s.anyOf...)
+	subs = append(subs, s.oneOf...)
+	for _, dep := range s.dependentSchemas {
+		subs = append(subs, dep)
+	}
+	return subs
+}
+
+// descending returns the subschemas that validate values within the value
+// that s validates.
+func (s *jsonSchema) descending() []*jsonSchema {
+	subs := []*jsonSchema{s.additionalProperties, s.propertyNames, s.items, s.contains}
+	subs = append(subs, s.prefixItems...)
+	for _, p := range s.properties {
+		subs = append(subs, p)
+	}
+	for _, p := range s.patternProperties {
+		subs = append(subs, p.s)
+	}
+	return subs
+}
+
+// compileAt compiles the schema at the JSON pointer within a document.
+func (c *jsonCompiler) compileAt(doc, ptr string) (*jsonSchema, error) {
+	key := doc + "#" + ptr
+	if s, ok := c.compiled[key]; ok {
+		return s, nil
+	}
+	raw, ok := c.docs[doc]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema reference %q", doc)
+	}
+	raw, err := jsonPointer(raw, ptr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid reference %q: %w", key, err)
+	}
+	// We store the schema before compiling it so that recursive
+	// references resolve to it.
+	s := new(jsonSchema)
+	c.compiled[key] = s
+	if err := c.compile(s, doc, ptr, raw); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// jsonPointer evaluates an RFC 6901 JSON pointer against v.
+func jsonPointer(v interface{}, ptr string) (interface{}, error) {
+	if ptr == "" {
+		return v, nil
+	}
+	if !strings.HasPrefix(ptr, "/") {
+		return nil, fmt.Errorf("pointer %q does not begin with /", ptr)
+	}
+	for _, tok := range strings.Split(ptr[1:], "/") {
+		tok = strings.ReplaceAll(strings.ReplaceAll(tok, "~1", "/"), "~0", "~")
+		switch t := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = t[tok]; !ok {
+				return nil, fmt.Errorf("pointer %q: missing key %q", ptr, tok)
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(tok)
+			if err != nil || idx < 0 || idx >= len(t) {
+				return nil, fmt.Errorf("pointer %q: invalid index %q", ptr, tok)
+			}
+			v = t[idx]
+		default:
+			return nil, fmt.Errorf("pointer %q: cannot index into %T", ptr, v)
+		}
+	}
+	return v, nil
+}
+
+func escapePointer(tok string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(tok, "~", "~0"), "/", "~1")
+}
+
+// resolveRef returns the document and pointer that a $ref refers to.
+func (c *jsonCompiler) resolveRef(doc, ref string) (string, string, error) {
+	target, frag := ref, ""
+	if idx := strings.IndexByte(ref, '#'); idx >= 0 {
+		target, frag = ref[:idx], ref[idx+1:]
+	}
+	if frag != "" && !strings.HasPrefix(frag, "/") {
+		return "", "", fmt.Errorf("$ref %q: anchors are not supported", ref)
+	}
+	if target == "" {
+		return doc, frag, nil
+	}
+	if _, ok := c.docs[target]; ok {
+		return target, frag, nil
+	}
+	// References are commonly named by the final path component of the
+	// URL that is used in $ref.
+	base := target[strings.LastIndexByte(target, '/')+1:]
+	for name := range c.docs {
+		if name != "" && (name == base || name[strings.LastIndexByte(name, '/')+1:] == base) {
+			return name, frag, nil
+		}
+	}
+	return "", "", fmt.Errorf("$ref %q refers to an unknown schema", ref)
+}
+
+func (c *jsonCompiler) compile(s *jsonSchema, doc, ptr string, raw interface{}) error {
+	switch t := raw.(type) {
+	case bool:
+		s.always = &t
+		return nil
+	case map[string]interface{}:
+	default:
+		return fmt.Errorf("schema at %q must be an object or boolean", doc+"#"+ptr)
+	}
+	m := raw.(map[string]interface{})
+
+	var err error
+	sub := func(kw string) *jsonSchema {
+		if err != nil {
+			return nil
+		}
+		var s *jsonSchema
+		s, err = c.compileAt(doc, ptr+"/"+escapePointer(kw))
+		return s
+	}
+	subs := func(kw string) []*jsonSchema {
+		arr, ok := m[kw].([]interface{})
+		if !ok {
+			return nil
+		}
+		var ss []*jsonSchema
+		for i := range arr {
+			if err != nil {
+				return nil
+			}
+			var s *jsonSchema
+			s, err = c.compileAt(doc, fmt.Sprintf("%s/%s/%d", ptr, kw, i))
+			ss = append(ss, s)
+		}
+		return ss
+	}
+	subMap := func(kw string) map[string]*jsonSchema {
+		obj, ok := m[kw].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		ss := make(map[string]*jsonSchema, len(obj))
+		for k := range obj {
+			if err != nil {
+				return nil
+			}
+			ss[k], err = c.compileAt(doc, ptr+"/"+kw+"/"+escapePointer(k))
+		}
+		return ss
+	}
+	intKw := func(kw string, def int) int {
+		n, ok := m[kw].(json.Number)
+		if !ok {
+			return def
+		}
+		i, perr := strconv.Atoi(n.String())
+		if perr != nil && err == nil {
+			err = fmt.Errorf("schema keyword %q must be an integer", kw)
+		}
+		return i
+	}
+	ratKw := func(kw string) *big.Rat {
+		n, ok := m[kw].(json.Number)
+		if !ok {
+			return nil
+		}
+		r, ok := new(big.Rat).SetString(n.String())
+		if !ok && err == nil {
+			err = fmt.Errorf("schema keyword %q is an invalid number", kw)
+		}
+		return r
+	}
+
+	if ref, ok := m["$ref"].(string); ok {
+		refDoc, refPtr, rerr := c.resolveRef(doc, ref)
+		if rerr != nil {
+			return rerr
+		}
+		if s.ref, err = c.compileAt(refDoc, refPtr); err != nil {
+			return err
+		}
+	}
+
+	switch t := m["type"].(type) {
+	case string:
+		s.types = []string{t}
+	case []interface{}:
+		for _, e := range t {
+			if st, ok := e.(string); ok {
+				s.types = append(s.types, st)
+			}
+		}
+	}
+	s.enum, _ = m["enum"].([]interface{})
+	s.constValue, s.hasConst = m["const"]
+
+	if _, ok := m["properties"]; ok {
+		s.properties = subMap("properties")
+	}
+	if pp, ok := m["patternProperties"].(map[string]interface{}); ok {
+		var keys []string
+		for k := range pp {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			re, rerr := regexp.Compile(k)
+			if rerr != nil {
+				return fmt.Errorf("invalid patternProperties pattern %q: %w", k, rerr)
+			}
+			var ps *jsonSchema
+			if ps, err = c.compileAt(doc, ptr+"/patternProperties/"+escapePointer(k)); err != nil {
+				return err
+			}
+			s.patternProperties = append(s.patternProperties, jsonPatternSchema{re, ps})
+		}
+	}
+	if _, ok := m["additionalProperties"]; ok {
+		s.additionalProperties = sub("additionalProperties")
+	}
+	if req, ok := m["required"].([]interface{}); ok {
+		for _, r := range req {
+			if rs, ok := r.(string); ok {
+				s.required = append(s.required, rs)
+			}
+		}
+	}
+	if _, ok := m["propertyNames"]; ok {
+		s.propertyNames = sub("propertyNames")
+	}
+	s.minProperties = intKw("minProperties", 0)
+	s.maxProperties = intKw("maxProperties", -1)
+
+	// draft-04 through draft-07 "dependencies" are either a list of
+	// required properties or a schema; 2019-09 split the two.
+	addDependents := func(kw string) {
+		deps, ok := m[kw].(map[string]interface{})
+		if !ok {
+			return
+		}
+		for k, v := range deps {
+			if arr, ok := v.([]interface{}); ok {
+				if s.dependentRequired == nil {
+					s.dependentRequired = make(map[string][]string)
+				}
+				for _, e := range arr {
+					if es, ok := e.(string); ok {
+						s.dependentRequired[k] = append(s.dependentRequired[k], es)
+					}
+				}
+				continue
+			}
+			if s.dependentSchemas == nil {
+				s.dependentSchemas = make(map[string]*jsonSchema)
+			}
+			if err == nil {
+				s.dependentSchemas[k], err = c.compileAt(doc, ptr+"/"+kw+"/"+escapePointer(k))
+			}
+		}
+	}
+	addDependents("dependencies")
+	addDependents("dependentRequired")
+	addDependents("dependentSchemas")
+
+	// Before 2020-12, an "items" array is tuple validation, and
+	// "additionalItems" applies past the tuple. In 2020-12, tuples are
+	// "prefixItems", and "items" applies past the tuple.
+	if _, ok := m["prefixItems"]; ok {
+		s.prefixItems = subs("prefixItems")
+		if _, ok := m["items"]; ok {
+			s.items = sub("items")
+		}
+	} else if _, ok := m["items"].([]interface{}); ok {
+		s.prefixItems = subs("items")
+		if _, ok := m["additionalItems"]; ok {
+			s.items = sub("additionalItems")
+		}
+	} else if _, ok := m["items"]; ok {
+		s.items = sub("items")
+	}
+	if _, ok := m["contains"]; ok {
+		s.contains = sub("contains")
+	}
+	s.minItems = intKw("minItems", 0)
+	s.maxItems = intKw("maxItems", -1)
+	s.uniqueItems, _ = m["uniqueItems"].(bool)
+
+	s.minimum = ratKw("minimum")
+	s.maximum = ratKw("maximum")
+	s.exclusiveMinimum = ratKw("exclusiveMinimum")
+	s.exclusiveMaximum = ratKw("exclusiveMaximum")
+	// draft-04 exclusive bounds are booleans modifying minimum/maximum.
+	if excl, _ := m["exclusiveMinimum"].(bool); excl {
+		s.exclusiveMinimum, s.minimum = s.minimum, nil
+	}
+	if excl, _ := m["exclusiveMaximum"].(bool); excl {
+		s.exclusiveMaximum, s.maximum = s.maximum, nil
+	}
+	s.multipleOf = ratKw("multipleOf")
+	if s.multipleOf != nil && s.multipleOf.Sign() <= 0 {
+		return errors.New("schema keyword \"multipleOf\" must be positive")
+	}
+
+	s.minLength = intKw("minLength", 0)
+	s.maxLength = intKw("maxLength", -1)
+	if pattern, ok := m["pattern"].(string); ok {
+		var rerr error
+		if s.pattern, rerr = regexp.Compile(pattern); rerr != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, rerr)
+		}
+	}
+
+	s.allOf = subs("allOf")
+	s.anyOf = subs("anyOf")
+	s.oneOf = subs("oneOf")
+	if _, ok := m["not"]; ok {
+		s.not = sub("not")
+	}
+	if _, ok := m["if"]; ok {
+		s.ifs = sub("if")
+		if _, ok := m["then"]; ok {
+			s.thens = sub("then")
+		}
+		if _, ok := m["else"]; ok {
+			s.elses = sub("else")
+		}
+	}
+	return err
+}
+
+//////////////
+// VALIDATE //
+//////////////
+
+func jsonRat(n json.Number) (*big.Rat, bool) {
+	return new(big.Rat).SetString(n.String())
+}
+
+func jsonTypeOf(v interface{}) string {
+	switch t := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case json.Number:
+		if r, ok := jsonRat(t); ok && r.IsInt() {
+			return "integer"
+		}
+		return "number"
+	default:
+		return fmt.Sprintf("%T", v)
+	}
+}
+
+// jsonEqual returns whether two generic JSON values are equal, comparing
+// numbers by value.
+func jsonEqual(l, r interface{}) bool {
+	switch lt := l.(type) {
+	case json.Number:
+		rt, ok := r.(json.Number)
+		if !ok {
+			return false
+		}
+		lr, lok := jsonRat(lt)
+		rr, rok := jsonRat(rt)
+		return lok && rok && lr.Cmp(rr) == 0
+	case []interface{}:
+		rt, ok := r.([]interface{})
+		if !ok || len(lt) != len(rt) {
+			return false
+		}
+		for i := range lt {
+			if !jsonEqual(lt[i], rt[i]) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		rt, ok := r.(map[string]interface{})
+		if !ok || len(lt) != len(rt) {
+			return false
+		}
+		for k, lv := range lt {
+			rv, ok := rt[k]
+			if !ok || !jsonEqual(lv, rv) {
+				return false
+			}
+		}
+		return true
+	default:
+		return l == r
+	}
+}
+
+// validate validates v, which must be a generic JSON value decoded with
+// json.Number numbers, returning the first failure.
+func (s *jsonSchema) validate(v interface{}, path string) *ValidationError {
+	fail := func(kw, format string, args ...interface{}) *ValidationError {
+		return &ValidationError{Path: path, Keyword: kw, Message: fmt.Sprintf(format, args...)}
+	}
+
+	if s.always != nil {
+		if !*s.always {
+			return fail("false", "no value is allowed")
+		}
+		return nil
+	}
+	if s.ref != nil {
+		if err := s.ref.validate(v, path); err != nil {
+			return err
+		}
+	}
+
+	if len(s.types) > 0 {
+		typ := jsonTypeOf(v)
+		var ok bool
+		for _, want := range s.types {
+			ok = ok || want == typ || want == "number" && typ == "integer"
+		}
+		if !ok {
+			return fail("type", "expected %s, got %s", strings.Join(s.types, " or "), typ)
+		}
+	}
+	if s.enum != nil {
+		var ok bool
+		for _, e := range s.enum {
+			ok = ok || jsonEqual(e, v)
+		}
+		if !ok {
+			return fail("enum", "value is not one of the enumerated values")
+		}
+	}
+	if s.hasConst && !jsonEqual(s.constValue, v) {
+		return fail("const", "value does not equal the constant")
+	}
+
+	switch t := v.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(t, path, fail); err != nil {
+			return err
+		}
+	case []interface{}:
+		if err := s.validateArray(t, path, fail); err != nil {
+			return err
+		}
+	case json.Number:
+		if err := s.validateNumber(t, fail); err != nil {
+			return err
+		}
+	case string:
+		if n := utf8.RuneCountInString(t); n < s.minLength {
+			return fail("minLength", "length %d is less than %d", n, s.minLength)
+		} else if s.maxLength >= 0 && n > s.maxLength {
+			return fail("maxLength", "length %d is greater than %d", n, s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(t) {
+			return fail("pattern", "%q does not match pattern %q", t, s.pattern)
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if len(s.anyOf) > 0 {
+		var ok bool
+		for _, sub := range s.anyOf {
+			if sub.validate(v, path) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fail("anyOf", "value does not match any schema")
+		}
+	}
+	if len(s.oneOf) > 0 {
+		var matched int
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("oneOf", "value matches %d schemas rather than exactly one", matched)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fail("not", "value matches a schema that it must not match")
+	}
+	if s.ifs != nil {
+		if s.ifs.validate(v, path) == nil {
+			if s.thens != nil {
+				return s.thens.validate(v, path)
+			}
+		} else if s.elses != nil {
+			return s.elses.validate(v, path)
+		}
+	}
+	return nil
+}
+
+type jsonFailFn func(kw, format string, args ...interface{}) *ValidationError
+
+func (s *jsonSchema) validateObject(obj map[string]interface{}, path string, fail jsonFailFn) *ValidationError {
+	if n := len(obj); n < s.minProperties {
+		return fail("minProperties", "object has %d properties, fewer than %d", n, s.minProperties)
+	} else if s.maxProperties >= 0 && n > s.maxProperties {
+		return fail("maxProperties", "object has %d properties, more than %d", n, s.maxProperties)
+	}
+	for _, r := range s.required {
+		if _, ok := obj[r]; !ok {
+			return fail("required", "missing required property %q", r)
+		}
+	}
+
+	// We validate keys in sorted order so that errors are deterministic.
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := obj[k]
+		kpath := path + "/" + escapePointer(k)
+
+		if s.propertyNames != nil {
+			if err := s.propertyNames.validate(k, kpath); err != nil {
+				return fail("propertyNames", "property name %q is invalid: %s", k, err.Message)
+			}
+		}
+		if deps, ok := s.dependentRequired[k]; ok {
+			for _, d := range deps {
+				if _, ok := obj[d]; !ok {
+					return fail("dependentRequired", "property %q requires property %q", k, d)
+				}
+			}
+		}
+		if dep, ok := s.dependentSchemas[k]; ok {
+			if err := dep.validate(obj, path); err != nil {
+				return err
+			}
+		}
+
+		matched := false
+		if ps, ok := s.properties[k]; ok {
+			matched = true
+			if err := ps.validate(v, kpath); err != nil {
+				return err
+			}
+		}
+		for _, pp := range s.patternProperties {
+			if pp.re.MatchString(k) {
+				matched = true
+				if err := pp.s.validate(v, kpath); err != nil {
+					return err
+				}
+			}
+		}
+		if !matched && s.additionalProperties != nil {
+			if ap := s.additionalProperties; ap.always != nil && !*ap.always {
+				return fail("additionalProperties", "property %q is not allowed", k)
+			}
+			if err := s.additionalProperties.validate(v, kpath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateArray(arr []interface{}, path string, fail jsonFailFn) *ValidationError {
+	if n := len(arr); n < s.minItems {
+		return fail("minItems", "array has %d items, fewer than %d", n, s.minItems)
+	} else if s.maxItems >= 0 && n > s.maxItems {
+		return fail("maxItems", "array has %d items, more than %d", n, s.maxItems)
+	}
+	if s.uniqueItems {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					return fail("uniqueItems", "items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+	for i, e := range arr {
+		ipath := path + "/" + strconv.Itoa(i)
+		switch {
+		case i < len(s.prefixItems):
+			if err := s.prefixItems[i].validate(e, ipath); err != nil {
+				return err
+			}
+		case s.items != nil:
+			if ai := s.items; ai.always != nil && !*ai.always {
+				return fail("items", "array has more than %d items", len(s.prefixItems))
+			}
+			if err := s.items.validate(e, ipath); err != nil {
+				return err
+			}
+		}
+	}
+	if s.contains != nil {
+		var ok bool
+		for i, e := range arr {
+			if s.contains.validate(e, path+"/"+strconv.Itoa(i)) == nil {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return fail("contains", "array does not contain a matching item")
+		}
+	}
+	return nil
+}
+
+func (s *jsonSchema) validateNumber(n json.Number, fail jsonFailFn) *ValidationError {
+	r, ok := jsonRat(n)
+	if !ok {
+		return fail("type", "invalid number %s", n)
+	}
+	if s.minimum != nil && r.Cmp(s.minimum) < 0 {
+		return fail("minimum", "%s is less than %s", n, s.minimum.RatString())
+	}
+	if s.maximum != nil && r.Cmp(s.maximum) > 0 {
+		return fail("maximum", "%s is greater than %s", n, s.maximum.RatString())
+	}
+	if s.exclusiveMinimum != nil && r.Cmp(s.exclusiveMinimum) <= 0 {
+		return fail("exclusiveMinimum", "%s is not greater than %s", n, s.exclusiveMinimum.RatString())
+	}
+	if s.exclusiveMaximum != nil && r.Cmp(s.exclusiveMaximum) >= 0 {
+		return fail("exclusiveMaximum", "%s is not less than %s", n, s.exclusiveMaximum.RatString())
+	}
+	if s.multipleOf != nil {
+		if q := new(big.Rat).Quo(r, s.multipleOf); !q.IsInt() {
+			return fail("multipleOf", "%s is not a multiple of %s", n, s.multipleOf.RatString())
+		}
+	}
+	return nil
+}
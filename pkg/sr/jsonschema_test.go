@@ -0,0 +1,173 @@
+package sr
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJSONSchemaValidate(t *testing.T) {
+	const schema = `{
+		"$schema": "http://json-schema.org/draft-07/schema#",
+		"type": "object",
+		"required": ["name", "items"],
+		"additionalProperties": false,
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 200},
+			"price": {"type": "number", "multipleOf": 0.01},
+			"items": {
+				"type": "array",
+				"uniqueItems": true,
+				"items": {"$ref": "#/definitions/item"}
+			},
+			"kind": {"enum": ["a", "b", 3]},
+			"node": {"$ref": "#/definitions/node"}
+		},
+		"definitions": {
+			"item": {
+				"type": "object",
+				"required": ["id"],
+				"properties": {"id": {"type": "integer"}},
+				"dependencies": {"discount": ["price"]}
+			},
+			"node": {
+				"type": "object",
+				"properties": {"next": {"oneOf": [{"type": "null"}, {"$ref": "#/definitions/node"}]}}
+			}
+		}
+	}`
+	compiled, err := compileJSONSchema(schema, nil)
+	if err != nil {
+		t.Fatalf("unable to compile: %v", err)
+	}
+
+	for _, test := range []struct {
+		in      string
+		path    string
+		keyword string
+	}{
+		{in: `{"name":"foo","items":[]}`},
+		{in: `{"name":"foo","items":[{"id":1},{"id":2}],"age":30,"price":1.25,"kind":3}`},
+		{in: `{"name":"foo","items":[],"node":{"next":{"next":null}}}`},
+
+		{in: `[]`, keyword: "type"},
+		{in: `{"items":[]}`, keyword: "required"},
+		{in: `{"name":"foo","items":[],"extra":1}`, keyword: "additionalProperties"},
+		{in: `{"name":"","items":[]}`, path: "/name", keyword: "minLength"},
+		{in: `{"name":"FOO","items":[]}`, path: "/name", keyword: "pattern"},
+		{in: `{"name":"foo","items":[],"age":1.5}`, path: "/age", keyword: "type"},
+		{in: `{"name":"foo","items":[],"age":200}`, path: "/age", keyword: "exclusiveMaximum"},
+		{in: `{"name":"foo","items":[],"age":-1}`, path: "/age", keyword: "minimum"},
+		{in: `{"name":"foo","items":[],"price":1.001}`, path: "/price", keyword: "multipleOf"},
+		{in: `{"name":"foo","items":[{"id":1},{"id":1.0}]}`, path: "/items", keyword: "uniqueItems"},
+		{in: `{"name":"foo","items":[{"id":1},{"id":"x"}]}`, path: "/items/1/id", keyword: "type"},
+		{in: `{"name":"foo","items":[{"id":1,"discount":2}]}`, path: "/items/0", keyword: "dependentRequired"},
+		{in: `{"name":"foo","items":[],"kind":"c"}`, path: "/kind", keyword: "enum"},
+		{in: `{"name":"foo","items":[],"node":{"next":{"next":1}}}`, path: "/node/next", keyword: "oneOf"},
+	} {
+		v, err := decodeJSON([]byte(test.in))
+		if err != nil {
+			t.Fatalf("%s: invalid test json: %v", test.in, err)
+		}
+		verr := compiled.validate(v, "")
+		switch {
+		case test.keyword == "" && verr != nil:
+			t.Errorf("%s: unexpected error: %v", test.in, verr)
+		case test.keyword != "" && verr == nil:
+			t.Errorf("%s: expected %s error at %q", test.in, test.keyword, test.path)
+		case test.keyword != "" && (verr.Path != test.path || verr.Keyword != test.keyword):
+			t.Errorf("%s: got %s error at %q != exp %s at %q", test.in, verr.Keyword, verr.Path, test.keyword, test.path)
+		}
+	}
+}
+
+func TestJSONSchemaRefCycles(t *testing.T) {
+	for _, test := range []struct {
+		schema string
+		refs   map[string]string
+		ok     bool
+	}{
+		{schema: `{"$ref":"#"}`},
+		{schema: `{"allOf":[{"$ref":"#"}]}`},
+		{schema: `{"$ref":"#/definitions/a","definitions":{"a":{"$ref":"#/definitions/b"},"b":{"anyOf":[{"$ref":"#/definitions/a"}]}}}`},
+		{schema: `{"properties":{"p":{"$ref":"#/definitions/a"}},"definitions":{"a":{"not":{"$ref":"#/definitions/a"}}}}`},
+		{schema: `{"$ref":"other.json"}`, refs: map[string]string{"other.json": `{"$ref":"#"}`}},
+
+		{schema: `{"properties":{"next":{"$ref":"#"}}}`, ok: true},
+		{schema: `{"items":{"oneOf":[{"type":"null"},{"$ref":"#"}]}}`, ok: true},
+		{schema: `{"$ref":"#/definitions/a","definitions":{"a":{"type":"object"}},"allOf":[{"$ref":"#/definitions/a"}]}`, ok: true},
+	} {
+		_, err := compileJSONSchema(test.schema, test.refs)
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got err %v, exp ok? %v", test.schema, err, test.ok)
+		}
+	}
+}
+
+func TestJSONSerde(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/schemas/ids/1":
+			json.NewEncoder(w).Encode(Schema{
+				Schema:     `{"type":"object","properties":{"addr":{"$ref":"address.json"}}}`,
+				Type:       TypeJSON,
+				References: []SchemaReference{{Name: "address.json", Subject: "address", Version: 1}},
+			})
+		case "/subjects/address/versions/1":
+			json.NewEncoder(w).Encode(SubjectSchema{
+				Subject: "address",
+				Version: 1,
+				ID:      2,
+				Schema: Schema{
+					Schema: `{"type":"object","required":["zip"],"properties":{"zip":{"type":"string","maxLength":5}}}`,
+					Type:   TypeJSON,
+				},
+			})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ResponseError{ErrorCode: 40403, Message: "not found"})
+		}
+	}))
+	defer srv.Close()
+
+	cl, err := NewClient(URLs(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	type address struct {
+		Zip string `json:"zip"`
+	}
+	type person struct {
+		Addr address `json:"addr"`
+	}
+
+	serde := NewJSONSerde(cl, ValidateDecode())
+	b, err := serde.Encode(ctx, 1, person{address{"12345"}})
+	if err != nil {
+		t.Fatalf("unable to encode: %v", err)
+	}
+	var out person
+	if err := serde.Decode(ctx, b, &out); err != nil || out.Addr.Zip != "12345" {
+		t.Errorf("got %v (err %v) != exp 12345", out, err)
+	}
+
+	_, err = serde.Encode(ctx, 1, person{address{"123456"}})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Path != "/addr/zip" || verr.Keyword != "maxLength" {
+		t.Errorf("expected maxLength validation error at /addr/zip, got %v", err)
+	}
+
+	invalid := append(AppendHeader(nil, 1), `{"addr":{}}`...)
+	if err := serde.Decode(ctx, invalid, &out); !errors.As(err, &verr) || verr.Keyword != "required" {
+		t.Errorf("expected required validation error on decode, got %v", err)
+	}
+	if err := NewJSONSerde(cl).Decode(ctx, invalid, &out); err != nil {
+		t.Errorf("unexpected error decoding without validation: %v", err)
+	}
+}
@@ -0,0 +1,981 @@
+// Package protofile contains a small parser for protobuf source files, as
+// stored in the schema registry.
+//
+// The parser understands the subset of the protobuf language that is
+// meaningful for schemas: the syntax, package, imports, file options,
+// messages (including nested messages, map fields, oneofs, and reserved
+// ranges), and enums. Services and extensions are parsed but dropped, and
+// custom options are skipped.
+//
+// This package is used by sr to check protobuf compatibility and to find
+// record names, and by the srproto module to build file descriptors. It is
+// not meant to be a general purpose protobuf parser.
+package protofile
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// File is a parsed protobuf source file.
+type File struct {
+	Syntax  string // "proto2" or "proto3"
+	Package string
+	Imports []Import
+	Options []Option
+
+	Messages []*Message
+	Enums    []*Enum
+}
+
+// Import is an import in a protobuf file.
+type Import struct {
+	Path   string
+	Public bool
+	Weak   bool
+}
+
+// Option is a simple (non-custom) option with its constant as written; string
+// constants are unquoted.
+type Option struct {
+	Name  string
+	Value string
+}
+
+// Message is a message declaration.
+type Message struct {
+	Name     string
+	FullName string
+
+	Fields   []*Field
+	Oneofs   []string
+	Messages []*Message
+	Enums    []*Enum
+
+	ReservedRanges []Range
+	ReservedNames  []string
+
+	Options []Option
+
+	// MapEntry is set for messages synthesized for map fields.
+	MapEntry bool
+}
+
+// Range is an inclusive range of field or enum numbers.
+type Range struct {
+	Start, End int32
+}
+
+// Label is a field label.
+type Label int8
+
+const (
+	LabelNone Label = iota
+	LabelOptional
+	LabelRequired
+	LabelRepeated
+)
+
+// Kind is the kind of a resolved field type.
+type Kind int8
+
+const (
+	KindScalar Kind = iota
+	KindMessage
+	KindEnum
+)
+
+// Field is a field in a message.
+type Field struct {
+	Name   string
+	Number int32
+	Label  Label
+
+	// Type is the type as written in the source. After Resolve, FullType
+	// contains the fully qualified name of message and enum types, and
+	// Kind contains what the type refers to.
+	Type     string
+	FullType string
+	Kind     Kind
+
+	// Map is non-nil if this field is a map field. Map fields are also
+	// represented as a repeated field of the synthesized Map message.
+	Map *Message
+
+	// Oneof is the index into the message's Oneofs, or -1.
+	Oneof int
+
+	// Proto3Optional is set for proto3 fields with the optional label.
+	Proto3Optional bool
+
+	Options []Option
+}
+
+// Enum is an enum declaration.
+type Enum struct {
+	Name     string
+	FullName string
+	Values   []EnumValue
+
+	ReservedRanges []Range
+	ReservedNames  []string
+
+	Options []Option
+}
+
+// EnumValue is a value of an enum.
+type EnumValue struct {
+	Name   string
+	Number int32
+}
+
+// Scalars contains every scalar type name.
+var Scalars = map[string]bool{
+	"double":   true,
+	"float":    true,
+	"int32":    true,
+	"int64":    true,
+	"uint32":   true,
+	"uint64":   true,
+	"sint32":   true,
+	"sint64":   true,
+	"fixed32":  true,
+	"fixed64":  true,
+	"sfixed32": true,
+	"sfixed64": true,
+	"bool":     true,
+	"string":   true,
+	"bytes":    true,
+}
+
+// Parse parses the protobuf source text.
+func Parse(text string) (*File, error) {
+	p := &parser{lex: lexer{s: text, line: 1}}
+	f, err := p.file()
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.lex.line, err)
+	}
+	return f, nil
+}
+
+//////////////
+// LEXER    //
+//////////////
+
+type tokKind int8
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokNumber
+	tokString
+	tokPunct
+)
+
+type token struct {
+	kind tokKind
+	text string // for strings, the unquoted value
+}
+
+type lexer struct {
+	s    string
+	line int
+
+	peeked *token
+}
+
+func (l *lexer) skipSpace() error {
+	for len(l.s) > 0 {
+		switch {
+		case l.s[0] == '\n':
+			l.line++
+			l.s = l.s[1:]
+		case l.s[0] == ' ' || l.s[0] == '\t' || l.s[0] == '\r' || l.s[0] == '\f' || l.s[0] == '\v':
+			l.s = l.s[1:]
+		case strings.HasPrefix(l.s, "//"):
+			idx := strings.IndexByte(l.s, '\n')
+			if idx < 0 {
+				l.s = ""
+			} else {
+				l.s = l.s[idx:]
+			}
+		case strings.HasPrefix(l.s, "/*"):
+			idx := strings.Index(l.s[2:], "*/")
+			if idx < 0 {
+				return errors.New("unterminated block comment")
+			}
+			l.line += strings.Count(l.s[:idx+2], "\n")
+			l.s = l.s[idx+4:]
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || c >= '0' && c <= '9'
+}
+
+func (l *lexer) next() (token, error) {
+	if l.peeked != nil {
+		t := *l.peeked
+		l.peeked = nil
+		return t, nil
+	}
+	if err := l.skipSpace(); err != nil {
+		return token{}, err
+	}
+	if len(l.s) == 0 {
+		return token{kind: tokEOF}, nil
+	}
+	c := l.s[0]
+	switch {
+	case isIdentStart(c) || c == '.' && len(l.s) > 1 && isIdentStart(l.s[1]):
+		// Identifiers include dotted names, so "foo.Bar" and
+		// ".foo.Bar" are one token.
+		i := 1
+		for i < len(l.s) && (isIdentChar(l.s[i]) || l.s[i] == '.') {
+			i++
+		}
+		t := token{kind: tokIdent, text: l.s[:i]}
+		l.s = l.s[i:]
+		return t, nil
+
+	case c >= '0' && c <= '9' || c == '.' && len(l.s) > 1 && l.s[1] >= '0' && l.s[1] <= '9':
+		i := 1
+		for i < len(l.s) && (isIdentChar(l.s[i]) || l.s[i] == '.' ||
+			(l.s[i] == '+' || l.s[i] == '-') && (l.s[i-1] == 'e' || l.s[i-1] == 'E')) {
+			i++
+		}
+		t := token{kind: tokNumber, text: l.s[:i]}
+		l.s = l.s[i:]
+		return t, nil
+
+	case c == '"' || c == '\'':
+		var sb strings.Builder
+		for {
+			s, err := l.quoted()
+			if err != nil {
+				return token{}, err
+			}
+			sb.WriteString(s)
+			// Adjacent strings are concatenated.
+			if err := l.skipSpace(); err != nil {
+				return token{}, err
+			}
+			if len(l.s) == 0 || l.s[0] != '"' && l.s[0] != '\'' {
+				break
+			}
+		}
+		return token{kind: tokString, text: sb.String()}, nil
+
+	default:
+		t := token{kind: tokPunct, text: l.s[:1]}
+		l.s = l.s[1:]
+		return t, nil
+	}
+}
+
+func (l *lexer) quoted() (string, error) {
+	q := l.s[0]
+	i := 1
+	for ; i < len(l.s) && l.s[i] != q; i++ {
+		switch l.s[i] {
+		case '\\':
+			i++
+		case '\n':
+			return "", errors.New("unterminated string")
+		}
+	}
+	if i >= len(l.s) {
+		return "", errors.New("unterminated string")
+	}
+	raw := l.s[1:i]
+	l.s = l.s[i+1:]
+	if q == '\'' {
+		raw = strings.ReplaceAll(raw, `\'`, `'`)
+		raw = strings.ReplaceAll(raw, `"`, `\"`)
+	}
+	s, err := strconv.Unquote(`"` + raw + `"`)
+	if err != nil {
+		return "", fmt.Errorf("invalid string literal: %w", err)
+	}
+	return s, nil
+}
+
+func (l *lexer) peek() (token, error) {
+	if l.peeked == nil {
+		t, err := l.next()
+		if err != nil {
+			return t, err
+		}
+		l.peeked = &t
+	}
+	return *l.peeked, nil
+}
+
+//////////////
+// PARSER   //
+//////////////
+
+type parser struct {
+	lex lexer
+	f   *File
+}
+
+func (p *parser) expect(punct string) error {
+	t, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	if t.kind != tokPunct || t.text != punct {
+		return fmt.Errorf("expected %q, got %q", punct, t.text)
+	}
+	return nil
+}
+
+func (p *parser) ident() (string, error) {
+	t, err := p.lex.next()
+	if err != nil {
+		return "", err
+	}
+	if t.kind != tokIdent {
+		return "", fmt.Errorf("expected identifier, got %q", t.text)
+	}
+	return t.text, nil
+}
+
+// maybe consumes the next token if it is the given punctuation.
+func (p *parser) maybe(punct string) (bool, error) {
+	t, err := p.lex.peek()
+	if err != nil {
+		return false, err
+	}
+	if t.kind == tokPunct && t.text == punct {
+		p.lex.next()
+		return true, nil
+	}
+	return false, nil
+}
+
+func (p *parser) int32() (int32, error) {
+	neg, err := p.maybe("-")
+	if err != nil {
+		return 0, err
+	}
+	t, err := p.lex.next()
+	if err != nil {
+		return 0, err
+	}
+	if t.kind != tokNumber {
+		return 0, fmt.Errorf("expected number, got %q", t.text)
+	}
+	n, err := strconv.ParseInt(t.text, 0, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid number %q", t.text)
+	}
+	if neg {
+		n = -n
+	}
+	if n < -1<<31 || n > 1<<31-1 {
+		return 0, fmt.Errorf("number %q out of range", t.text)
+	}
+	return int32(n), nil
+}
+
+func (p *parser) file() (*File, error) {
+	p.f = &File{Syntax: "proto2"}
+	first := true
+	for {
+		t, err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokEOF {
+			return p.f, nil
+		}
+		if t.kind == tokPunct && t.text == ";" {
+			continue
+		}
+		if t.kind != tokIdent {
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+		switch t.text {
+		case "syntax":
+			if !first {
+				return nil, errors.New("syntax must be the first statement")
+			}
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			s, err := p.lex.next()
+			if err != nil {
+				return nil, err
+			}
+			if s.kind != tokString || s.text != "proto2" && s.text != "proto3" {
+				return nil, fmt.Errorf("unsupported syntax %q", s.text)
+			}
+			p.f.Syntax = s.text
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+
+		case "package":
+			if p.f.Package, err = p.ident(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+
+		case "import":
+			var imp Import
+			s, err := p.lex.next()
+			if err != nil {
+				return nil, err
+			}
+			if s.kind == tokIdent && (s.text == "public" || s.text == "weak") {
+				imp.Public, imp.Weak = s.text == "public", s.text == "weak"
+				if s, err = p.lex.next(); err != nil {
+					return nil, err
+				}
+			}
+			if s.kind != tokString {
+				return nil, fmt.Errorf("expected import path, got %q", s.text)
+			}
+			imp.Path = s.text
+			p.f.Imports = append(p.f.Imports, imp)
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+
+		case "option":
+			o, err := p.option(";")
+			if err != nil {
+				return nil, err
+			}
+			if o != nil {
+				p.f.Options = append(p.f.Options, *o)
+			}
+
+		case "message":
+			m, err := p.message(p.f.Package)
+			if err != nil {
+				return nil, err
+			}
+			p.f.Messages = append(p.f.Messages, m)
+
+		case "enum":
+			e, err := p.enum(p.f.Package)
+			if err != nil {
+				return nil, err
+			}
+			p.f.Enums = append(p.f.Enums, e)
+
+		case "service", "extend":
+			if err := p.skipDecl(); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, fmt.Errorf("unexpected %q", t.text)
+		}
+		first = false
+	}
+}
+
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+// skipDecl skips a declaration up to and including its balanced braces.
+func (p *parser) skipDecl() error {
+	for {
+		t, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tokEOF:
+			return errors.New("unexpected end of file")
+		case t.kind == tokPunct && t.text == "{":
+			return p.skipBalanced("{", "}")
+		}
+	}
+}
+
+// skipBalanced skips tokens until the closing punctuation that balances an
+// already consumed opening punctuation.
+func (p *parser) skipBalanced(open, close string) error {
+	depth := 1
+	for depth > 0 {
+		t, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tokEOF:
+			return errors.New("unexpected end of file")
+		case t.kind == tokPunct && t.text == open:
+			depth++
+		case t.kind == tokPunct && t.text == close:
+			depth--
+		}
+	}
+	return nil
+}
+
+// optionName parses an option name, which may contain parenthesized
+// extension names. Custom options are reported with custom=true.
+func (p *parser) optionName() (name string, custom bool, err error) {
+	var sb strings.Builder
+	for {
+		t, err := p.lex.next()
+		if err != nil {
+			return "", false, err
+		}
+		switch {
+		case t.kind == tokPunct && t.text == "(":
+			custom = true
+			inner, err := p.ident()
+			if err != nil {
+				return "", false, err
+			}
+			if err := p.expect(")"); err != nil {
+				return "", false, err
+			}
+			sb.WriteString("(" + inner + ")")
+		case t.kind == tokIdent:
+			sb.WriteString(t.text)
+		default:
+			return "", false, fmt.Errorf("invalid option name near %q", t.text)
+		}
+		next, err := p.lex.peek()
+		if err != nil {
+			return "", false, err
+		}
+		// Sub-fields of a parenthesized name begin with a dot that
+		// the lexer attaches to the following identifier.
+		if next.kind != tokIdent || !strings.HasPrefix(next.text, ".") {
+			return sb.String(), custom, nil
+		}
+	}
+}
+
+// constant parses an option constant, skipping aggregate values.
+func (p *parser) constant() (string, error) {
+	t, err := p.lex.next()
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case t.kind == tokPunct && t.text == "{":
+		return "", p.skipBalanced("{", "}")
+	case t.kind == tokPunct && (t.text == "-" || t.text == "+"):
+		n, err := p.lex.next()
+		if err != nil {
+			return "", err
+		}
+		if t.text == "-" {
+			return "-" + n.text, nil
+		}
+		return n.text, nil
+	case t.kind == tokIdent || t.kind == tokNumber || t.kind == tokString:
+		return t.text, nil
+	default:
+		return "", fmt.Errorf("invalid option value %q", t.text)
+	}
+}
+
+// option parses "name = constant" followed by the given terminator. Custom
+// options return nil.
+func (p *parser) option(term string) (*Option, error) {
+	name, custom, err := p.optionName()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	value, err := p.constant()
+	if err != nil {
+		return nil, err
+	}
+	if term != "" {
+		if err := p.expect(term); err != nil {
+			return nil, err
+		}
+	}
+	if custom {
+		return nil, nil
+	}
+	return &Option{Name: name, Value: value}, nil
+}
+
+// compactOptions parses bracketed options following a field or enum value, if
+// present.
+func (p *parser) compactOptions() ([]Option, error) {
+	open, err := p.maybe("[")
+	if err != nil || !open {
+		return nil, err
+	}
+	var opts []Option
+	for {
+		o, err := p.option("")
+		if err != nil {
+			return nil, err
+		}
+		if o != nil {
+			opts = append(opts, *o)
+		}
+		t, err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == tokPunct && t.text == "]" {
+			return opts, nil
+		}
+		if t.kind != tokPunct || t.text != "," {
+			return nil, fmt.Errorf("expected \",\" or \"]\", got %q", t.text)
+		}
+	}
+}
+
+func (p *parser) reserved() (ranges []Range, names []string, err error) {
+	for {
+		t, err := p.lex.peek()
+		if err != nil {
+			return nil, nil, err
+		}
+		if t.kind == tokString {
+			p.lex.next()
+			names = append(names, t.text)
+		} else {
+			start, err := p.int32()
+			if err != nil {
+				return nil, nil, err
+			}
+			r := Range{start, start}
+			if t, err := p.lex.peek(); err == nil && t.kind == tokIdent && t.text == "to" {
+				p.lex.next()
+				if t, err = p.lex.peek(); err == nil && t.kind == tokIdent && t.text == "max" {
+					p.lex.next()
+					r.End = 1<<29 - 1
+				} else if r.End, err = p.int32(); err != nil {
+					return nil, nil, err
+				}
+			}
+			ranges = append(ranges, r)
+		}
+		t, err = p.lex.next()
+		if err != nil {
+			return nil, nil, err
+		}
+		if t.kind == tokPunct && t.text == ";" {
+			return ranges, names, nil
+		}
+		if t.kind != tokPunct || t.text != "," {
+			return nil, nil, fmt.Errorf("expected \",\" or \";\", got %q", t.text)
+		}
+	}
+}
+
+func (p *parser) message(scope string) (*Message, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	m := &Message{Name: name, FullName: qualify(scope, name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	if err := p.messageBody(m, -1); err != nil {
+		return nil, fmt.Errorf("message %s: %w", name, err)
+	}
+	return m, nil
+}
+
+// messageBody parses the body of a message, or of a oneof within a message
+// if oneof is non-negative, up to and including the closing brace.
+func (p *parser) messageBody(m *Message, oneof int) error {
+	for {
+		t, err := p.lex.next()
+		if err != nil {
+			return err
+		}
+		switch {
+		case t.kind == tokEOF:
+			return errors.New("unexpected end of file")
+		case t.kind == tokPunct && t.text == "}":
+			return nil
+		case t.kind == tokPunct && t.text == ";":
+			continue
+		case t.kind != tokIdent:
+			return fmt.Errorf("unexpected %q", t.text)
+		}
+
+		if oneof < 0 {
+			switch t.text {
+			case "message":
+				nested, err := p.message(m.FullName)
+				if err != nil {
+					return err
+				}
+				m.Messages = append(m.Messages, nested)
+				continue
+
+			case "enum":
+				e, err := p.enum(m.FullName)
+				if err != nil {
+					return err
+				}
+				m.Enums = append(m.Enums, e)
+				continue
+
+			case "extend":
+				if err := p.skipDecl(); err != nil {
+					return err
+				}
+				continue
+
+			case "extensions":
+				if _, _, err := p.reserved(); err != nil {
+					return err
+				}
+				continue
+
+			case "reserved":
+				ranges, names, err := p.reserved()
+				if err != nil {
+					return err
+				}
+				m.ReservedRanges = append(m.ReservedRanges, ranges...)
+				m.ReservedNames = append(m.ReservedNames, names...)
+				continue
+
+			case "oneof":
+				name, err := p.ident()
+				if err != nil {
+					return err
+				}
+				if err := p.expect("{"); err != nil {
+					return err
+				}
+				m.Oneofs = append(m.Oneofs, name)
+				if err := p.messageBody(m, len(m.Oneofs)-1); err != nil {
+					return fmt.Errorf("oneof %s: %w", name, err)
+				}
+				continue
+			}
+		}
+
+		if t.text == "option" {
+			o, err := p.option(";")
+			if err != nil {
+				return err
+			}
+			if o != nil && oneof < 0 {
+				m.Options = append(m.Options, *o)
+			}
+			continue
+		}
+
+		if err := p.field(m, t.text, oneof); err != nil {
+			return err
+		}
+	}
+}
+
+// field parses a field whose first token (a label or type) is first.
+func (p *parser) field(m *Message, first string, oneof int) error {
+	f := &Field{Oneof: oneof}
+	typ := first
+	switch first {
+	case "optional", "required", "repeated":
+		if oneof >= 0 {
+			return fmt.Errorf("fields in oneofs cannot have label %q", first)
+		}
+		f.Label = map[string]Label{
+			"optional": LabelOptional,
+			"required": LabelRequired,
+			"repeated": LabelRepeated,
+		}[first]
+		var err error
+		if typ, err = p.ident(); err != nil {
+			return err
+		}
+	case "group":
+		return errors.New("groups are not supported")
+	}
+
+	if typ == "map" {
+		if isMap, err := p.maybe("<"); err != nil {
+			return err
+		} else if isMap {
+			return p.mapField(m, f)
+		}
+	}
+	f.Type = typ
+
+	var err error
+	if f.Name, err = p.ident(); err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	if f.Number, err = p.int32(); err != nil {
+		return err
+	}
+	if f.Options, err = p.compactOptions(); err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+	if p.f.Syntax == "proto3" && f.Label == LabelOptional {
+		f.Proto3Optional = true
+	}
+	m.Fields = append(m.Fields, f)
+	return nil
+}
+
+func (p *parser) mapField(m *Message, f *Field) error {
+	key, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(","); err != nil {
+		return err
+	}
+	value, err := p.ident()
+	if err != nil {
+		return err
+	}
+	if err := p.expect(">"); err != nil {
+		return err
+	}
+	if f.Name, err = p.ident(); err != nil {
+		return err
+	}
+	if err := p.expect("="); err != nil {
+		return err
+	}
+	if f.Number, err = p.int32(); err != nil {
+		return err
+	}
+	if f.Options, err = p.compactOptions(); err != nil {
+		return err
+	}
+	if err := p.expect(";"); err != nil {
+		return err
+	}
+
+	entryName := MapEntryName(f.Name)
+	f.Label = LabelRepeated
+	f.Type = entryName
+	f.Map = &Message{
+		Name:     entryName,
+		FullName: qualify(m.FullName, entryName),
+		MapEntry: true,
+		Fields: []*Field{
+			{Name: "key", Number: 1, Label: LabelOptional, Type: key, Oneof: -1},
+			{Name: "value", Number: 2, Label: LabelOptional, Type: value, Oneof: -1},
+		},
+	}
+	m.Messages = append(m.Messages, f.Map)
+	m.Fields = append(m.Fields, f)
+	return nil
+}
+
+// MapEntryName returns the name of the message synthesized for a map field,
+// which is the field name in CamelCase followed by "Entry".
+func MapEntryName(field string) string {
+	var sb strings.Builder
+	upper := true
+	for i := 0; i < len(field); i++ {
+		c := field[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			sb.WriteByte(c - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteByte(c)
+			upper = false
+		}
+	}
+	sb.WriteString("Entry")
+	return sb.String()
+}
+
+func (p *parser) enum(scope string) (*Enum, error) {
+	name, err := p.ident()
+	if err != nil {
+		return nil, err
+	}
+	e := &Enum{Name: name, FullName: qualify(scope, name)}
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	for {
+		t, err := p.lex.next()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case t.kind == tokEOF:
+			return nil, errors.New("unexpected end of file")
+		case t.kind == tokPunct && t.text == "}":
+			return e, nil
+		case t.kind == tokPunct && t.text == ";":
+			continue
+		case t.kind != tokIdent:
+			return nil, fmt.Errorf("enum %s: unexpected %q", name, t.text)
+		}
+
+		switch t.text {
+		case "option":
+			o, err := p.option(";")
+			if err != nil {
+				return nil, err
+			}
+			if o != nil {
+				e.Options = append(e.Options, *o)
+			}
+		case "reserved":
+			ranges, names, err := p.reserved()
+			if err != nil {
+				return nil, err
+			}
+			e.ReservedRanges = append(e.ReservedRanges, ranges...)
+			e.ReservedNames = append(e.ReservedNames, names...)
+		default:
+			if err := p.expect("="); err != nil {
+				return nil, err
+			}
+			n, err := p.int32()
+			if err != nil {
+				return nil, err
+			}
+			if _, err := p.compactOptions(); err != nil {
+				return nil, err
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+			e.Values = append(e.Values, EnumValue{Name: t.text, Number: n})
+		}
+	}
+}
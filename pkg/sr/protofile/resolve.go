@@ -0,0 +1,128 @@
+package protofile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Symbols returns every message and enum declared in the file, keyed by
+// fully qualified name.
+func (f *File) Symbols() map[string]Kind {
+	syms := make(map[string]Kind)
+	var addMessages func([]*Message)
+	addMessages = func(ms []*Message) {
+		for _, m := range ms {
+			syms[m.FullName] = KindMessage
+			for _, e := range m.Enums {
+				syms[e.FullName] = KindEnum
+			}
+			addMessages(m.Messages)
+		}
+	}
+	addMessages(f.Messages)
+	for _, e := range f.Enums {
+		syms[e.FullName] = KindEnum
+	}
+	return syms
+}
+
+// Resolve resolves the types of every field in the file, using the file's
+// own symbols as well as the symbols in deps (which typically are the symbols
+// of every imported file). Resolution follows protobuf scoping rules: a
+// relative name is searched for from the innermost enclosing scope outward.
+func (f *File) Resolve(deps map[string]Kind) error {
+	syms := f.Symbols()
+	for name, kind := range deps {
+		if _, exists := syms[name]; !exists {
+			syms[name] = kind
+		}
+	}
+	var resolve func([]*Message) error
+	resolve = func(ms []*Message) error {
+		for _, m := range ms {
+			for _, fd := range m.Fields {
+				if err := resolveField(syms, m.FullName, fd); err != nil {
+					return fmt.Errorf("message %s: %w", m.FullName, err)
+				}
+			}
+			if err := resolve(m.Messages); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return resolve(f.Messages)
+}
+
+func resolveField(syms map[string]Kind, scope string, fd *Field) error {
+	if Scalars[fd.Type] {
+		fd.Kind = KindScalar
+		fd.FullType = fd.Type
+		return nil
+	}
+	full, kind, ok := Lookup(syms, scope, fd.Type)
+	if !ok {
+		return fmt.Errorf("field %s: unknown type %q", fd.Name, fd.Type)
+	}
+	fd.FullType, fd.Kind = full, kind
+	return nil
+}
+
+// Lookup resolves name relative to scope in syms, returning the fully
+// qualified name and its kind.
+func Lookup(syms map[string]Kind, scope, name string) (string, Kind, bool) {
+	if strings.HasPrefix(name, ".") {
+		kind, ok := syms[name[1:]]
+		return name[1:], kind, ok
+	}
+	for {
+		full := qualify(scope, name)
+		if kind, ok := syms[full]; ok {
+			return full, kind, true
+		}
+		if scope == "" {
+			return "", 0, false
+		}
+		if idx := strings.LastIndexByte(scope, '.'); idx >= 0 {
+			scope = scope[:idx]
+		} else {
+			scope = ""
+		}
+	}
+}
+
+// FindMessage returns the message with the given fully qualified name.
+func (f *File) FindMessage(full string) *Message {
+	var find func([]*Message) *Message
+	find = func(ms []*Message) *Message {
+		for _, m := range ms {
+			if m.FullName == full {
+				return m
+			}
+			if found := find(m.Messages); found != nil {
+				return found
+			}
+		}
+		return nil
+	}
+	return find(f.Messages)
+}
+
+// MessageByIndex returns the message at the given index path, as used in the
+// schema registry wire format: the first index is the index of a top level
+// message, and each following index is the index of a nested message.
+func (f *File) MessageByIndex(index []int) (*Message, error) {
+	if len(index) == 0 {
+		index = []int{0}
+	}
+	ms := f.Messages
+	var m *Message
+	for _, idx := range index {
+		if idx < 0 || idx >= len(ms) {
+			return nil, fmt.Errorf("message index %v is out of range", index)
+		}
+		m = ms[idx]
+		ms = m.Messages
+	}
+	return m, nil
+}
@@ -3,13 +3,18 @@ package sr
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"reflect"
 	"sync"
 	"sync/atomic"
 )
 
 // The wire format for encoded types is 0, then big endian uint32 of the ID,
-// then the encoded message.
+// then the encoded message. For protobuf, the ID is followed by the message
+// index path: the indexes of the message type within the schema, which is
+// the index of a top level message followed by the indexes of nested
+// messages. The path is encoded as a zigzag varint count followed by zigzag
+// varint indexes, with the common path [0] encoded as a single 0 byte.
 //
 // https://docs.confluent.io/platform/current/schema-registry/serdes-develop/index.html#wire-format
 
@@ -22,6 +27,10 @@ var (
 	// ErrBadHeader is returned from Decode when the input slice is shorter
 	// than five bytes, or if the first byte is not the magic 0 byte.
 	ErrBadHeader = errors.New("5 byte header for value is missing or does no have 0 magic byte")
+
+	// ErrBadIndex is returned from Decode or DecodeIndex when the protobuf
+	// message index path following the header is truncated or invalid.
+	ErrBadIndex = errors.New("protobuf message index following the header is invalid")
 )
 
 type (
@@ -48,11 +57,40 @@ func DecodeFn(fn func([]byte, interface{}) error) SerdeOpt {
 	return serdeOpt{func(t *tserde) { t.decode = fn }}
 }
 
+// Index registers the protobuf message index path for a value, which is
+// required for protobuf schemas. Encoding writes the index path after the
+// schema ID, and decoding reads the index path and uses the decode function
+// registered for the ID and path. The path for the first top level message in
+// a schema is 0; the path for the second nested message in the first top
+// level message is 0, 1.
+//
+// Once any value is registered with an index for an ID, decoding that ID
+// always expects an index path.
+func Index(index ...int) SerdeOpt {
+	return serdeOpt{func(t *tserde) { t.index = index }}
+}
+
+// RecordName sets the fully qualified record name of a schema registered
+// with RegisterSchema, for use with subject name strategies. By default, the
+// record name is determined from the schema: the namespace qualified name of
+// an Avro record, the title of a JSON schema, or the fully qualified name of
+// the protobuf message at the Index path.
+func RecordName(name string) SerdeOpt {
+	return serdeOpt{func(t *tserde) { t.record = name }}
+}
+
 type tserde struct {
 	id           uint32
+	index        []int
+	schema       *Schema
+	record       string
 	encode       func(interface{}) ([]byte, error)
 	appendEncode func([]byte, interface{}) ([]byte, error)
 	decode       func([]byte, interface{}) error
+
+	// subindex, if non-nil, contains the registrations for an ID by
+	// message index path.
+	subindex map[string]tserde
 }
 
 // Serde encodes and decodes values according to the schema registry wire
@@ -66,10 +104,21 @@ type tserde struct {
 // To use a Serde for decoding, you can either pre-register schema ids and
 // values you will consume, or you can discover the schema every time you
 // receive an ErrNotRegistered error from decode.
+//
+// Alternatively, a Serde can be given a Client with UseRegistry, and values
+// can be registered with their schema rather than an ID with RegisterSchema.
+// EncodeFor then looks up or registers the schema in the subject for the topic
+// being produced to on first use, and caches the resulting ID.
 type Serde struct {
-	ids   atomic.Value // map[int]tserde
-	types atomic.Value // map[reflect.Type]tserde
-	mu    sync.Mutex
+	ids      atomic.Value // map[int]tserde
+	types    atomic.Value // map[reflect.Type]tserde
+	subjects atomic.Value // map[typeSubject]int
+	mu       sync.Mutex
+
+	cl         *Client
+	strategy   SubjectNameStrategy
+	lookupOnly bool
+	useLatest  bool
 }
 
 var (
@@ -104,15 +153,7 @@ func (s *Serde) Register(id int, v interface{}, opts ...SerdeOpt) {
 
 	s.mu.Lock()
 	defer s.mu.Unlock()
-
-	{
-		dup := make(map[int]tserde)
-		for k, v := range s.loadIDs() {
-			dup[k] = v
-		}
-		dup[id] = t
-		s.ids.Store(dup)
-	}
+	s.storeID(id, t)
 
 	{
 		dup := make(map[reflect.Type]tserde)
@@ -124,6 +165,30 @@ func (s *Serde) Register(id int, v interface{}, opts ...SerdeOpt) {
 	}
 }
 
+// storeID stores the registration for an ID, and must be called with the
+// mutex held.
+func (s *Serde) storeID(id int, t tserde) {
+	dup := make(map[int]tserde)
+	for k, v := range s.loadIDs() {
+		dup[k] = v
+	}
+	existing := dup[id]
+	if len(t.index) > 0 {
+		subindex := make(map[string]tserde)
+		for k, v := range existing.subindex {
+			subindex[k] = v
+		}
+		subindex[indexKey(t.index)] = t
+		existing.id = t.id
+		existing.subindex = subindex
+		dup[id] = existing
+	} else {
+		t.subindex = existing.subindex
+		dup[id] = t
+	}
+	s.ids.Store(dup)
+}
+
 // Encode encodes a value according to the schema registry wire format and
 // returns it. If EncodeFn was not used, this returns ErrNotRegistered.
 func (s *Serde) Encode(v interface{}) ([]byte, error) {
@@ -131,21 +196,22 @@ func (s *Serde) Encode(v interface{}) ([]byte, error) {
 }
 
 // AppendEncode appends an encoded value to b according to the schema registry
-// wire format and returns it. If EncodeFn was not used, this returns
+// wire format and returns it. If EncodeFn was not used, or if the value was
+// registered with RegisterSchema rather than Register, this returns
 // ErrNotRegistered.
 func (s *Serde) AppendEncode(b []byte, v interface{}) ([]byte, error) {
 	t, ok := s.loadTypes()[reflect.TypeOf(v)]
-	if !ok || (t.encode == nil && t.appendEncode == nil) {
+	if !ok || t.schema != nil || (t.encode == nil && t.appendEncode == nil) {
 		return b, ErrNotRegistered
 	}
+	return t.appendEncodeID(b, int(t.id), v)
+}
 
-	b = append(b,
-		0,
-		byte(t.id>>24),
-		byte(t.id>>16),
-		byte(t.id>>8),
-		byte(t.id>>0),
-	)
+func (t tserde) appendEncodeID(b []byte, id int, v interface{}) ([]byte, error) {
+	b = AppendHeader(b, id)
+	if len(t.index) > 0 {
+		b = AppendIndex(b, t.index)
+	}
 
 	if t.appendEncode != nil {
 		return t.appendEncode(b, v)
@@ -184,14 +250,101 @@ func (s *Serde) MustAppendEncode(b []byte, v interface{}) []byte {
 // full decode function for any top-level ID, regardless of how many other
 // schemas are referenced in top-level ID.
 func (s *Serde) Decode(b []byte, v interface{}) error {
-	if len(b) < 5 || b[0] != 0 {
-		return ErrBadHeader
+	id, b, err := DecodeHeader(b)
+	if err != nil {
+		return err
 	}
-	id := binary.BigEndian.Uint32(b[1:5])
 
-	t, ok := s.loadIDs()[int(id)]
+	t, ok := s.loadIDs()[id]
+	if ok && t.subindex != nil {
+		var index []int
+		if index, b, err = DecodeIndex(b); err != nil {
+			return err
+		}
+		t, ok = t.subindex[indexKey(index)]
+	}
 	if !ok || t.decode == nil {
 		return ErrNotRegistered
 	}
-	return t.decode(b[5:], v)
+	return t.decode(b, v)
+}
+
+func indexKey(index []int) string {
+	if len(index) == 0 {
+		index = []int{0}
+	}
+	return fmt.Sprint(index)
+}
+
+// AppendHeader appends the schema registry wire format header for the given
+// schema ID to b: the magic 0 byte followed by the big endian ID.
+func AppendHeader(b []byte, id int) []byte {
+	return append(b,
+		0,
+		byte(id>>24),
+		byte(id>>16),
+		byte(id>>8),
+		byte(id>>0),
+	)
+}
+
+// DecodeHeader returns the schema ID in the wire format header at the start
+// of b, and the bytes following the header. This returns ErrBadHeader if the
+// header is missing or invalid.
+func DecodeHeader(b []byte) (id int, rest []byte, err error) {
+	if len(b) < 5 || b[0] != 0 {
+		return 0, nil, ErrBadHeader
+	}
+	return int(binary.BigEndian.Uint32(b[1:5])), b[5:], nil
+}
+
+// AppendIndex appends a protobuf message index path to b. An empty path is
+// equivalent to the path 0, which is the first top level message.
+func AppendIndex(b []byte, index []int) []byte {
+	if len(index) == 0 || len(index) == 1 && index[0] == 0 {
+		return append(b, 0)
+	}
+	b = appendVarint(b, int64(len(index)))
+	for _, idx := range index {
+		b = appendVarint(b, int64(idx))
+	}
+	return b
+}
+
+// DecodeIndex returns the protobuf message index path at the start of b, and
+// the bytes following the path. This returns ErrBadIndex if the path is
+// truncated or has a negative count or index.
+func DecodeIndex(b []byte) (index []int, rest []byte, err error) {
+	n, read := binary.Varint(b)
+	if read <= 0 || n < 0 {
+		return nil, nil, ErrBadIndex
+	}
+	b = b[read:]
+	if n == 0 {
+		return []int{0}, b, nil
+	}
+	if n > int64(len(b)) {
+		return nil, nil, ErrBadIndex
+	}
+	index = make([]int, 0, n)
+	for i := int64(0); i < n; i++ {
+		idx, read := binary.Varint(b)
+		if read <= 0 || idx < 0 {
+			return nil, nil, ErrBadIndex
+		}
+		b = b[read:]
+		index = append(index, int(idx))
+	}
+	return index, b, nil
+}
+
+// appendVarint appends a zigzag encoded varint, which is the encoding used by
+// both the message index path and Avro ints and longs.
+func appendVarint(b []byte, l int64) []byte {
+	u := uint64((l << 1) ^ (l >> 63))
+	for u >= 0x80 {
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
 }
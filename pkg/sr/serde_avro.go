@@ -0,0 +1,219 @@
+package sr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+)
+
+// ErrNotAvro is returned from AvroSerde when a schema ID refers to a schema
+// that is not an Avro schema.
+var ErrNotAvro = errors.New("schema is not an avro schema")
+
+// AvroSerde encodes and decodes Avro values according to the schema registry
+// wire format. Unlike Serde, AvroSerde does not require registering IDs up
+// front: schemas for unknown IDs are lazily fetched from the registry, along
+// with any schemas they reference, and are parsed and cached for the lifetime
+// of the AvroSerde. Concurrent lookups of the same unknown ID share one fetch.
+//
+// Decoding can be into a pointer to a struct, a map[string]interface{}, or an
+// interface{}. Struct fields are matched to Avro fields by their "avro" struct
+// tag, or by case insensitive field name if there is no tag. Decoding into an
+// interface{} or a map produces generic values: records decode as
+// map[string]interface{}, arrays as []interface{}, enums as strings, int and
+// long as int32 and int64, float and double as float32 and float64, bytes and
+// fixed as []byte, and unions as the value of the written branch.
+//
+// If a reader schema is registered for the type being decoded into, values are
+// resolved from the writer schema (the schema of the encoded ID) into the
+// reader schema according to the Avro schema resolution rules: fields only in
+// the writer are dropped, fields only in the reader are filled with their
+// defaults, and numeric types are promoted.
+type AvroSerde struct {
+	cl *Client
+
+	ids     idCache      // *avroSchema
+	readers atomic.Value // map[reflect.Type]*avroSchema
+	mu      sync.Mutex
+}
+
+// NewAvroSerde returns a new AvroSerde that fetches schemas with cl.
+func NewAvroSerde(cl *Client) *AvroSerde {
+	return &AvroSerde{cl: cl}
+}
+
+var noAvroReaders = make(map[reflect.Type]*avroSchema)
+
+func (s *AvroSerde) loadReaders() map[reflect.Type]*avroSchema {
+	readers := s.readers.Load()
+	if readers == nil {
+		return noAvroReaders
+	}
+	return readers.(map[reflect.Type]*avroSchema)
+}
+
+// RegisterReader registers a reader schema for the type of v. When decoding
+// into a value of this type (or a pointer to it), the writer schema is
+// resolved into this reader schema. Any references in the schema are fetched
+// from the registry.
+func (s *AvroSerde) RegisterReader(ctx context.Context, v interface{}, schema Schema) error {
+	reader, err := s.resolve(ctx, schema)
+	if err != nil {
+		return err
+	}
+	typ := reflect.TypeOf(v)
+	for typ != nil && typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dup := make(map[reflect.Type]*avroSchema)
+	for k, v := range s.loadReaders() {
+		dup[k] = v
+	}
+	dup[typ] = reader
+	s.readers.Store(dup)
+	return nil
+}
+
+// Encode encodes v with the Avro schema for the given ID, according to the
+// schema registry wire format.
+func (s *AvroSerde) Encode(ctx context.Context, id int, v interface{}) ([]byte, error) {
+	return s.AppendEncode(ctx, nil, id, v)
+}
+
+// AppendEncode appends v encoded with the Avro schema for the given ID to b,
+// according to the schema registry wire format.
+func (s *AvroSerde) AppendEncode(ctx context.Context, b []byte, id int, v interface{}) ([]byte, error) {
+	schema, err := s.schema(ctx, id)
+	if err != nil {
+		return b, err
+	}
+	b = AppendHeader(b, id)
+	return avroEncode(b, schema, v)
+}
+
+// Decode decodes b into v, which must be a non-nil pointer. The schema for the
+// ID in b is fetched from the registry if it is not yet known.
+func (s *AvroSerde) Decode(ctx context.Context, b []byte, v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return fmt.Errorf("avro: decode requires a non-nil pointer, got %T", v)
+	}
+
+	id, b, err := DecodeHeader(b)
+	if err != nil {
+		return err
+	}
+	writer, err := s.schema(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	var reader *avroSchema
+	for typ := rv.Type(); typ.Kind() == reflect.Ptr && reader == nil; typ = typ.Elem() {
+		reader = s.loadReaders()[typ.Elem()]
+	}
+
+	rd := &avroReader{b}
+	decoded, err := avroDecode(rd, writer, reader)
+	if err != nil {
+		return err
+	}
+	if len(rd.b) != 0 {
+		return fmt.Errorf("avro: %d trailing bytes after decoding schema ID %d", len(rd.b), id)
+	}
+	return avroAssign(rv.Elem(), decoded)
+}
+
+// AppendJSON decodes b, which is encoded according to the schema registry
+// wire format, and appends the value to dst in the Avro JSON encoding. This
+// can be used with kgo's RecordFormatter to print Avro encoded records.
+func (s *AvroSerde) AppendJSON(ctx context.Context, dst, b []byte) ([]byte, error) {
+	id, b, err := DecodeHeader(b)
+	if err != nil {
+		return dst, err
+	}
+	schema, err := s.schema(ctx, id)
+	if err != nil {
+		return dst, err
+	}
+	rd := &avroReader{b}
+	out, err := avroAppendJSON(dst, rd, schema)
+	if err != nil {
+		return dst, err
+	}
+	if len(rd.b) != 0 {
+		return dst, fmt.Errorf("avro: %d trailing bytes after decoding schema ID %d", len(rd.b), id)
+	}
+	return out, nil
+}
+
+// EncodeJSON encodes a value in the Avro JSON encoding with the Avro schema
+// for the given ID, according to the schema registry wire format. This is the
+// inverse of AppendJSON, and can be used with kgo's RecordReader.
+func (s *AvroSerde) EncodeJSON(ctx context.Context, id int, js []byte) ([]byte, error) {
+	schema, err := s.schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v, err := decodeJSON(js)
+	if err != nil {
+		return nil, err
+	}
+	return avroEncodeJSON(AppendHeader(nil, id), schema, v)
+}
+
+// schema returns the parsed schema for the given ID, fetching it if
+// necessary.
+func (s *AvroSerde) schema(ctx context.Context, id int) (*avroSchema, error) {
+	v, err := s.ids.get(ctx, id, s.fetch)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*avroSchema), nil
+}
+
+func (s *AvroSerde) fetch(ctx context.Context, id int) (interface{}, error) {
+	schema, err := s.cl.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := s.resolve(ctx, schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema ID %d: %w", id, err)
+	}
+	return parsed, nil
+}
+
+// resolve parses a schema after parsing all of its references, recursively.
+func (s *AvroSerde) resolve(ctx context.Context, schema Schema) (*avroSchema, error) {
+	names := make(avroNames)
+	seen := make(map[SchemaReference]bool)
+	var parse func(Schema) (*avroSchema, error)
+	parse = func(schema Schema) (*avroSchema, error) {
+		if schema.Type != TypeAvro {
+			return nil, ErrNotAvro
+		}
+		for _, ref := range schema.References {
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			ss, err := s.cl.SchemaByVersion(ctx, ref.Subject, ref.Version, HideDeleted)
+			if err != nil {
+				return nil, fmt.Errorf("unable to fetch reference %q: %w", ref.Name, err)
+			}
+			if _, err := parse(ss.Schema); err != nil {
+				return nil, fmt.Errorf("reference %q: %w", ref.Name, err)
+			}
+		}
+		return parseAvroSchema(schema.Schema, names)
+	}
+	return parse(schema)
+}
@@ -0,0 +1,199 @@
+package sr
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrNotJSON is returned from JSONSerde when a schema ID refers to a schema
+// that is not a JSON schema.
+var ErrNotJSON = errors.New("schema is not a json schema")
+
+type (
+	// JSONSerdeOpt is an option to configure a JSONSerde.
+	JSONSerdeOpt interface{ apply(*JSONSerde) }
+	jsonSerdeOpt struct{ fn func(*JSONSerde) }
+)
+
+func (o jsonSerdeOpt) apply(s *JSONSerde) { o.fn(s) }
+
+// ValidateDecode sets the JSONSerde to validate payloads against their schema
+// when decoding, in addition to when encoding. By default, payloads are
+// assumed to have been validated by their producer.
+func ValidateDecode() JSONSerdeOpt {
+	return jsonSerdeOpt{func(s *JSONSerde) { s.validateDecode = true }}
+}
+
+// JSONSerde encodes and decodes JSON values according to the schema registry
+// wire format, validating values against JSON schemas.
+//
+// Values are encoded with encoding/json. Before the encoded value is written,
+// it is validated against the schema of the ID being encoded with; if the
+// value is invalid, the error is a *ValidationError that names the JSON
+// pointer path to the failing value.
+//
+// Schemas for unknown IDs are lazily fetched from the registry, along with any
+// schemas they reference, and are compiled into validators that are cached for
+// the lifetime of the JSONSerde. Concurrent lookups of the same unknown ID
+// share one fetch. A "$ref" in a schema can refer to a referenced schema by
+// its reference name (or the final path component of its name), optionally
+// followed by a JSON pointer fragment.
+type JSONSerde struct {
+	cl             *Client
+	validateDecode bool
+
+	ids idCache // *jsonSchema
+}
+
+// NewJSONSerde returns a new JSONSerde that fetches schemas with cl.
+func NewJSONSerde(cl *Client, opts ...JSONSerdeOpt) *JSONSerde {
+	s := &JSONSerde{cl: cl}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+// Encode encodes v as JSON according to the schema registry wire format,
+// after validating it against the JSON schema for the given ID.
+func (s *JSONSerde) Encode(ctx context.Context, id int, v interface{}) ([]byte, error) {
+	return s.AppendEncode(ctx, nil, id, v)
+}
+
+// AppendEncode appends v encoded as JSON to b according to the schema registry
+// wire format, after validating it against the JSON schema for the given ID.
+func (s *JSONSerde) AppendEncode(ctx context.Context, b []byte, id int, v interface{}) ([]byte, error) {
+	schema, err := s.schema(ctx, id)
+	if err != nil {
+		return b, err
+	}
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		return b, err
+	}
+	if err := validateJSON(schema, encoded); err != nil {
+		return b, err
+	}
+	b = AppendHeader(b, id)
+	return append(b, encoded...), nil
+}
+
+// Decode decodes b into v with encoding/json. If the JSONSerde was created
+// with ValidateDecode, the payload is validated against the schema for the ID
+// in b before decoding.
+func (s *JSONSerde) Decode(ctx context.Context, b []byte, v interface{}) error {
+	id, b, err := DecodeHeader(b)
+	if err != nil {
+		return err
+	}
+	if s.validateDecode {
+		schema, err := s.schema(ctx, id)
+		if err != nil {
+			return err
+		}
+		if err := validateJSON(schema, b); err != nil {
+			return err
+		}
+	}
+	return json.Unmarshal(b, v)
+}
+
+// AppendJSON appends the JSON in b, which is encoded according to the schema
+// registry wire format, to dst. The JSON is compacted so that it is on a
+// single line. This can be used with kgo's RecordFormatter.
+func (s *JSONSerde) AppendJSON(ctx context.Context, dst, b []byte) ([]byte, error) {
+	_, b, err := DecodeHeader(b)
+	if err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	if err := json.Compact(buf, b); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeJSON validates js against the JSON schema for the given ID and
+// encodes it according to the schema registry wire format. This is the
+// inverse of AppendJSON, and can be used with kgo's RecordReader.
+func (s *JSONSerde) EncodeJSON(ctx context.Context, id int, js []byte) ([]byte, error) {
+	schema, err := s.schema(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateJSON(schema, js); err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(AppendHeader(nil, id))
+	if err := json.Compact(buf, js); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func validateJSON(schema *jsonSchema, b []byte) error {
+	generic, err := decodeJSON(b)
+	if err != nil {
+		return err
+	}
+	if verr := schema.validate(generic, ""); verr != nil {
+		return verr
+	}
+	return nil
+}
+
+// schema returns the compiled schema for the given ID, fetching it if
+// necessary.
+func (s *JSONSerde) schema(ctx context.Context, id int) (*jsonSchema, error) {
+	v, err := s.ids.get(ctx, id, s.fetch)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*jsonSchema), nil
+}
+
+func (s *JSONSerde) fetch(ctx context.Context, id int) (interface{}, error) {
+	schema, err := s.cl.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if schema.Type != TypeJSON {
+		return nil, ErrNotJSON
+	}
+
+	// We gather the text of all references, recursively. References are
+	// keyed by name; all names share one namespace.
+	refs := make(map[string]string)
+	var gather func([]SchemaReference) error
+	gather = func(references []SchemaReference) error {
+		for _, ref := range references {
+			if _, ok := refs[ref.Name]; ok {
+				continue
+			}
+			ss, err := s.cl.SchemaByVersion(ctx, ref.Subject, ref.Version, HideDeleted)
+			if err != nil {
+				return fmt.Errorf("unable to fetch reference %q: %w", ref.Name, err)
+			}
+			if ss.Type != TypeJSON {
+				return fmt.Errorf("reference %q: %w", ref.Name, ErrNotJSON)
+			}
+			refs[ref.Name] = ss.Schema.Schema
+			if err := gather(ss.References); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := gather(schema.References); err != nil {
+		return nil, fmt.Errorf("schema ID %d: %w", id, err)
+	}
+
+	compiled, err := compileJSONSchema(schema.Schema, refs)
+	if err != nil {
+		return nil, fmt.Errorf("schema ID %d: %w", id, err)
+	}
+	return compiled, nil
+}
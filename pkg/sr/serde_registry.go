@@ -0,0 +1,178 @@
+package sr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+type (
+	// RegistryOpt is an option to configure how a Serde uses a schema
+	// registry when encoding values registered with RegisterSchema.
+	RegistryOpt interface{ apply(*Serde) }
+	registryOpt struct{ fn func(*Serde) }
+)
+
+func (o registryOpt) apply(s *Serde) { o.fn(s) }
+
+// NameStrategy sets the strategy used to determine the subject that a schema
+// is registered under or looked up in, overriding the default
+// TopicNameStrategy. When a strategy is set, the record name of every schema
+// registered with RegisterSchema must be determinable (see RecordName).
+func NameStrategy(strategy SubjectNameStrategy) RegistryOpt {
+	return registryOpt{func(s *Serde) { s.strategy = strategy }}
+}
+
+// DisableAutoRegister sets the Serde to only look up schemas in their subject,
+// rather than registering them. Encoding a value whose schema is not
+// registered in the subject fails.
+func DisableAutoRegister() RegistryOpt {
+	return registryOpt{func(s *Serde) { s.lookupOnly = true }}
+}
+
+// UseLatestVersion sets the Serde to encode with the ID of the latest schema
+// in the subject, rather than the ID of the schema registered with
+// RegisterSchema. This is useful for producers that should not register
+// schemas and should follow the schema that is managed elsewhere; the
+// registered schema is only used to determine the record name. The latest
+// version is fetched once per subject and cached for the life of the Serde.
+// This option implies DisableAutoRegister.
+func UseLatestVersion() RegistryOpt {
+	return registryOpt{func(s *Serde) { s.useLatest = true }}
+}
+
+type typeSubject struct {
+	typ     reflect.Type
+	subject string
+}
+
+func (s *Serde) loadSubjects() map[typeSubject]int {
+	subjects := s.subjects.Load()
+	if subjects == nil {
+		return nil
+	}
+	return subjects.(map[typeSubject]int)
+}
+
+// UseRegistry sets the client that the Serde uses to look up or register
+// schemas that were registered with RegisterSchema. This must be called
+// before encoding, and must not be called concurrently with encoding.
+func (s *Serde) UseRegistry(cl *Client, opts ...RegistryOpt) {
+	s.cl = cl
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+}
+
+// RegisterSchema registers a schema and the value it corresponds to, as well
+// as the encoding or decoding functions. Unlike Register, the schema ID is not
+// known up front: the first EncodeFor of a value of this type into a given
+// subject looks up or registers the schema with the Serde's registry client,
+// and the resulting ID is cached. Once an ID is known, Decode can decode it
+// with the registered decode function.
+//
+// Values registered with this function can only be encoded with EncodeFor or
+// AppendEncodeFor.
+func (s *Serde) RegisterSchema(v interface{}, schema Schema, opts ...SerdeOpt) {
+	t := tserde{schema: &schema}
+	for _, opt := range opts {
+		opt.apply(&t)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dup := make(map[reflect.Type]tserde)
+	for k, v := range s.loadTypes() {
+		dup[k] = v
+	}
+	dup[reflect.TypeOf(v)] = t
+	s.types.Store(dup)
+}
+
+// EncodeFor encodes a value for use as the key or value of a record in the
+// given topic. If the value was registered with Register, this is equivalent
+// to Encode. If the value was registered with RegisterSchema, the schema ID is
+// determined from the subject for the topic, looking up or registering the
+// schema as necessary.
+func (s *Serde) EncodeFor(ctx context.Context, topic string, kv KeyOrValue, v interface{}) ([]byte, error) {
+	return s.AppendEncodeFor(ctx, nil, topic, kv, v)
+}
+
+// AppendEncodeFor appends an encoded value to b for use as the key or value of
+// a record in the given topic. See EncodeFor for more details.
+func (s *Serde) AppendEncodeFor(ctx context.Context, b []byte, topic string, kv KeyOrValue, v interface{}) ([]byte, error) {
+	typ := reflect.TypeOf(v)
+	t, ok := s.loadTypes()[typ]
+	if !ok || (t.encode == nil && t.appendEncode == nil) {
+		return b, ErrNotRegistered
+	}
+	id := int(t.id)
+	if t.schema != nil {
+		var err error
+		if id, err = s.subjectID(ctx, topic, kv, typ, t); err != nil {
+			return b, err
+		}
+	}
+	return t.appendEncodeID(b, id, v)
+}
+
+// subjectID returns the schema ID for a type in the subject for the topic,
+// looking up or registering the type's schema if the ID is not yet cached.
+func (s *Serde) subjectID(ctx context.Context, topic string, kv KeyOrValue, typ reflect.Type, t tserde) (int, error) {
+	if s.cl == nil {
+		return 0, errors.New("sr: encoding a value registered with RegisterSchema requires UseRegistry")
+	}
+
+	var subject string
+	if s.strategy == nil {
+		subject = TopicNameStrategy(topic, kv, t.record)
+	} else {
+		record := t.record
+		if record == "" {
+			var err error
+			if record, err = recordName(*t.schema, t.index); err != nil {
+				return 0, fmt.Errorf("sr: unable to determine record name for %v: %w", typ, err)
+			}
+		}
+		subject = s.strategy(topic, kv, record)
+	}
+
+	key := typeSubject{typ, subject}
+	if id, ok := s.loadSubjects()[key]; ok {
+		return id, nil
+	}
+
+	var (
+		ss  SubjectSchema
+		err error
+	)
+	switch {
+	case s.useLatest:
+		ss, err = s.cl.SchemaByVersion(ctx, subject, -1, HideDeleted)
+	case s.lookupOnly:
+		ss, err = s.cl.LookupSchema(ctx, subject, *t.schema)
+	default:
+		ss, err = s.cl.CreateSchema(ctx, subject, *t.schema)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("sr: unable to determine schema ID in subject %q: %w", subject, err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dup := make(map[typeSubject]int)
+	for k, v := range s.loadSubjects() {
+		dup[k] = v
+	}
+	dup[key] = ss.ID
+	s.subjects.Store(dup)
+
+	if t.decode != nil {
+		t.id = uint32(ss.ID)
+		s.storeID(ss.ID, t)
+	}
+	return ss.ID, nil
+}
@@ -0,0 +1,183 @@
+package sr
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestIndex(t *testing.T) {
+	for _, test := range []struct {
+		index []int
+		exp   []byte
+	}{
+		{nil, []byte{0}},
+		{[]int{0}, []byte{0}},
+		{[]int{1}, []byte{2, 2}},
+		{[]int{0, 2, 70}, []byte{6, 0, 4, 0x8c, 0x01}},
+	} {
+		b := AppendIndex(nil, test.index)
+		if !reflect.DeepEqual(b, test.exp) {
+			t.Errorf("encoding %v: got %x != exp %x", test.index, b, test.exp)
+		}
+		index, rest, err := DecodeIndex(append(b, 0xff))
+		if err != nil {
+			t.Errorf("decoding %v: unexpected err %v", test.index, err)
+			continue
+		}
+		exp := test.index
+		if len(exp) == 0 {
+			exp = []int{0}
+		}
+		if !reflect.DeepEqual(index, exp) || len(rest) != 1 {
+			t.Errorf("decoding %v: got %v (rest %x)", test.index, index, rest)
+		}
+	}
+
+	for _, bad := range [][]byte{nil, {1}, {4, 2}, {2, 1}} {
+		if _, _, err := DecodeIndex(bad); err != ErrBadIndex {
+			t.Errorf("decoding %x: got err %v != exp ErrBadIndex", bad, err)
+		}
+	}
+}
+
+func TestSerdeIndex(t *testing.T) {
+	type (
+		first  struct{ s string }
+		second struct{ s string }
+	)
+	var serde Serde
+	for i, v := range []interface{}{first{}, second{}} {
+		i := i
+		serde.Register(3, v,
+			Index(0, i),
+			AppendEncodeFn(func(b []byte, v interface{}) ([]byte, error) { return append(b, 'x'), nil }),
+			DecodeFn(func(b []byte, v interface{}) error {
+				*v.(*int) = i
+				return nil
+			}),
+		)
+	}
+
+	b, err := serde.Encode(second{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exp := []byte{0, 0, 0, 0, 3, 4, 0, 2, 'x'}; !reflect.DeepEqual(b, exp) {
+		t.Errorf("got %x != exp %x", b, exp)
+	}
+	var which int
+	if err := serde.Decode(b, &which); err != nil || which != 1 {
+		t.Errorf("got %d (err %v) != exp 1", which, err)
+	}
+	if err := serde.Decode([]byte{0, 0, 0, 0, 3, 0}, &which); err != ErrNotRegistered {
+		t.Errorf("got err %v != exp ErrNotRegistered for unregistered index", err)
+	}
+}
+
+func TestSerdeRegistry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		requests []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		mu.Unlock()
+		switch {
+		case r.Method == http.MethodPost && strings.HasSuffix(r.URL.Path, "/versions"):
+			json.NewEncoder(w).Encode(map[string]int{"id": 7})
+		case r.URL.Path == "/schemas/ids/7/versions":
+			json.NewEncoder(w).Encode([]SubjectSchema{{Subject: "foo-test.User", Version: 1}})
+		case r.URL.Path == "/subjects/foo-test.User/versions/1":
+			json.NewEncoder(w).Encode(SubjectSchema{Subject: "foo-test.User", Version: 1, ID: 7})
+		case r.URL.Path == "/subjects/foo-value/versions/latest":
+			json.NewEncoder(w).Encode(SubjectSchema{Subject: "foo-value", Version: 3, ID: 9})
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			json.NewEncoder(w).Encode(ResponseError{ErrorCode: 40401, Message: "subject not found"})
+		}
+	}))
+	defer srv.Close()
+	cl, err := NewClient(URLs(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	type user struct{ name string }
+	register := func(s *Serde) {
+		s.RegisterSchema(user{}, Schema{Schema: `{"type":"record","name":"User","namespace":"test","fields":[]}`},
+			EncodeFn(func(v interface{}) ([]byte, error) { return []byte(v.(user).name), nil }),
+			DecodeFn(func(b []byte, v interface{}) error {
+				v.(*user).name = string(b)
+				return nil
+			}),
+		)
+	}
+
+	var serde Serde
+	serde.UseRegistry(cl, NameStrategy(TopicRecordNameStrategy))
+	register(&serde)
+	if _, err := serde.Encode(user{}); err != ErrNotRegistered {
+		t.Errorf("got err %v != exp ErrNotRegistered encoding without a topic", err)
+	}
+	for i := 0; i < 2; i++ {
+		b, err := serde.EncodeFor(ctx, "foo", ForValue, user{"bar"})
+		if err != nil {
+			t.Fatalf("unable to encode: %v", err)
+		}
+		if exp := []byte{0, 0, 0, 0, 7, 'b', 'a', 'r'}; !reflect.DeepEqual(b, exp) {
+			t.Errorf("got %x != exp %x", b, exp)
+		}
+		var out user
+		if err := serde.Decode(b, &out); err != nil || out.name != "bar" {
+			t.Errorf("got %v (err %v) != exp bar", out, err)
+		}
+	}
+	mu.Lock()
+	if exp := []string{"POST /subjects/foo-test.User/versions", "GET /schemas/ids/7/versions", "GET /subjects/foo-test.User/versions/1"}; !reflect.DeepEqual(requests, exp) {
+		t.Errorf("got requests %v != exp %v", requests, exp)
+	}
+	mu.Unlock()
+
+	var latest Serde
+	latest.UseRegistry(cl, UseLatestVersion())
+	register(&latest)
+	if b, err := latest.EncodeFor(ctx, "foo", ForValue, user{}); err != nil || !reflect.DeepEqual(b, []byte{0, 0, 0, 0, 9}) {
+		t.Errorf("got %x (err %v) != exp latest ID 9", b, err)
+	}
+
+	var lookup Serde
+	lookup.UseRegistry(cl, DisableAutoRegister())
+	register(&lookup)
+	if _, err := lookup.EncodeFor(ctx, "foo", ForKey, user{}); err == nil || !strings.Contains(err.Error(), "foo-key") {
+		t.Errorf("expected lookup failure in subject foo-key, got %v", err)
+	}
+}
+
+func TestRecordName(t *testing.T) {
+	for _, test := range []struct {
+		schema Schema
+		index  []int
+		exp    string
+	}{
+		{Schema{Schema: `{"type":"record","name":"User","namespace":"a.b"}`}, nil, "a.b.User"},
+		{Schema{Schema: `{"type":"record","name":"c.User","namespace":"a.b"}`}, nil, "c.User"},
+		{Schema{Schema: `{"title":"Person"}`, Type: TypeJSON}, nil, "Person"},
+		{Schema{Schema: "syntax = \"proto3\";\npackage p;\nmessage A { message B {} }\nmessage C {}\n", Type: TypeProtobuf}, []int{0, 0}, "p.A.B"},
+	} {
+		got, err := recordName(test.schema, test.index)
+		if err != nil || got != test.exp {
+			t.Errorf("%s: got %q (err %v) != exp %q", test.schema.Schema, got, err, test.exp)
+		}
+	}
+	if _, err := recordName(Schema{Schema: `"string"`}, nil); err == nil {
+		t.Error("expected error for unnamed avro schema")
+	}
+}
@@ -0,0 +1,796 @@
+// Package srfake provides an in-memory schema registry for tests.
+//
+// The Registry type implements the schema registry HTTP endpoints that the sr
+// package's Client uses: subjects, versions, schema IDs, references, config,
+// mode, and compatibility checks. Compatibility is enforced with
+// sr.CheckCompatibilityOffline, so registering an incompatible schema fails
+// just as it would against a real registry.
+//
+//	reg := srfake.New()
+//	defer reg.Close()
+//
+//	cl, err := sr.NewClient(sr.URLs(reg.URL()))
+//	// handle err; use cl as normal
+//
+// The registry is not persistent and does not implement every feature of a
+// real registry: exporters, schema normalization, and registering with
+// explicit IDs in IMPORT mode are not supported.
+package srfake
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+// Error codes returned from the registry in sr.ResponseError, matching the
+// codes of a real registry.
+const (
+	CodeSubjectNotFound       = 40401
+	CodeVersionNotFound       = 40402
+	CodeSchemaNotFound        = 40403
+	CodeSubjectSoftDeleted    = 40404
+	CodeSubjectNotSoftDeleted = 40405
+	CodeVersionSoftDeleted    = 40406
+	CodeVersionNotSoftDeleted = 40407
+	CodeSubjectCompatNotFound = 40408
+	CodeSubjectModeNotFound   = 40409
+	CodeIncompatibleSchema    = 409
+	CodeInvalidSchema         = 42201
+	CodeInvalidVersion        = 42202
+	CodeInvalidCompatibility  = 42203
+	CodeInvalidMode           = 42204
+	CodeOperationNotPermitted = 42205
+	CodeReferenceExists       = 42206
+	CodeInternal              = 50001
+)
+
+// errNotFound is returned for unknown endpoints.
+const errNotFound = 404
+
+// Registry is an in-memory schema registry served over HTTP.
+type Registry struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	nextID   int
+	ids      map[int]sr.Schema // every schema ever registered, by ID
+	byText   map[string]int    // schema key => ID, see schemaKey
+	subjects map[string]*subject
+	compat   sr.CompatibilityLevel
+	mode     sr.Mode
+}
+
+type subject struct {
+	versions []*version // ordered by version number
+	compat   *sr.CompatibilityLevel
+	mode     *sr.Mode
+}
+
+type version struct {
+	version int
+	id      int
+	deleted bool
+}
+
+// Opt is an option to configure a Registry.
+type Opt interface {
+	apply(*Registry)
+}
+
+type opt struct{ fn func(*Registry) }
+
+func (o opt) apply(r *Registry) { o.fn(r) }
+
+// GlobalCompatibility sets the initial global compatibility level, overriding
+// the default BACKWARD.
+func GlobalCompatibility(level sr.CompatibilityLevel) Opt {
+	return opt{func(r *Registry) { r.compat = level }}
+}
+
+// GlobalMode sets the initial global mode, overriding the default READWRITE.
+func GlobalMode(mode sr.Mode) Opt {
+	return opt{func(r *Registry) { r.mode = mode }}
+}
+
+// New returns a new Registry that is serving on a local httptest server. The
+// registry must be closed when done.
+func New(opts ...Opt) *Registry {
+	r := NewUnstarted(opts...)
+	r.srv = httptest.NewServer(r)
+	return r
+}
+
+// NewUnstarted returns a new Registry that is not serving, which can be used
+// as an http.Handler in a server of your own. URL and Close must not be used
+// on an unstarted registry.
+func NewUnstarted(opts ...Opt) *Registry {
+	r := &Registry{
+		nextID:   1,
+		ids:      make(map[int]sr.Schema),
+		byText:   make(map[string]int),
+		subjects: make(map[string]*subject),
+		compat:   sr.CompatBackward,
+		mode:     sr.ModeReadWrite,
+	}
+	for _, opt := range opts {
+		opt.apply(r)
+	}
+	return r
+}
+
+// URL returns the URL of the registry, to be used in sr.URLs.
+func (r *Registry) URL() string { return r.srv.URL }
+
+// Close shuts down the registry's server.
+func (r *Registry) Close() { r.srv.Close() }
+
+// httpError is an error response.
+type httpError struct {
+	code int
+	msg  string
+}
+
+func errorf(code int, format string, args ...interface{}) *httpError {
+	return &httpError{code, fmt.Sprintf(format, args...)}
+}
+
+// status returns the HTTP status of an error code: registry error codes are
+// the HTTP status followed by two digits.
+func (e *httpError) status() int {
+	if e.code >= 10000 {
+		return e.code / 100
+	}
+	return e.code
+}
+
+// ServeHTTP implements http.Handler.
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var segs []string
+	for _, seg := range strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			writeError(w, errorf(errNotFound, "invalid path: %v", err))
+			return
+		}
+		segs = append(segs, unescaped)
+	}
+
+	r.mu.Lock()
+	resp, herr := r.route(req, segs)
+	r.mu.Unlock()
+
+	if herr != nil {
+		writeError(w, herr)
+		return
+	}
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func writeError(w http.ResponseWriter, e *httpError) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	w.WriteHeader(e.status())
+	json.NewEncoder(w).Encode(sr.ResponseError{ErrorCode: e.code, Message: e.msg})
+}
+
+// route dispatches a request, and must be called with the mutex held.
+func (r *Registry) route(req *http.Request, segs []string) (interface{}, *httpError) {
+	q := req.URL.Query()
+	deleted := q.Get("deleted") == "true"
+	m := req.Method
+	n := len(segs)
+
+	decode := func(into interface{}) *httpError {
+		if err := json.NewDecoder(req.Body).Decode(into); err != nil {
+			return errorf(CodeInvalidSchema, "unable to decode request body: %v", err)
+		}
+		return nil
+	}
+
+	switch {
+	case n == 1 && segs[0] == "contexts" && m == http.MethodGet:
+		return r.contexts(), nil
+
+	case segs[0] == "schemas" && m == http.MethodGet:
+		switch {
+		case n == 1:
+			return r.listSchemas(q.Get("subjectPrefix"), deleted, q.Get("latestOnly") == "true"), nil
+		case n == 2 && segs[1] == "types":
+			return []sr.SchemaType{sr.TypeAvro, sr.TypeProtobuf, sr.TypeJSON}, nil
+		case n >= 3 && segs[1] == "ids":
+			id, err := strconv.Atoi(segs[2])
+			if err != nil {
+				return nil, errorf(CodeSchemaNotFound, "invalid schema id %q", segs[2])
+			}
+			s, ok := r.ids[id]
+			if !ok {
+				return nil, errorf(CodeSchemaNotFound, "schema %d not found", id)
+			}
+			switch {
+			case n == 3:
+				return s, nil
+			case n == 4 && segs[3] == "schema":
+				return s.Schema, nil
+			case n == 4 && segs[3] == "versions":
+				return r.idVersions(id, deleted), nil
+			}
+		}
+
+	case segs[0] == "subjects":
+		switch {
+		case n == 1 && m == http.MethodGet:
+			return r.listSubjects(q.Get("subjectPrefix"), deleted), nil
+		case n == 2 && m == http.MethodPost:
+			var s sr.Schema
+			if err := decode(&s); err != nil {
+				return nil, err
+			}
+			return r.lookup(segs[1], s, deleted)
+		case n == 2 && m == http.MethodDelete:
+			return r.deleteSubject(segs[1], q.Get("permanent") == "true")
+		case n == 3 && segs[2] == "versions" && m == http.MethodGet:
+			return r.versions(segs[1], deleted)
+		case n == 3 && segs[2] == "versions" && m == http.MethodPost:
+			var s sr.Schema
+			if err := decode(&s); err != nil {
+				return nil, err
+			}
+			id, err := r.register(segs[1], s)
+			if err != nil {
+				return nil, err
+			}
+			return struct {
+				ID int `json:"id"`
+			}{id}, nil
+		case n >= 4 && segs[2] == "versions":
+			s, v, err := r.version(segs[1], segs[3], deleted || m == http.MethodDelete)
+			if err != nil {
+				return nil, err
+			}
+			switch {
+			case n == 4 && m == http.MethodGet:
+				return r.subjectSchema(segs[1], v), nil
+			case n == 4 && m == http.MethodDelete:
+				return r.deleteVersion(segs[1], s, v, q.Get("permanent") == "true")
+			case n == 5 && segs[4] == "schema" && m == http.MethodGet:
+				return r.ids[v.id].Schema, nil
+			case n == 5 && segs[4] == "referencedby" && m == http.MethodGet:
+				return r.referencedBy(segs[1], v.version), nil
+			}
+		}
+
+	case segs[0] == "compatibility" && n >= 4 && segs[1] == "subjects" && segs[3] == "versions" && m == http.MethodPost:
+		var s sr.Schema
+		if err := decode(&s); err != nil {
+			return nil, err
+		}
+		var which string
+		if n == 5 {
+			which = segs[4]
+		}
+		return r.checkCompatibility(segs[2], which, s)
+
+	case segs[0] == "config" && n <= 2:
+		var subject string
+		if n == 2 {
+			subject = segs[1]
+		}
+		switch m {
+		case http.MethodGet:
+			return r.getConfig(subject, q.Get("defaultToGlobal") == "true")
+		case http.MethodPut:
+			var c struct {
+				Level sr.CompatibilityLevel `json:"compatibility"`
+			}
+			if err := decode(&c); err != nil {
+				return nil, errorf(CodeInvalidCompatibility, "invalid compatibility level: %v", err)
+			}
+			return r.setConfig(subject, c.Level), nil
+		case http.MethodDelete:
+			return r.deleteConfig(subject)
+		}
+
+	case segs[0] == "mode" && n <= 2:
+		var subject string
+		if n == 2 {
+			subject = segs[1]
+		}
+		switch m {
+		case http.MethodGet:
+			return r.getMode(subject), nil
+		case http.MethodPut:
+			var md struct {
+				Mode sr.Mode `json:"mode"`
+			}
+			if err := decode(&md); err != nil {
+				return nil, errorf(CodeInvalidMode, "invalid mode: %v", err)
+			}
+			return r.setMode(subject, md.Mode, q.Get("force") == "true")
+		case http.MethodDelete:
+			return r.deleteMode(subject)
+		}
+	}
+	return nil, errorf(errNotFound, "HTTP %d Not Found: %s %s", http.StatusNotFound, m, req.URL.Path)
+}
+
+// schemaKey returns a key that uniquely identifies a schema, so that
+// registering the same schema in multiple subjects reuses the same ID.
+func schemaKey(s sr.Schema) string {
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// live returns the versions of a subject that are not soft deleted.
+func (s *subject) live() []*version {
+	var live []*version
+	for _, v := range s.versions {
+		if !v.deleted {
+			live = append(live, v)
+		}
+	}
+	return live
+}
+
+func matchesPrefix(subject, prefix string) bool {
+	if prefix == ":.:" {
+		context, _ := sr.SplitContextSubject(subject)
+		return context == sr.DefaultContext
+	}
+	return strings.HasPrefix(subject, prefix)
+}
+
+func (r *Registry) sortedSubjects(prefix string, deleted bool) []string {
+	var names []string
+	for name, s := range r.subjects {
+		if !matchesPrefix(name, prefix) || len(s.versions) == 0 {
+			continue
+		}
+		if !deleted && len(s.live()) == 0 {
+			continue
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (r *Registry) contexts() []string {
+	contexts := map[string]bool{sr.DefaultContext: true}
+	for _, name := range r.sortedSubjects("", true) {
+		context, _ := sr.SplitContextSubject(name)
+		contexts[context] = true
+	}
+	var sorted []string
+	for context := range contexts {
+		sorted = append(sorted, context)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+func (r *Registry) listSubjects(prefix string, deleted bool) []string {
+	names := r.sortedSubjects(prefix, deleted)
+	if names == nil {
+		names = []string{}
+	}
+	return names
+}
+
+func (r *Registry) listSchemas(prefix string, deleted, latestOnly bool) []sr.SubjectSchema {
+	schemas := []sr.SubjectSchema{}
+	for _, name := range r.sortedSubjects(prefix, deleted) {
+		versions := r.subjects[name].versions
+		if !deleted {
+			versions = r.subjects[name].live()
+		}
+		if latestOnly && len(versions) > 0 {
+			versions = versions[len(versions)-1:]
+		}
+		for _, v := range versions {
+			schemas = append(schemas, r.subjectSchema(name, v))
+		}
+	}
+	return schemas
+}
+
+func (r *Registry) subjectSchema(name string, v *version) sr.SubjectSchema {
+	return sr.SubjectSchema{
+		Subject: name,
+		Version: v.version,
+		ID:      v.id,
+		Schema:  r.ids[v.id],
+	}
+}
+
+func (r *Registry) idVersions(id int, deleted bool) interface{} {
+	type subjectVersion struct {
+		Subject string `json:"subject"`
+		Version int    `json:"version"`
+	}
+	svs := []subjectVersion{}
+	for _, name := range r.sortedSubjects("", true) {
+		for _, v := range r.subjects[name].versions {
+			if v.id == id && (deleted || !v.deleted) {
+				svs = append(svs, subjectVersion{name, v.version})
+			}
+		}
+	}
+	return svs
+}
+
+// subject returns the subject if it exists and has live versions, or soft
+// deleted versions if deleted is true.
+func (r *Registry) subject(name string, deleted bool) (*subject, *httpError) {
+	s, ok := r.subjects[name]
+	if !ok || len(s.versions) == 0 || !deleted && len(s.live()) == 0 {
+		return nil, errorf(CodeSubjectNotFound, "Subject '%s' not found.", name)
+	}
+	return s, nil
+}
+
+func (r *Registry) versions(name string, deleted bool) (interface{}, *httpError) {
+	s, err := r.subject(name, deleted)
+	if err != nil {
+		return nil, err
+	}
+	versions := []int{}
+	for _, v := range s.versions {
+		if deleted || !v.deleted {
+			versions = append(versions, v.version)
+		}
+	}
+	return versions, nil
+}
+
+// version returns the subject version for "latest", "-1", or a number.
+func (r *Registry) version(name, which string, deleted bool) (*subject, *version, *httpError) {
+	s, err := r.subject(name, deleted)
+	if err != nil {
+		return nil, nil, err
+	}
+	if which == "latest" || which == "-1" {
+		live := s.live()
+		if len(live) == 0 {
+			if !deleted {
+				return nil, nil, errorf(CodeVersionNotFound, "Version latest not found.")
+			}
+			return s, s.versions[len(s.versions)-1], nil
+		}
+		return s, live[len(live)-1], nil
+	}
+	n, perr := strconv.Atoi(which)
+	if perr != nil || n <= 0 {
+		return nil, nil, errorf(CodeInvalidVersion, "The specified version '%s' is not a valid version id.", which)
+	}
+	for _, v := range s.versions {
+		if v.version == n && (deleted || !v.deleted) {
+			return s, v, nil
+		}
+	}
+	return nil, nil, errorf(CodeVersionNotFound, "Version %d not found.", n)
+}
+
+// resolve returns the schema for a reference.
+func (r *Registry) resolve(ref sr.SchemaReference) (sr.Schema, error) {
+	_, v, err := r.version(ref.Subject, strconv.Itoa(ref.Version), false)
+	if err != nil {
+		return sr.Schema{}, fmt.Errorf("%s", err.msg)
+	}
+	return r.ids[v.id], nil
+}
+
+func (r *Registry) lookup(name string, s sr.Schema, deleted bool) (interface{}, *httpError) {
+	sub, err := r.subject(name, deleted)
+	if err != nil {
+		return nil, err
+	}
+	id, ok := r.byText[schemaKey(s)]
+	if ok {
+		for _, v := range sub.versions {
+			if v.id == id && (deleted || !v.deleted) {
+				return r.subjectSchema(name, v), nil
+			}
+		}
+	}
+	return nil, errorf(CodeSchemaNotFound, "Schema not found")
+}
+
+func (r *Registry) compatLevel(name string) sr.CompatibilityLevel {
+	if s, ok := r.subjects[name]; ok && s.compat != nil {
+		return *s.compat
+	}
+	return r.compat
+}
+
+func (r *Registry) modeOf(name string) sr.Mode {
+	if s, ok := r.subjects[name]; ok && s.mode != nil {
+		return *s.mode
+	}
+	return r.mode
+}
+
+// check parses s and checks it against previous schemas, returning the
+// reasons s is incompatible.
+func (r *Registry) check(level sr.CompatibilityLevel, s sr.Schema, previous []*version) ([]string, *httpError) {
+	// With no compatibility, we still check against no schemas to
+	// validate that the schema parses.
+	if level == sr.CompatNone {
+		level, previous = sr.CompatBackward, nil
+	}
+	var prev []sr.Schema
+	for _, v := range previous {
+		if other := r.ids[v.id]; other.Type == s.Type {
+			prev = append(prev, other)
+		} else {
+			return []string{fmt.Sprintf("schema type %s differs from the previous schema type %s", s.Type, other.Type)}, nil
+		}
+	}
+	incompats, err := sr.CheckCompatibilityOffline(level, s, prev, r.resolve)
+	if err != nil {
+		return nil, errorf(CodeInvalidSchema, "Invalid schema: %v", err)
+	}
+	var msgs []string
+	for _, incompat := range incompats {
+		msgs = append(msgs, incompat.String())
+	}
+	return msgs, nil
+}
+
+func (r *Registry) register(name string, s sr.Schema) (int, *httpError) {
+	if r.modeOf(name) == sr.ModeReadOnly {
+		return 0, errorf(CodeOperationNotPermitted, "Subject %s is in read-only mode", name)
+	}
+	sub, ok := r.subjects[name]
+	if !ok {
+		sub = new(subject)
+	}
+	live := sub.live()
+
+	key := schemaKey(s)
+	if id, ok := r.byText[key]; ok {
+		for _, v := range live {
+			if v.id == id {
+				return id, nil
+			}
+		}
+	}
+
+	msgs, err := r.check(r.compatLevel(name), s, live)
+	if err != nil {
+		return 0, err
+	}
+	if len(msgs) > 0 {
+		return 0, errorf(CodeIncompatibleSchema, "Schema being registered is incompatible with an earlier schema for subject \"%s\", details: [%s]", name, strings.Join(msgs, ", "))
+	}
+
+	id, ok := r.byText[key]
+	if !ok {
+		id = r.nextID
+		r.nextID++
+		r.byText[key] = id
+		r.ids[id] = s
+	}
+	next := 1
+	if len(sub.versions) > 0 {
+		next = sub.versions[len(sub.versions)-1].version + 1
+	}
+	sub.versions = append(sub.versions, &version{version: next, id: id})
+	r.subjects[name] = sub
+	return id, nil
+}
+
+// referencedBy returns the IDs of live schemas that reference the subject
+// version.
+func (r *Registry) referencedBy(name string, ver int) []int {
+	uniq := make(map[int]bool)
+	for _, sub := range r.subjects {
+		for _, v := range sub.live() {
+			for _, ref := range r.ids[v.id].References {
+				if ref.Subject == name && ref.Version == ver {
+					uniq[v.id] = true
+				}
+			}
+		}
+	}
+	ids := []int{}
+	for id := range uniq {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	return ids
+}
+
+func (r *Registry) deleteSubject(name string, permanent bool) (interface{}, *httpError) {
+	if r.modeOf(name) == sr.ModeReadOnly {
+		return nil, errorf(CodeOperationNotPermitted, "Subject %s is in read-only mode", name)
+	}
+	sub, err := r.subject(name, true)
+	if err != nil {
+		return nil, err
+	}
+	live := sub.live()
+	if permanent && len(live) > 0 {
+		return nil, errorf(CodeSubjectNotSoftDeleted, "Subject '%s' was not deleted first before being permanently deleted", name)
+	}
+	if !permanent && len(live) == 0 {
+		return nil, errorf(CodeSubjectSoftDeleted, "Subject '%s' was soft deleted. Set permanent=true to delete permanently", name)
+	}
+	for _, v := range live {
+		if len(r.referencedBy(name, v.version)) > 0 {
+			return nil, errorf(CodeReferenceExists, "One or more references exist to the schema {subject=%s,version=%d}", name, v.version)
+		}
+	}
+	versions := []int{}
+	for _, v := range sub.versions {
+		versions = append(versions, v.version)
+		v.deleted = true
+	}
+	if permanent {
+		sub.versions = nil
+		if sub.compat == nil && sub.mode == nil {
+			delete(r.subjects, name)
+		}
+	}
+	return versions, nil
+}
+
+func (r *Registry) deleteVersion(name string, sub *subject, v *version, permanent bool) (interface{}, *httpError) {
+	if r.modeOf(name) == sr.ModeReadOnly {
+		return nil, errorf(CodeOperationNotPermitted, "Subject %s is in read-only mode", name)
+	}
+	if permanent && !v.deleted {
+		return nil, errorf(CodeVersionNotSoftDeleted, "Subject '%s' Version %d was not deleted first before being permanently deleted", name, v.version)
+	}
+	if !permanent && v.deleted {
+		return nil, errorf(CodeVersionSoftDeleted, "Subject '%s' Version %d was soft deleted. Set permanent=true to delete permanently", name, v.version)
+	}
+	if len(r.referencedBy(name, v.version)) > 0 {
+		return nil, errorf(CodeReferenceExists, "One or more references exist to the schema {subject=%s,version=%d}", name, v.version)
+	}
+	v.deleted = true
+	if permanent {
+		for i, other := range sub.versions {
+			if other == v {
+				sub.versions = append(sub.versions[:i], sub.versions[i+1:]...)
+				break
+			}
+		}
+	}
+	return v.version, nil
+}
+
+type compatibilityResponse struct {
+	Is       bool     `json:"is_compatible"`
+	Messages []string `json:"messages,omitempty"`
+}
+
+// checkCompatibility checks a schema against a single version, or against
+// the versions the subject's compatibility level requires if which is
+// empty.
+func (r *Registry) checkCompatibility(name, which string, s sr.Schema) (interface{}, *httpError) {
+	level := r.compatLevel(name)
+	if level == sr.CompatNone {
+		return compatibilityResponse{Is: true}, nil
+	}
+
+	var previous []*version
+	if which == "" {
+		if sub, ok := r.subjects[name]; ok {
+			previous = sub.live()
+		}
+	} else {
+		_, v, err := r.version(name, which, false)
+		if err != nil {
+			return nil, err
+		}
+		previous = []*version{v}
+		// Checking against one version is never transitive.
+		switch level {
+		case sr.CompatBackwardTransitive:
+			level = sr.CompatBackward
+		case sr.CompatForwardTransitive:
+			level = sr.CompatForward
+		case sr.CompatFullTransitive:
+			level = sr.CompatFull
+		}
+	}
+	msgs, err := r.check(level, s, previous)
+	if err != nil {
+		return nil, err
+	}
+	return compatibilityResponse{Is: len(msgs) == 0, Messages: msgs}, nil
+}
+
+func (r *Registry) getConfig(name string, defaultToGlobal bool) (interface{}, *httpError) {
+	type config struct {
+		Level sr.CompatibilityLevel `json:"compatibilityLevel"`
+	}
+	if name == "" {
+		return config{r.compat}, nil
+	}
+	if s, ok := r.subjects[name]; ok && s.compat != nil {
+		return config{*s.compat}, nil
+	}
+	if defaultToGlobal {
+		return config{r.compat}, nil
+	}
+	return nil, errorf(CodeSubjectCompatNotFound, "Subject '%s' does not have subject-level compatibility configured", name)
+}
+
+type compatibility struct {
+	Level sr.CompatibilityLevel `json:"compatibility"`
+}
+
+func (r *Registry) subjectConfig(name string) *subject {
+	s, ok := r.subjects[name]
+	if !ok {
+		s = new(subject)
+		r.subjects[name] = s
+	}
+	return s
+}
+
+func (r *Registry) setConfig(name string, level sr.CompatibilityLevel) interface{} {
+	if name == "" {
+		r.compat = level
+	} else {
+		r.subjectConfig(name).compat = &level
+	}
+	return compatibility{level}
+}
+
+func (r *Registry) deleteConfig(name string) (interface{}, *httpError) {
+	s, ok := r.subjects[name]
+	if name == "" || !ok || s.compat == nil {
+		return nil, errorf(CodeSubjectNotFound, "Subject '%s' not found.", name)
+	}
+	prev := *s.compat
+	s.compat = nil
+	return compatibility{prev}, nil
+}
+
+type modeResponse struct {
+	Mode sr.Mode `json:"mode"`
+}
+
+func (r *Registry) getMode(name string) interface{} {
+	return modeResponse{r.modeOf(name)}
+}
+
+func (r *Registry) setMode(name string, mode sr.Mode, force bool) (interface{}, *httpError) {
+	if mode == sr.ModeImport && !force {
+		nonempty := len(r.sortedSubjects("", true)) > 0
+		if name != "" {
+			s, ok := r.subjects[name]
+			nonempty = ok && len(s.versions) > 0
+		}
+		if nonempty {
+			return nil, errorf(CodeOperationNotPermitted, "Cannot import since found existing subjects")
+		}
+	}
+	if name == "" {
+		r.mode = mode
+	} else {
+		r.subjectConfig(name).mode = &mode
+	}
+	return modeResponse{mode}, nil
+}
+
+func (r *Registry) deleteMode(name string) (interface{}, *httpError) {
+	s, ok := r.subjects[name]
+	if name == "" || !ok || s.mode == nil {
+		return nil, errorf(CodeSubjectModeNotFound, "Subject '%s' does not have subject-level mode configured", name)
+	}
+	prev := *s.mode
+	s.mode = nil
+	return modeResponse{prev}, nil
+}
@@ -0,0 +1,206 @@
+package srfake
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+func TestRegistry(t *testing.T) {
+	reg := New()
+	defer reg.Close()
+	cl, err := sr.NewClient(sr.URLs(reg.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	code := func(err error) int {
+		var re *sr.ResponseError
+		if !errors.As(err, &re) {
+			return 0
+		}
+		return re.ErrorCode
+	}
+
+	const (
+		v1 = `{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`
+		v2 = `{"type":"record","name":"r","fields":[{"name":"a","type":"int"},{"name":"b","type":"string","default":""}]}`
+		v3 = `{"type":"record","name":"r","fields":[{"name":"a","type":"int"},{"name":"c","type":"string"}]}`
+	)
+
+	ss, err := cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: v1})
+	if err != nil || ss.ID != 1 || ss.Version != 1 {
+		t.Fatalf("got %v (err %v) != exp id 1 version 1", ss, err)
+	}
+	if ss, err = cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: v1}); err != nil || ss.Version != 1 {
+		t.Fatalf("re-registering: got %v (err %v) != exp version 1", ss, err)
+	}
+	if ss, err = cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: v2}); err != nil || ss.ID != 2 || ss.Version != 2 {
+		t.Fatalf("got %v (err %v) != exp id 2 version 2", ss, err)
+	}
+	if _, err = cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: v3}); code(err) != CodeIncompatibleSchema {
+		t.Fatalf("got err %v != exp incompatible", err)
+	}
+	if _, err = cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: `{`}); code(err) != CodeInvalidSchema {
+		t.Fatalf("got err %v != exp invalid", err)
+	}
+
+	// The same schema in another subject reuses the ID.
+	if ss, err = cl.CreateSchema(ctx, "bar-value", sr.Schema{Schema: v1}); err != nil || ss.ID != 1 || ss.Version != 1 {
+		t.Fatalf("got %v (err %v) != exp id 1 version 1", ss, err)
+	}
+	if subjects, err := cl.Subjects(ctx, sr.HideDeleted); err != nil || !reflect.DeepEqual(subjects, []string{"bar-value", "foo-value"}) {
+		t.Fatalf("got subjects %v (err %v)", subjects, err)
+	}
+	if s, err := cl.SchemaByID(ctx, 2); err != nil || s.Schema != v2 {
+		t.Fatalf("got %v (err %v) != exp v2", s, err)
+	}
+	if ss, err := cl.SchemaByVersion(ctx, "foo-value", -1, sr.HideDeleted); err != nil || ss.Version != 2 {
+		t.Fatalf("got latest %v (err %v) != exp version 2", ss, err)
+	}
+	if ss, err := cl.LookupSchema(ctx, "foo-value", sr.Schema{Schema: v1}); err != nil || ss.Version != 1 {
+		t.Fatalf("got lookup %v (err %v) != exp version 1", ss, err)
+	}
+	if _, err := cl.LookupSchema(ctx, "foo-value", sr.Schema{Schema: v3}); code(err) != CodeSchemaNotFound {
+		t.Fatalf("got err %v != exp schema not found", err)
+	}
+	if usages, err := cl.SchemaUsagesByID(ctx, 1, sr.HideDeleted); err != nil || len(usages) != 2 {
+		t.Fatalf("got usages %v (err %v) != exp 2", usages, err)
+	}
+
+	// Compatibility checks and levels.
+	if ok, err := cl.CheckCompatibility(ctx, "foo-value", -1, sr.Schema{Schema: v3}); err != nil || ok {
+		t.Fatalf("got compatible %v (err %v) != exp false", ok, err)
+	}
+	if rs := cl.SetCompatibilityLevel(ctx, sr.CompatNone, "foo-value"); rs[0].Err != nil {
+		t.Fatal(rs[0].Err)
+	}
+	if rs := cl.CompatibilityLevel(ctx, "foo-value", "bar-value"); rs[0].Level != sr.CompatNone || rs[1].Level != sr.CompatBackward {
+		t.Fatalf("got levels %v", rs)
+	}
+	if ss, err = cl.CreateSchema(ctx, "foo-value", sr.Schema{Schema: v3}); err != nil || ss.Version != 3 {
+		t.Fatalf("got %v (err %v) != exp version 3 with no compatibility", ss, err)
+	}
+	if rs := cl.ResetCompatibilityLevel(ctx, "foo-value"); rs[0].Err != nil || rs[0].Level != sr.CompatNone {
+		t.Fatalf("got reset %v", rs)
+	}
+
+	// References.
+	const (
+		dep  = `syntax = "proto3"; package dep; message D { int32 x = 1; }`
+		main = `syntax = "proto3"; import "dep.proto"; message M { dep.D d = 1; }`
+	)
+	if _, err := cl.CreateSchema(ctx, "dep", sr.Schema{Schema: dep, Type: sr.TypeProtobuf}); err != nil {
+		t.Fatal(err)
+	}
+	refs := []sr.SchemaReference{{Name: "dep.proto", Subject: "dep", Version: 1}}
+	ss, err = cl.CreateSchema(ctx, "main", sr.Schema{Schema: main, Type: sr.TypeProtobuf, References: refs})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refd, err := cl.SchemaReferences(ctx, "dep", 1, sr.HideDeleted); err != nil || len(refd) != 1 || refd[0].Subject != "main" {
+		t.Fatalf("got referenced by %v (err %v)", refd, err)
+	}
+	if _, err := cl.DeleteSubject(ctx, "dep", sr.SoftDelete); code(err) != CodeReferenceExists {
+		t.Fatalf("got err %v != exp reference exists", err)
+	}
+
+	// Deletion.
+	if _, err := cl.DeleteSubject(ctx, "foo-value", sr.HardDelete); code(err) != CodeSubjectNotSoftDeleted {
+		t.Fatalf("got err %v != exp not soft deleted", err)
+	}
+	if versions, err := cl.DeleteSubject(ctx, "foo-value", sr.SoftDelete); err != nil || !reflect.DeepEqual(versions, []int{1, 2, 3}) {
+		t.Fatalf("got deleted %v (err %v)", versions, err)
+	}
+	if _, err := cl.SchemaByVersion(ctx, "foo-value", 1, sr.HideDeleted); code(err) != CodeSubjectNotFound {
+		t.Fatalf("got err %v != exp subject not found", err)
+	}
+	if ss, err := cl.SchemaByVersion(ctx, "foo-value", 1, sr.ShowDeleted); err != nil || ss.ID != 1 {
+		t.Fatalf("got %v (err %v) != exp soft deleted id 1", ss, err)
+	}
+	if _, err := cl.DeleteSubject(ctx, "foo-value", sr.HardDelete); err != nil {
+		t.Fatal(err)
+	}
+	if subjects, err := cl.Subjects(ctx, sr.ShowDeleted); err != nil || !reflect.DeepEqual(subjects, []string{"bar-value", "dep", "main"}) {
+		t.Fatalf("got subjects %v (err %v)", subjects, err)
+	}
+	if err := cl.DeleteSchema(ctx, "bar-value", 1, sr.SoftDelete); err != nil {
+		t.Fatal(err)
+	}
+	if err := cl.DeleteSchema(ctx, "bar-value", 1, sr.HardDelete); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegistryModes(t *testing.T) {
+	reg := New()
+	defer reg.Close()
+	cl, err := sr.NewClient(sr.URLs(reg.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	code := func(err error) int {
+		var re *sr.ResponseError
+		if !errors.As(err, &re) {
+			return 0
+		}
+		return re.ErrorCode
+	}
+
+	const v1 = `{"type":"record","name":"r","fields":[{"name":"a","type":"int"}]}`
+	if _, err := cl.CreateSchema(ctx, "bar-value", sr.Schema{Schema: v1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// SetMode must send the requested mode: a request without it is
+	// rejected, and the registry must then report the new mode.
+	if rs := cl.SetMode(ctx, sr.ModeReadOnly, false, "bar-value"); rs[0].Err != nil || rs[0].Mode != sr.ModeReadOnly {
+		t.Fatalf("got set mode %v", rs)
+	}
+	if _, err := cl.CreateSchema(ctx, "bar-value", sr.Schema{Schema: `"string"`}); code(err) != CodeOperationNotPermitted {
+		t.Fatalf("got err %v != exp not permitted in read only mode", err)
+	}
+	if rs := cl.Mode(ctx, "bar-value", sr.GlobalSubject); rs[0].Mode != sr.ModeReadOnly || rs[1].Mode != sr.ModeReadWrite {
+		t.Fatalf("got modes %v", rs)
+	}
+	if rs := cl.SetMode(ctx, sr.ModeImport, false); code(rs[0].Err) != CodeOperationNotPermitted {
+		t.Fatalf("got err %v != exp not permitted importing into a non-empty registry", rs[0].Err)
+	}
+	if rs := cl.ResetMode(ctx, "bar-value"); rs[0].Err != nil {
+		t.Fatalf("got reset mode err %v", rs[0].Err)
+	}
+	if rs := cl.Mode(ctx, "bar-value"); rs[0].Mode != sr.ModeReadWrite {
+		t.Fatalf("got mode %v after reset != exp READWRITE", rs)
+	}
+}
+
+func TestRegistryContexts(t *testing.T) {
+	reg := New(GlobalCompatibility(sr.CompatNone))
+	defer reg.Close()
+	cl, err := sr.NewClient(sr.URLs(reg.URL()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	for _, subject := range []string{"a", sr.ContextSubject("ctx", "b")} {
+		if _, err := cl.CreateSchema(ctx, subject, sr.Schema{Schema: `"string"`}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if contexts, err := cl.Contexts(ctx); err != nil || !reflect.DeepEqual(contexts, []string{".", ".ctx"}) {
+		t.Fatalf("got contexts %v (err %v)", contexts, err)
+	}
+	if subjects, err := cl.ContextSubjects(ctx, sr.DefaultContext, sr.HideDeleted); err != nil || !reflect.DeepEqual(subjects, []string{"a"}) {
+		t.Fatalf("got default context subjects %v (err %v)", subjects, err)
+	}
+	if schemas, err := cl.ListSchemas(ctx, ":.ctx:", sr.HideDeleted, sr.LatestOnly); err != nil || len(schemas) != 1 || schemas[0].Subject != ":.ctx:b" {
+		t.Fatalf("got schemas %v (err %v)", schemas, err)
+	}
+}
@@ -0,0 +1,334 @@
+package srproto
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+
+	// The schema registry provides the well known types to every schema
+	// without references, so we must link them in to resolve imports.
+	_ "google.golang.org/protobuf/types/known/anypb"
+	_ "google.golang.org/protobuf/types/known/apipb"
+	_ "google.golang.org/protobuf/types/known/durationpb"
+	_ "google.golang.org/protobuf/types/known/emptypb"
+	_ "google.golang.org/protobuf/types/known/fieldmaskpb"
+	_ "google.golang.org/protobuf/types/known/sourcecontextpb"
+	_ "google.golang.org/protobuf/types/known/structpb"
+	_ "google.golang.org/protobuf/types/known/timestamppb"
+	_ "google.golang.org/protobuf/types/known/typepb"
+	_ "google.golang.org/protobuf/types/known/wrapperspb"
+
+	"github.com/twmb/franz-go/pkg/sr/protofile"
+)
+
+// This file converts parsed protobuf source into descriptors.
+
+var scalarTypes = map[string]descriptorpb.FieldDescriptorProto_Type{
+	"double":   descriptorpb.FieldDescriptorProto_TYPE_DOUBLE,
+	"float":    descriptorpb.FieldDescriptorProto_TYPE_FLOAT,
+	"int32":    descriptorpb.FieldDescriptorProto_TYPE_INT32,
+	"int64":    descriptorpb.FieldDescriptorProto_TYPE_INT64,
+	"uint32":   descriptorpb.FieldDescriptorProto_TYPE_UINT32,
+	"uint64":   descriptorpb.FieldDescriptorProto_TYPE_UINT64,
+	"sint32":   descriptorpb.FieldDescriptorProto_TYPE_SINT32,
+	"sint64":   descriptorpb.FieldDescriptorProto_TYPE_SINT64,
+	"fixed32":  descriptorpb.FieldDescriptorProto_TYPE_FIXED32,
+	"fixed64":  descriptorpb.FieldDescriptorProto_TYPE_FIXED64,
+	"sfixed32": descriptorpb.FieldDescriptorProto_TYPE_SFIXED32,
+	"sfixed64": descriptorpb.FieldDescriptorProto_TYPE_SFIXED64,
+	"bool":     descriptorpb.FieldDescriptorProto_TYPE_BOOL,
+	"string":   descriptorpb.FieldDescriptorProto_TYPE_STRING,
+	"bytes":    descriptorpb.FieldDescriptorProto_TYPE_BYTES,
+}
+
+// buildFile parses text and builds a file descriptor named name. Imports must
+// already be registered in files; the new file is registered in files as
+// well.
+func buildFile(files *protoregistry.Files, name, text string) (protoreflect.FileDescriptor, error) {
+	f, err := protofile.Parse(text)
+	if err != nil {
+		return nil, err
+	}
+
+	deps := make(map[string]protofile.Kind)
+	for _, imp := range f.Imports {
+		fd, err := files.FindFileByPath(imp.Path)
+		if err != nil && isWellKnown(imp.Path) {
+			fd, err = registerGlobal(files, imp.Path)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("import %q is unknown: %w", imp.Path, err)
+		}
+		addSymbols(deps, fd, make(map[string]bool))
+	}
+	if err := f.Resolve(deps); err != nil {
+		return nil, err
+	}
+
+	fd, err := protodesc.NewFile(fileProto(name, f), files)
+	if err != nil {
+		return nil, err
+	}
+	if err := files.RegisterFile(fd); err != nil {
+		return nil, err
+	}
+	return fd, nil
+}
+
+// registerGlobal registers a file from protoregistry.GlobalFiles, and all of
+// its imports, into files.
+func registerGlobal(files *protoregistry.Files, path string) (protoreflect.FileDescriptor, error) {
+	if fd, err := files.FindFileByPath(path); err == nil {
+		return fd, nil
+	}
+	fd, err := protoregistry.GlobalFiles.FindFileByPath(path)
+	if err != nil {
+		return nil, err
+	}
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if _, err := registerGlobal(files, imports.Get(i).Path()); err != nil {
+			return nil, err
+		}
+	}
+	return fd, files.RegisterFile(fd)
+}
+
+// addSymbols adds all messages and enums in fd and its public imports to
+// syms.
+func addSymbols(syms map[string]protofile.Kind, fd protoreflect.FileDescriptor, seen map[string]bool) {
+	if seen[fd.Path()] {
+		return
+	}
+	seen[fd.Path()] = true
+
+	var addMessages func(protoreflect.MessageDescriptors)
+	addEnums := func(es protoreflect.EnumDescriptors) {
+		for i := 0; i < es.Len(); i++ {
+			syms[string(es.Get(i).FullName())] = protofile.KindEnum
+		}
+	}
+	addMessages = func(ms protoreflect.MessageDescriptors) {
+		for i := 0; i < ms.Len(); i++ {
+			m := ms.Get(i)
+			syms[string(m.FullName())] = protofile.KindMessage
+			addEnums(m.Enums())
+			addMessages(m.Messages())
+		}
+	}
+	addMessages(fd.Messages())
+	addEnums(fd.Enums())
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		if imp := imports.Get(i); imp.IsPublic {
+			addSymbols(syms, imp.FileDescriptor, seen)
+		}
+	}
+}
+
+func fileProto(name string, f *protofile.File) *descriptorpb.FileDescriptorProto {
+	fdp := &descriptorpb.FileDescriptorProto{
+		Name:   proto.String(name),
+		Syntax: proto.String(f.Syntax),
+	}
+	if f.Package != "" {
+		fdp.Package = proto.String(f.Package)
+	}
+	for i, imp := range f.Imports {
+		fdp.Dependency = append(fdp.Dependency, imp.Path)
+		if imp.Public {
+			fdp.PublicDependency = append(fdp.PublicDependency, int32(i))
+		}
+		if imp.Weak {
+			fdp.WeakDependency = append(fdp.WeakDependency, int32(i))
+		}
+	}
+	for _, o := range f.Options {
+		if o.Name == "go_package" {
+			fdp.Options = &descriptorpb.FileOptions{GoPackage: proto.String(o.Value)}
+		}
+	}
+	for _, m := range f.Messages {
+		fdp.MessageType = append(fdp.MessageType, messageProto(f.Syntax, m))
+	}
+	for _, e := range f.Enums {
+		fdp.EnumType = append(fdp.EnumType, enumProto(e))
+	}
+	return fdp
+}
+
+func messageProto(syntax string, m *protofile.Message) *descriptorpb.DescriptorProto {
+	dp := &descriptorpb.DescriptorProto{Name: proto.String(m.Name)}
+	if m.MapEntry {
+		dp.Options = &descriptorpb.MessageOptions{MapEntry: proto.Bool(true)}
+	}
+	for _, o := range m.Oneofs {
+		dp.OneofDecl = append(dp.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(o)})
+	}
+	for _, f := range m.Fields {
+		fp := fieldProto(syntax, f)
+		if f.Proto3Optional {
+			// Proto3 optional fields are represented as being the
+			// only field in a synthetic oneof, and synthetic oneofs
+			// must follow all real oneofs.
+			fp.Proto3Optional = proto.Bool(true)
+			fp.OneofIndex = proto.Int32(int32(len(dp.OneofDecl)))
+			dp.OneofDecl = append(dp.OneofDecl, &descriptorpb.OneofDescriptorProto{Name: proto.String(syntheticOneof(m, f.Name))})
+		}
+		dp.Field = append(dp.Field, fp)
+	}
+	for _, nested := range m.Messages {
+		dp.NestedType = append(dp.NestedType, messageProto(syntax, nested))
+	}
+	for _, e := range m.Enums {
+		dp.EnumType = append(dp.EnumType, enumProto(e))
+	}
+	for _, r := range m.ReservedRanges {
+		dp.ReservedRange = append(dp.ReservedRange, &descriptorpb.DescriptorProto_ReservedRange{
+			Start: proto.Int32(r.Start),
+			End:   proto.Int32(r.End + 1), // exclusive in descriptors
+		})
+	}
+	dp.ReservedName = append(dp.ReservedName, m.ReservedNames...)
+	return dp
+}
+
+// syntheticOneof returns the name of the synthetic oneof for a proto3
+// optional field, which is the field name prefixed with an underscore, and
+// then prefixed with X until it does not conflict with any other name in the
+// message. This matches protoc.
+func syntheticOneof(m *protofile.Message, field string) string {
+	name := "_" + field
+	for {
+		conflict := false
+		for _, o := range m.Oneofs {
+			conflict = conflict || o == name
+		}
+		for _, f := range m.Fields {
+			conflict = conflict || f.Name == name
+		}
+		if !conflict {
+			return name
+		}
+		name = "X" + name
+	}
+}
+
+func fieldProto(syntax string, f *protofile.Field) *descriptorpb.FieldDescriptorProto {
+	fp := &descriptorpb.FieldDescriptorProto{
+		Name:   proto.String(f.Name),
+		Number: proto.Int32(f.Number),
+	}
+	switch f.Label {
+	case protofile.LabelRequired:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_REQUIRED.Enum()
+	case protofile.LabelRepeated:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_REPEATED.Enum()
+	default:
+		fp.Label = descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum()
+	}
+	switch f.Kind {
+	case protofile.KindMessage:
+		fp.Type = descriptorpb.FieldDescriptorProto_TYPE_MESSAGE.Enum()
+		fp.TypeName = proto.String("." + f.FullType)
+	case protofile.KindEnum:
+		fp.Type = descriptorpb.FieldDescriptorProto_TYPE_ENUM.Enum()
+		fp.TypeName = proto.String("." + f.FullType)
+	default:
+		fp.Type = scalarTypes[f.Type].Enum()
+	}
+	if f.Oneof >= 0 {
+		fp.OneofIndex = proto.Int32(int32(f.Oneof))
+	}
+	for _, o := range f.Options {
+		switch o.Name {
+		case "json_name":
+			fp.JsonName = proto.String(o.Value)
+		case "default":
+			if syntax == "proto2" {
+				fp.DefaultValue = proto.String(o.Value)
+			}
+		case "packed":
+			if fp.Options == nil {
+				fp.Options = new(descriptorpb.FieldOptions)
+			}
+			fp.Options.Packed = proto.Bool(o.Value == "true")
+		case "deprecated":
+			if fp.Options == nil {
+				fp.Options = new(descriptorpb.FieldOptions)
+			}
+			fp.Options.Deprecated = proto.Bool(o.Value == "true")
+		}
+	}
+	return fp
+}
+
+func enumProto(e *protofile.Enum) *descriptorpb.EnumDescriptorProto {
+	ep := &descriptorpb.EnumDescriptorProto{Name: proto.String(e.Name)}
+	for _, v := range e.Values {
+		ep.Value = append(ep.Value, &descriptorpb.EnumValueDescriptorProto{
+			Name:   proto.String(v.Name),
+			Number: proto.Int32(v.Number),
+		})
+	}
+	for _, o := range e.Options {
+		if o.Name == "allow_alias" {
+			ep.Options = &descriptorpb.EnumOptions{AllowAlias: proto.Bool(o.Value == "true")}
+		}
+	}
+	for _, r := range e.ReservedRanges {
+		ep.ReservedRange = append(ep.ReservedRange, &descriptorpb.EnumDescriptorProto_EnumReservedRange{
+			Start: proto.Int32(r.Start),
+			End:   proto.Int32(r.End), // inclusive in enum descriptors
+		})
+	}
+	ep.ReservedName = append(ep.ReservedName, e.ReservedNames...)
+	return ep
+}
+
+// messageIndex returns the message index path of md within its file.
+func messageIndex(md protoreflect.MessageDescriptor) []int {
+	var index []int
+	var d protoreflect.Descriptor = md
+	for {
+		index = append(index, d.Index())
+		parent := d.Parent()
+		if _, ok := parent.(protoreflect.FileDescriptor); ok || parent == nil {
+			break
+		}
+		d = parent
+	}
+	for i, j := 0, len(index)-1; i < j; i, j = i+1, j-1 {
+		index[i], index[j] = index[j], index[i]
+	}
+	return index
+}
+
+// messageByIndex returns the message at the given index path in fd.
+func messageByIndex(fd protoreflect.FileDescriptor, index []int) (protoreflect.MessageDescriptor, error) {
+	if len(index) == 0 {
+		index = []int{0}
+	}
+	ms := fd.Messages()
+	var md protoreflect.MessageDescriptor
+	for _, idx := range index {
+		if idx < 0 || idx >= ms.Len() {
+			return nil, fmt.Errorf("message index %v is out of range for %s", index, fd.Path())
+		}
+		md = ms.Get(idx)
+		ms = md.Messages()
+	}
+	return md, nil
+}
+
+// isWellKnown returns whether an import is a well known protobuf file that the
+// schema registry provides without explicit references.
+func isWellKnown(path string) bool {
+	return strings.HasPrefix(path, "google/protobuf/")
+}
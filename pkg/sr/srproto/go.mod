@@ -0,0 +1,12 @@
+module github.com/twmb/franz-go/pkg/sr/srproto
+
+go 1.15
+
+require (
+	github.com/twmb/franz-go/pkg/sr v1.0.0
+	google.golang.org/protobuf v1.28.1
+)
+
+// srproto requires the sr release that it is tagged alongside; this replace
+// only applies when building within this repository and is ignored by users.
+replace github.com/twmb/franz-go/pkg/sr => ../
@@ -0,0 +1,270 @@
+package srproto
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// printFile returns protobuf source for a file descriptor, which is what we
+// register with the schema registry. Message and enum types are always
+// printed fully qualified, and custom options are not printed.
+func printFile(fd protoreflect.FileDescriptor) (string, error) {
+	p := &printer{syntax: fd.Syntax()}
+	switch p.syntax {
+	case protoreflect.Proto2:
+		p.line(0, `syntax = "proto2";`)
+	case protoreflect.Proto3:
+		p.line(0, `syntax = "proto3";`)
+	default:
+		return "", fmt.Errorf("%s: unsupported syntax %v", fd.Path(), p.syntax)
+	}
+	if pkg := fd.Package(); pkg != "" {
+		p.line(0, "")
+		p.line(0, "package %s;", pkg)
+	}
+
+	imports := fd.Imports()
+	if imports.Len() > 0 {
+		p.line(0, "")
+	}
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		switch {
+		case imp.IsPublic:
+			p.line(0, "import public %s;", strconv.Quote(imp.Path()))
+		case imp.IsWeak:
+			p.line(0, "import weak %s;", strconv.Quote(imp.Path()))
+		default:
+			p.line(0, "import %s;", strconv.Quote(imp.Path()))
+		}
+	}
+
+	for i := 0; i < fd.Messages().Len(); i++ {
+		p.line(0, "")
+		if err := p.message(0, fd.Messages().Get(i)); err != nil {
+			return "", err
+		}
+	}
+	for i := 0; i < fd.Enums().Len(); i++ {
+		p.line(0, "")
+		p.enum(0, fd.Enums().Get(i))
+	}
+	return p.sb.String(), nil
+}
+
+type printer struct {
+	syntax protoreflect.Syntax
+	sb     strings.Builder
+}
+
+func (p *printer) line(indent int, format string, args ...interface{}) {
+	p.sb.WriteString(strings.Repeat("  ", indent))
+	fmt.Fprintf(&p.sb, format, args...)
+	p.sb.WriteByte('\n')
+}
+
+func (p *printer) message(indent int, md protoreflect.MessageDescriptor) error {
+	p.line(indent, "message %s {", md.Name())
+
+	fields := md.Fields()
+	printedOneofs := make(map[protoreflect.Name]bool)
+	for i := 0; i < fields.Len(); i++ {
+		f := fields.Get(i)
+		if o := f.ContainingOneof(); o != nil && !o.IsSynthetic() {
+			if printedOneofs[o.Name()] {
+				continue
+			}
+			printedOneofs[o.Name()] = true
+			p.line(indent+1, "oneof %s {", o.Name())
+			for j := 0; j < o.Fields().Len(); j++ {
+				of := o.Fields().Get(j)
+				typ, err := fieldType(of)
+				if err != nil {
+					return err
+				}
+				p.line(indent+2, "%s %s = %d%s;", typ, of.Name(), of.Number(), p.fieldOptions(of))
+			}
+			p.line(indent+1, "}")
+			continue
+		}
+		if f.IsMap() {
+			continue
+		}
+		if err := p.field(indent+1, f); err != nil {
+			return err
+		}
+	}
+
+	// Map fields synthesize nested messages when parsed. To ensure nested
+	// message indexes are the same when our output is parsed, we print map
+	// fields at the position of their entry message.
+	for i := 0; i < md.Messages().Len(); i++ {
+		nested := md.Messages().Get(i)
+		if nested.IsMapEntry() {
+			for j := 0; j < fields.Len(); j++ {
+				if f := fields.Get(j); f.IsMap() && f.Message().FullName() == nested.FullName() {
+					if err := p.field(indent+1, f); err != nil {
+						return err
+					}
+				}
+			}
+			continue
+		}
+		if err := p.message(indent+1, nested); err != nil {
+			return err
+		}
+	}
+	for i := 0; i < md.Enums().Len(); i++ {
+		p.enum(indent+1, md.Enums().Get(i))
+	}
+
+	if rr := md.ReservedRanges(); rr.Len() > 0 {
+		var ranges []string
+		for i := 0; i < rr.Len(); i++ {
+			r := rr.Get(i) // end is exclusive
+			if r[1]-r[0] == 1 {
+				ranges = append(ranges, strconv.Itoa(int(r[0])))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d to %d", r[0], r[1]-1))
+			}
+		}
+		p.line(indent+1, "reserved %s;", strings.Join(ranges, ", "))
+	}
+	if rn := md.ReservedNames(); rn.Len() > 0 {
+		var names []string
+		for i := 0; i < rn.Len(); i++ {
+			names = append(names, strconv.Quote(string(rn.Get(i))))
+		}
+		p.line(indent+1, "reserved %s;", strings.Join(names, ", "))
+	}
+
+	p.line(indent, "}")
+	return nil
+}
+
+func (p *printer) field(indent int, f protoreflect.FieldDescriptor) error {
+	if f.IsMap() {
+		k, err := fieldType(f.MapKey())
+		if err != nil {
+			return err
+		}
+		v, err := fieldType(f.MapValue())
+		if err != nil {
+			return err
+		}
+		p.line(indent, "map<%s, %s> %s = %d%s;", k, v, f.Name(), f.Number(), p.fieldOptions(f))
+		return nil
+	}
+	typ, err := fieldType(f)
+	if err != nil {
+		return err
+	}
+	var label string
+	switch {
+	case f.Cardinality() == protoreflect.Repeated:
+		label = "repeated "
+	case f.Cardinality() == protoreflect.Required:
+		label = "required "
+	case p.syntax == protoreflect.Proto2 || f.HasOptionalKeyword():
+		label = "optional "
+	}
+	p.line(indent, "%s%s %s = %d%s;", label, typ, f.Name(), f.Number(), p.fieldOptions(f))
+	return nil
+}
+
+func (p *printer) fieldOptions(f protoreflect.FieldDescriptor) string {
+	var opts []string
+	if p.syntax == protoreflect.Proto2 && f.HasDefault() {
+		switch f.Kind() {
+		case protoreflect.StringKind:
+			opts = append(opts, "default = "+strconv.Quote(f.Default().String()))
+		case protoreflect.BytesKind:
+			opts = append(opts, "default = "+strconv.Quote(string(f.Default().Bytes())))
+		case protoreflect.EnumKind:
+			opts = append(opts, "default = "+string(f.DefaultEnumValue().Name()))
+		default:
+			opts = append(opts, "default = "+fmt.Sprint(f.Default().Interface()))
+		}
+	}
+	if f.JSONName() != jsonCamelCase(string(f.Name())) {
+		opts = append(opts, "json_name = "+strconv.Quote(f.JSONName()))
+	}
+	if len(opts) == 0 {
+		return ""
+	}
+	return " [" + strings.Join(opts, ", ") + "]"
+}
+
+// jsonCamelCase returns the default JSON name for a field name.
+func jsonCamelCase(s string) string {
+	var sb strings.Builder
+	upper := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '_':
+			upper = true
+		case upper && c >= 'a' && c <= 'z':
+			sb.WriteByte(c - 'a' + 'A')
+			upper = false
+		default:
+			sb.WriteByte(c)
+			upper = false
+		}
+	}
+	return sb.String()
+}
+
+func fieldType(f protoreflect.FieldDescriptor) (string, error) {
+	switch f.Kind() {
+	case protoreflect.MessageKind:
+		return "." + string(f.Message().FullName()), nil
+	case protoreflect.EnumKind:
+		return "." + string(f.Enum().FullName()), nil
+	case protoreflect.GroupKind:
+		return "", fmt.Errorf("field %s: groups are not supported", f.FullName())
+	default:
+		return f.Kind().String(), nil
+	}
+}
+
+func (p *printer) enum(indent int, ed protoreflect.EnumDescriptor) {
+	p.line(indent, "enum %s {", ed.Name())
+	values := ed.Values()
+	aliased := make(map[protoreflect.EnumNumber]bool)
+	for i := 0; i < values.Len(); i++ {
+		n := values.Get(i).Number()
+		if aliased[n] {
+			p.line(indent+1, "option allow_alias = true;")
+			break
+		}
+		aliased[n] = true
+	}
+	for i := 0; i < values.Len(); i++ {
+		v := values.Get(i)
+		p.line(indent+1, "%s = %d;", v.Name(), v.Number())
+	}
+	if rr := ed.ReservedRanges(); rr.Len() > 0 {
+		var ranges []string
+		for i := 0; i < rr.Len(); i++ {
+			r := rr.Get(i) // end is inclusive
+			if r[0] == r[1] {
+				ranges = append(ranges, strconv.Itoa(int(r[0])))
+			} else {
+				ranges = append(ranges, fmt.Sprintf("%d to %d", r[0], r[1]))
+			}
+		}
+		p.line(indent+1, "reserved %s;", strings.Join(ranges, ", "))
+	}
+	if rn := ed.ReservedNames(); rn.Len() > 0 {
+		var names []string
+		for i := 0; i < rn.Len(); i++ {
+			names = append(names, strconv.Quote(string(rn.Get(i))))
+		}
+		p.line(indent+1, "reserved %s;", strings.Join(names, ", "))
+	}
+	p.line(indent, "}")
+}
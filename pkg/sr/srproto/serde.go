@@ -0,0 +1,398 @@
+// Package srproto provides a schema registry serde for protobuf messages.
+//
+// The Confluent protobuf wire format is the standard schema registry header
+// (a magic 0 byte and a big endian schema ID), followed by the message index
+// path of the encoded message within its schema, followed by the protobuf
+// encoded message. See sr.AppendIndex and sr.DecodeIndex for details of the
+// index path encoding.
+//
+// The Serde in this package registers or looks up schemas for messages when
+// encoding, and fetches and parses schemas (and their references) when
+// decoding. Well known types (files in google/protobuf/) are provided by the
+// schema registry and are never registered as references.
+package srproto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+// ErrNotProtobuf is returned when a schema ID refers to a schema that is not a
+// protobuf schema.
+var ErrNotProtobuf = errors.New("schema is not a protobuf schema")
+
+type (
+	// Opt is an option to configure a Serde.
+	Opt interface{ apply(*Serde) }
+	opt struct{ fn func(*Serde) }
+)
+
+func (o opt) apply(s *Serde) { o.fn(s) }
+
+// SubjectNameStrategy sets the strategy used to determine the subject that a
+// message's schema is registered under when encoding, overriding the default
+// sr.TopicNameStrategy. The strategy is given the fully qualified message
+// name as the record name.
+func SubjectNameStrategy(strategy sr.SubjectNameStrategy) Opt {
+	return opt{func(s *Serde) { s.strategy = strategy }}
+}
+
+// DisableAutoRegister sets the Serde to only look up existing schemas when
+// encoding, rather than registering them. Encoding a message whose schema is
+// not registered in the subject fails.
+func DisableAutoRegister() Opt {
+	return opt{func(s *Serde) { s.lookupOnly = true }}
+}
+
+// Serde encodes and decodes protobuf messages according to the schema
+// registry wire format.
+//
+// When encoding, the schema for the message's file is printed from its
+// descriptor and is registered (or looked up) in the subject chosen by the
+// subject name strategy. Imported files are registered as references under
+// subjects named by their import path. The resulting schema ID is cached per
+// subject and file.
+//
+// When decoding, the schema for an unknown ID is fetched along with its
+// references, parsed, and cached. The message index path in the payload
+// selects the message within the schema.
+type Serde struct {
+	cl         *sr.Client
+	strategy   sr.SubjectNameStrategy
+	lookupOnly bool
+
+	ids      atomic.Value // map[int]protoreflect.FileDescriptor
+	subjects atomic.Value // map[subjectFile]int
+
+	mu       sync.Mutex
+	inflight map[int]*fetch
+}
+
+type subjectFile struct {
+	subject string
+	path    string
+}
+
+type fetch struct {
+	done chan struct{}
+	fd   protoreflect.FileDescriptor
+	err  error
+}
+
+// NewSerde returns a new protobuf Serde that uses cl to talk to the schema
+// registry.
+func NewSerde(cl *sr.Client, opts ...Opt) *Serde {
+	s := &Serde{
+		cl:       cl,
+		strategy: sr.TopicNameStrategy,
+		inflight: make(map[int]*fetch),
+	}
+	for _, opt := range opts {
+		opt.apply(s)
+	}
+	return s
+}
+
+func (s *Serde) loadIDs() map[int]protoreflect.FileDescriptor {
+	ids := s.ids.Load()
+	if ids == nil {
+		return nil
+	}
+	return ids.(map[int]protoreflect.FileDescriptor)
+}
+
+func (s *Serde) loadSubjects() map[subjectFile]int {
+	subjects := s.subjects.Load()
+	if subjects == nil {
+		return nil
+	}
+	return subjects.(map[subjectFile]int)
+}
+
+// Encode encodes m according to the schema registry wire format, for use as
+// the key or value of a record in the given topic.
+func (s *Serde) Encode(ctx context.Context, topic string, kv sr.KeyOrValue, m proto.Message) ([]byte, error) {
+	return s.AppendEncode(ctx, nil, topic, kv, m)
+}
+
+// AppendEncode appends m encoded according to the schema registry wire format
+// to b, for use as the key or value of a record in the given topic.
+func (s *Serde) AppendEncode(ctx context.Context, b []byte, topic string, kv sr.KeyOrValue, m proto.Message) ([]byte, error) {
+	md := m.ProtoReflect().Descriptor()
+	subject := s.strategy(topic, kv, string(md.FullName()))
+	id, err := s.subjectID(ctx, subject, md.ParentFile())
+	if err != nil {
+		return b, err
+	}
+	b = sr.AppendHeader(b, id)
+	b = sr.AppendIndex(b, messageIndex(md))
+	return proto.MarshalOptions{}.MarshalAppend(b, m)
+}
+
+// Decode decodes b into a new message. If the message type in the schema is
+// registered in protoregistry.GlobalTypes (i.e., the generated Go type is
+// linked into the binary), the returned message is of that type; otherwise,
+// the returned message is a *dynamicpb.Message.
+func (s *Serde) Decode(ctx context.Context, b []byte) (proto.Message, error) {
+	md, b, err := s.decodeDescriptor(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+	var m proto.Message
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName()); err == nil {
+		m = mt.New().Interface()
+	} else {
+		m = dynamicpb.NewMessage(md)
+	}
+	if err := proto.Unmarshal(b, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// DecodeInto decodes b into m. This returns an error if the message in the
+// schema does not have the same fully qualified name as m.
+func (s *Serde) DecodeInto(ctx context.Context, b []byte, m proto.Message) error {
+	md, b, err := s.decodeDescriptor(ctx, b)
+	if err != nil {
+		return err
+	}
+	if into := m.ProtoReflect().Descriptor().FullName(); into != md.FullName() {
+		return fmt.Errorf("cannot decode %s into %s", md.FullName(), into)
+	}
+	return proto.Unmarshal(b, m)
+}
+
+// AppendJSON decodes b and appends the message to dst in the protobuf JSON
+// format, on a single line. This can be used with kgo's RecordFormatter to
+// print protobuf encoded records.
+func (s *Serde) AppendJSON(ctx context.Context, dst, b []byte) ([]byte, error) {
+	md, b, err := s.decodeDescriptor(ctx, b)
+	if err != nil {
+		return dst, err
+	}
+	m := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(b, m); err != nil {
+		return dst, err
+	}
+	js, err := protojson.Marshal(m)
+	if err != nil {
+		return dst, err
+	}
+	return append(dst, js...), nil
+}
+
+// EncodeJSON encodes a message in the protobuf JSON format with the schema for
+// the given ID. The message is the first message in the schema, which is the
+// message that a message index of [0] refers to. This is the inverse of
+// AppendJSON for schemas with one top level message, and can be used with
+// kgo's RecordReader.
+func (s *Serde) EncodeJSON(ctx context.Context, id int, js []byte) ([]byte, error) {
+	fd, err := s.file(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	md, err := messageByIndex(fd, []int{0})
+	if err != nil {
+		return nil, err
+	}
+	m := dynamicpb.NewMessage(md)
+	if err := protojson.Unmarshal(js, m); err != nil {
+		return nil, err
+	}
+	b := sr.AppendHeader(nil, id)
+	b = sr.AppendIndex(b, []int{0})
+	return proto.MarshalOptions{}.MarshalAppend(b, m)
+}
+
+// decodeDescriptor returns the message descriptor for the ID and index path
+// in b, as well as the encoded message following the index path.
+func (s *Serde) decodeDescriptor(ctx context.Context, b []byte) (protoreflect.MessageDescriptor, []byte, error) {
+	id, b, err := sr.DecodeHeader(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	index, b, err := sr.DecodeIndex(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	fd, err := s.file(ctx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	md, err := messageByIndex(fd, index)
+	if err != nil {
+		return nil, nil, err
+	}
+	return md, b, nil
+}
+
+// subjectID returns the schema ID for the file in the subject, registering or
+// looking up the schema if necessary.
+func (s *Serde) subjectID(ctx context.Context, subject string, fd protoreflect.FileDescriptor) (int, error) {
+	key := subjectFile{subject, fd.Path()}
+	if id, ok := s.loadSubjects()[key]; ok {
+		return id, nil
+	}
+
+	schema, err := s.schema(ctx, fd, make(map[string]int))
+	if err != nil {
+		return 0, err
+	}
+	ss, err := s.registerOrLookup(ctx, subject, schema)
+	if err != nil {
+		return 0, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	dup := make(map[subjectFile]int)
+	for k, v := range s.loadSubjects() {
+		dup[k] = v
+	}
+	dup[key] = ss.ID
+	s.subjects.Store(dup)
+	return ss.ID, nil
+}
+
+func (s *Serde) registerOrLookup(ctx context.Context, subject string, schema sr.Schema) (sr.SubjectSchema, error) {
+	if s.lookupOnly {
+		return s.cl.LookupSchema(ctx, subject, schema)
+	}
+	return s.cl.CreateSchema(ctx, subject, schema)
+}
+
+// schema returns the schema for fd, registering (or looking up) every
+// non-well-known import as a reference. Imports are registered under a
+// subject that is the import path. The versions map tracks references that
+// have already been handled.
+func (s *Serde) schema(ctx context.Context, fd protoreflect.FileDescriptor, versions map[string]int) (sr.Schema, error) {
+	text, err := printFile(fd)
+	if err != nil {
+		return sr.Schema{}, err
+	}
+	schema := sr.Schema{
+		Schema: text,
+		Type:   sr.TypeProtobuf,
+	}
+
+	imports := fd.Imports()
+	for i := 0; i < imports.Len(); i++ {
+		imp := imports.Get(i)
+		path := imp.Path()
+		if isWellKnown(path) {
+			continue
+		}
+		version, ok := versions[path]
+		if !ok {
+			dep, err := s.schema(ctx, imp.FileDescriptor, versions)
+			if err != nil {
+				return sr.Schema{}, err
+			}
+			ss, err := s.registerOrLookup(ctx, path, dep)
+			if err != nil {
+				return sr.Schema{}, fmt.Errorf("unable to register reference %q: %w", path, err)
+			}
+			version = ss.Version
+			versions[path] = version
+		}
+		schema.References = append(schema.References, sr.SchemaReference{
+			Name:    path,
+			Subject: path,
+			Version: version,
+		})
+	}
+	return schema, nil
+}
+
+// file returns the file descriptor for the given schema ID, fetching and
+// parsing it if necessary.
+func (s *Serde) file(ctx context.Context, id int) (protoreflect.FileDescriptor, error) {
+	if fd, ok := s.loadIDs()[id]; ok {
+		return fd, nil
+	}
+
+	s.mu.Lock()
+	if fd, ok := s.loadIDs()[id]; ok {
+		s.mu.Unlock()
+		return fd, nil
+	}
+	f, waiting := s.inflight[id]
+	if !waiting {
+		f = &fetch{done: make(chan struct{})}
+		s.inflight[id] = f
+	}
+	s.mu.Unlock()
+
+	if waiting {
+		select {
+		case <-f.done:
+			return f.fd, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	f.fd, f.err = s.fetch(ctx, id)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.inflight, id)
+	if f.err == nil {
+		dup := make(map[int]protoreflect.FileDescriptor)
+		for k, v := range s.loadIDs() {
+			dup[k] = v
+		}
+		dup[id] = f.fd
+		s.ids.Store(dup)
+	}
+	close(f.done)
+	return f.fd, f.err
+}
+
+func (s *Serde) fetch(ctx context.Context, id int) (protoreflect.FileDescriptor, error) {
+	schema, err := s.cl.SchemaByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	// Every schema is built into its own set of files, because different
+	// schemas can define the same types (e.g. different versions of the
+	// same file).
+	files := new(protoregistry.Files)
+	fd, err := s.build(ctx, files, fmt.Sprintf("schema-id-%d.proto", id), schema)
+	if err != nil {
+		return nil, fmt.Errorf("schema ID %d: %w", id, err)
+	}
+	return fd, nil
+}
+
+// build builds a schema into files after building its references.
+func (s *Serde) build(ctx context.Context, files *protoregistry.Files, name string, schema sr.Schema) (protoreflect.FileDescriptor, error) {
+	if schema.Type != sr.TypeProtobuf {
+		return nil, ErrNotProtobuf
+	}
+	for _, ref := range schema.References {
+		if _, err := files.FindFileByPath(ref.Name); err == nil {
+			continue
+		}
+		ss, err := s.cl.SchemaByVersion(ctx, ref.Subject, ref.Version, sr.HideDeleted)
+		if err != nil {
+			return nil, fmt.Errorf("unable to fetch reference %q: %w", ref.Name, err)
+		}
+		if _, err := s.build(ctx, files, ref.Name, ss.Schema); err != nil {
+			return nil, fmt.Errorf("reference %q: %w", ref.Name, err)
+		}
+	}
+	return buildFile(files, name, schema.Schema)
+}
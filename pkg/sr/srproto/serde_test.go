@@ -0,0 +1,191 @@
+package srproto
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/dynamicpb"
+
+	"github.com/twmb/franz-go/pkg/sr"
+)
+
+const (
+	testCommon = `syntax = "proto3";
+package test.common;
+
+enum Color {
+  RED = 0;
+  BLUE = 1;
+}
+`
+
+	testEvent = `syntax = "proto3";
+package test;
+
+import "common.proto";
+import "google/protobuf/timestamp.proto";
+
+// Other is first, so Event's index is 1.
+message Other {
+  string s = 1;
+}
+
+message Event {
+  message Inner {
+    int64 n = 1;
+  }
+  map<string, int32> counts = 1;
+  Inner inner = 2;
+  repeated test.common.Color colors = 3;
+  optional string maybe = 4;
+  oneof choice {
+    string a = 5;
+    bytes b = 6;
+  }
+  google.protobuf.Timestamp at = 7;
+  reserved 10 to 12, 15;
+  reserved "gone";
+}
+`
+)
+
+// testRegistry is a minimal registry that supports what the Serde uses.
+type testRegistry struct {
+	mu       sync.Mutex
+	schemas  []sr.Schema
+	subjects map[string][]int // subject => IDs, by version
+}
+
+func (r *testRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var id, version int
+	var subject string
+	switch path := req.URL.Path; {
+	case req.Method == http.MethodPost && strings.HasSuffix(path, "/versions"):
+		subject = strings.TrimSuffix(strings.TrimPrefix(path, "/subjects/"), "/versions")
+		var s sr.Schema
+		json.NewDecoder(req.Body).Decode(&s)
+		id = -1
+		for i, existing := range r.schemas {
+			if existing.Schema == s.Schema {
+				id = i + 1
+			}
+		}
+		if id < 0 {
+			r.schemas = append(r.schemas, s)
+			id = len(r.schemas)
+		}
+		r.subjects[subject] = append(r.subjects[subject], id)
+		json.NewEncoder(w).Encode(map[string]int{"id": id})
+
+	case strings.HasPrefix(path, "/schemas/ids/") && strings.HasSuffix(path, "/versions"):
+		fmt.Sscanf(path, "/schemas/ids/%d/versions", &id)
+		var svs []map[string]interface{}
+		for subject, ids := range r.subjects {
+			for i, sid := range ids {
+				if sid == id {
+					svs = append(svs, map[string]interface{}{"subject": subject, "version": i + 1})
+				}
+			}
+		}
+		json.NewEncoder(w).Encode(svs)
+
+	case strings.HasPrefix(path, "/schemas/ids/"):
+		fmt.Sscanf(path, "/schemas/ids/%d", &id)
+		json.NewEncoder(w).Encode(r.schemas[id-1])
+
+	default:
+		parts := strings.Split(strings.TrimPrefix(path, "/subjects/"), "/versions/")
+		subject = parts[0]
+		fmt.Sscanf(parts[1], "%d", &version)
+		id = r.subjects[subject][version-1]
+		json.NewEncoder(w).Encode(sr.SubjectSchema{
+			Subject: subject,
+			Version: version,
+			ID:      id,
+			Schema:  r.schemas[id-1],
+		})
+	}
+}
+
+func TestSerdeRoundTrip(t *testing.T) {
+	// We build our test types from source, which also tests parsing.
+	files := new(protoregistry.Files)
+	if _, err := buildFile(files, "common.proto", testCommon); err != nil {
+		t.Fatalf("unable to build common: %v", err)
+	}
+	fd, err := buildFile(files, "event.proto", testEvent)
+	if err != nil {
+		t.Fatalf("unable to build event: %v", err)
+	}
+	md := fd.Messages().ByName("Event")
+
+	reg := &testRegistry{subjects: make(map[string][]int)}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+	cl, err := sr.NewClient(sr.URLs(srv.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+
+	in := dynamicpb.NewMessage(md)
+	inner := dynamicpb.NewMessage(md.Messages().ByName("Inner"))
+	inner.Set(inner.Descriptor().Fields().ByName("n"), protoreflect.ValueOf(int64(-3)))
+	in.Set(md.Fields().ByName("inner"), protoreflect.ValueOf(inner))
+	in.Set(md.Fields().ByName("maybe"), protoreflect.ValueOf("here"))
+	in.Set(md.Fields().ByName("b"), protoreflect.ValueOf([]byte("bytes")))
+	counts := in.Mutable(md.Fields().ByName("counts")).Map()
+	counts.Set(protoreflect.ValueOf("x").MapKey(), protoreflect.ValueOf(int32(2)))
+
+	for _, strategy := range []sr.SubjectNameStrategy{sr.TopicNameStrategy, sr.RecordNameStrategy} {
+		serde := NewSerde(cl, SubjectNameStrategy(strategy))
+		b, err := serde.Encode(ctx, "foo", sr.ForValue, in)
+		if err != nil {
+			t.Fatalf("unable to encode: %v", err)
+		}
+		// The Event message is the second top level message.
+		if _, rest, _ := sr.DecodeHeader(b); len(rest) < 2 || rest[0] != 2 || rest[1] != 2 {
+			t.Errorf("unexpected index encoding in %x", b)
+		}
+
+		// Decoding uses a new serde to ensure we fetch and parse
+		// what we registered.
+		out, err := NewSerde(cl).Decode(ctx, b)
+		if err != nil {
+			t.Fatalf("unable to decode: %v", err)
+		}
+		if out.ProtoReflect().Descriptor().FullName() != md.FullName() {
+			t.Errorf("decoded %s != exp %s", out.ProtoReflect().Descriptor().FullName(), md.FullName())
+		}
+		inb, _ := proto.MarshalOptions{Deterministic: true}.Marshal(in)
+		outb, _ := proto.MarshalOptions{Deterministic: true}.Marshal(out)
+		if string(inb) != string(outb) {
+			t.Errorf("round trip mismatch: %x != %x", inb, outb)
+		}
+
+		js, err := NewSerde(cl).AppendJSON(ctx, nil, b)
+		if err != nil || !strings.Contains(string(js), `"here"`) || !strings.Contains(string(js), `"-3"`) {
+			t.Errorf("got json %s (err %v), expected the message fields", js, err)
+		}
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, exp := range []string{"foo-value", "test.Event", "common.proto"} {
+		if _, ok := reg.subjects[exp]; !ok {
+			t.Errorf("missing registration for subject %q", exp)
+		}
+	}
+}
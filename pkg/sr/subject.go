@@ -0,0 +1,97 @@
+package sr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/twmb/franz-go/pkg/sr/protofile"
+)
+
+// KeyOrValue is a typed bool indicating whether a schema is for the key or the
+// value of a record.
+type KeyOrValue bool
+
+const (
+	// ForValue indicates a schema is for record values.
+	ForValue KeyOrValue = false
+	// ForKey indicates a schema is for record keys.
+	ForKey KeyOrValue = true
+)
+
+// SubjectNameStrategy returns the subject that a schema is registered under
+// or looked up in. The strategy is given the topic being produced to, whether
+// the schema is for the record key or value, and the fully qualified name of
+// the record type being encoded: the Avro record name, or the protobuf message
+// name.
+//
+// These strategies mirror the strategies available in Confluent serializers.
+type SubjectNameStrategy func(topic string, kv KeyOrValue, record string) string
+
+// TopicNameStrategy uses "<topic>-key" or "<topic>-value" as the subject. This
+// is the default strategy and requires every record in a topic to use the
+// same schema (or compatible versions of it).
+func TopicNameStrategy(topic string, kv KeyOrValue, _ string) string {
+	if kv == ForKey {
+		return topic + "-key"
+	}
+	return topic + "-value"
+}
+
+// RecordNameStrategy uses the fully qualified record name as the subject,
+// allowing a topic to contain many record types, with each record type
+// evolving independently across all topics.
+func RecordNameStrategy(_ string, _ KeyOrValue, record string) string {
+	return record
+}
+
+// TopicRecordNameStrategy uses "<topic>-<record>" as the subject, allowing a
+// topic to contain many record types, with each record type evolving
+// independently per topic.
+func TopicRecordNameStrategy(topic string, _ KeyOrValue, record string) string {
+	return topic + "-" + record
+}
+
+// recordName returns the fully qualified record name of a schema: the
+// namespace qualified name of an Avro record, the title of a JSON schema, or
+// the fully qualified name of the protobuf message at the given index path.
+func recordName(schema Schema, index []int) (string, error) {
+	switch schema.Type {
+	case TypeAvro:
+		var named struct {
+			Name      string `json:"name"`
+			Namespace string `json:"namespace"`
+		}
+		if err := json.Unmarshal([]byte(schema.Schema), &named); err != nil || named.Name == "" {
+			return "", errors.New("unable to determine the record name of an avro schema that is not a named type")
+		}
+		if named.Namespace == "" || strings.ContainsRune(named.Name, '.') {
+			return named.Name, nil
+		}
+		return named.Namespace + "." + named.Name, nil
+
+	case TypeJSON:
+		var titled struct {
+			Title string `json:"title"`
+		}
+		if err := json.Unmarshal([]byte(schema.Schema), &titled); err != nil || titled.Title == "" {
+			return "", errors.New("unable to determine the record name of a json schema without a title")
+		}
+		return titled.Title, nil
+
+	case TypeProtobuf:
+		f, err := protofile.Parse(schema.Schema)
+		if err != nil {
+			return "", err
+		}
+		m, err := f.MessageByIndex(index)
+		if err != nil {
+			return "", err
+		}
+		return m.FullName, nil
+
+	default:
+		return "", fmt.Errorf("unknown schema type %v", schema.Type)
+	}
+}